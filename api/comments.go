@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const (
+	commentsLimit         = 500 // max comments reddit returns for a comment tree request
+	moreChildrenBatchSize = 100 // max ids per /api/morechildren request
+	maxMoreChildrenCalls  = 10  // cap on /api/morechildren requests per post
+)
+
+// redditThing is a generic kind/data envelope
+type redditThing struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// redditListing is a listing whose children are decoded lazily
+type redditListing struct {
+	Kind string `json:"kind"`
+	Data struct {
+		After    string        `json:"after"`
+		Before   string        `json:"before"`
+		Children []redditThing `json:"children"`
+	} `json:"data"`
+}
+
+// RedditComment represents the Reddit API response structure for a comment (kind t1)
+type RedditComment struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	ParentID   string          `json:"parent_id"`
+	LinkID     string          `json:"link_id"`
+	Subreddit  string          `json:"subreddit"`
+	Author     string          `json:"author"`
+	Body       string          `json:"body"`
+	Score      int             `json:"score"`
+	Depth      int             `json:"depth"`
+	CreatedUTC float64         `json:"created_utc"`
+	Permalink  string          `json:"permalink"`
+	Replies    json.RawMessage `json:"replies"` // "" when there are no replies, otherwise a listing
+}
+
+// RedditMore represents a "load more comments" stub (kind more)
+type RedditMore struct {
+	ID       string   `json:"id"`
+	ParentID string   `json:"parent_id"`
+	Count    int      `json:"count"`
+	Depth    int      `json:"depth"`
+	Children []string `json:"children"`
+}
+
+// moreChildrenResponse represents the /api/morechildren response structure
+type moreChildrenResponse struct {
+	JSON struct {
+		Errors [][]interface{} `json:"errors"`
+		Data   struct {
+			Things []redditThing `json:"things"`
+		} `json:"data"`
+	} `json:"json"`
+}
+
+// FetchComments fetches the full comment tree of a post, expanding "more" stubs via /api/morechildren
+func (r *RedditAPI) FetchComments(ctx context.Context, subreddit, postID string) ([]models.Comment, error) {
+	params := url.Values{}
+	params.Set("limit", fmt.Sprint(commentsLimit))
+	params.Set("sort", "new")
+	params.Set("raw_json", "1")
+
+	// the response is a pair of listings: the post itself, then the top-level comments
+	var listings []redditListing
+	if err := r.getJSON(ctx, fmt.Sprintf("/r/%s/comments/%s.json", subreddit, postID), params, &listings); err != nil {
+		return nil, err
+	}
+	if len(listings) < 2 {
+		return nil, fmt.Errorf("unexpected comments response for post %s: got %d listings", postID, len(listings))
+	}
+
+	now := time.Now()
+	tree := &commentTree{postID: postID, subreddit: subreddit, processed: now, seen: make(map[string]bool)}
+	if err := tree.walk(listings[1].Data.Children); err != nil {
+		return nil, err
+	}
+
+	// expand "more" stubs; each round may uncover further stubs
+	for calls := 0; len(tree.more) > 0 && calls < maxMoreChildrenCalls; calls++ {
+		batch := tree.more
+		if len(batch) > moreChildrenBatchSize {
+			batch = batch[:moreChildrenBatchSize]
+		}
+		tree.more = tree.more[len(batch):]
+
+		things, err := r.fetchMoreChildren(ctx, postID, batch)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.walk(things); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tree.more) > 0 {
+		r.log.WithFields(logrus.Fields{
+			"post_id":    postID,
+			"unexpanded": len(tree.more),
+		}).Debug("Reached morechildren limit; some comments were not fetched")
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"post_id":       postID,
+		"subreddit":     subreddit,
+		"comment_count": len(tree.comments),
+	}).Debug("Fetched comments from Reddit")
+
+	return tree.comments, nil
+}
+
+// fetchMoreChildren loads the comments hidden behind "more" stubs
+func (r *RedditAPI) fetchMoreChildren(ctx context.Context, postID string, children []string) ([]redditThing, error) {
+	params := url.Values{}
+	params.Set("api_type", "json")
+	params.Set("link_id", "t3_"+postID)
+	params.Set("children", strings.Join(children, ","))
+	params.Set("sort", "new")
+	params.Set("limit_children", "false")
+	params.Set("raw_json", "1")
+
+	var resp moreChildrenResponse
+	if err := r.getJSON(ctx, "/api/morechildren", params, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.JSON.Errors) > 0 {
+		return nil, fmt.Errorf("morechildren request for post %s failed: %v", postID, resp.JSON.Errors)
+	}
+
+	return resp.JSON.Data.Things, nil
+}
+
+// commentTree accumulates comments while walking a (possibly nested) set of things
+type commentTree struct {
+	postID    string
+	subreddit string
+	processed time.Time
+	comments  []models.Comment
+	more      []string
+	seen      map[string]bool
+}
+
+func (t *commentTree) walk(things []redditThing) error {
+	for _, thing := range things {
+		switch thing.Kind {
+		case "t1":
+			var c RedditComment
+			if err := json.Unmarshal(thing.Data, &c); err != nil {
+				return fmt.Errorf("failed to decode comment: %w", err)
+			}
+			if !t.seen[c.ID] {
+				t.seen[c.ID] = true
+				t.comments = append(t.comments, t.toModel(c))
+			}
+
+			// replies is an empty string when there aren't any
+			if len(c.Replies) > 0 && c.Replies[0] == '{' {
+				var replies redditListing
+				if err := json.Unmarshal(c.Replies, &replies); err != nil {
+					return fmt.Errorf("failed to decode replies of comment %s: %w", c.ID, err)
+				}
+				if err := t.walk(replies.Data.Children); err != nil {
+					return err
+				}
+			}
+		case "more":
+			var m RedditMore
+			if err := json.Unmarshal(thing.Data, &m); err != nil {
+				return fmt.Errorf("failed to decode more stub: %w", err)
+			}
+			// "continue this thread" stubs have no children and need a separate request per thread; skip them
+			for _, id := range m.Children {
+				if !t.seen[id] {
+					t.more = append(t.more, id)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *commentTree) toModel(c RedditComment) models.Comment {
+	subreddit := c.Subreddit
+	if subreddit == "" {
+		subreddit = t.subreddit
+	}
+
+	return models.Comment{
+		ID:            c.ID,
+		PostID:        t.postID,
+		ParentID:      c.ParentID,
+		Subreddit:     subreddit,
+		Author:        c.Author,
+		Body:          c.Body,
+		Score:         c.Score,
+		Depth:         c.Depth,
+		CreatedUTC:    c.CreatedUTC,
+		CreatedAt:     time.Unix(int64(c.CreatedUTC), 0),
+		Permalink:     c.Permalink,
+		ProcessedTime: t.processed,
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func TestFetchCommentsNestedWithMoreStubs(t *testing.T) {
+	r, fake := newFakeAPI(t)
+
+	fake.AddPosts("golang", models.Post{ID: "p1", Title: "post", CreatedUTC: 1700000000})
+	fake.AddComments("p1",
+		models.Comment{ID: "c1", Author: "alice", Body: "top 1", CreatedUTC: 1700000010},
+		models.Comment{ID: "c2", Author: "bob", Body: "top 2", CreatedUTC: 1700000020},
+		models.Comment{ID: "c3", Author: "carol", Body: "top 3", CreatedUTC: 1700000030},
+		models.Comment{ID: "r1", ParentID: "t1_c1", Author: "bob", Body: "reply", CreatedUTC: 1700000040},
+		models.Comment{ID: "r2", ParentID: "t1_r1", Author: "alice", Body: "reply to reply", CreatedUTC: 1700000050},
+		models.Comment{ID: "r3", ParentID: "t1_c3", Author: "dave", Body: "hidden reply", CreatedUTC: 1700000060},
+	)
+	// only render two comments per level so the rest have to be expanded through /api/morechildren
+	fake.SetMorePageSize(2)
+
+	comments, err := r.FetchComments(context.Background(), "golang", "p1")
+	require.NoError(t, err)
+	assert.Len(t, comments, 6)
+	assert.Equal(t, 1, fake.RequestCount("/api/morechildren"))
+
+	byID := make(map[string]models.Comment)
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+		assert.Equal(t, "p1", comment.PostID)
+		assert.Equal(t, "golang", comment.Subreddit)
+	}
+
+	assert.Equal(t, "t3_p1", byID["c1"].ParentID)
+	assert.Equal(t, "t1_c1", byID["r1"].ParentID)
+	assert.Equal(t, "t1_r1", byID["r2"].ParentID)
+	assert.Equal(t, 2, byID["r2"].Depth)
+	assert.Equal(t, "t1_c3", byID["r3"].ParentID)
+	assert.Equal(t, "hidden reply", byID["r3"].Body)
+}
+
+func TestFetchCommentsUnknownPost(t *testing.T) {
+	r, _ := newFakeAPI(t)
+
+	_, err := r.FetchComments(context.Background(), "golang", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
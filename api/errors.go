@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors for classifying failed Reddit API calls; use errors.Is to check them
+var (
+	ErrAuthFailed   = errors.New("reddit authentication failed")
+	ErrUnauthorized = errors.New("reddit access token rejected")
+	ErrForbidden    = errors.New("reddit denied access")
+	ErrNotFound     = errors.New("reddit resource not found")
+	ErrRateLimited  = errors.New("reddit rate limit exceeded")
+	ErrServerError  = errors.New("reddit server error")
+)
+
+// APIError is returned when Reddit responds with a non-200 status code
+type APIError struct {
+	StatusCode int
+	Endpoint   string
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, if any
+	Auth       bool          // true if the error came from the access token endpoint
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request to %s failed with status %d: %s", e.Endpoint, e.StatusCode, e.Body)
+}
+
+// Unwrap maps the status code onto one of the sentinel errors
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized && e.Auth:
+		return ErrAuthFailed
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServerError
+	}
+	return nil
+}
+
+// Retryable reports whether the request may succeed if tried again
+func (e *APIError) Retryable() bool {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		// an expired or revoked token is fixed by authenticating again; bad credentials are not
+		return !e.Auth
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode == http.StatusTooManyRequests:
+		return true
+	case e.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying.
+// Context cancellation and permanent API errors (403, 404, bad credentials, bad responses) are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	// transport errors (connection refused, resets, client timeouts, ...) are worth another go
+	var transportErr *transportError
+	return errors.As(err, &transportErr)
+}
+
+// transportError wraps a failure to get any response at all
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
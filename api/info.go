@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// MaxInfoBatch is the most fullnames /api/info accepts in one request
+const MaxInfoBatch = 100
+
+// FetchInfo fetches the current state of up to 100 posts by fullname (t3_...). Posts reddit
+// doesn't return (e.g. purged ones) are simply missing from the result.
+func (r *RedditAPI) FetchInfo(ctx context.Context, fullnames []string) ([]models.Post, error) {
+	if len(fullnames) == 0 {
+		return nil, nil
+	}
+	if len(fullnames) > MaxInfoBatch {
+		return nil, fmt.Errorf("too many fullnames for /api/info: %d (max %d)", len(fullnames), MaxInfoBatch)
+	}
+
+	params := url.Values{}
+	params.Set("id", strings.Join(fullnames, ","))
+	params.Set("raw_json", "1")
+
+	var redditResp RedditResponse
+	if err := r.getJSON(ctx, "/api/info", params, &redditResp); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	posts := make([]models.Post, 0, len(redditResp.Data.Children))
+	for _, redditPost := range redditResp.Data.Children {
+		if redditPost.Kind != "t3" {
+			continue
+		}
+		posts = append(posts, redditPost.toModel(now))
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"requested": len(fullnames),
+		"returned":  len(posts),
+	}).Debug("Fetched post info from Reddit")
+
+	return posts, nil
+}
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -17,9 +20,15 @@ import (
 )
 
 const (
-	baseURL      = "https://oauth.reddit.com"
-	authURL      = "https://www.reddit.com/api/v1/access_token"
-	defaultLimit = 100 // max number of posts per request
+	defaultBaseURL = "https://oauth.reddit.com"
+	defaultAuthURL = "https://www.reddit.com/api/v1/access_token"
+	defaultLimit   = 100 // max number of posts per request
+
+	rateLimitPeriod     = 600.0 // seconds in one Reddit rate limit period
+	defaultAllocation   = 1000  // requests allowed per period
+	rateSafetyFactor    = 0.95  // only use 95% of the budget we calculate
+	rateReserveFraction = 0.05  // share of the allocation held back for the end of a period
+	maxRateFactor       = 2.0   // max speed up over the steady rate when budget is plentiful
 )
 
 // TokenBucket implements a rate limiter using the token bucket algorithm
@@ -30,6 +39,8 @@ type TokenBucket struct {
 	fillRate    float64       // rate at which tokens are added (tokens per second)
 	lastRefill  time.Time     // time of last token refill
 	waitTimeout time.Duration // max time to wait for a token
+	lastUsed    int           // X-Ratelimit-Used from the last update
+	lastReset   int           // X-Ratelimit-Reset from the last update
 }
 
 // NewTokenBucket creates a new token bucket rate limiter
@@ -93,27 +104,92 @@ func (tb *TokenBucket) TakeWithTimeout() bool {
 	return tb.Take()
 }
 
-// Update updates the rate limiter parameters based on Reddit API headers
-func (tb *TokenBucket) Update(used int, reset int, maxRequests int) {
+// Wait blocks until a token is available or ctx is done
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		if tb.Take() {
+			return nil
+		}
+
+		tb.mutex.Lock()
+		tokensNeeded := 1 - tb.tokens
+		timeToWait := time.Duration(tokensNeeded / tb.fillRate * float64(time.Second))
+		if timeToWait > tb.waitTimeout {
+			timeToWait = tb.waitTimeout
+		}
+		tb.mutex.Unlock()
+
+		if err := sleepContext(ctx, timeToWait); err != nil {
+			return err
+		}
+	}
+}
+
+// Update recalculates the fill rate from the Reddit rate limit headers.
+// used and reset come from X-Ratelimit-Used and X-Ratelimit-Reset, and allocation is the
+// number of requests allowed per period. Returns true if a new period was detected.
+func (tb *TokenBucket) Update(used int, reset int, allocation int) bool {
 	tb.mutex.Lock()
 	defer tb.mutex.Unlock()
 	
 	// Reddit allocates 1000 requests per rolling 10-minute period (600 seconds)
 	// reset_sec counts down from ~600 to 0
-	// remaining is broken/bugged (always 0)
+	// remaining is broken/bugged (always 0), so we derive it from used instead
 	// used counts up from 0 to 1000
-	
-	// use the full allocation period and total requests for calculation
-	totalAllocationPeriod := 600
-	totalAllocation := 1000
-	
-	// calculate the rate based on the entire allocation
-	// lets use 95% of the full rate for safety buffer
-	fullRate := float64(totalAllocation) / float64(totalAllocationPeriod)
-	targetRate := fullRate * 0.95
-	
-	// set fill rate based on allocation
+	if allocation <= 0 {
+		allocation = defaultAllocation
+	}
+
+	// detect the start of a new period; reset jumps back up and used drops back down
+	rolledOver := tb.lastReset > 0 && (reset > tb.lastReset || used < tb.lastUsed)
+	if rolledOver {
+		// the new period starts with a full budget, so let the next request through right away
+		tb.tokens = float64(tb.capacity)
+		tb.lastRefill = time.Now()
+	}
+	tb.lastUsed = used
+	tb.lastReset = reset
+
+	steadyRate := float64(allocation) / rateLimitPeriod * rateSafetyFactor
+
+	// the period is about to end; no point pacing against a budget that's about to refill
+	if reset <= 0 {
+		tb.fillRate = steadyRate
+		return rolledOver
+	}
+
+	remaining := float64(allocation - used)
+	reserve := float64(allocation) * rateReserveFraction
+
+	var targetRate float64
+	if remaining > reserve {
+		// spread what's left (minus a small reserve) over the rest of the period;
+		// this speeds up when we've been idle and slows down when we've been busy
+		targetRate = (remaining - reserve) / float64(reset) * rateSafetyFactor
+	} else {
+		// nearly exhausted; trickle out the reserve so we don't run into 429s before the reset
+		targetRate = math.Max(remaining, 1) / float64(reset) * rateSafetyFactor
+	}
+
+	// never burst far beyond the steady rate, and never stall completely
+	maxRate := steadyRate * maxRateFactor
+	minRate := 1.0 / rateLimitPeriod
+	if targetRate > maxRate {
+		targetRate = maxRate
+	}
+	if targetRate < minRate {
+		targetRate = minRate
+	}
+
 	tb.fillRate = targetRate
+	return rolledOver
+}
+
+// FillRate returns the current fill rate in tokens per second
+func (tb *TokenBucket) FillRate() float64 {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return tb.fillRate
 }
 
 // RedditAPI represents a Reddit API client
@@ -121,6 +197,8 @@ type RedditAPI struct {
 	clientID           string
 	clientSecret       string
 	userAgent          string
+	baseURL            string
+	authURL            string
 	httpClient         *http.Client
 	accessToken        string
 	tokenExpiry        time.Time
@@ -132,6 +210,7 @@ type RedditAPI struct {
 	rateResetCached    int
 	rateUsedCached     int
 	rateHeadersMutex   sync.RWMutex
+	retryPolicy        RetryPolicy
 }
 
 // RedditPost represents the Reddit API response structure for a post
@@ -149,14 +228,41 @@ type RedditPost struct {
 		Downs       int     `json:"downs"`
 		Score       int     `json:"score"`
 		NumComments int     `json:"num_comments"`
+		UpvoteRatio float64 `json:"upvote_ratio"`
 		PostHint    string  `json:"post_hint"`
 		IsVideo     bool    `json:"is_video"`
 		IsSelf      bool    `json:"is_self"`
 		SelfText    string  `json:"selftext"`
 		Permalink   string  `json:"permalink"`
+		Over18      bool    `json:"over_18"`
 	} `json:"data"`
 }
 
+// toModel converts the API representation of a post into a models.Post observed at the given time
+func (p RedditPost) toModel(observedAt time.Time) models.Post {
+	return models.Post{
+		ID:            p.Data.ID,
+		Title:         p.Data.Title,
+		Author:        p.Data.Author,
+		Subreddit:     p.Data.Subreddit,
+		URL:           p.Data.URL,
+		CreatedUTC:    p.Data.CreatedUTC,
+		CreatedAt:     time.Unix(int64(p.Data.CreatedUTC), 0),
+		Upvotes:       p.Data.Ups,
+		Downvotes:     p.Data.Downs,
+		Score:         p.Data.Score,
+		NumComments:   p.Data.NumComments,
+		UpvoteRatio:   p.Data.UpvoteRatio,
+		PostHint:      p.Data.PostHint,
+		IsVideo:       p.Data.IsVideo,
+		IsSelf:        p.Data.IsSelf,
+		SelfText:      p.Data.SelfText,
+		Permalink:     p.Data.Permalink,
+		Over18:        p.Data.Over18,
+		ProcessedTime: observedAt,
+	}
+}
+
 // RedditResponse represents the Reddit API response structure
 type RedditResponse struct {
 	Kind string `json:"kind"`
@@ -167,8 +273,32 @@ type RedditResponse struct {
 	} `json:"data"`
 }
 
+// Option configures optional RedditAPI settings
+type Option func(*RedditAPI)
+
+// WithBaseURL overrides the OAuth API base URL (default https://oauth.reddit.com)
+func WithBaseURL(baseURL string) Option {
+	return func(r *RedditAPI) {
+		r.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithAuthURL overrides the access token endpoint URL
+func WithAuthURL(authURL string) Option {
+	return func(r *RedditAPI) {
+		r.authURL = authURL
+	}
+}
+
+// WithHTTPClient overrides the HTTP client used for all requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *RedditAPI) {
+		r.httpClient = client
+	}
+}
+
 // NewRedditAPI creates a new Reddit API client
-func NewRedditAPI(clientID, clientSecret, userAgent string, maxRequestsPerMinute int, log *logrus.Logger) *RedditAPI {
+func NewRedditAPI(clientID, clientSecret, userAgent string, maxRequestsPerMinute int, log *logrus.Logger, opts ...Option) *RedditAPI {
 	// default to 100 requests per minute (real Reddit limit)
 	if maxRequestsPerMinute <= 0 {
 		maxRequestsPerMinute = 100
@@ -177,8 +307,8 @@ func NewRedditAPI(clientID, clientSecret, userAgent string, maxRequestsPerMinute
 	// our 10 minute allocation
 	totalAllocation := maxRequestsPerMinute * 10
 	
-	standardRate := float64(totalAllocation) / 600.0 
-	targetRate := standardRate * 0.95 
+	standardRate := float64(totalAllocation) / rateLimitPeriod
+	targetRate := standardRate * rateSafetyFactor
 	
 	// Create a token bucket rate limiter:
 	// - capacity: 1 (no burst capacity when set to 1)
@@ -190,10 +320,12 @@ func NewRedditAPI(clientID, clientSecret, userAgent string, maxRequestsPerMinute
 		30 * time.Second,
 	)
 	
-	return &RedditAPI{
+	r := &RedditAPI{
 		clientID:           clientID,
 		clientSecret:       clientSecret,
 		userAgent:          userAgent,
+		baseURL:            defaultBaseURL,
+		authURL:            defaultAuthURL,
 		httpClient:         &http.Client{Timeout: 30 * time.Second},
 		log:                log,
 		rateLimiter:        rateLimiter,
@@ -201,7 +333,14 @@ func NewRedditAPI(clientID, clientSecret, userAgent string, maxRequestsPerMinute
 		rateRemainingCached: 0,
 		rateResetCached:    600,
 		rateUsedCached:     0,
+		retryPolicy:        DefaultRetryPolicy,
+	}
+
+	for _, opt := range opts {
+		opt(r)
 	}
+
+	return r
 }
 
 // GetRateLimitStatus returns the current rate limit status (remaining requests, reset time in seconds, and used requests)
@@ -212,7 +351,7 @@ func (r *RedditAPI) GetRateLimitStatus() (int, int, int) {
 }
 
 // authenticate authenticates with the Reddit API
-func (r *RedditAPI) authenticate() error {
+func (r *RedditAPI) authenticate(ctx context.Context) error {
 	// first check if we already have a valid token without holding the lock for long
 	r.mutex.RLock()
 	token := r.accessToken
@@ -226,8 +365,8 @@ func (r *RedditAPI) authenticate() error {
 	r.log.Info("Authenticating with Reddit API")
 
 	// wait for rate limiting
-	if !r.rateLimiter.TakeWithTimeout() {
-		return fmt.Errorf("rate limit exceeded during authentication attempt")
+	if err := r.rateLimiter.Wait(ctx); err != nil {
+		return err
 	}
 
 	data := url.Values{}
@@ -235,7 +374,7 @@ func (r *RedditAPI) authenticate() error {
 	r.log.Debug("Using application-only auth with client credentials")
 	data.Set("grant_type", "client_credentials")
 
-	req, err := http.NewRequest("POST", authURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", r.authURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return fmt.Errorf("failed to create auth request: %w", err)
 	}
@@ -246,16 +385,21 @@ func (r *RedditAPI) authenticate() error {
 
 	resp, err := r.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to execute auth request: %w", err)
+		return &transportError{fmt.Errorf("failed to execute auth request: %w", err)}
 	}
 	defer resp.Body.Close()
 
-	// note: see the TODO in updateRateLimits
 	r.updateRateLimits(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("auth request failed with status %d: %s", resp.StatusCode, string(body))
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Endpoint:   r.authURL,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header),
+			Auth:       true,
+		}
 	}
 
 	var authResp struct {
@@ -277,38 +421,75 @@ func (r *RedditAPI) authenticate() error {
 	return nil
 }
 
-// FetchPosts fetches posts from a subreddit
-func (r *RedditAPI) FetchPosts(subreddit string, limit int, after string) ([]models.Post, string, error) {
-	if err := r.authenticate(); err != nil {
-		return nil, "", err
-	}
+// invalidateToken drops the cached access token so the next request authenticates again
+func (r *RedditAPI) invalidateToken() {
+	r.mutex.Lock()
+	r.accessToken = ""
+	r.tokenExpiry = time.Time{}
+	r.mutex.Unlock()
+}
 
-	if limit <= 0 || limit > 100 {
-		limit = defaultLimit
+// getJSON performs an authenticated GET request against the API and decodes the response into out.
+// Transient failures are retried according to the retry policy; the returned error wraps the
+// last *APIError (if any) so callers can classify it with errors.Is / errors.As.
+func (r *RedditAPI) getJSON(ctx context.Context, path string, params url.Values, out interface{}) error {
+	endpoint := r.baseURL + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
 	}
 
-	if !r.rateLimiter.TakeWithTimeout() {
-		r.log.Warn("Rate limit exceeded, waiting before retrying")
-		// wait 1 second and retry recursively!! :)
-		// TODO: we could use exponential backoff here, but not going to worry about it for now
-		time.Sleep(time.Second)
-		return r.FetchPosts(subreddit, limit, after)
+	var lastErr error
+	for attempt := 1; attempt <= r.retryPolicy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := r.retryPolicy.backoff(attempt - 1)
+
+			var apiErr *APIError
+			if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > delay {
+				delay = apiErr.RetryAfter
+			}
+
+			r.log.WithFields(logrus.Fields{
+				"endpoint": path,
+				"attempt":  attempt,
+				"delay":    delay.String(),
+			}).WithError(lastErr).Warn("Retrying Reddit API request")
+
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+		}
+
+		lastErr = r.doGetJSON(ctx, endpoint, out)
+		if lastErr == nil {
+			return nil
+		}
+
+		if errors.Is(lastErr, ErrUnauthorized) {
+			// our token expired or was revoked; authenticate again on the next attempt
+			r.invalidateToken()
+		}
+
+		if !IsRetryable(lastErr) {
+			return lastErr
+		}
 	}
 
-	endpoint := fmt.Sprintf("%s/r/%s/new.json?limit=%d", baseURL, subreddit, limit)
-	if after != "" {
-		endpoint += "&after=" + after
+	return fmt.Errorf("giving up after %d attempts: %w", r.retryPolicy.MaxAttempts, lastErr)
+}
+
+// doGetJSON performs a single attempt of getJSON
+func (r *RedditAPI) doGetJSON(ctx context.Context, endpoint string, out interface{}) error {
+	if err := r.authenticate(ctx); err != nil {
+		return err
 	}
 
-	r.log.WithFields(logrus.Fields{
-		"subreddit": subreddit,
-		"after": after,
-		"limit": limit,
-	}).Info("Fetching posts from Reddit API with pagination token")
+	if err := r.rateLimiter.Wait(ctx); err != nil {
+		return err
+	}
 
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	r.mutex.RLock()
@@ -320,67 +501,120 @@ func (r *RedditAPI) FetchPosts(subreddit string, limit int, after string) ([]mod
 
 	resp, err := r.httpClient.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to execute request: %w", err)
+		return &transportError{fmt.Errorf("failed to execute request: %w", err)}
 	}
 	defer resp.Body.Close()
 
-	// note: see the TODO in updateRateLimits
 	r.updateRateLimits(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		r.log.WithFields(logrus.Fields{
-			"subreddit":     subreddit,
+			"endpoint":      endpoint,
 			"response_body": string(body),
 			"status_code":   resp.StatusCode,
 		}).Error("Reddit API error response")
-		return nil, "", fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Endpoint:   endpoint,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header),
+		}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// ListingOptions controls which listing FetchListing requests and which page of it
+type ListingOptions struct {
+	Listing models.Listing    // defaults to new
+	Time    models.TimeWindow // only used by top and controversial; defaults to day
+	Limit   int               // posts per page, at most 100
+	After   string            // fullname cursor for the next (older/lower ranked) page
+	Before  string            // fullname cursor for the previous page
+}
+
+// FetchPosts fetches posts from a subreddit's new listing
+func (r *RedditAPI) FetchPosts(ctx context.Context, subreddit string, limit int, after string) ([]models.Post, string, error) {
+	return r.FetchListing(ctx, subreddit, ListingOptions{
+		Listing: models.ListingNew,
+		Limit:   limit,
+		After:   after,
+	})
+}
+
+// FetchListing fetches one page of a subreddit listing and returns the posts with the cursor for the next page
+func (r *RedditAPI) FetchListing(ctx context.Context, subreddit string, opts ListingOptions) ([]models.Post, string, error) {
+	limit := opts.Limit
+	if limit <= 0 || limit > 100 {
+		limit = defaultLimit
 	}
 
+	listing := opts.Listing
+	if listing == "" {
+		listing = models.ListingNew
+	}
+	if !listing.Valid() {
+		return nil, "", fmt.Errorf("unknown listing %q", listing)
+	}
+
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(limit))
+	if opts.After != "" {
+		params.Set("after", opts.After)
+	}
+	if opts.Before != "" {
+		params.Set("before", opts.Before)
+	}
+	if listing.HasTimeWindow() {
+		window := opts.Time
+		if window == "" {
+			window = models.TimeDay
+		}
+		if !window.Valid() {
+			return nil, "", fmt.Errorf("unknown time window %q", window)
+		}
+		params.Set("t", string(window))
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"subreddit": subreddit,
+		"listing":   listing,
+		"time":      params.Get("t"),
+		"after":     opts.After,
+		"before":    opts.Before,
+		"limit":     limit,
+	}).Info("Fetching posts from Reddit API with pagination token")
+
 	var redditResp RedditResponse
-	if err := json.NewDecoder(resp.Body).Decode(&redditResp); err != nil {
-		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	if err := r.getJSON(ctx, fmt.Sprintf("/r/%s/%s.json", subreddit, listing), params, &redditResp); err != nil {
+		return nil, "", err
 	}
 
 	posts := make([]models.Post, 0, len(redditResp.Data.Children))
 	now := time.Now()
 
 	for _, redditPost := range redditResp.Data.Children {
-		post := models.Post{
-			ID:            redditPost.Data.ID,
-			Title:         redditPost.Data.Title,
-			Author:        redditPost.Data.Author,
-			Subreddit:     redditPost.Data.Subreddit,
-			URL:           redditPost.Data.URL,
-			CreatedUTC:    redditPost.Data.CreatedUTC,
-			CreatedAt:     time.Unix(int64(redditPost.Data.CreatedUTC), 0),
-			Upvotes:       redditPost.Data.Ups,
-			Downvotes:     redditPost.Data.Downs,
-			Score:         redditPost.Data.Score,
-			NumComments:   redditPost.Data.NumComments,
-			PostHint:      redditPost.Data.PostHint,
-			IsVideo:       redditPost.Data.IsVideo,
-			IsSelf:        redditPost.Data.IsSelf,
-			SelfText:      redditPost.Data.SelfText,
-			Permalink:     redditPost.Data.Permalink,
-			ProcessedTime: now,
-		}
-		posts = append(posts, post)
+		posts = append(posts, redditPost.toModel(now))
 	}
 
 	r.log.WithFields(logrus.Fields{
 		"post_count": len(posts),
 		"subreddit":  subreddit,
-		"after":      after,
+		"listing":    listing,
+		"after":      opts.After,
 		"next_after": redditResp.Data.After,
-		"pagination_changed": after != redditResp.Data.After && redditResp.Data.After != "",
+		"pagination_changed": opts.After != redditResp.Data.After && redditResp.Data.After != "",
 	}).Info("Fetched posts from Reddit with pagination info")
 
 	return posts, redditResp.Data.After, nil
 }
 
 // updateRateLimits updates the rate limiter based on response headers
-// TODO: this isn't actually adapting based off of the header responses;  this is simply used for debuggng atm
 func (r *RedditAPI) updateRateLimits(resp *http.Response) {
 	// X-Ratelimit-Used: Approximate number of requests used in this period
 	// X-Ratelimit-Remaining: Approximate number of requests left to use (bugged - always 0)
@@ -394,25 +628,41 @@ func (r *RedditAPI) updateRateLimits(resp *http.Response) {
 		return
 	}
 	
-	// reddit allocates 1000 requests per 600 seconds (10 minutes); this indicates the total allocation of 1k
-	totalAllocation := 1000.0
+	totalAllocation := r.maxRequestsPerMin * 10
 
 	r.rateHeadersMutex.Lock()
 	r.rateRemainingCached = remaining // bugged - always 0; update anyways in case reddit fixes it
 	r.rateResetCached = reset
 	r.rateUsedCached = used
 	r.rateHeadersMutex.Unlock()
-	
-	r.rateLimiter.Update(used, reset, r.maxRequestsPerMin)
+
+	// only trust remaining when it's non-zero and tells us we have less budget than used implies
+	effectiveUsed := used
+	if remaining > 0 && totalAllocation-remaining > used {
+		effectiveUsed = totalAllocation - remaining
+	}
+
+	if r.rateLimiter.Update(effectiveUsed, reset, totalAllocation) {
+		r.log.WithFields(logrus.Fields{
+			"used":      used,
+			"reset_sec": reset,
+		}).Info("Detected new rate limit period, values have reset")
+	}
 
 	r.log.WithFields(logrus.Fields{
 		"used":               used,
+		"remaining":          remaining,
 		"reset_sec":          reset,
-		"new_fill_rate":      r.rateLimiter.fillRate,
-		"usage_pct":          float64(used) / totalAllocation * 100,
+		"new_fill_rate":      r.rateLimiter.FillRate(),
+		"usage_pct":          float64(effectiveUsed) / float64(totalAllocation) * 100,
 	}).Debug("Updated rate limiter based on Reddit headers")
 }
 
+// RequestRate returns the request rate (per second) the client is currently pacing itself to
+func (r *RedditAPI) RequestRate() float64 {
+	return r.rateLimiter.FillRate()
+}
+
 func getHeaderAsInt(header http.Header, name string) int {
 	value := header.Get(name)
 	if value == "" {
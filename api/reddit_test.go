@@ -1,9 +1,18 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"testing"
 	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/brettboylen/reddit-tracker/api/redditfake"
+	"github.com/brettboylen/reddit-tracker/models"
 )
 
 func TestGetHeaderAsInt(t *testing.T) {
@@ -72,10 +81,194 @@ func TestTokenBucketUpdate(t *testing.T) {
 	
 	tb.Update(200, 400, 1000) // 200 used, 400 seconds left in period, 1000 requests allowed
 	
-	// we expect .95 of the full rate
-	expectedRate := (1000.0 / 600.0) * 0.95
+	// we expect 95% of the budget left (minus the 5% reserve) spread over the rest of the period
+	expectedRate := ((1000.0 - 200.0 - 50.0) / 400.0) * 0.95
 	
 	if tb.fillRate != expectedRate {
 		t.Errorf("Update() fillRate = %f; want %f", tb.fillRate, expectedRate)
 	}
-} 
\ No newline at end of file
+}
+
+func TestTokenBucketUpdateAdapts(t *testing.T) {
+	steadyRate := (1000.0 / 600.0) * 0.95
+
+	tests := []struct {
+		name    string
+		used    int
+		reset   int
+		minRate float64
+		maxRate float64
+	}{
+		{
+			name:    "Plentiful budget speeds up but is capped",
+			used:    0,
+			reset:   60,
+			minRate: steadyRate * maxRateFactor,
+			maxRate: steadyRate * maxRateFactor,
+		},
+		{
+			name:    "Near exhaustion paces down",
+			used:    980,
+			reset:   300,
+			minRate: 0,
+			maxRate: 0.1,
+		},
+		{
+			name:    "Exhausted budget never stalls completely",
+			used:    1200,
+			reset:   300,
+			minRate: 1.0 / 600.0,
+			maxRate: 0.01,
+		},
+		{
+			name:    "End of period falls back to the steady rate",
+			used:    999,
+			reset:   0,
+			minRate: steadyRate,
+			maxRate: steadyRate,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := NewTokenBucket(1, 1.0, time.Second)
+			tb.Update(tc.used, tc.reset, 1000)
+
+			rate := tb.FillRate()
+			assert.GreaterOrEqual(t, rate, tc.minRate-1e-9)
+			assert.LessOrEqual(t, rate, tc.maxRate+1e-9)
+		})
+	}
+}
+
+func TestTokenBucketUpdateDetectsRollover(t *testing.T) {
+	tb := NewTokenBucket(1, 1.0, time.Second)
+
+	assert.False(t, tb.Update(100, 500, 1000), "first update has nothing to compare against")
+	assert.False(t, tb.Update(150, 450, 1000))
+	assert.True(t, tb.Update(2, 598, 1000), "reset jumped back up")
+	assert.False(t, tb.Update(10, 590, 1000))
+}
+
+func newFakeAPI(t *testing.T) (*RedditAPI, *redditfake.Server) {
+	t.Helper()
+
+	fake := redditfake.NewServer()
+	t.Cleanup(fake.Close)
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	// use a high request budget so the token bucket doesn't slow the tests down
+	r := NewRedditAPI("id", "secret", "test-agent", 60000, log,
+		WithBaseURL(fake.URL()),
+		WithAuthURL(fake.AuthURL()),
+	)
+	return r, fake
+}
+
+func TestFetchPostsPagination(t *testing.T) {
+	r, fake := newFakeAPI(t)
+
+	for i := 1; i <= 5; i++ {
+		fake.AddPosts("golang", models.Post{
+			ID:         fmt.Sprintf("p%d", i),
+			Title:      fmt.Sprintf("post %d", i),
+			Author:     "gopher",
+			CreatedUTC: float64(1700000000 + i),
+			Upvotes:    i,
+		})
+	}
+
+	posts, after, err := r.FetchPosts(context.Background(), "golang", 3, "")
+	assert.NoError(t, err)
+	assert.Len(t, posts, 3)
+	assert.Equal(t, "p5", posts[0].ID)
+	assert.Equal(t, "t3_p3", after)
+
+	posts, after, err = r.FetchPosts(context.Background(), "golang", 3, after)
+	assert.NoError(t, err)
+	assert.Len(t, posts, 2)
+	assert.Equal(t, "p2", posts[0].ID)
+	assert.Equal(t, "", after)
+
+	// one auth request, two listing requests
+	assert.Equal(t, 1, fake.RequestCount("/api/v1/access_token"))
+	assert.Equal(t, 2, fake.RequestCount("/r/golang/new.json"))
+}
+
+func TestFetchPostsServerError(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	r.retryPolicy = RetryPolicy{MaxAttempts: 1}
+	fake.InjectFault(redditfake.Fault{PathPrefix: "/r/", StatusCode: http.StatusInternalServerError})
+
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.ErrorIs(t, err, ErrServerError)
+}
+
+func TestRateLimitHeadersAreCached(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	fake.SetRateLimit(redditfake.RateLimit{Used: 10, Reset: 300})
+
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.NoError(t, err)
+
+	_, reset, used := r.GetRateLimitStatus()
+	assert.Equal(t, 300, reset)
+	assert.Equal(t, 11, used)
+}
+
+func TestFetchListingTopWithTimeWindow(t *testing.T) {
+	r, fake := newFakeAPI(t)
+
+	now := time.Now().Unix()
+	fake.AddPosts("golang",
+		models.Post{ID: "old", Score: 1000, CreatedUTC: float64(now - 30*24*3600)},
+		models.Post{ID: "low", Score: 5, CreatedUTC: float64(now - 3600)},
+		models.Post{ID: "high", Score: 50, CreatedUTC: float64(now - 7200)},
+	)
+
+	posts, _, err := r.FetchListing(context.Background(), "golang", ListingOptions{
+		Listing: models.ListingTop,
+		Time:    models.TimeDay,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, posts, 2) {
+		assert.Equal(t, "high", posts[0].ID)
+		assert.Equal(t, "low", posts[1].ID)
+	}
+
+	requests := fake.Requests()
+	last := requests[len(requests)-1]
+	assert.Equal(t, "/r/golang/top.json", last.Path)
+	assert.Equal(t, "day", last.Query.Get("t"))
+
+	_, _, err = r.FetchListing(context.Background(), "golang", ListingOptions{Listing: "best"})
+	assert.Error(t, err)
+}
+
+func TestSearchPostsBetween(t *testing.T) {
+	r, fake := newFakeAPI(t)
+
+	now := time.Now().Truncate(time.Second)
+	fake.AddPosts("golang",
+		models.Post{ID: "a", CreatedUTC: float64(now.Add(-3 * time.Hour).Unix())},
+		models.Post{ID: "b", CreatedUTC: float64(now.Add(-2 * time.Hour).Unix())},
+		models.Post{ID: "c", CreatedUTC: float64(now.Add(-time.Hour).Unix())},
+	)
+
+	posts, after, err := r.SearchPostsBetween(context.Background(), "golang",
+		now.Add(-150*time.Minute), now.Add(-30*time.Minute), 100, "")
+	assert.NoError(t, err)
+	assert.Empty(t, after)
+	if assert.Len(t, posts, 2) {
+		assert.Equal(t, "c", posts[0].ID)
+		assert.Equal(t, "b", posts[1].ID)
+	}
+
+	requests := fake.Requests()
+	last := requests[len(requests)-1]
+	assert.Equal(t, "/r/golang/search.json", last.Path)
+	assert.Equal(t, "new", last.Query.Get("sort"))
+	assert.Equal(t, "on", last.Query.Get("restrict_sr"))
+}
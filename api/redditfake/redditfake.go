@@ -0,0 +1,736 @@
+// Package redditfake provides an in-process fake of the Reddit API endpoints used by
+// the tracker so the API client, collector and HTTP server can be exercised offline.
+package redditfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const (
+	authPath     = "/api/v1/access_token"
+	defaultLimit = 25 // reddit's default page size when no limit is given
+	maxLimit     = 100
+)
+
+// RateLimit holds the values reported in the X-Ratelimit-* response headers
+type RateLimit struct {
+	Used      int
+	Remaining int
+	Reset     int
+}
+
+// Fault describes an error response to inject for matching requests
+type Fault struct {
+	PathPrefix string // only requests whose path starts with this prefix match; empty matches all
+	StatusCode int
+	RetryAfter int    // seconds, sent in the Retry-After header when > 0
+	Body       string // optional response body
+	Times      int    // number of requests to fail; <= 0 means one
+}
+
+// Request is a record of a request received by the fake
+type Request struct {
+	Method     string
+	Path       string
+	Query      url.Values
+	Authorized bool
+}
+
+// Server is a fake Reddit API backed by httptest
+type Server struct {
+	srv          *httptest.Server
+	mutex        sync.Mutex
+	clientID     string
+	clientSecret string
+	tokenTTL     int
+	tokenSeq     int
+	tokens       map[string]bool
+	listings     map[string][]models.Post    // keyed by lowercase subreddit, newest first
+	comments     map[string][]models.Comment // keyed by post id
+	morePageSize int                         // max comments rendered per level before a "more" stub; 0 for no limit
+	listingDepth int                         // max posts a listing reaches back, like reddit's ~1000; 0 for no limit
+	faults       []*Fault
+	rateLimit    *RateLimit
+	requests     []Request
+}
+
+// NewServer starts a new fake Reddit server; callers must Close it when done
+func NewServer() *Server {
+	s := &Server{
+		tokenTTL: 3600,
+		tokens:   make(map[string]bool),
+		listings: make(map[string][]models.Post),
+		comments: make(map[string][]models.Comment),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(authPath, s.handleAuth)
+	mux.HandleFunc("/r/", s.requireAuth(s.handleSubreddit))
+	mux.HandleFunc("/api/morechildren", s.requireAuth(s.handleMoreChildren))
+	mux.HandleFunc("/api/info", s.requireAuth(s.handleInfo))
+	s.srv = httptest.NewServer(s.withFaults(mux))
+
+	return s
+}
+
+// URL returns the base URL to use in place of https://oauth.reddit.com
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// AuthURL returns the access token endpoint URL
+func (s *Server) AuthURL() string {
+	return s.srv.URL + authPath
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetCredentials restricts authentication to the given client id and secret;
+// by default any credentials are accepted
+func (s *Server) SetCredentials(clientID, clientSecret string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clientID = clientID
+	s.clientSecret = clientSecret
+}
+
+// SetTokenTTL sets the expires_in value returned for new access tokens
+func (s *Server) SetTokenTTL(seconds int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokenTTL = seconds
+}
+
+// RevokeTokens invalidates every issued access token so the next API call gets a 401
+func (s *Server) RevokeTokens() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// SetRateLimit enables X-Ratelimit-* headers starting from the given values;
+// Used is incremented (and Remaining decremented) on each API request
+func (s *Server) SetRateLimit(rl RateLimit) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rateLimit = &rl
+}
+
+// InjectFault queues an error response for matching requests
+func (s *Server) InjectFault(f Fault) {
+	if f.Times <= 0 {
+		f.Times = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// AddPosts adds posts to a subreddit's listing; listings are ordered newest first by created_utc
+func (s *Server) AddPosts(subreddit string, posts ...models.Post) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := strings.ToLower(subreddit)
+	listing := s.listings[key]
+	for _, post := range posts {
+		if post.Subreddit == "" {
+			post.Subreddit = subreddit
+		}
+		listing = append(listing, post)
+	}
+
+	sort.SliceStable(listing, func(i, j int) bool {
+		if listing[i].CreatedUTC != listing[j].CreatedUTC {
+			return listing[i].CreatedUTC > listing[j].CreatedUTC
+		}
+		return compareIDs(listing[i].ID, listing[j].ID) > 0
+	})
+	s.listings[key] = listing
+}
+
+// UpdatePosts replaces stored posts with the same id, e.g. to change their score
+func (s *Server) UpdatePosts(posts ...models.Post) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, post := range posts {
+		for _, listing := range s.listings {
+			for i := range listing {
+				if listing[i].ID == post.ID {
+					if post.Subreddit == "" {
+						post.Subreddit = listing[i].Subreddit
+					}
+					listing[i] = post
+				}
+			}
+		}
+	}
+}
+
+// AddComments adds comments to a post; ParentID links replies to their parent comment
+// (t1_...) and defaults to the post itself (t3_...)
+func (s *Server) AddComments(postID string, comments ...models.Comment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, comment := range comments {
+		comment.PostID = postID
+		if comment.ParentID == "" {
+			comment.ParentID = fullname(postID)
+		}
+		s.comments[postID] = append(s.comments[postID], comment)
+	}
+}
+
+// SetMorePageSize limits how many comments are rendered per level of a comment tree;
+// the rest are replaced with a "more" stub that must be expanded through /api/morechildren
+func (s *Server) SetMorePageSize(size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.morePageSize = size
+}
+
+// SetListingDepth limits how many posts a listing reaches back, like reddit's ~1000 post cap;
+// search isn't limited. 0 removes the limit.
+func (s *Server) SetListingDepth(depth int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listingDepth = depth
+}
+
+// Requests returns a copy of every request received so far
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestCount returns the number of received requests whose path starts with prefix
+func (s *Server) RequestCount(prefix string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, req := range s.requests {
+		if strings.HasPrefix(req.Path, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// withFaults records every request and serves injected faults before the real handlers
+func (s *Server) withFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mutex.Lock()
+		s.requests = append(s.requests, Request{
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			Query:      req.URL.Query(),
+			Authorized: s.tokens[bearerToken(req)],
+		})
+		s.writeRateLimitHeaders(w, req)
+
+		var fault *Fault
+		for i, f := range s.faults {
+			if strings.HasPrefix(req.URL.Path, f.PathPrefix) {
+				fault = f
+				f.Times--
+				if f.Times <= 0 {
+					s.faults = append(s.faults[:i], s.faults[i+1:]...)
+				}
+				break
+			}
+		}
+		s.mutex.Unlock()
+
+		if fault != nil {
+			if fault.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(fault.RetryAfter))
+			}
+			body := fault.Body
+			if body == "" {
+				body = fmt.Sprintf(`{"message": "%s", "error": %d}`, http.StatusText(fault.StatusCode), fault.StatusCode)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(fault.StatusCode)
+			fmt.Fprint(w, body)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// writeRateLimitHeaders must be called with the mutex held
+func (s *Server) writeRateLimitHeaders(w http.ResponseWriter, req *http.Request) {
+	if s.rateLimit == nil || req.URL.Path == authPath {
+		return
+	}
+
+	s.rateLimit.Used++
+	if s.rateLimit.Remaining > 0 {
+		s.rateLimit.Remaining--
+	}
+	w.Header().Set("X-Ratelimit-Used", strconv.Itoa(s.rateLimit.Used))
+	w.Header().Set("X-Ratelimit-Remaining", strconv.Itoa(s.rateLimit.Remaining))
+	w.Header().Set("X-Ratelimit-Reset", strconv.Itoa(s.rateLimit.Reset))
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, secret, ok := req.BasicAuth()
+	s.mutex.Lock()
+	if !ok || (s.clientID != "" && (id != s.clientID || secret != s.clientSecret)) {
+		s.mutex.Unlock()
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"message": "Unauthorized", "error": 401})
+		return
+	}
+
+	s.tokenSeq++
+	token := fmt.Sprintf("fake-token-%d", s.tokenSeq)
+	s.tokens[token] = true
+	ttl := s.tokenTTL
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "bearer",
+		"expires_in":   ttl,
+		"scope":        "*",
+	})
+}
+
+// requireAuth rejects requests without a valid bearer token
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		s.mutex.Lock()
+		valid := s.tokens[bearerToken(req)]
+		s.mutex.Unlock()
+
+		if !valid {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"message": "Unauthorized", "error": 401})
+			return
+		}
+		next(w, req)
+	}
+}
+
+// handleSubreddit serves /r/{sub}/... endpoints
+func (s *Server) handleSubreddit(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) == 3 && listingSorts[strings.TrimSuffix(parts[2], ".json")] {
+		s.handleListing(w, req, parts[1], strings.TrimSuffix(parts[2], ".json"))
+		return
+	}
+	if len(parts) == 3 && parts[2] == "search.json" {
+		s.handleSearch(w, req, parts[1])
+		return
+	}
+	if len(parts) == 4 && parts[2] == "comments" {
+		s.handleComments(w, req, parts[1], strings.TrimSuffix(parts[3], ".json"))
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "Not Found", "error": 404})
+}
+
+// listingSorts are the listings the fake serves; new is ordered by created_utc, hot, rising and top
+// by score, and controversial by lowest score first
+var listingSorts = map[string]bool{"new": true, "hot": true, "rising": true, "top": true, "controversial": true}
+
+// timeWindows maps the t= parameter onto how far back top and controversial look
+var timeWindows = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+}
+
+// sortListing reorders a copy of the new listing for the given sort
+func sortListing(posts []models.Post, sortName, window string) []models.Post {
+	if sortName == "top" || sortName == "controversial" {
+		if window == "" {
+			window = "day"
+		}
+		if d, ok := timeWindows[window]; ok {
+			cutoff := float64(time.Now().Add(-d).Unix())
+			filtered := posts[:0]
+			for _, post := range posts {
+				if post.CreatedUTC >= cutoff {
+					filtered = append(filtered, post)
+				}
+			}
+			posts = filtered
+		}
+	}
+
+	switch sortName {
+	case "hot", "rising", "top":
+		sort.SliceStable(posts, func(i, j int) bool { return posts[i].Score > posts[j].Score })
+	case "controversial":
+		sort.SliceStable(posts, func(i, j int) bool { return posts[i].Score < posts[j].Score })
+	}
+	return posts
+}
+
+func (s *Server) handleListing(w http.ResponseWriter, req *http.Request, subreddit, sortName string) {
+	query := req.URL.Query()
+	limit := defaultLimit
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	s.mutex.Lock()
+	listing := append([]models.Post(nil), s.listings[strings.ToLower(subreddit)]...)
+	depth := s.listingDepth
+	s.mutex.Unlock()
+	listing = sortListing(listing, sortName, query.Get("t"))
+	if depth > 0 && len(listing) > depth {
+		listing = listing[:depth]
+	}
+
+	writePage(w, listing, query, limit)
+}
+
+// handleSearch serves /r/{sub}/search.json for sort=new with a cloudsearch timestamp:since..until query
+func (s *Server) handleSearch(w http.ResponseWriter, req *http.Request, subreddit string) {
+	query := req.URL.Query()
+	limit := defaultLimit
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	since, until := math.Inf(-1), math.Inf(1)
+	if rng, ok := strings.CutPrefix(query.Get("q"), "timestamp:"); ok {
+		from, to, _ := strings.Cut(rng, "..")
+		if v, err := strconv.ParseFloat(from, 64); err == nil {
+			since = v
+		}
+		if v, err := strconv.ParseFloat(to, 64); err == nil {
+			until = v
+		}
+	}
+
+	s.mutex.Lock()
+	matches := make([]models.Post, 0)
+	for _, post := range s.listings[strings.ToLower(subreddit)] {
+		if post.CreatedUTC >= since && post.CreatedUTC <= until {
+			matches = append(matches, post)
+		}
+	}
+	s.mutex.Unlock()
+
+	writePage(w, matches, query, limit)
+}
+
+// writePage writes the page of a listing selected by the after or before cursor
+func writePage(w http.ResponseWriter, listing []models.Post, query url.Values, limit int) {
+	start, end := 0, len(listing)
+	if after := query.Get("after"); after != "" {
+		start = indexOf(listing, after) + 1
+		if start == 0 {
+			// unknown cursor; reddit returns an empty page
+			start = len(listing)
+		}
+	} else if before := query.Get("before"); before != "" {
+		end = indexOf(listing, before)
+		if end < 0 {
+			end = 0
+		}
+		start = end - limit
+		if start < 0 {
+			start = 0
+		}
+	}
+	if start+limit < end {
+		end = start + limit
+	}
+
+	page := listing[start:end]
+	var afterCursor, beforeCursor interface{}
+	if end < len(listing) && len(page) > 0 {
+		afterCursor = fullname(page[len(page)-1].ID)
+	}
+	if start > 0 && len(page) > 0 {
+		beforeCursor = fullname(page[0].ID)
+	}
+
+	writeJSON(w, http.StatusOK, listingResponse(page, afterCursor, beforeCursor))
+}
+
+func (s *Server) handleComments(w http.ResponseWriter, req *http.Request, subreddit, postID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var post *models.Post
+	for _, p := range s.listings[strings.ToLower(subreddit)] {
+		if p.ID == postID {
+			p := p
+			post = &p
+			break
+		}
+	}
+	comments := s.comments[postID]
+	if post == nil && len(comments) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "Not Found", "error": 404})
+		return
+	}
+
+	var posts []models.Post
+	if post != nil {
+		posts = append(posts, *post)
+	}
+
+	children := s.renderComments(comments, fullname(postID), 0)
+	writeJSON(w, http.StatusOK, []interface{}{
+		listingResponse(posts, nil, nil),
+		map[string]interface{}{
+			"kind": "Listing",
+			"data": map[string]interface{}{"after": nil, "before": nil, "children": children},
+		},
+	})
+}
+
+func (s *Server) handleMoreChildren(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	postID := strings.TrimPrefix(query.Get("link_id"), "t3_")
+
+	s.mutex.Lock()
+	byID := make(map[string]models.Comment)
+	for _, comment := range s.comments[postID] {
+		byID[comment.ID] = comment
+	}
+	s.mutex.Unlock()
+
+	things := make([]interface{}, 0)
+	for _, id := range strings.Split(query.Get("children"), ",") {
+		if comment, ok := byID[id]; ok {
+			things = append(things, commentThing(comment, depthOf(byID, comment), ""))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"json": map[string]interface{}{
+			"errors": []interface{}{},
+			"data":   map[string]interface{}{"things": things},
+		},
+	})
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	byName := make(map[string]models.Post)
+	for _, listing := range s.listings {
+		for _, post := range listing {
+			byName[fullname(post.ID)] = post
+		}
+	}
+	s.mutex.Unlock()
+
+	var posts []models.Post
+	for _, name := range strings.Split(req.URL.Query().Get("id"), ",") {
+		if post, ok := byName[name]; ok {
+			posts = append(posts, post)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, listingResponse(posts, nil, nil))
+}
+
+// renderComments renders the replies to parent as nested things; must be called with the mutex held
+func (s *Server) renderComments(comments []models.Comment, parent string, depth int) []interface{} {
+	var replies []models.Comment
+	for _, comment := range comments {
+		if comment.ParentID == parent {
+			replies = append(replies, comment)
+		}
+	}
+
+	things := make([]interface{}, 0, len(replies))
+	for i, comment := range replies {
+		if s.morePageSize > 0 && i >= s.morePageSize {
+			// hide the rest (and their descendants) behind a "more" stub
+			var hidden []string
+			for _, rest := range replies[i:] {
+				hidden = append(hidden, rest.ID)
+				hidden = append(hidden, descendants(comments, rest.ID)...)
+			}
+			things = append(things, map[string]interface{}{
+				"kind": "more",
+				"data": map[string]interface{}{
+					"id":        hidden[0],
+					"name":      "t1_" + hidden[0],
+					"parent_id": parent,
+					"count":     len(hidden),
+					"depth":     depth,
+					"children":  hidden,
+				},
+			})
+			break
+		}
+
+		var nested interface{} = ""
+		if children := s.renderComments(comments, "t1_"+comment.ID, depth+1); len(children) > 0 {
+			nested = map[string]interface{}{
+				"kind": "Listing",
+				"data": map[string]interface{}{"after": nil, "before": nil, "children": children},
+			}
+		}
+		things = append(things, commentThing(comment, depth, nested))
+	}
+
+	return things
+}
+
+func commentThing(comment models.Comment, depth int, replies interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"kind": "t1",
+		"data": map[string]interface{}{
+			"id":          comment.ID,
+			"name":        "t1_" + comment.ID,
+			"parent_id":   comment.ParentID,
+			"link_id":     fullname(comment.PostID),
+			"subreddit":   comment.Subreddit,
+			"author":      comment.Author,
+			"body":        comment.Body,
+			"score":       comment.Score,
+			"depth":       depth,
+			"created_utc": comment.CreatedUTC,
+			"permalink":   comment.Permalink,
+			"replies":     replies,
+		},
+	}
+}
+
+// descendants returns the ids of every reply below the given comment
+func descendants(comments []models.Comment, id string) []string {
+	var ids []string
+	for _, comment := range comments {
+		if comment.ParentID == "t1_"+id {
+			ids = append(ids, comment.ID)
+			ids = append(ids, descendants(comments, comment.ID)...)
+		}
+	}
+	return ids
+}
+
+// depthOf returns how deep a comment sits in its tree (0 for top-level comments)
+func depthOf(byID map[string]models.Comment, comment models.Comment) int {
+	depth := 0
+	for strings.HasPrefix(comment.ParentID, "t1_") {
+		parent, ok := byID[strings.TrimPrefix(comment.ParentID, "t1_")]
+		if !ok {
+			break
+		}
+		comment = parent
+		depth++
+	}
+	return depth
+}
+
+func listingResponse(posts []models.Post, after, before interface{}) map[string]interface{} {
+	children := make([]map[string]interface{}, 0, len(posts))
+	for _, post := range posts {
+		children = append(children, postThing(post))
+	}
+
+	return map[string]interface{}{
+		"kind": "Listing",
+		"data": map[string]interface{}{
+			"after":    after,
+			"before":   before,
+			"dist":     len(children),
+			"children": children,
+		},
+	}
+}
+
+func postThing(post models.Post) map[string]interface{} {
+	permalink := post.Permalink
+	if permalink == "" {
+		permalink = fmt.Sprintf("/r/%s/comments/%s/", post.Subreddit, post.ID)
+	}
+
+	return map[string]interface{}{
+		"kind": "t3",
+		"data": map[string]interface{}{
+			"id":           post.ID,
+			"name":         fullname(post.ID),
+			"title":        post.Title,
+			"author":       post.Author,
+			"subreddit":    post.Subreddit,
+			"url":          post.URL,
+			"created_utc":  post.CreatedUTC,
+			"ups":          post.Upvotes,
+			"downs":        post.Downvotes,
+			"score":        post.Score,
+			"num_comments": post.NumComments,
+			"upvote_ratio": post.UpvoteRatio,
+			"post_hint":    post.PostHint,
+			"is_video":     post.IsVideo,
+			"is_self":      post.IsSelf,
+			"selftext":     post.SelfText,
+			"over_18":      post.Over18,
+			"permalink":    permalink,
+		},
+	}
+}
+
+func indexOf(listing []models.Post, name string) int {
+	id := strings.TrimPrefix(name, "t3_")
+	for i, post := range listing {
+		if post.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func fullname(id string) string {
+	return "t3_" + id
+}
+
+// compareIDs orders base36 ids numerically (shorter ids are older)
+func compareIDs(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+func bearerToken(req *http.Request) string {
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
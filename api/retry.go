@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts per call, including the first
+	BaseDelay   time.Duration // delay before the first retry; doubles on each retry
+	MaxDelay    time.Duration // upper bound for the backoff delay (Retry-After may exceed it)
+}
+
+// DefaultRetryPolicy gives up after 5 attempts, which is at most 0.5+1+2+4 = 7.5 seconds of backoff
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// WithRetryPolicy overrides the default retry policy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(r *RedditAPI) {
+		if policy.MaxAttempts < 1 {
+			policy.MaxAttempts = 1
+		}
+		r.retryPolicy = policy
+	}
+}
+
+// backoff returns the delay before the given retry (1 for the first retry) using
+// exponential backoff with full jitter
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			delay = p.MaxDelay
+			break
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
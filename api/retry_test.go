@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/brettboylen/reddit-tracker/api/redditfake"
+)
+
+var fastRetries = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestBackoffIsBounded(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for retry := 1; retry <= 10; retry++ {
+		delay := policy.backoff(retry)
+		assert.Greater(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, time.Second)
+	}
+
+	// a base delay above the maximum is capped from the first retry
+	policy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Second}
+	for i := 0; i < 20; i++ {
+		assert.LessOrEqual(t, policy.backoff(1), time.Second)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, parseRetryAfter(http.Header{"Retry-After": {"3"}}))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(http.Header{}))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(http.Header{"Retry-After": {"soon"}}))
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	delay := parseRetryAfter(http.Header{"Retry-After": {future}})
+	assert.Greater(t, delay, 50*time.Second)
+}
+
+func TestFetchPostsRetriesServerErrors(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	WithRetryPolicy(fastRetries)(r)
+	fake.InjectFault(redditfake.Fault{PathPrefix: "/r/", StatusCode: http.StatusServiceUnavailable, Times: 2})
+
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fake.RequestCount("/r/golang/new.json"))
+}
+
+func TestFetchPostsGivesUpAfterRetryBudget(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	WithRetryPolicy(fastRetries)(r)
+	fake.InjectFault(redditfake.Fault{PathPrefix: "/r/", StatusCode: http.StatusBadGateway, Times: 10})
+
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.ErrorIs(t, err, ErrServerError)
+
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.Equal(t, 3, fake.RequestCount("/r/golang/new.json"))
+}
+
+func TestFetchPostsPermanentErrors(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+		r, fake := newFakeAPI(t)
+		WithRetryPolicy(fastRetries)(r)
+		fake.InjectFault(redditfake.Fault{PathPrefix: "/r/", StatusCode: status, Times: 10})
+
+		_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+		assert.Error(t, err)
+		assert.False(t, IsRetryable(err))
+		assert.Equal(t, 1, fake.RequestCount("/r/golang/new.json"), "status %d should not be retried", status)
+	}
+}
+
+func TestFetchPostsHonoursRetryAfter(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	WithRetryPolicy(fastRetries)(r)
+	fake.InjectFault(redditfake.Fault{PathPrefix: "/r/", StatusCode: http.StatusTooManyRequests, RetryAfter: 1})
+
+	start := time.Now()
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestFetchPostsReauthenticatesOn401(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	WithRetryPolicy(fastRetries)(r)
+
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.NoError(t, err)
+
+	fake.RevokeTokens()
+
+	_, _, err = r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.RequestCount("/api/v1/access_token"))
+}
+
+func TestFetchPostsBadCredentialsArePermanent(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	WithRetryPolicy(fastRetries)(r)
+	fake.SetCredentials("other-id", "other-secret")
+
+	_, _, err := r.FetchPosts(context.Background(), "golang", 10, "")
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.Equal(t, 1, fake.RequestCount("/api/v1/access_token"))
+}
+
+func TestFetchPostsStopsOnContextCancel(t *testing.T) {
+	r, fake := newFakeAPI(t)
+	WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Minute})(r)
+	fake.InjectFault(redditfake.Fault{PathPrefix: "/r/", StatusCode: http.StatusInternalServerError, Times: 10})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := r.FetchPosts(ctx, "golang", 10, "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
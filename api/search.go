@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// SearchPostsBetween searches a subreddit for posts created between since and until, newest first,
+// using the cloudsearch timestamp syntax. Unlike listings, which stop after ~1000 posts, search
+// reaches back to a subreddit's first post. Pass the returned cursor as after to get the next page;
+// it's empty on the last page.
+func (r *RedditAPI) SearchPostsBetween(ctx context.Context, subreddit string, since, until time.Time, limit int, after string) ([]models.Post, string, error) {
+	if limit <= 0 || limit > 100 {
+		limit = defaultLimit
+	}
+
+	params := url.Values{}
+	params.Set("q", fmt.Sprintf("timestamp:%d..%d", since.Unix(), until.Unix()))
+	params.Set("syntax", "cloudsearch")
+	params.Set("restrict_sr", "on")
+	params.Set("sort", "new")
+	params.Set("t", string(models.TimeAll))
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("raw_json", "1")
+	if after != "" {
+		params.Set("after", after)
+	}
+
+	var redditResp RedditResponse
+	if err := r.getJSON(ctx, fmt.Sprintf("/r/%s/search.json", subreddit), params, &redditResp); err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	posts := make([]models.Post, 0, len(redditResp.Data.Children))
+	for _, redditPost := range redditResp.Data.Children {
+		if redditPost.Kind != "t3" {
+			continue
+		}
+		posts = append(posts, redditPost.toModel(now))
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"subreddit":  subreddit,
+		"since":      since.UTC().Format(time.RFC3339),
+		"until":      until.UTC().Format(time.RFC3339),
+		"post_count": len(posts),
+		"next_after": redditResp.Data.After,
+	}).Debug("Searched posts on Reddit")
+
+	return posts, redditResp.Data.After, nil
+}
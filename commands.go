@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/stats"
+	"github.com/brettboylen/reddit-tracker/utils"
+)
+
+// runCommand runs a one-off subcommand, e.g. `reddit-tracker coverage`, instead of the collector
+func runCommand(name string, args []string, config *utils.Config, database db.Store, log *logrus.Logger, out io.Writer) error {
+	switch name {
+	case "coverage":
+		return runCoverage(args, config.Reddit.Subreddits, database, out)
+	case "backfill":
+		redditAPI := api.NewRedditAPI(
+			config.Reddit.ClientID,
+			config.Reddit.ClientSecret,
+			config.Reddit.UserAgent,
+			config.Reddit.MaxRequestsPerMinute,
+			log,
+		)
+		collector := stats.NewCollector(redditAPI, database, config.Reddit.Subreddits, config.Reddit.PollingInterval, log,
+			stats.WithBackfill(config.Reddit.BackfillBudgetShare, config.Reddit.BackfillMaxPages, 0),
+		)
+		return runBackfill(args, collector, database, config.Reddit.BackfillMaxPages, out)
+	case "migrate":
+		sqlDatabase, ok := database.(*db.Database)
+		if !ok {
+			return fmt.Errorf("the %s store has no schema to migrate", config.Database.Driver)
+		}
+		return runMigrate(args, sqlDatabase, out)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// runCoverage prints the coverage gaps of the tracked subreddits and optionally queues backfills for them
+func runCoverage(args []string, subreddits []string, database db.Store, out io.Writer) error {
+	defaults := stats.DefaultCoverageOptions()
+
+	fs := flag.NewFlagSet("coverage", flag.ContinueOnError)
+	fs.SetOutput(out)
+	subreddit := fs.String("subreddit", "", "Only analyse this subreddit (default: every configured subreddit)")
+	window := fs.Duration("window", 7*24*time.Hour, "How far back to look")
+	since := fs.String("since", "", "Time to look back to, as 2006-01-02, RFC3339 or unix seconds; can't be combined with -window")
+	minGap := fs.Duration("min-gap", defaults.MinGap, "Ignore silences between posts shorter than this")
+	gapFactor := fs.Float64("gap-factor", defaults.GapFactor, "Flag silences this many times longer than the median time between posts")
+	schedule := fs.Bool("schedule", false, "Queue a backfill job for each gap")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *subreddit != "" {
+		subreddits = []string{*subreddit}
+	}
+
+	opts := stats.CoverageOptions{MinGap: *minGap, GapFactor: *gapFactor}
+	var err error
+	if opts.Since, err = sinceFlag(fs, *window, *since); err != nil {
+		return err
+	}
+	gaps, err := analyzeCoverage(database, subreddits, opts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBREDDIT\tSTART\tEND\tREASON\tEST. MISSING")
+	for _, gap := range gaps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n",
+			gap.Subreddit, gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339), gap.Reason, gap.EstimatedMissing)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d gaps since %s\n", len(gaps), opts.Since.UTC().Format(time.RFC3339))
+
+	if !*schedule {
+		return nil
+	}
+
+	jobs, err := stats.ScheduleCoverageBackfills(database, gaps)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d backfill jobs queued\n", len(jobs))
+
+	return nil
+}
+
+// runBackfill fetches the history of a subreddit back to a cutoff, or queues the job for the running
+// collector. The job is claimed while it runs, so a collector sharing the database leaves it alone,
+// and it spends the share of the request rate a collector would spend on it.
+func runBackfill(args []string, collector *stats.Collector, database db.Store, maxPages int, out io.Writer) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fs.SetOutput(out)
+	subreddit := fs.String("subreddit", "", "Subreddit to backfill (required)")
+	window := fs.Duration("window", 14*24*time.Hour, "How far back to go")
+	since := fs.String("since", "", "Time to go back to, as 2006-01-02, RFC3339 or unix seconds; can't be combined with -window (replaces -cutoff)")
+	pages := fs.Int("max-pages", maxPages, "Stop after this many pages of 100 posts")
+	queue := fs.Bool("queue", false, "Only queue the job for the running collector instead of running it now")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *subreddit == "" {
+		return fmt.Errorf("-subreddit is required")
+	}
+
+	cutoff, err := sinceFlag(fs, *window, *since)
+	if err != nil {
+		return err
+	}
+	job := models.BackfillJob{
+		Subreddit: *subreddit,
+		Since:     cutoff,
+		Until:     time.Now(),
+		Reason:    "backfill command",
+		MaxPages:  *pages,
+	}
+
+	// an interrupted run of the same request is checkpointed; pick it up rather than starting over
+	unfinished, err := unfinishedBackfill(database, job, *since == "")
+	if err != nil {
+		return err
+	}
+	if unfinished != nil {
+		job = *unfinished
+		fmt.Fprintf(out, "resuming backfill job %d after %d pages\n", job.ID, job.Pages)
+	} else if err := database.ScheduleBackfill(&job); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "backfill job %d: %s since %s\n", job.ID, job.Subreddit, job.Since.Format(time.RFC3339))
+	if *queue {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = collector.RunBackfill(ctx, &job)
+	if errors.Is(err, db.ErrBackfillJobHeld) {
+		return fmt.Errorf("%w; a running collector may be working on it, so rerun with -queue or later", err)
+	}
+	fmt.Fprintf(out, "backfill job %d %s after %d pages: %d posts saved\n", job.ID, job.Status, job.Pages, job.Posts)
+	if job.Error != "" && err == nil {
+		fmt.Fprintln(out, job.Error)
+	}
+
+	return err
+}
+
+// runMigrate applies or rolls back schema migrations, or lists them: `migrate up|down|status`
+func runMigrate(args []string, database *db.Database, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+
+	switch args[0] {
+	case "up":
+		to := fs.Int("to", 0, "Stop after this version (default: apply every pending migration)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		applied, err := database.MigrateUp(*to)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migrations applied\n", len(applied))
+	case "down":
+		steps := fs.Int("steps", 1, "How many migrations to roll back")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		reverted, err := database.MigrateDown(*steps)
+		for _, m := range reverted {
+			fmt.Fprintf(out, "rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d migrations rolled back\n", len(reverted))
+	case "status":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		statuses, err := database.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", ""
+			if status.Applied {
+				state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q: expected up, down or status", args[0])
+	}
+
+	return nil
+}
+
+// unfinishedBackfill returns the oldest pending or running backfill job for the same request as
+// the given one, if any: the same subreddit and page limit, going back to the same cutoff or, when
+// relative, as far back from when the job was queued
+func unfinishedBackfill(database db.Store, request models.BackfillJob, relative bool) (*models.BackfillJob, error) {
+	jobs, err := database.GetBackfillJobs("")
+	if err != nil {
+		return nil, err
+	}
+
+	span := request.Until.Unix() - request.Since.Unix()
+	for _, job := range jobs {
+		if !strings.EqualFold(job.Subreddit, request.Subreddit) || job.MaxPages != request.MaxPages {
+			continue
+		}
+		if job.Status != models.BackfillPending && job.Status != models.BackfillRunning {
+			continue
+		}
+		if relative && job.Until.Unix()-job.Since.Unix() == span || !relative && job.Since.Unix() == request.Since.Unix() {
+			return &job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// sinceFlag returns the start of a command's range: the -since time when it's set, otherwise
+// -window back from now
+func sinceFlag(fs *flag.FlagSet, window time.Duration, since string) (time.Time, error) {
+	windowSet := false
+	fs.Visit(func(f *flag.Flag) {
+		windowSet = windowSet || f.Name == "window"
+	})
+
+	switch {
+	case since != "" && windowSet:
+		return time.Time{}, fmt.Errorf("-window can't be combined with -since")
+	case since != "":
+		if t, err := time.Parse("2006-01-02", since); err == nil {
+			return t, nil
+		}
+		t, err := parseTimeParam(since)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid -since %q: expected 2006-01-02, RFC3339 or unix seconds", since)
+		}
+		return t, nil
+	case window <= 0:
+		return time.Time{}, fmt.Errorf("-window must be positive")
+	}
+	return time.Now().Add(-window), nil
+}
+
+// analyzeCoverage runs the coverage analysis for each subreddit
+func analyzeCoverage(database db.Store, subreddits []string, opts stats.CoverageOptions) ([]models.CoverageGap, error) {
+	gaps := make([]models.CoverageGap, 0)
+	for _, subreddit := range subreddits {
+		subredditGaps, err := stats.AnalyzeCoverage(database, subreddit, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyse coverage of %s: %w", subreddit, err)
+		}
+		gaps = append(gaps, subredditGaps...)
+	}
+
+	return gaps, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/stats"
+	"github.com/brettboylen/reddit-tracker/utils"
+)
+
+func TestRunCoverage(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	now := time.Now()
+	require.NoError(t, database.LogPoll(models.PollRecord{Subreddit: "golang", PolledAt: now.Add(-time.Hour),
+		CoveredFrom: now.Add(-2 * time.Hour), GapStart: now.Add(-4 * time.Hour), GapEnd: now.Add(-2 * time.Hour)}))
+
+	var out bytes.Buffer
+	require.NoError(t, runCoverage([]string{"-window", "24h", "-schedule"}, []string{"golang"}, database, &out))
+	assert.Contains(t, out.String(), "poll_truncated")
+	assert.Contains(t, out.String(), "1 gaps since")
+	assert.Contains(t, out.String(), "1 backfill jobs queued")
+
+	err = runCoverage([]string{"-window", "24h", "-since", "2024-01-01"}, []string{"golang"}, database, &out)
+	assert.ErrorContains(t, err, "-window can't be combined with -since")
+	err = runCoverage([]string{"-since", "24h"}, []string{"golang"}, database, &out)
+	assert.ErrorContains(t, err, "invalid -since")
+
+	jobs, err := database.GetBackfillJobs(models.BackfillPending)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1)
+}
+
+func TestRunBackfill(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, runBackfill([]string{"-subreddit", "golang", "-window", "24h", "-queue"}, nil, database, 10, &out))
+	assert.NotContains(t, out.String(), "resuming")
+
+	// the same request resumes the job, however much later it's made
+	time.Sleep(time.Second)
+	out.Reset()
+	require.NoError(t, runBackfill([]string{"-subreddit", "golang", "-window", "24h", "-queue"}, nil, database, 10, &out))
+	assert.Contains(t, out.String(), "resuming backfill job 1")
+
+	// a different range or page limit is a job of its own
+	for _, args := range [][]string{{"-window", "48h"}, {"-max-pages", "3"}, {"-since", "2024-01-01"}} {
+		out.Reset()
+		args = append([]string{"-subreddit", "golang", "-queue"}, args...)
+		require.NoError(t, runBackfill(args, nil, database, 10, &out))
+		assert.NotContains(t, out.String(), "resuming", args)
+	}
+
+	jobs, err := database.GetBackfillJobs(models.BackfillPending)
+	require.NoError(t, err)
+	require.Len(t, jobs, 4)
+
+	err = runBackfill([]string{"-subreddit", "golang", "-window", "24h", "-since", "2024-01-01", "-queue"}, nil, database, 10, &out)
+	assert.ErrorContains(t, err, "-window can't be combined with -since")
+	err = runBackfill([]string{"-subreddit", "golang", "-since", "24h", "-queue"}, nil, database, 10, &out)
+	assert.ErrorContains(t, err, "invalid -since")
+
+	// a job a collector holds isn't run alongside it
+	_, err = database.ClaimBackfillJob(jobs[0].ID, time.Now(), time.Hour)
+	require.NoError(t, err)
+
+	redditAPI := api.NewRedditAPI("id", "secret", "test-agent", 60, log)
+	collector := stats.NewCollector(redditAPI, database, []string{"golang"}, 60, log, stats.WithBackfill(0.1, 10, 0))
+	err = runBackfill([]string{"-subreddit", "golang", "-window", "24h"}, collector, database, 10, &out)
+	assert.ErrorIs(t, err, db.ErrBackfillJobHeld)
+}
+
+func TestRunMigrate(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	database, err := db.OpenDatabase(db.DriverSQLite, filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, runMigrate([]string{"status"}, database, &out))
+	assert.Regexp(t, `0001\s+initial_schema\s+pending`, out.String())
+
+	out.Reset()
+	require.NoError(t, runMigrate([]string{"up"}, database, &out))
+	assert.Contains(t, out.String(), "applied 0001_initial_schema")
+
+	out.Reset()
+	require.NoError(t, runMigrate([]string{"down", "-steps", "1"}, database, &out))
+	assert.Contains(t, out.String(), "1 migrations rolled back")
+
+	assert.Error(t, runMigrate([]string{"sideways"}, database, &out))
+
+	// the memory store has no schema
+	config := &utils.Config{Database: utils.DatabaseConfig{Driver: db.DriverMemory}}
+	err = runCommand("migrate", []string{"status"}, config, db.NewMemoryStore(), log, &out)
+	assert.ErrorContains(t, err, "no schema to migrate")
+}
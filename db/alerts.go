@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const defaultAlertsLimit = 100
+
+// SaveAlerts records alerts in one transaction and returns the ones that are new. An alert for a
+// rule that already fired for the same post is skipped, so each rule fires at most once per post.
+func (d *Database) SaveAlerts(alerts []models.Alert) ([]models.Alert, error) {
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := d.prepare(tx, `
+	INSERT INTO alerts (
+		rule, post_id, subreddit, title, author, permalink, score, num_comments, matched, fired_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT DO NOTHING
+	RETURNING id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare alert insert: %w", err)
+	}
+	defer stmt.Close()
+
+	fired := make([]models.Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		err := stmt.QueryRow(
+			alert.Rule, alert.PostID, alert.Subreddit, alert.Title, alert.Author, alert.Permalink,
+			alert.Score, alert.NumComments, alert.Matched, alert.FiredAt.Unix(),
+		).Scan(&alert.ID)
+		// no row comes back when the rule already fired for the post
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save alert %s for post %s: %w", alert.Rule, alert.PostID, err)
+		}
+		fired = append(fired, alert)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit alerts: %w", err)
+	}
+
+	return fired, nil
+}
+
+// GetAlerts returns the alerts that match filter, newest first; at most 100 unless filter sets a limit
+func (d *Database) GetAlerts(filter models.AlertFilter) ([]models.Alert, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var conditions []string
+	var args []interface{}
+	if filter.Rule != "" {
+		conditions = append(conditions, "rule = ?")
+		args = append(args, filter.Rule)
+	}
+	if filter.Subreddit != "" {
+		conditions = append(conditions, "subreddit = ? COLLATE NOCASE")
+		args = append(args, filter.Subreddit)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "fired_at >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAlertsLimit
+	}
+	args = append(args, limit)
+
+	rows, err := d.query(`
+	SELECT id, rule, post_id, subreddit, title, author, permalink, score, num_comments, matched, fired_at
+	FROM alerts
+	`+where+`
+	ORDER BY fired_at DESC, id DESC
+	LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := make([]models.Alert, 0)
+	for rows.Next() {
+		var alert models.Alert
+		var firedAt int64
+		if err := rows.Scan(
+			&alert.ID, &alert.Rule, &alert.PostID, &alert.Subreddit, &alert.Title, &alert.Author,
+			&alert.Permalink, &alert.Score, &alert.NumComments, &alert.Matched, &firedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alert.FiredAt = time.Unix(firedAt, 0)
+		alerts = append(alerts, alert)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// ErrAuthorNotFound is returned when an author has no posts stored, or is left out of author rankings
+var ErrAuthorNotFound = errors.New("author not found")
+
+const defaultAuthorsLimit = 25
+
+// AuthorQuery selects and ranks authors by the posts that match Filter
+type AuthorQuery struct {
+	Filter   PostFilter
+	Sort     models.AuthorSort // posts unless set
+	MinPosts int               // leave out authors with fewer posts, e.g. to keep one lucky post from topping avg_score
+	Limit    int               // 25 unless set
+}
+
+// authorOrder maps each ranking to its ORDER BY clause; ties go to the author with more posts, then by name.
+// Expressions repeat the aggregates rather than their aliases, which PostgreSQL doesn't allow in them.
+var authorOrder = map[models.AuthorSort]string{
+	models.SortAuthorsByPosts:    "post_count DESC, author ASC",
+	models.SortAuthorsByScore:    "total_score DESC, post_count DESC, author ASC",
+	models.SortAuthorsByComments: "total_comments DESC, post_count DESC, author ASC",
+	models.SortAuthorsByAvgScore: "CAST(COALESCE(SUM(score), 0) AS DOUBLE PRECISION) / COUNT(*) DESC, post_count DESC, author ASC",
+}
+
+const authorAggregates = `author, COUNT(*) AS post_count, COALESCE(SUM(score), 0) AS total_score,
+	COALESCE(SUM(num_comments), 0) AS total_comments, COUNT(DISTINCT LOWER(subreddit)), MIN(created_utc), MAX(created_utc)`
+
+// authorWhere adds the exclusion of [deleted], AutoModerator and the like to a filter's WHERE clause
+func authorWhere(filter PostFilter) (string, []interface{}) {
+	where, args := filter.where()
+
+	excluded := models.ExcludedAuthors()
+	condition := "LOWER(author) NOT IN (?" + strings.Repeat(", ?", len(excluded)-1) + ")"
+	for _, name := range excluded {
+		args = append(args, name)
+	}
+
+	if where == "" {
+		return "WHERE " + condition, args
+	}
+	return where + " AND " + condition, args
+}
+
+// GetAuthors ranks the authors of the posts that match the query
+func (d *Database) GetAuthors(query AuthorQuery) ([]models.AuthorStats, error) {
+	sort := query.Sort
+	if sort == "" {
+		sort = models.SortAuthorsByPosts
+	}
+	order, ok := authorOrder[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown author sort %q", sort)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultAuthorsLimit
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	where, args := authorWhere(query.Filter)
+	args = append(args, max(query.MinPosts, 1), limit)
+
+	rows, err := d.query(`
+	SELECT `+authorAggregates+`
+	FROM posts
+	`+where+`
+	GROUP BY author
+	HAVING COUNT(*) >= ?
+	ORDER BY `+order+`
+	LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query authors: %w", err)
+	}
+	defer rows.Close()
+
+	authors := make([]models.AuthorStats, 0)
+	for rows.Next() {
+		author, err := scanAuthorStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		author.Rank = len(authors) + 1
+		authors = append(authors, author)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return authors, nil
+}
+
+// GetAuthor returns the statistics of one author's posts that match the filter, with a breakdown by
+// subreddit. It returns ErrAuthorNotFound if there are none.
+func (d *Database) GetAuthor(name string, filter PostFilter) (*models.AuthorStats, error) {
+	if models.IsExcludedAuthor(name) {
+		return nil, ErrAuthorNotFound
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	where, args := authorWhere(filter)
+	where += " AND author = ? COLLATE NOCASE"
+	args = append(args, name)
+
+	row := d.queryRow(`
+	SELECT `+authorAggregates+`
+	FROM posts
+	`+where+`
+	GROUP BY author
+	ORDER BY post_count DESC
+	LIMIT 1
+	`, args...)
+
+	author, err := scanAuthorStats(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAuthorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.query(`
+	SELECT MIN(subreddit) AS display_name, COUNT(*) AS post_count, COALESCE(SUM(score), 0)
+	FROM posts
+	`+where+`
+	GROUP BY LOWER(subreddit)
+	ORDER BY post_count DESC, display_name ASC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subreddits of %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	author.Subreddits = make([]models.SubredditPostCount, 0, author.SubredditCount)
+	for rows.Next() {
+		var sub models.SubredditPostCount
+		if err := rows.Scan(&sub.Subreddit, &sub.PostCount, &sub.TotalScore); err != nil {
+			return nil, fmt.Errorf("failed to scan subreddit post count: %w", err)
+		}
+		author.Subreddits = append(author.Subreddits, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return &author, nil
+}
+
+// scanAuthorStats scans the columns of authorAggregates and derives the averages and cadence
+func scanAuthorStats(row rowScanner) (models.AuthorStats, error) {
+	var author models.AuthorStats
+	var first, last float64
+	if err := row.Scan(
+		&author.Author, &author.PostCount, &author.TotalScore, &author.TotalComments, &author.SubredditCount,
+		&first, &last,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return author, err
+		}
+		return author, fmt.Errorf("failed to scan author: %w", err)
+	}
+
+	deriveAuthorStats(&author, first, last)
+	return author, nil
+}
+
+// deriveAuthorStats fills in the averages and cadence of an author from the totals and the
+// creation times of their first and last posts
+func deriveAuthorStats(author *models.AuthorStats, first, last float64) {
+	author.AvgScore = float64(author.TotalScore) / float64(author.PostCount)
+	author.AvgComments = float64(author.TotalComments) / float64(author.PostCount)
+	author.FirstSeen = time.Unix(0, int64(first*1e9)).UTC()
+	author.LastSeen = time.Unix(0, int64(last*1e9)).UTC()
+
+	span := author.LastSeen.Sub(author.FirstSeen)
+	author.PostsPerDay = float64(author.PostCount) / max(span.Hours()/24, 1)
+	if author.PostCount > 1 {
+		author.AvgHoursBetweenPosts = span.Hours() / float64(author.PostCount-1)
+	}
+}
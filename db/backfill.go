@@ -0,0 +1,228 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// Errors of claiming and checkpointing backfill jobs
+var (
+	ErrNoBackfillJobs    = errors.New("no backfill jobs queued")
+	ErrBackfillJobHeld   = errors.New("backfill job is finished or held by another worker")
+	ErrBackfillLeaseLost = errors.New("backfill job was claimed by another worker")
+)
+
+// ScheduleBackfill queues a backfill job and sets its id, status and timestamps. A pending, running
+// or done job for the same subreddit and range is returned instead of being added twice; a failed
+// one is queued again from the start, with the new reason and page limit.
+func (d *Database) ScheduleBackfill(job *models.BackfillJob) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	_, err := d.exec(`
+	INSERT INTO backfill_jobs (
+		subreddit, since_utc, until_utc, reason, status, max_pages, source, created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (subreddit, since_utc, until_utc) DO UPDATE SET
+		reason = excluded.reason, status = excluded.status, max_pages = excluded.max_pages,
+		source = excluded.source, cursor = '', pages = 0, posts = 0, oldest_utc = 0, error = '',
+		lease_until = 0, updated_at = excluded.updated_at
+	WHERE backfill_jobs.status = ?
+	`,
+		job.Subreddit, job.Since.Unix(), job.Until.Unix(), job.Reason, string(models.BackfillPending),
+		job.MaxPages, string(models.BackfillFromListing), now.Unix(), now.Unix(),
+		string(models.BackfillFailed),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to schedule backfill of %s: %w", job.Subreddit, err)
+	}
+
+	row := d.queryRow(`
+	SELECT `+backfillColumns+`
+	FROM backfill_jobs
+	WHERE subreddit = ? AND since_utc = ? AND until_utc = ?
+	`, job.Subreddit, job.Since.Unix(), job.Until.Unix())
+
+	scheduled, err := scanBackfillJob(row)
+	if err != nil {
+		return fmt.Errorf("failed to read back backfill job: %w", err)
+	}
+	*job = scheduled
+
+	return nil
+}
+
+// ClaimNextBackfillJob claims the job to work on next for lease: an abandoned running job first,
+// then the oldest pending one. A running job is abandoned when its lease has run out. It returns
+// ErrNoBackfillJobs when there's nothing to claim.
+func (d *Database) ClaimNextBackfillJob(now time.Time, lease time.Duration) (*models.BackfillJob, error) {
+	job, err := d.claimBackfillJob(0, now, lease)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoBackfillJobs
+	}
+	return job, err
+}
+
+// ClaimBackfillJob claims a pending or abandoned job for lease. It returns ErrBackfillJobHeld when
+// the job is finished or another worker holds it.
+func (d *Database) ClaimBackfillJob(id int64, now time.Time, lease time.Duration) (*models.BackfillJob, error) {
+	job, err := d.claimBackfillJob(id, now, lease)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: job %d", ErrBackfillJobHeld, id)
+	}
+	return job, err
+}
+
+// claimBackfillJob marks the next claimable job, or the one with the given id, as running until
+// the lease runs out, in one statement so that two workers never claim the same job
+func (d *Database) claimBackfillJob(id int64, now time.Time, lease time.Duration) (*models.BackfillJob, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	running, pending := string(models.BackfillRunning), string(models.BackfillPending)
+	row := d.queryRow(`
+	UPDATE backfill_jobs
+	SET status = ?, lease_until = ?, updated_at = ?
+	WHERE id = (
+		SELECT id
+		FROM backfill_jobs
+		WHERE (status = ? OR (status = ? AND lease_until <= ?)) AND (? = 0 OR id = ?)
+		ORDER BY status = ? DESC, id ASC
+		LIMIT 1
+		`+d.dialect.skipLocked+`
+	)
+	RETURNING `+backfillColumns,
+		running, now.Add(lease).Unix(), now.Unix(),
+		pending, running, now.Unix(), id, id,
+		running,
+	)
+
+	job, err := scanBackfillJob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to claim backfill job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// UpdateBackfillJob saves a job's status and checkpoint and renews its lease for another lease
+// from now; a finished job's lease is dropped, and a lease of 0 releases a running job to any
+// worker. It fails with ErrBackfillLeaseLost if another worker claimed the job since its lease
+// was last renewed.
+func (d *Database) UpdateBackfillJob(job *models.BackfillJob, lease time.Duration) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	leaseUntil := backfillLeaseUntil(job, now, lease)
+
+	result, err := d.exec(`
+	UPDATE backfill_jobs
+	SET status = ?, source = ?, cursor = ?, pages = ?, posts = ?, oldest_utc = ?, error = ?, updated_at = ?,
+		lease_until = ?
+	WHERE id = ? AND lease_until = ?
+	`,
+		string(job.Status), string(job.Source), job.Cursor, job.Pages, job.Posts, unixOrZero(job.Oldest), job.Error,
+		now.Unix(), unixOrZero(leaseUntil), job.ID, unixOrZero(job.LeaseUntil),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update backfill job %d: %w", job.ID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: job %d", ErrBackfillLeaseLost, job.ID)
+	}
+
+	job.UpdatedAt, job.LeaseUntil = now, leaseUntil
+	return nil
+}
+
+// backfillLeaseUntil is the lease a job is saved with: none once it's finished
+func backfillLeaseUntil(job *models.BackfillJob, now time.Time, lease time.Duration) time.Time {
+	if job.Status != models.BackfillPending && job.Status != models.BackfillRunning {
+		return time.Time{}
+	}
+	return now.Add(lease).Truncate(time.Second)
+}
+
+// unixOrZero is a time in unix seconds, with 0 for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// GetBackfillJobs returns the backfill jobs with the given status, or every job when status
+// is empty, oldest first
+func (d *Database) GetBackfillJobs(status models.BackfillStatus) ([]models.BackfillJob, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	query := `
+	SELECT ` + backfillColumns + `
+	FROM backfill_jobs
+	WHERE ? = '' OR status = ?
+	ORDER BY id ASC
+	`
+
+	rows, err := d.query(query, string(status), string(status))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query backfill jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]models.BackfillJob, 0)
+	for rows.Next() {
+		job, err := scanBackfillJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan backfill job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return jobs, nil
+}
+
+const backfillColumns = `id, subreddit, since_utc, until_utc, reason, status, max_pages, created_at, updated_at,
+		source, cursor, pages, posts, oldest_utc, error, lease_until`
+
+// scanBackfillJob scans a row selected with backfillColumns
+func scanBackfillJob(row rowScanner) (models.BackfillJob, error) {
+	var job models.BackfillJob
+	var since, until, createdAt, updatedAt, oldest, leaseUntil int64
+	var status, source string
+
+	err := row.Scan(
+		&job.ID, &job.Subreddit, &since, &until, &job.Reason, &status, &job.MaxPages, &createdAt, &updatedAt,
+		&source, &job.Cursor, &job.Pages, &job.Posts, &oldest, &job.Error, &leaseUntil,
+	)
+	if err != nil {
+		return job, err
+	}
+
+	job.Since = time.Unix(since, 0).UTC()
+	job.Until = time.Unix(until, 0).UTC()
+	job.Status = models.BackfillStatus(status)
+	job.Source = models.BackfillSource(source)
+	job.CreatedAt = time.Unix(createdAt, 0).UTC()
+	job.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	if oldest > 0 {
+		job.Oldest = time.Unix(oldest, 0).UTC()
+	}
+	if leaseUntil > 0 {
+		job.LeaseUntil = time.Unix(leaseUntil, 0).UTC()
+	}
+	return job, nil
+}
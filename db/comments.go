@@ -0,0 +1,127 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// SaveComments saves a batch of comments in a single transaction, replacing any existing rows
+func (d *Database) SaveComments(comments []models.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := d.prepare(tx, `
+	INSERT INTO comments (
+		id, post_id, parent_id, subreddit, author, body, score, depth,
+		created_utc, created_at, permalink, processed_time
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET
+		post_id = excluded.post_id, parent_id = excluded.parent_id, subreddit = excluded.subreddit,
+		author = excluded.author, body = excluded.body, score = excluded.score, depth = excluded.depth,
+		created_utc = excluded.created_utc, created_at = excluded.created_at, permalink = excluded.permalink,
+		processed_time = excluded.processed_time
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare comment insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, comment := range comments {
+		_, err := stmt.Exec(
+			comment.ID, comment.PostID, comment.ParentID, comment.Subreddit,
+			comment.Author, comment.Body, comment.Score, comment.Depth,
+			comment.CreatedUTC, comment.CreatedAt.Unix(), comment.Permalink, comment.ProcessedTime.Unix(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save comment %s: %w", comment.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit comments: %w", err)
+	}
+
+	return nil
+}
+
+// GetCommentsByPost returns the stored comments of a post created in [since, until), oldest first;
+// zero times don't bound the range
+func (d *Database) GetCommentsByPost(postID string, since, until time.Time) ([]models.Comment, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	conditions, args := appendRange([]string{"post_id = ?"}, []interface{}{postID}, "created_utc", since, until)
+	query := `
+	SELECT id, post_id, parent_id, subreddit, author, body, score, depth,
+		created_utc, created_at, permalink, processed_time
+	FROM comments
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_utc ASC, id ASC
+	`
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comments for post %s: %w", postID, err)
+	}
+	defer rows.Close()
+
+	comments := make([]models.Comment, 0)
+	for rows.Next() {
+		var comment models.Comment
+		var createdAt, processedTime int64
+
+		err := rows.Scan(
+			&comment.ID, &comment.PostID, &comment.ParentID, &comment.Subreddit,
+			&comment.Author, &comment.Body, &comment.Score, &comment.Depth,
+			&comment.CreatedUTC, &createdAt, &comment.Permalink, &processedTime,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+
+		comment.CreatedAt = time.Unix(createdAt, 0).UTC()
+		comment.ProcessedTime = time.Unix(processedTime, 0).UTC()
+		comments = append(comments, comment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return comments, nil
+}
+
+// GetActivePosts returns up to limit posts created since the given time that have comments,
+// most commented first
+func (d *Database) GetActivePosts(since time.Time, limit int) ([]models.Post, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	query := `
+	SELECT ` + postColumns + `
+	FROM posts
+	WHERE created_utc >= ? AND num_comments > 0
+	ORDER BY num_comments DESC
+	LIMIT ?
+	`
+
+	posts, err := d.queryPosts(query, float64(since.Unix()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active posts: %w", err)
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// LogPoll records the outcome of one poll of a subreddit's new listing
+func (d *Database) LogPoll(record models.PollRecord) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, err := d.exec(`
+	INSERT INTO poll_log (
+		subreddit, polled_at, pages, posts, new_posts, caught_up, covered_from, gap_start, gap_end, error
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		record.Subreddit, record.PolledAt.Unix(), record.Pages, record.Posts, record.NewPosts,
+		record.CaughtUp, nullUnix(record.CoveredFrom), nullUnix(record.GapStart), nullUnix(record.GapEnd), record.Error,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to log poll of %s: %w", record.Subreddit, err)
+	}
+
+	return nil
+}
+
+// PrunePollLog deletes poll records older than before
+func (d *Database) PrunePollLog(before time.Time) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, err := d.exec(`DELETE FROM poll_log WHERE polled_at < ?`, before.Unix()); err != nil {
+		return fmt.Errorf("failed to prune poll log: %w", err)
+	}
+
+	return nil
+}
+
+// GetPollLog returns the polls of a subreddit since the given time, oldest first
+func (d *Database) GetPollLog(subreddit string, since time.Time) ([]models.PollRecord, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	query := `
+	SELECT subreddit, polled_at, pages, posts, new_posts, caught_up, covered_from, gap_start, gap_end, error
+	FROM poll_log
+	WHERE subreddit = ? COLLATE NOCASE AND polled_at >= ?
+	ORDER BY polled_at ASC, id ASC
+	`
+
+	rows, err := d.query(query, subreddit, since.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query poll log of %s: %w", subreddit, err)
+	}
+	defer rows.Close()
+
+	records := make([]models.PollRecord, 0)
+	for rows.Next() {
+		var record models.PollRecord
+		var polledAt int64
+		var coveredFrom, gapStart, gapEnd sql.NullInt64
+
+		err := rows.Scan(
+			&record.Subreddit, &polledAt, &record.Pages, &record.Posts, &record.NewPosts,
+			&record.CaughtUp, &coveredFrom, &gapStart, &gapEnd, &record.Error,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan poll record: %w", err)
+		}
+
+		record.PolledAt = time.Unix(polledAt, 0).UTC()
+		record.CoveredFrom = fromNullUnix(coveredFrom)
+		record.GapStart = fromNullUnix(gapStart)
+		record.GapEnd = fromNullUnix(gapEnd)
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return records, nil
+}
+
+// GetPostTimeline returns the id and creation time of every post of a subreddit created since
+// the given time, oldest first
+func (d *Database) GetPostTimeline(subreddit string, since time.Time) ([]models.PostRef, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	query := `
+	SELECT id, created_utc
+	FROM posts
+	WHERE subreddit = ? COLLATE NOCASE AND created_utc >= ?
+	ORDER BY created_utc ASC
+	`
+
+	rows, err := d.query(query, subreddit, float64(since.Unix()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post timeline of %s: %w", subreddit, err)
+	}
+	defer rows.Close()
+
+	refs := make([]models.PostRef, 0)
+	for rows.Next() {
+		var ref models.PostRef
+		if err := rows.Scan(&ref.ID, &ref.CreatedUTC); err != nil {
+			return nil, fmt.Errorf("failed to scan post: %w", err)
+		}
+		refs = append(refs, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return refs, nil
+}
+
+// nullUnix stores a zero time as NULL
+func nullUnix(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+func fromNullUnix(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.Unix(v.Int64, 0).UTC()
+}
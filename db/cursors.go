@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetFrontier returns the id of the newest post seen in a subreddit's new listing, or "" if
+// the subreddit hasn't been polled yet
+func (d *Database) GetFrontier(subreddit string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var newestID string
+	err := d.queryRow(`SELECT newest_id FROM subreddit_cursors WHERE subreddit = ?`, subreddit).Scan(&newestID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query frontier of %s: %w", subreddit, err)
+	}
+
+	return newestID, nil
+}
+
+// SaveFrontier records the id of the newest post seen in a subreddit's new listing
+func (d *Database) SaveFrontier(subreddit, newestID string, updatedAt time.Time) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, err := d.exec(`
+	INSERT INTO subreddit_cursors (subreddit, newest_id, updated_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT (subreddit) DO UPDATE SET newest_id = excluded.newest_id, updated_at = excluded.updated_at
+	`, subreddit, newestID, updatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save frontier of %s: %w", subreddit, err)
+	}
+
+	return nil
+}
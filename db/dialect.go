@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect holds what differs between the SQL databases the tracker runs on. Queries are written
+// once, in the SQL both SQLite and PostgreSQL accept, with ? placeholders that are rebound for
+// drivers that number them.
+type dialect struct {
+	name string // the database/sql driver name
+	// numbered placeholders: $1, $2, ... instead of ?
+	numbered bool
+	// lock is run at the start of every migration transaction, so instances sharing a database
+	// don't migrate it at the same time
+	lock string
+	// skipLocked ends the SELECT of rows being claimed, so instances claiming at the same time take
+	// different rows; SQLite runs one write at a time and needs none
+	skipLocked string
+}
+
+var (
+	sqliteDialect   = dialect{name: "sqlite3"}
+	postgresDialect = dialect{
+		name:       "postgres",
+		numbered:   true,
+		lock:       "SELECT pg_advisory_xact_lock(7270696)",
+		skipLocked: "FOR UPDATE SKIP LOCKED",
+	}
+)
+
+// dialectFor returns the dialect of a database/sql driver name
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case "sqlite3", "sqlite":
+		return sqliteDialect, nil
+	case "postgres", "postgresql":
+		return postgresDialect, nil
+	default:
+		return dialect{}, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// rebind rewrites the ? placeholders of a query for the dialect, leaving quoted strings alone
+func (dl dialect) rebind(query string) string {
+	if !dl.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+			b.WriteByte(c)
+		case c == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
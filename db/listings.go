@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// SaveListingRanks saves the post positions of one or more listing snapshots in a single transaction
+func (d *Database) SaveListingRanks(ranks []models.ListingRank) error {
+	if len(ranks) == 0 {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := d.prepare(tx, `
+	INSERT INTO listing_ranks (
+		subreddit, listing, time_window, snapshot_at, rank, post_id, score
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (subreddit, listing, time_window, snapshot_at, rank) DO UPDATE SET
+		post_id = excluded.post_id, score = excluded.score
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare listing rank insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, rank := range ranks {
+		_, err := stmt.Exec(
+			rank.Subreddit, string(rank.Listing), string(rank.Time),
+			rank.SnapshotAt.Unix(), rank.Rank, rank.PostID, rank.Score,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save listing rank of post %s: %w", rank.PostID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit listing ranks: %w", err)
+	}
+
+	return nil
+}
+
+// GetPostRanks returns every recorded listing position of a post, oldest snapshot first
+func (d *Database) GetPostRanks(postID string) ([]models.ListingRank, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	query := `
+	SELECT subreddit, listing, time_window, snapshot_at, rank, post_id, score
+	FROM listing_ranks
+	WHERE post_id = ?
+	ORDER BY snapshot_at ASC, listing ASC, time_window ASC
+	`
+
+	rows, err := d.query(query, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listing ranks for post %s: %w", postID, err)
+	}
+	defer rows.Close()
+
+	ranks := make([]models.ListingRank, 0)
+	for rows.Next() {
+		var rank models.ListingRank
+		var snapshotAt int64
+
+		err := rows.Scan(
+			&rank.Subreddit, &rank.Listing, &rank.Time, &snapshotAt,
+			&rank.Rank, &rank.PostID, &rank.Score,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan listing rank: %w", err)
+		}
+
+		rank.SnapshotAt = time.Unix(snapshotAt, 0).UTC()
+		ranks = append(ranks, rank)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return ranks, nil
+}
@@ -0,0 +1,1074 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// MemoryStore keeps everything in memory, for tests and for runs whose data doesn't need to outlive
+// them. It answers every query the way Database does, including storing times to the second.
+type MemoryStore struct {
+	mutex sync.RWMutex
+
+	posts     map[string]models.Post
+	snapshots map[string][]models.PostSnapshot // by post id, oldest first
+	comments  map[string]models.Comment
+	ranks     map[rankKey]models.ListingRank
+	refreshes map[string]models.PostRefresh
+	frontiers map[string]string
+	tracked   map[string]models.TrackedSubreddit // keyed by lowercase name
+
+	polls      []memoryPoll
+	jobs       []models.BackfillJob
+	alerts     []models.Alert
+	deliveries []models.WebhookDelivery
+	crossings  []models.ScoreCrossing
+	lastID     int64
+}
+
+// rankKey identifies one position in one listing snapshot
+type rankKey struct {
+	subreddit, listing, window string
+	snapshotAt                 int64
+	rank                       int
+}
+
+// memoryPoll is a poll record with the id that orders records polled in the same second
+type memoryPoll struct {
+	id     int64
+	record models.PollRecord
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		posts:     make(map[string]models.Post),
+		snapshots: make(map[string][]models.PostSnapshot),
+		comments:  make(map[string]models.Comment),
+		ranks:     make(map[rankKey]models.ListingRank),
+		refreshes: make(map[string]models.PostRefresh),
+		frontiers: make(map[string]string),
+		tracked:   make(map[string]models.TrackedSubreddit),
+	}
+}
+
+// Close does nothing; the data goes when the store does
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// nextID returns the next row id; callers hold the mutex
+func (m *MemoryStore) nextID() int64 {
+	m.lastID++
+	return m.lastID
+}
+
+// toSecond drops what's below a second, as the SQL stores do for the times they keep as unix seconds
+func toSecond(t time.Time) time.Time {
+	return time.Unix(t.Unix(), 0).UTC()
+}
+
+// limited returns at most limit items; a negative limit returns every one, as LIMIT does in SQLite
+func limited[T any](items []T, limit int) []T {
+	if limit >= 0 && len(items) > limit {
+		return items[:limit]
+	}
+	return items
+}
+
+// SavePost saves a post and records a snapshot of its score if it changed
+func (m *MemoryStore) SavePost(post *models.Post) error {
+	return m.SavePosts([]models.Post{*post})
+}
+
+// SavePosts saves a batch of posts and records a snapshot of each post's score if it changed
+func (m *MemoryStore) SavePosts(posts []models.Post) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, post := range posts {
+		post.CreatedAt, post.ProcessedTime = toSecond(post.CreatedAt), toSecond(post.ProcessedTime)
+		if post.Domain == "" {
+			post.Domain = post.LinkDomain()
+		}
+		m.posts[post.ID] = post
+
+		history := m.snapshots[post.ID]
+		if n := len(history); n > 0 {
+			latest := history[n-1]
+			if latest.Score == post.Score && latest.Upvotes == post.Upvotes &&
+				latest.NumComments == post.NumComments && latest.UpvoteRatio == post.UpvoteRatio {
+				continue
+			}
+		}
+
+		observedAt := post.ProcessedTime
+		if observedAt.IsZero() {
+			observedAt = time.Now()
+		}
+		snapshot := models.PostSnapshot{
+			PostID:      post.ID,
+			ObservedAt:  toSecond(observedAt),
+			Score:       post.Score,
+			Upvotes:     post.Upvotes,
+			NumComments: post.NumComments,
+			UpvoteRatio: post.UpvoteRatio,
+		}
+
+		// one snapshot per second; a later one in the same second replaces it
+		i := sort.Search(len(history), func(i int) bool {
+			return !history[i].ObservedAt.Before(snapshot.ObservedAt)
+		})
+		switch {
+		case i < len(history) && history[i].ObservedAt.Equal(snapshot.ObservedAt):
+			history[i] = snapshot
+		default:
+			history = append(history, models.PostSnapshot{})
+			copy(history[i+1:], history[i:])
+			history[i] = snapshot
+		}
+		m.snapshots[post.ID] = history
+	}
+
+	return nil
+}
+
+// GetPost returns a single post by id, or ErrPostNotFound
+func (m *MemoryStore) GetPost(id string) (*models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	post, ok := m.posts[id]
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+	return &post, nil
+}
+
+// GetPostHistory returns the snapshots of a post observed in [since, until), oldest first
+func (m *MemoryStore) GetPostHistory(postID string, since, until time.Time) ([]models.PostSnapshot, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	snapshots := make([]models.PostSnapshot, 0, len(m.snapshots[postID]))
+	for _, snapshot := range m.snapshots[postID] {
+		if inRange(float64(snapshot.ObservedAt.Unix()), since, until) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// GetPostSummaries returns the statistics-relevant fields of every stored post
+func (m *MemoryStore) GetPostSummaries() ([]models.PostSummary, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	summaries := make([]models.PostSummary, 0, len(m.posts))
+	for _, post := range m.posts {
+		summaries = append(summaries, models.PostSummary{ID: post.ID, Subreddit: post.Subreddit, Author: post.Author, Upvotes: post.Upvotes})
+	}
+	return summaries, nil
+}
+
+// GetPostsBySubreddit returns the posts of a subreddit created in [since, until), most upvoted first
+func (m *MemoryStore) GetPostsBySubreddit(subreddit string, since, until time.Time) ([]models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	posts := m.filterPosts(PostFilter{Subreddit: subreddit, Since: since, Until: until}.matches)
+	sortByUpvotes(posts)
+	return posts, nil
+}
+
+// GetTotalPosts returns the number of stored posts
+func (m *MemoryStore) GetTotalPosts() (int, error) {
+	return m.CountPostsInRange(PostFilter{})
+}
+
+// GetTopPostsByUpvotes returns the top N posts by upvotes
+func (m *MemoryStore) GetTopPostsByUpvotes(limit int) ([]models.Post, error) {
+	return m.GetTopPostsInRange(PostFilter{}, limit)
+}
+
+// GetTopUsersByPostCount returns the top N users by post count, most posts first
+func (m *MemoryStore) GetTopUsersByPostCount(limit int) ([]models.UserPostCount, error) {
+	return m.GetTopUsersInRange(PostFilter{}, limit)
+}
+
+// GetTopPostsInRange returns the top N posts by upvotes that match the filter
+func (m *MemoryStore) GetTopPostsInRange(filter PostFilter, limit int) ([]models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	posts := m.filterPosts(filter.matches)
+	sortByUpvotes(posts)
+	return limited(posts, limit), nil
+}
+
+// GetTopUsersInRange returns the top N users by the number of their posts that match the filter,
+// most posts first; [deleted], AutoModerator and the like aren't ranked
+func (m *MemoryStore) GetTopUsersInRange(filter PostFilter, limit int) ([]models.UserPostCount, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, post := range m.filterPosts(filter.matchesRanked) {
+		counts[post.Author]++
+	}
+
+	users := make([]models.UserPostCount, 0, len(counts))
+	for author, count := range counts {
+		users = append(users, models.UserPostCount{Author: author, PostCount: count})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].PostCount != users[j].PostCount {
+			return users[i].PostCount > users[j].PostCount
+		}
+		return users[i].Author < users[j].Author
+	})
+	return limited(users, limit), nil
+}
+
+// CountPostsInRange returns the number of posts that match the filter
+func (m *MemoryStore) CountPostsInRange(filter PostFilter) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return len(m.filterPosts(filter.matches)), nil
+}
+
+// GetAuthors ranks the authors of the posts that match the query
+func (m *MemoryStore) GetAuthors(query AuthorQuery) ([]models.AuthorStats, error) {
+	sortBy := query.Sort
+	if sortBy == "" {
+		sortBy = models.SortAuthorsByPosts
+	}
+	if _, ok := authorOrder[sortBy]; !ok {
+		return nil, fmt.Errorf("unknown author sort %q", sortBy)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultAuthorsLimit
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	byAuthor := make(map[string][]models.Post)
+	for _, post := range m.filterPosts(query.Filter.matchesRanked) {
+		byAuthor[post.Author] = append(byAuthor[post.Author], post)
+	}
+
+	authors := make([]models.AuthorStats, 0, len(byAuthor))
+	for _, posts := range byAuthor {
+		if len(posts) >= max(query.MinPosts, 1) {
+			authors = append(authors, aggregateAuthor(posts))
+		}
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		a, b := authors[i], authors[j]
+		switch sortBy {
+		case models.SortAuthorsByScore:
+			if a.TotalScore != b.TotalScore {
+				return a.TotalScore > b.TotalScore
+			}
+		case models.SortAuthorsByComments:
+			if a.TotalComments != b.TotalComments {
+				return a.TotalComments > b.TotalComments
+			}
+		case models.SortAuthorsByAvgScore:
+			if a.AvgScore != b.AvgScore {
+				return a.AvgScore > b.AvgScore
+			}
+		}
+		if a.PostCount != b.PostCount {
+			return a.PostCount > b.PostCount
+		}
+		return a.Author < b.Author
+	})
+
+	authors = limited(authors, limit)
+	for i := range authors {
+		authors[i].Rank = i + 1
+	}
+	return authors, nil
+}
+
+// GetAuthor returns the statistics of one author's posts that match the filter, with a breakdown by
+// subreddit. It returns ErrAuthorNotFound if there are none.
+func (m *MemoryStore) GetAuthor(name string, filter PostFilter) (*models.AuthorStats, error) {
+	if models.IsExcludedAuthor(name) {
+		return nil, ErrAuthorNotFound
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	posts := m.filterPosts(func(post models.Post) bool {
+		return filter.matchesRanked(post) && strings.EqualFold(post.Author, name)
+	})
+	if len(posts) == 0 {
+		return nil, ErrAuthorNotFound
+	}
+
+	// the spelling with the most posts names the author, as GROUP BY author does in SQL
+	bySpelling := make(map[string][]models.Post)
+	for _, post := range posts {
+		bySpelling[post.Author] = append(bySpelling[post.Author], post)
+	}
+	var spelling string
+	for candidate, candidatePosts := range bySpelling {
+		if len(candidatePosts) > len(bySpelling[spelling]) ||
+			(len(candidatePosts) == len(bySpelling[spelling]) && candidate < spelling) {
+			spelling = candidate
+		}
+	}
+	author := aggregateAuthor(bySpelling[spelling])
+
+	// the breakdown covers every spelling
+	bySubreddit := make(map[string]*models.SubredditPostCount)
+	for _, post := range posts {
+		key := strings.ToLower(post.Subreddit)
+		sub := bySubreddit[key]
+		if sub == nil {
+			sub = &models.SubredditPostCount{Subreddit: post.Subreddit}
+			bySubreddit[key] = sub
+		}
+		sub.Subreddit = min(sub.Subreddit, post.Subreddit)
+		sub.PostCount++
+		sub.TotalScore += post.Score
+	}
+
+	author.Subreddits = make([]models.SubredditPostCount, 0, len(bySubreddit))
+	for _, sub := range bySubreddit {
+		author.Subreddits = append(author.Subreddits, *sub)
+	}
+	sort.Slice(author.Subreddits, func(i, j int) bool {
+		a, b := author.Subreddits[i], author.Subreddits[j]
+		if a.PostCount != b.PostCount {
+			return a.PostCount > b.PostCount
+		}
+		return a.Subreddit < b.Subreddit
+	})
+
+	return &author, nil
+}
+
+// aggregateAuthor totals the posts of one author
+func aggregateAuthor(posts []models.Post) models.AuthorStats {
+	author := models.AuthorStats{Author: posts[0].Author, PostCount: len(posts)}
+	subreddits := make(map[string]bool)
+	first, last := posts[0].CreatedUTC, posts[0].CreatedUTC
+	for _, post := range posts {
+		author.TotalScore += post.Score
+		author.TotalComments += post.NumComments
+		subreddits[strings.ToLower(post.Subreddit)] = true
+		first = min(first, post.CreatedUTC)
+		last = max(last, post.CreatedUTC)
+	}
+	author.SubredditCount = len(subreddits)
+
+	deriveAuthorStats(&author, first, last)
+	return author
+}
+
+// GetPosts returns a page of the posts that match the query, and the cursor of the next page, or
+// an empty one after the last page
+func (m *MemoryStore) GetPosts(query PostQuery) ([]models.Post, string, error) {
+	query, err := query.normalized()
+	if err != nil {
+		return nil, "", err
+	}
+	var after postCursor
+	if query.After != "" {
+		if after, err = decodePostCursor(query); err != nil {
+			return nil, "", err
+		}
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	// before reports whether a post with the value and id comes before another in the order
+	before := func(value float64, id string, otherValue float64, otherID string) bool {
+		if value != otherValue {
+			return (value < otherValue) == query.Ascending
+		}
+		return id < otherID
+	}
+
+	posts := m.filterPosts(func(post models.Post) bool {
+		if !query.matches(post) {
+			return false
+		}
+		return query.After == "" || before(after.Value, after.ID, postSortValue(post, query.Sort), post.ID)
+	})
+	sort.Slice(posts, func(i, j int) bool {
+		return before(postSortValue(posts[i], query.Sort), posts[i].ID, postSortValue(posts[j], query.Sort), posts[j].ID)
+	})
+
+	posts, next := nextPage(query, limited(posts, query.Limit+1))
+	return posts, next, nil
+}
+
+// SearchPosts returns one page of the posts that match a search query and the filter, scanning
+// every post as a SQLite store without FTS5 does
+func (m *MemoryStore) SearchPosts(query string, filter PostFilter, order models.SearchSort, page Page) (*models.SearchResults, error) {
+	terms, order, page, err := prepareSearch(query, order, page)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	posts, total := searchPosts(m.filterPosts(filter.matches), terms, order, page)
+	return newSearchResults(query, order, page, total, false, posts, terms), nil
+}
+
+// filterPosts returns copies of the posts that match; callers hold the mutex
+func (m *MemoryStore) filterPosts(match func(models.Post) bool) []models.Post {
+	posts := make([]models.Post, 0)
+	for _, post := range m.posts {
+		if match(post) {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+// sortByUpvotes orders posts most upvoted first, then by id so the order is stable
+func sortByUpvotes(posts []models.Post) {
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].Upvotes != posts[j].Upvotes {
+			return posts[i].Upvotes > posts[j].Upvotes
+		}
+		return posts[i].ID < posts[j].ID
+	})
+}
+
+// matches reports whether a post passes the filter, as its WHERE clause does
+func (f PostFilter) matches(post models.Post) bool {
+	if f.Subreddit != "" && !strings.EqualFold(post.Subreddit, f.Subreddit) {
+		return false
+	}
+	return inRange(post.CreatedUTC, f.Since, f.Until)
+}
+
+// inRange reports whether unix seconds fall in [since, until), as appendRange's conditions do
+func inRange(seconds float64, since, until time.Time) bool {
+	if !since.IsZero() && seconds < unixSeconds(since) {
+		return false
+	}
+	if !until.IsZero() && seconds >= unixSeconds(until) {
+		return false
+	}
+	return true
+}
+
+// matchesRanked also leaves out the authors that aren't ranked, as authorWhere does
+func (f PostFilter) matchesRanked(post models.Post) bool {
+	return f.matches(post) && !models.IsExcludedAuthor(post.Author)
+}
+
+// SaveComments saves a batch of comments, replacing any existing ones
+func (m *MemoryStore) SaveComments(comments []models.Comment) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, comment := range comments {
+		comment.CreatedAt, comment.ProcessedTime = toSecond(comment.CreatedAt), toSecond(comment.ProcessedTime)
+		m.comments[comment.ID] = comment
+	}
+	return nil
+}
+
+// GetCommentsByPost returns the stored comments of a post created in [since, until), oldest first
+func (m *MemoryStore) GetCommentsByPost(postID string, since, until time.Time) ([]models.Comment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	comments := make([]models.Comment, 0)
+	for _, comment := range m.comments {
+		if comment.PostID == postID && inRange(comment.CreatedUTC, since, until) {
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].CreatedUTC != comments[j].CreatedUTC {
+			return comments[i].CreatedUTC < comments[j].CreatedUTC
+		}
+		return comments[i].ID < comments[j].ID
+	})
+	return comments, nil
+}
+
+// GetActivePosts returns up to limit posts created since the given time that have comments,
+// most commented first
+func (m *MemoryStore) GetActivePosts(since time.Time, limit int) ([]models.Post, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	posts := m.filterPosts(func(post models.Post) bool {
+		return post.CreatedUTC >= float64(since.Unix()) && post.NumComments > 0
+	})
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].NumComments != posts[j].NumComments {
+			return posts[i].NumComments > posts[j].NumComments
+		}
+		return posts[i].ID < posts[j].ID
+	})
+	return limited(posts, limit), nil
+}
+
+// SaveListingRanks saves the post positions of one or more listing snapshots
+func (m *MemoryStore) SaveListingRanks(ranks []models.ListingRank) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, rank := range ranks {
+		rank.SnapshotAt = toSecond(rank.SnapshotAt)
+		key := rankKey{rank.Subreddit, string(rank.Listing), string(rank.Time), rank.SnapshotAt.Unix(), rank.Rank}
+		m.ranks[key] = rank
+	}
+	return nil
+}
+
+// GetPostRanks returns every recorded listing position of a post, oldest snapshot first
+func (m *MemoryStore) GetPostRanks(postID string) ([]models.ListingRank, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ranks := make([]models.ListingRank, 0)
+	for _, rank := range m.ranks {
+		if rank.PostID == postID {
+			ranks = append(ranks, rank)
+		}
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		a, b := ranks[i], ranks[j]
+		if !a.SnapshotAt.Equal(b.SnapshotAt) {
+			return a.SnapshotAt.Before(b.SnapshotAt)
+		}
+		if a.Listing != b.Listing {
+			return a.Listing < b.Listing
+		}
+		return a.Time < b.Time
+	})
+	return ranks, nil
+}
+
+// ScheduleRefresh records when a post was last observed and when its score should next be refreshed
+func (m *MemoryStore) ScheduleRefresh(postID string, lastRefreshed, nextRefresh time.Time) error {
+	return m.ScheduleRefreshes([]models.PostRefresh{{PostID: postID, LastRefreshed: lastRefreshed, NextRefresh: nextRefresh}})
+}
+
+// ScheduleRefreshes records the refresh schedule of a batch of posts
+func (m *MemoryStore) ScheduleRefreshes(refreshes []models.PostRefresh) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, refresh := range refreshes {
+		m.refreshes[refresh.PostID] = refresh
+	}
+	return nil
+}
+
+// GetPostsDueForRefresh returns the ids of up to limit posts whose refresh is due at the given time,
+// most overdue first
+func (m *MemoryStore) GetPostsDueForRefresh(now time.Time, limit int) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	due := make([]models.PostRefresh, 0)
+	for _, refresh := range m.refreshes {
+		if !refresh.NextRefresh.IsZero() && refresh.NextRefresh.Unix() <= now.Unix() {
+			due = append(due, refresh)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if a, b := due[i].NextRefresh.Unix(), due[j].NextRefresh.Unix(); a != b {
+			return a < b
+		}
+		return due[i].PostID < due[j].PostID
+	})
+
+	ids := make([]string, 0, len(due))
+	for _, refresh := range limited(due, limit) {
+		ids = append(ids, refresh.PostID)
+	}
+	return ids, nil
+}
+
+// GetFrontier returns the id of the newest post seen in a subreddit's new listing, or ""
+func (m *MemoryStore) GetFrontier(subreddit string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.frontiers[subreddit], nil
+}
+
+// SaveFrontier records the id of the newest post seen in a subreddit's new listing
+func (m *MemoryStore) SaveFrontier(subreddit, newestID string, updatedAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.frontiers[subreddit] = newestID
+	return nil
+}
+
+// GetTrackedSubreddits returns the subreddits added or removed at runtime, oldest change first
+func (m *MemoryStore) GetTrackedSubreddits() ([]models.TrackedSubreddit, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	subreddits := make([]models.TrackedSubreddit, 0, len(m.tracked))
+	for _, sub := range m.tracked {
+		subreddits = append(subreddits, sub)
+	}
+	sort.Slice(subreddits, func(i, j int) bool {
+		if !subreddits[i].UpdatedAt.Equal(subreddits[j].UpdatedAt) {
+			return subreddits[i].UpdatedAt.Before(subreddits[j].UpdatedAt)
+		}
+		return strings.ToLower(subreddits[i].Name) < strings.ToLower(subreddits[j].Name)
+	})
+	return subreddits, nil
+}
+
+// SaveTrackedSubreddit records that a subreddit was added to or removed from the tracked set
+func (m *MemoryStore) SaveTrackedSubreddit(sub models.TrackedSubreddit) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sub.UpdatedAt = toSecond(sub.UpdatedAt)
+	m.tracked[strings.ToLower(sub.Name)] = sub
+	return nil
+}
+
+// LogPoll records the outcome of one poll of a subreddit's new listing
+func (m *MemoryStore) LogPoll(record models.PollRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	record.PolledAt = toSecond(record.PolledAt)
+	for _, t := range []*time.Time{&record.CoveredFrom, &record.GapStart, &record.GapEnd} {
+		if !t.IsZero() {
+			*t = toSecond(*t)
+		}
+	}
+	m.polls = append(m.polls, memoryPoll{id: m.nextID(), record: record})
+	return nil
+}
+
+// PrunePollLog deletes poll records older than before
+func (m *MemoryStore) PrunePollLog(before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	kept := m.polls[:0]
+	for _, poll := range m.polls {
+		if poll.record.PolledAt.Unix() >= before.Unix() {
+			kept = append(kept, poll)
+		}
+	}
+	m.polls = kept
+	return nil
+}
+
+// GetPollLog returns the polls of a subreddit since the given time, oldest first
+func (m *MemoryStore) GetPollLog(subreddit string, since time.Time) ([]models.PollRecord, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	polls := make([]memoryPoll, 0)
+	for _, poll := range m.polls {
+		if strings.EqualFold(poll.record.Subreddit, subreddit) && poll.record.PolledAt.Unix() >= since.Unix() {
+			polls = append(polls, poll)
+		}
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		if !polls[i].record.PolledAt.Equal(polls[j].record.PolledAt) {
+			return polls[i].record.PolledAt.Before(polls[j].record.PolledAt)
+		}
+		return polls[i].id < polls[j].id
+	})
+
+	records := make([]models.PollRecord, 0, len(polls))
+	for _, poll := range polls {
+		records = append(records, poll.record)
+	}
+	return records, nil
+}
+
+// GetPostTimeline returns the id and creation time of every post of a subreddit created since
+// the given time, oldest first
+func (m *MemoryStore) GetPostTimeline(subreddit string, since time.Time) ([]models.PostRef, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	posts := m.filterPosts(func(post models.Post) bool {
+		return strings.EqualFold(post.Subreddit, subreddit) && post.CreatedUTC >= float64(since.Unix())
+	})
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].CreatedUTC != posts[j].CreatedUTC {
+			return posts[i].CreatedUTC < posts[j].CreatedUTC
+		}
+		return posts[i].ID < posts[j].ID
+	})
+
+	refs := make([]models.PostRef, 0, len(posts))
+	for _, post := range posts {
+		refs = append(refs, models.PostRef{ID: post.ID, CreatedUTC: post.CreatedUTC})
+	}
+	return refs, nil
+}
+
+// ScheduleBackfill queues a backfill job and sets its id, status and timestamps. A pending, running
+// or done job for the same subreddit and range is returned instead of being added twice; a failed
+// one is queued again from the start, with the new reason and page limit.
+func (m *MemoryStore) ScheduleBackfill(job *models.BackfillJob) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	since, until := toSecond(job.Since), toSecond(job.Until)
+	now := toSecond(time.Now())
+	scheduled := models.BackfillJob{
+		Subreddit: job.Subreddit,
+		Since:     since,
+		Until:     until,
+		Reason:    job.Reason,
+		Status:    models.BackfillPending,
+		MaxPages:  job.MaxPages,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Source:    models.BackfillFromListing,
+	}
+
+	for i, queued := range m.jobs {
+		if queued.Subreddit == job.Subreddit && queued.Since.Equal(since) && queued.Until.Equal(until) {
+			if queued.Status == models.BackfillFailed {
+				scheduled.ID, scheduled.CreatedAt = queued.ID, queued.CreatedAt
+				m.jobs[i] = scheduled
+			}
+			*job = m.jobs[i]
+			return nil
+		}
+	}
+
+	scheduled.ID = m.nextID()
+	m.jobs = append(m.jobs, scheduled)
+	*job = scheduled
+	return nil
+}
+
+// ClaimNextBackfillJob claims the job to work on next for lease: an abandoned running job first,
+// then the oldest pending one. A running job is abandoned when its lease has run out. It returns
+// ErrNoBackfillJobs when there's nothing to claim.
+func (m *MemoryStore) ClaimNextBackfillJob(now time.Time, lease time.Duration) (*models.BackfillJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, status := range []models.BackfillStatus{models.BackfillRunning, models.BackfillPending} {
+		for i := range m.jobs {
+			if m.jobs[i].Status == status && claimable(m.jobs[i], now) {
+				return claim(&m.jobs[i], now, lease), nil
+			}
+		}
+	}
+	return nil, ErrNoBackfillJobs
+}
+
+// ClaimBackfillJob claims a pending or abandoned job for lease. It returns ErrBackfillJobHeld when
+// the job is finished or another worker holds it.
+func (m *MemoryStore) ClaimBackfillJob(id int64, now time.Time, lease time.Duration) (*models.BackfillJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.jobs {
+		if m.jobs[i].ID == id && claimable(m.jobs[i], now) {
+			return claim(&m.jobs[i], now, lease), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: job %d", ErrBackfillJobHeld, id)
+}
+
+// claimable reports whether a job is pending, or running with a lease that has run out
+func claimable(job models.BackfillJob, now time.Time) bool {
+	return job.Status == models.BackfillPending ||
+		job.Status == models.BackfillRunning && job.LeaseUntil.Unix() <= now.Unix()
+}
+
+func claim(job *models.BackfillJob, now time.Time, lease time.Duration) *models.BackfillJob {
+	job.Status = models.BackfillRunning
+	job.LeaseUntil = toSecond(now.Add(lease))
+	job.UpdatedAt = toSecond(now)
+	claimed := *job
+	return &claimed
+}
+
+// UpdateBackfillJob saves a job's status and checkpoint and renews its lease for another lease
+// from now; a finished job's lease is dropped, and a lease of 0 releases a running job to any
+// worker. It fails with ErrBackfillLeaseLost if another worker claimed the job since its lease
+// was last renewed.
+func (m *MemoryStore) UpdateBackfillJob(job *models.BackfillJob, lease time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := range m.jobs {
+		if m.jobs[i].ID != job.ID {
+			continue
+		}
+		stored := &m.jobs[i]
+		if !stored.LeaseUntil.Equal(toSecond(job.LeaseUntil)) {
+			return fmt.Errorf("%w: job %d", ErrBackfillLeaseLost, job.ID)
+		}
+		job.UpdatedAt, job.LeaseUntil = now, backfillLeaseUntil(job, now, lease)
+		stored.Status, stored.Source, stored.Cursor = job.Status, job.Source, job.Cursor
+		stored.Pages, stored.Posts, stored.Error = job.Pages, job.Posts, job.Error
+		stored.Oldest = time.Time{}
+		if !job.Oldest.IsZero() {
+			stored.Oldest = toSecond(job.Oldest)
+		}
+		stored.UpdatedAt, stored.LeaseUntil = job.UpdatedAt, job.LeaseUntil
+		return nil
+	}
+	return fmt.Errorf("%w: job %d", ErrBackfillLeaseLost, job.ID)
+}
+
+// GetBackfillJobs returns the backfill jobs with the given status, or every job when status
+// is empty, oldest first
+func (m *MemoryStore) GetBackfillJobs(status models.BackfillStatus) ([]models.BackfillJob, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	jobs := make([]models.BackfillJob, 0)
+	for _, job := range m.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// SaveAlerts records alerts and returns the ones that are new; each rule fires at most once per post
+func (m *MemoryStore) SaveAlerts(alerts []models.Alert) ([]models.Alert, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fired := make([]models.Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		duplicate := false
+		for _, saved := range m.alerts {
+			if saved.Rule == alert.Rule && saved.PostID == alert.PostID {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		alert.ID = m.nextID()
+		alert.FiredAt = toSecond(alert.FiredAt)
+		m.alerts = append(m.alerts, alert)
+		fired = append(fired, alert)
+	}
+	return fired, nil
+}
+
+// GetAlerts returns the alerts that match filter, newest first; at most 100 unless filter sets a limit
+func (m *MemoryStore) GetAlerts(filter models.AlertFilter) ([]models.Alert, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	alerts := make([]models.Alert, 0)
+	for _, alert := range m.alerts {
+		if filter.Rule != "" && alert.Rule != filter.Rule {
+			continue
+		}
+		if filter.Subreddit != "" && !strings.EqualFold(alert.Subreddit, filter.Subreddit) {
+			continue
+		}
+		if !filter.Since.IsZero() && alert.FiredAt.Unix() < filter.Since.Unix() {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].FiredAt.Equal(alerts[j].FiredAt) {
+			return alerts[i].FiredAt.After(alerts[j].FiredAt)
+		}
+		return alerts[i].ID > alerts[j].ID
+	})
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAlertsLimit
+	}
+	return limited(alerts, limit), nil
+}
+
+// EnqueueWebhookDeliveries adds deliveries to the outbox and returns how many were new. A delivery
+// whose webhook and dedup key are already in the outbox is skipped.
+func (m *MemoryStore) EnqueueWebhookDeliveries(deliveries []models.WebhookDelivery) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	queued := 0
+	for _, delivery := range deliveries {
+		duplicate := false
+		for _, saved := range m.deliveries {
+			if saved.Webhook == delivery.Webhook && saved.DedupKey == delivery.DedupKey {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		m.deliveries = append(m.deliveries, models.WebhookDelivery{
+			ID:            m.nextID(),
+			Webhook:       delivery.Webhook,
+			Event:         delivery.Event,
+			DedupKey:      delivery.DedupKey,
+			Payload:       delivery.Payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: toSecond(delivery.NextAttemptAt),
+			CreatedAt:     toSecond(delivery.CreatedAt),
+		})
+		queued++
+	}
+	return queued, nil
+}
+
+// ClaimWebhookDeliveries claims up to limit pending deliveries whose next attempt is due, those due
+// longest first, by putting their next attempt off until the lease runs out, and returns them in
+// the order they were queued. Dispatchers sharing the outbox claim different deliveries; one that
+// isn't settled within the lease, e.g. because its dispatcher stopped, is due again.
+func (m *MemoryStore) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	due := make([]*models.WebhookDelivery, 0)
+	for i := range m.deliveries {
+		delivery := &m.deliveries[i]
+		if delivery.Status == models.DeliveryPending && delivery.NextAttemptAt.Unix() <= now.Unix() {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	claimed := make([]models.WebhookDelivery, 0, len(due))
+	for _, delivery := range limited(due, limit) {
+		delivery.NextAttemptAt = toSecond(now.Add(lease))
+		claimed = append(claimed, *delivery)
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	return claimed, nil
+}
+
+// SaveScoreCrossings records score crossings and returns the ones that are new. A crossing only
+// counts if the post was observed below the threshold before, so a post first seen above it, e.g.
+// in a top listing or a backfill, never crossed it; and each webhook hears about each post and
+// threshold at most once.
+func (m *MemoryStore) SaveScoreCrossings(crossings []models.ScoreCrossing) ([]models.ScoreCrossing, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	crossed := make([]models.ScoreCrossing, 0, len(crossings))
+	for _, crossing := range crossings {
+		if !m.observedBelow(crossing.PostID, crossing.Threshold) || m.crossed(crossing) {
+			continue
+		}
+
+		crossing.ID = m.nextID()
+		crossing.CrossedAt = toSecond(crossing.CrossedAt)
+		m.crossings = append(m.crossings, crossing)
+		crossed = append(crossed, crossing)
+	}
+	return crossed, nil
+}
+
+// observedBelow reports whether a snapshot of the post has a score below threshold
+func (m *MemoryStore) observedBelow(postID string, threshold int) bool {
+	for _, snapshot := range m.snapshots[postID] {
+		if snapshot.Score < threshold {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) crossed(crossing models.ScoreCrossing) bool {
+	for _, saved := range m.crossings {
+		if saved.Webhook == crossing.Webhook && saved.PostID == crossing.PostID && saved.Threshold == crossing.Threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWebhookDeliveries returns the deliveries of a webhook, newest first; an empty webhook returns every one
+func (m *MemoryStore) GetWebhookDeliveries(webhook string, limit int) ([]models.WebhookDelivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if limit <= 0 {
+		limit = defaultDeliveriesLimit
+	}
+	deliveries := make([]models.WebhookDelivery, 0)
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if webhook == "" || m.deliveries[i].Webhook == webhook {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	return limited(deliveries, limit), nil
+}
+
+// UpdateWebhookDelivery saves the outcome of a delivery attempt
+func (m *MemoryStore) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.deliveries {
+		if m.deliveries[i].ID != delivery.ID {
+			continue
+		}
+		stored := &m.deliveries[i]
+		stored.Status, stored.Attempts, stored.LastError = delivery.Status, delivery.Attempts, delivery.LastError
+		stored.NextAttemptAt = toSecond(delivery.NextAttemptAt)
+		stored.DeliveredAt = time.Time{}
+		if !delivery.DeliveredAt.IsZero() {
+			stored.DeliveredAt = toSecond(delivery.DeliveredAt)
+		}
+	}
+	return nil
+}
+
+// PruneWebhookDeliveries deletes the delivered and failed deliveries created before the given time;
+// pending ones are kept however old they are
+func (m *MemoryStore) PruneWebhookDeliveries(before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	kept := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.Status == models.DeliveryPending || delivery.CreatedAt.Unix() >= before.Unix() {
+			kept = append(kept, delivery)
+		}
+	}
+	m.deliveries = kept
+	return nil
+}
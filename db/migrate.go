@@ -0,0 +1,554 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// migrationFiles holds the schema migrations of each dialect, named
+// migrations/<dialect>/<version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migration is one versioned step of the schema
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+	// afterUp runs in the same transaction as up, for changes SQL alone can't express
+	afterUp func(tx *sql.Tx) error
+}
+
+// migrationHooks are the Go steps of migrations, keyed by dialect and version
+var migrationHooks = map[string]map[int]func(tx *sql.Tx) error{
+	sqliteDialect.name: {
+		1: addLegacyColumns,
+		2: convertTextTimes,
+		7: fillPostDomains(sqliteDialect),
+	},
+	postgresDialect.name: {
+		7: fillPostDomains(postgresDialect),
+	},
+}
+
+// MigrationStatus is a known or applied migration and whether it has run
+type MigrationStatus struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+}
+
+// loadMigrations reads the embedded migrations of a dialect, ordered by version
+func loadMigrations(dl dialect) ([]migration, error) {
+	dir := path.Join("migrations", migrationDir(dl))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: match[2], afterUp: migrationHooks[dl.name][version]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations for %s", dl.name)
+	}
+
+	return migrations, nil
+}
+
+// migrationDir is the directory of a dialect's migrations under migrations/
+func migrationDir(dl dialect) string {
+	if dl.name == sqliteDialect.name {
+		return "sqlite"
+	}
+	return dl.name
+}
+
+// ensureMigrationsTable creates the table recording the applied migrations; callers hold the mutex
+func (d *Database) ensureMigrationsTable() error {
+	tx, err := d.beginMigration()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns the applied migrations keyed by version; callers hold the mutex
+func (d *Database) appliedMigrations() (map[int]MigrationStatus, error) {
+	if err := d.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.query("SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt int64
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		status.Applied = true
+		status.AppliedAt = time.Unix(appliedAt, 0)
+		applied[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return applied, nil
+}
+
+// MigrationStatus lists every known migration and any applied one this build doesn't know, by version
+func (d *Database) MigrationStatus() ([]MigrationStatus, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	migrations, err := loadMigrations(d.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status, ok := applied[m.version]
+		if !ok {
+			status = MigrationStatus{Version: m.version, Name: m.name}
+		}
+		delete(applied, m.version)
+		statuses = append(statuses, status)
+	}
+	for _, status := range applied {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// SchemaVersion returns the highest applied migration, or 0 for an empty database
+func (d *Database) SchemaVersion() (int, error) {
+	statuses, err := d.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for _, status := range statuses {
+		if status.Applied {
+			version = max(version, status.Version)
+		}
+	}
+	return version, nil
+}
+
+// MigrateUp applies the pending migrations up to and including target, or all of them when target
+// is 0, each in its own transaction. It returns the migrations it applied.
+func (d *Database) MigrateUp(target int) ([]MigrationStatus, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	migrations, err := loadMigrations(d.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// a database migrated by a newer release may depend on a schema this build doesn't understand
+	latest := migrations[len(migrations)-1].version
+	for version := range applied {
+		if version > latest {
+			return nil, fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, latest)
+		}
+	}
+
+	done := make([]MigrationStatus, 0)
+	for _, m := range migrations {
+		if target > 0 && m.version > target {
+			break
+		}
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		status, err := d.applyMigration(m)
+		if err != nil {
+			return done, err
+		}
+		done = append(done, status)
+		d.log.WithField("version", m.version).WithField("name", m.name).Info("Applied migration")
+	}
+
+	return done, nil
+}
+
+// MigrateDown rolls back the last steps applied migrations, newest first, each in its own transaction.
+// It returns the migrations it rolled back.
+func (d *Database) MigrateDown(steps int) ([]MigrationStatus, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	migrations, err := loadMigrations(d.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]migration, len(migrations))
+	for _, m := range migrations {
+		known[m.version] = m
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	done := make([]MigrationStatus, 0)
+	for _, version := range versions {
+		if len(done) >= steps {
+			break
+		}
+		m, ok := known[version]
+		if !ok {
+			return done, fmt.Errorf("migration %d was applied by a newer release and can't be rolled back by this build", version)
+		}
+
+		if err := d.revertMigration(m); err != nil {
+			return done, err
+		}
+		done = append(done, applied[version])
+		d.log.WithField("version", m.version).WithField("name", m.name).Info("Rolled back migration")
+	}
+
+	return done, nil
+}
+
+// applyMigration runs one migration and records it in the same transaction; callers hold the mutex.
+// Another instance sharing the database may have applied it in the meantime, which isn't an error.
+func (d *Database) applyMigration(m migration) (MigrationStatus, error) {
+	tx, err := d.beginMigration()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(d.dialect.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.version).Scan(&count)
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to check migration %d: %w", m.version, err)
+	}
+	if count > 0 {
+		return MigrationStatus{Version: m.version, Name: m.name, Applied: true}, nil
+	}
+
+	if _, err := tx.Exec(m.up); err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to apply migration %d_%s: %w", m.version, m.name, err)
+	}
+	if m.afterUp != nil {
+		if err := m.afterUp(tx); err != nil {
+			return MigrationStatus{}, fmt.Errorf("failed to apply migration %d_%s: %w", m.version, m.name, err)
+		}
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(d.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.version, m.name, now.Unix())
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+	}
+
+	return MigrationStatus{Version: m.version, Name: m.name, Applied: true, AppliedAt: time.Unix(now.Unix(), 0)}, nil
+}
+
+// revertMigration runs the down step of one migration and forgets it in the same transaction;
+// callers hold the mutex
+func (d *Database) revertMigration(m migration) error {
+	tx, err := d.beginMigration()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.down); err != nil {
+		return fmt.Errorf("failed to roll back migration %d_%s: %w", m.version, m.name, err)
+	}
+	if _, err := tx.Exec(d.dialect.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.version); err != nil {
+		return fmt.Errorf("failed to forget migration %d: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollback of migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// beginMigration starts a migration transaction holding the dialect's migration lock
+func (d *Database) beginMigration() (*sql.Tx, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if d.dialect.lock != "" {
+		if _, err := tx.Exec(d.dialect.lock); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+	}
+	return tx, nil
+}
+
+// addLegacyColumns adds the columns that SQLite databases created before migrations existed may lack
+func addLegacyColumns(tx *sql.Tx) error {
+	columns := []struct{ table, column, definition string }{
+		{"posts", "upvote_ratio", "REAL NOT NULL DEFAULT 0"},
+		{"posts", "over_18", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"posts", "domain", "TEXT NOT NULL DEFAULT ''"},
+		{"backfill_jobs", "max_pages", "INTEGER NOT NULL DEFAULT 0"},
+		{"backfill_jobs", "source", "TEXT NOT NULL DEFAULT 'listing'"},
+		{"backfill_jobs", "cursor", "TEXT NOT NULL DEFAULT ''"},
+		{"backfill_jobs", "pages", "INTEGER NOT NULL DEFAULT 0"},
+		{"backfill_jobs", "posts", "INTEGER NOT NULL DEFAULT 0"},
+		{"backfill_jobs", "oldest_utc", "INTEGER NOT NULL DEFAULT 0"},
+		{"backfill_jobs", "error", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table unless it's already there
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration error: %w", err)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// textTimeFormats are the layouts time columns were written in before they held unix seconds: the
+// driver's own for a Go time, with and without a zone, and RFC3339. Times without a zone are UTC.
+var textTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// convertTextTimes turns the created_at and processed_time values of posts and comments that are
+// still text into unix seconds. A value in none of the known layouts fails the migration rather
+// than being guessed at.
+func convertTextTimes(tx *sql.Tx) error {
+	for _, table := range []string{"posts", "comments"} {
+		rows, err := tx.Query(fmt.Sprintf(`
+		SELECT id, created_at, processed_time FROM %s
+		WHERE typeof(created_at) != 'integer' OR typeof(processed_time) != 'integer'`, table))
+		if err != nil {
+			return fmt.Errorf("failed to query times of %s: %w", table, err)
+		}
+
+		type rowTimes struct {
+			id                       string
+			createdAt, processedTime int64
+		}
+		converted := make([]rowTimes, 0)
+		for rows.Next() {
+			var id, createdAt, processedTime string
+			if err := rows.Scan(&id, &createdAt, &processedTime); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan times of %s: %w", table, err)
+			}
+
+			row := rowTimes{id: id}
+			if row.createdAt, err = parseTextTime(createdAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("%s %s: created_at: %w", table, id, err)
+			}
+			if row.processedTime, err = parseTextTime(processedTime); err != nil {
+				rows.Close()
+				return fmt.Errorf("%s %s: processed_time: %w", table, id, err)
+			}
+			converted = append(converted, row)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return fmt.Errorf("row iteration error: %w", err)
+		}
+		rows.Close()
+
+		for _, row := range converted {
+			_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET created_at = ?, processed_time = ? WHERE id = ?", table),
+				row.createdAt, row.processedTime, row.id)
+			if err != nil {
+				return fmt.Errorf("failed to convert times of %s %s: %w", table, row.id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseTextTime parses a time column written before it held unix seconds
+func parseTextTime(value string) (int64, error) {
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return int64(math.Floor(secs)), nil
+	}
+	for _, layout := range textTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized time %q", value)
+}
+
+// fillPostDomains sets the domain of every post saved without one, as the stores now do on save
+func fillPostDomains(dl dialect) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, subreddit, url, is_self FROM posts WHERE domain = ''")
+		if err != nil {
+			return fmt.Errorf("failed to query posts without a domain: %w", err)
+		}
+
+		var posts []models.Post
+		for rows.Next() {
+			var post models.Post
+			if err := rows.Scan(&post.ID, &post.Subreddit, &post.URL, &post.IsSelf); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan post: %w", err)
+			}
+			posts = append(posts, post)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return fmt.Errorf("row iteration error: %w", err)
+		}
+		rows.Close()
+
+		update := dl.rebind("UPDATE posts SET domain = ? WHERE id = ?")
+		for _, post := range posts {
+			domain := post.LinkDomain()
+			if domain == "" {
+				continue
+			}
+			if _, err := tx.Exec(update, domain, post.ID); err != nil {
+				return fmt.Errorf("failed to set domain of post %s: %w", post.ID, err)
+			}
+		}
+		return nil
+	}
+}
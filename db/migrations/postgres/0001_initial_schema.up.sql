@@ -0,0 +1,155 @@
+-- The same tables as the SQLite schema after its unix_time_columns migration: every time column
+-- holds unix seconds. Subreddit and author names compare case-insensitively through the nocase
+-- collation, which needs PostgreSQL 12 or later built with ICU (see Storage in the README).
+CREATE COLLATION IF NOT EXISTS nocase (provider = icu, locale = 'und-u-ks-level2', deterministic = false);
+
+CREATE TABLE IF NOT EXISTS posts (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	author TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	url TEXT,
+	created_utc DOUBLE PRECISION NOT NULL,
+	created_at BIGINT NOT NULL,
+	upvotes INTEGER NOT NULL,
+	downvotes INTEGER NOT NULL,
+	score INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	upvote_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+	post_hint TEXT,
+	is_video BOOLEAN NOT NULL,
+	is_self BOOLEAN NOT NULL,
+	self_text TEXT,
+	permalink TEXT NOT NULL,
+	processed_time BIGINT NOT NULL,
+	over_18 BOOLEAN NOT NULL DEFAULT FALSE,
+	domain TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_posts_upvotes ON posts(upvotes DESC);
+CREATE INDEX IF NOT EXISTS idx_posts_author ON posts(author);
+CREATE INDEX IF NOT EXISTS idx_posts_created_utc ON posts(created_utc);
+CREATE INDEX IF NOT EXISTS idx_posts_subreddit_created_utc ON posts(subreddit COLLATE nocase, created_utc);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id TEXT PRIMARY KEY,
+	post_id TEXT NOT NULL,
+	parent_id TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	author TEXT NOT NULL,
+	body TEXT,
+	score INTEGER NOT NULL,
+	depth INTEGER NOT NULL,
+	created_utc DOUBLE PRECISION NOT NULL,
+	created_at BIGINT NOT NULL,
+	permalink TEXT,
+	processed_time BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_comments_post_id_created_utc ON comments(post_id, created_utc);
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
+
+CREATE TABLE IF NOT EXISTS listing_ranks (
+	subreddit TEXT NOT NULL,
+	listing TEXT NOT NULL,
+	time_window TEXT NOT NULL DEFAULT '',
+	snapshot_at BIGINT NOT NULL,
+	rank INTEGER NOT NULL,
+	post_id TEXT NOT NULL,
+	score INTEGER NOT NULL,
+	PRIMARY KEY (subreddit, listing, time_window, snapshot_at, rank)
+);
+CREATE INDEX IF NOT EXISTS idx_listing_ranks_post_id ON listing_ranks(post_id);
+
+CREATE TABLE IF NOT EXISTS post_refresh (
+	post_id TEXT PRIMARY KEY,
+	last_refreshed BIGINT NOT NULL,
+	next_refresh BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_post_refresh_next_refresh ON post_refresh(next_refresh);
+
+CREATE TABLE IF NOT EXISTS post_snapshots (
+	post_id TEXT NOT NULL,
+	observed_at BIGINT NOT NULL,
+	score INTEGER NOT NULL,
+	upvotes INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	upvote_ratio DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (post_id, observed_at)
+);
+
+CREATE TABLE IF NOT EXISTS subreddit_cursors (
+	subreddit TEXT PRIMARY KEY,
+	newest_id TEXT NOT NULL,
+	updated_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tracked_subreddits (
+	name TEXT COLLATE nocase PRIMARY KEY,
+	tracked BOOLEAN NOT NULL,
+	updated_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS poll_log (
+	id BIGSERIAL PRIMARY KEY,
+	subreddit TEXT NOT NULL,
+	polled_at BIGINT NOT NULL,
+	pages INTEGER NOT NULL,
+	posts INTEGER NOT NULL,
+	new_posts INTEGER NOT NULL,
+	caught_up BOOLEAN NOT NULL,
+	covered_from BIGINT,
+	gap_start BIGINT,
+	gap_end BIGINT,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_poll_log_subreddit_polled_at ON poll_log(subreddit, polled_at);
+
+CREATE TABLE IF NOT EXISTS backfill_jobs (
+	id BIGSERIAL PRIMARY KEY,
+	subreddit TEXT NOT NULL,
+	since_utc BIGINT NOT NULL,
+	until_utc BIGINT NOT NULL,
+	reason TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL,
+	max_pages INTEGER NOT NULL DEFAULT 0,
+	source TEXT NOT NULL DEFAULT 'listing',
+	cursor TEXT NOT NULL DEFAULT '',
+	pages INTEGER NOT NULL DEFAULT 0,
+	posts INTEGER NOT NULL DEFAULT 0,
+	oldest_utc BIGINT NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	UNIQUE (subreddit, since_utc, until_utc)
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+	id BIGSERIAL PRIMARY KEY,
+	rule TEXT NOT NULL,
+	post_id TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	title TEXT NOT NULL,
+	author TEXT NOT NULL,
+	permalink TEXT NOT NULL,
+	score INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	matched TEXT NOT NULL DEFAULT '',
+	fired_at BIGINT NOT NULL,
+	UNIQUE (rule, post_id)
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_fired_at ON alerts(fired_at);
+
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	webhook TEXT NOT NULL,
+	event TEXT NOT NULL,
+	dedup_key TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at BIGINT NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL,
+	delivered_at BIGINT,
+	UNIQUE (webhook, dedup_key)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_status_next_attempt ON webhook_outbox(status, next_attempt_at);
@@ -0,0 +1,2 @@
+-- 0002 changes nothing on PostgreSQL, so there's nothing to undo
+SELECT 1;
@@ -0,0 +1,3 @@
+-- The PostgreSQL schema has kept its times as unix seconds, and comments indexed by post and
+-- creation, since 0001; this version only keeps the numbering in step with SQLite.
+SELECT 1;
@@ -0,0 +1,9 @@
+-- Full-text search of posts: a tsvector of the searched columns, the title weighted the most, and
+-- its GIN index. Generated columns need PostgreSQL 12 or later.
+
+ALTER TABLE posts ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', title), 'A') ||
+	setweight(to_tsvector('simple', author || ' ' || subreddit), 'B') ||
+	setweight(to_tsvector('simple', COALESCE(self_text, '')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING GIN (search_vector);
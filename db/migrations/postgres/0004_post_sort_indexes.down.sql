@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_posts_num_comments_id;
+DROP INDEX IF EXISTS idx_posts_score_id;
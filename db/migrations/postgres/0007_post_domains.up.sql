@@ -0,0 +1,3 @@
+-- every post is saved with its domain from now on, not just those the domain extractor saw; the
+-- domains of posts saved before are filled in by the migration's Go step (fillPostDomains)
+SELECT 1;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS webhook_outbox;
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS backfill_jobs;
+DROP TABLE IF EXISTS poll_log;
+DROP TABLE IF EXISTS tracked_subreddits;
+DROP TABLE IF EXISTS subreddit_cursors;
+DROP TABLE IF EXISTS post_snapshots;
+DROP TABLE IF EXISTS post_refresh;
+DROP TABLE IF EXISTS listing_ranks;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS posts;
@@ -0,0 +1,153 @@
+-- The schema as it stood before migrations were versioned. Every statement is IF NOT EXISTS so
+-- databases created by older releases are adopted; columns they lack are added after this runs.
+
+CREATE TABLE IF NOT EXISTS posts (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	author TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	url TEXT,
+	created_utc REAL NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	upvotes INTEGER NOT NULL,
+	downvotes INTEGER NOT NULL,
+	score INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	upvote_ratio REAL NOT NULL DEFAULT 0,
+	post_hint TEXT,
+	is_video BOOLEAN NOT NULL,
+	is_self BOOLEAN NOT NULL,
+	self_text TEXT,
+	permalink TEXT NOT NULL,
+	processed_time TIMESTAMP NOT NULL,
+	over_18 BOOLEAN NOT NULL DEFAULT 0,
+	domain TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_posts_upvotes ON posts(upvotes DESC);
+CREATE INDEX IF NOT EXISTS idx_posts_author ON posts(author);
+CREATE INDEX IF NOT EXISTS idx_posts_created_utc ON posts(created_utc);
+CREATE INDEX IF NOT EXISTS idx_posts_subreddit_created_utc ON posts(subreddit COLLATE NOCASE, created_utc);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id TEXT PRIMARY KEY,
+	post_id TEXT NOT NULL,
+	parent_id TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	author TEXT NOT NULL,
+	body TEXT,
+	score INTEGER NOT NULL,
+	depth INTEGER NOT NULL,
+	created_utc REAL NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	permalink TEXT,
+	processed_time TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments(post_id);
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
+
+CREATE TABLE IF NOT EXISTS listing_ranks (
+	subreddit TEXT NOT NULL,
+	listing TEXT NOT NULL,
+	time_window TEXT NOT NULL DEFAULT '',
+	snapshot_at INTEGER NOT NULL,
+	rank INTEGER NOT NULL,
+	post_id TEXT NOT NULL,
+	score INTEGER NOT NULL,
+	PRIMARY KEY (subreddit, listing, time_window, snapshot_at, rank)
+);
+CREATE INDEX IF NOT EXISTS idx_listing_ranks_post_id ON listing_ranks(post_id);
+
+CREATE TABLE IF NOT EXISTS post_refresh (
+	post_id TEXT PRIMARY KEY,
+	last_refreshed INTEGER NOT NULL,
+	next_refresh INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_post_refresh_next_refresh ON post_refresh(next_refresh);
+
+CREATE TABLE IF NOT EXISTS post_snapshots (
+	post_id TEXT NOT NULL,
+	observed_at INTEGER NOT NULL,
+	score INTEGER NOT NULL,
+	upvotes INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	upvote_ratio REAL NOT NULL,
+	PRIMARY KEY (post_id, observed_at)
+);
+
+CREATE TABLE IF NOT EXISTS subreddit_cursors (
+	subreddit TEXT PRIMARY KEY,
+	newest_id TEXT NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tracked_subreddits (
+	name TEXT PRIMARY KEY COLLATE NOCASE,
+	tracked INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS poll_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit TEXT NOT NULL,
+	polled_at INTEGER NOT NULL,
+	pages INTEGER NOT NULL,
+	posts INTEGER NOT NULL,
+	new_posts INTEGER NOT NULL,
+	caught_up INTEGER NOT NULL,
+	covered_from INTEGER,
+	gap_start INTEGER,
+	gap_end INTEGER,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_poll_log_subreddit_polled_at ON poll_log(subreddit, polled_at);
+
+CREATE TABLE IF NOT EXISTS backfill_jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit TEXT NOT NULL,
+	since_utc INTEGER NOT NULL,
+	until_utc INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	max_pages INTEGER NOT NULL DEFAULT 0,
+	source TEXT NOT NULL DEFAULT 'listing',
+	cursor TEXT NOT NULL DEFAULT '',
+	pages INTEGER NOT NULL DEFAULT 0,
+	posts INTEGER NOT NULL DEFAULT 0,
+	oldest_utc INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	UNIQUE (subreddit, since_utc, until_utc)
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	rule TEXT NOT NULL,
+	post_id TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	title TEXT NOT NULL,
+	author TEXT NOT NULL,
+	permalink TEXT NOT NULL,
+	score INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	matched TEXT NOT NULL DEFAULT '',
+	fired_at INTEGER NOT NULL,
+	UNIQUE (rule, post_id)
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_fired_at ON alerts(fired_at);
+
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook TEXT NOT NULL,
+	event TEXT NOT NULL,
+	dedup_key TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	delivered_at INTEGER,
+	UNIQUE (webhook, dedup_key)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_status_next_attempt ON webhook_outbox(status, next_attempt_at);
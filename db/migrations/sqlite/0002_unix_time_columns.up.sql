@@ -0,0 +1,71 @@
+-- posts and comments kept created_at and processed_time as the text the driver wrote for a Go time,
+-- such as '2024-05-01 12:00:00.123456789+00:00', which readers couldn't parse reliably. They become
+-- unix seconds like every other time column. SQLite can't change a column's type in place, so both
+-- tables are rebuilt; the text values copied over are converted by the migration's Go step.
+
+CREATE TABLE posts_new (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	author TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	url TEXT,
+	created_utc REAL NOT NULL,
+	created_at INTEGER NOT NULL,
+	upvotes INTEGER NOT NULL,
+	downvotes INTEGER NOT NULL,
+	score INTEGER NOT NULL,
+	num_comments INTEGER NOT NULL,
+	upvote_ratio REAL NOT NULL DEFAULT 0,
+	post_hint TEXT,
+	is_video BOOLEAN NOT NULL,
+	is_self BOOLEAN NOT NULL,
+	self_text TEXT,
+	permalink TEXT NOT NULL,
+	processed_time INTEGER NOT NULL,
+	over_18 BOOLEAN NOT NULL DEFAULT 0,
+	domain TEXT NOT NULL DEFAULT ''
+);
+INSERT INTO posts_new (
+	id, title, author, subreddit, url, created_utc, created_at,
+	upvotes, downvotes, score, num_comments, upvote_ratio, post_hint,
+	is_video, is_self, self_text, permalink, processed_time, over_18, domain
+)
+SELECT
+	id, title, author, subreddit, url, created_utc, created_at,
+	upvotes, downvotes, score, num_comments, upvote_ratio, post_hint,
+	is_video, is_self, self_text, permalink, processed_time, over_18, domain
+FROM posts;
+DROP TABLE posts;
+ALTER TABLE posts_new RENAME TO posts;
+CREATE INDEX idx_posts_upvotes ON posts(upvotes DESC);
+CREATE INDEX idx_posts_author ON posts(author);
+CREATE INDEX idx_posts_created_utc ON posts(created_utc);
+CREATE INDEX idx_posts_subreddit_created_utc ON posts(subreddit COLLATE NOCASE, created_utc);
+
+CREATE TABLE comments_new (
+	id TEXT PRIMARY KEY,
+	post_id TEXT NOT NULL,
+	parent_id TEXT NOT NULL,
+	subreddit TEXT NOT NULL,
+	author TEXT NOT NULL,
+	body TEXT,
+	score INTEGER NOT NULL,
+	depth INTEGER NOT NULL,
+	created_utc REAL NOT NULL,
+	created_at INTEGER NOT NULL,
+	permalink TEXT,
+	processed_time INTEGER NOT NULL
+);
+INSERT INTO comments_new (
+	id, post_id, parent_id, subreddit, author, body, score, depth,
+	created_utc, created_at, permalink, processed_time
+)
+SELECT
+	id, post_id, parent_id, subreddit, author, body, score, depth,
+	created_utc, created_at, permalink, processed_time
+FROM comments;
+DROP TABLE comments;
+ALTER TABLE comments_new RENAME TO comments;
+-- comments of a post are read oldest first, optionally within a time range
+CREATE INDEX idx_comments_post_id_created_utc ON comments(post_id, created_utc);
+CREATE INDEX idx_comments_parent_id ON comments(parent_id);
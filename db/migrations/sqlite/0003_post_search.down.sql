@@ -0,0 +1,4 @@
+-- posts_fts itself is left alone: dropping it needs FTS5, which this build may not have
+DROP TRIGGER IF EXISTS posts_fts_insert;
+DROP TRIGGER IF EXISTS posts_fts_update;
+DROP TRIGGER IF EXISTS posts_fts_delete;
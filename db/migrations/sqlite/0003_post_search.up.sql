@@ -0,0 +1,5 @@
+-- The SQLite search index, the posts_fts FTS5 table and the triggers that keep it in step with
+-- posts, isn't created by a migration: only builds with the sqlite_fts5 tag can write it. Every
+-- build sets it up, or drops its triggers, at startup; see ensureSearchIndex. This version keeps
+-- the migrations of both databases numbered alike.
+SELECT 1;
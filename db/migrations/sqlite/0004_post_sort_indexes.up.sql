@@ -0,0 +1,4 @@
+-- /api/posts pages through posts ordered by score or comments, then id; these indexes let each
+-- page start where the previous one ended instead of sorting every post
+CREATE INDEX IF NOT EXISTS idx_posts_score_id ON posts(score DESC, id);
+CREATE INDEX IF NOT EXISTS idx_posts_num_comments_id ON posts(num_comments DESC, id);
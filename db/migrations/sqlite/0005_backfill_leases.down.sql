@@ -0,0 +1 @@
+ALTER TABLE backfill_jobs DROP COLUMN lease_until;
@@ -0,0 +1,3 @@
+-- a worker claims a backfill job until lease_until (unix seconds) and renews the lease with every
+-- checkpoint; a running job whose lease ran out was abandoned and can be claimed by another worker
+ALTER TABLE backfill_jobs ADD COLUMN lease_until INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- the post.score_threshold events sent, so each webhook hears about a post crossing a threshold
+-- once, however long ago its delivery was pruned from the outbox
+CREATE TABLE IF NOT EXISTS webhook_score_crossings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook TEXT NOT NULL,
+	post_id TEXT NOT NULL,
+	threshold INTEGER NOT NULL,
+	score INTEGER NOT NULL,
+	crossed_at INTEGER NOT NULL,
+	UNIQUE (webhook, post_id, threshold)
+);
@@ -0,0 +1,2 @@
+-- the filled in domains are valid either way, so there's nothing to undo
+SELECT 1;
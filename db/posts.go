@@ -0,0 +1,221 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// ErrInvalidCursor is returned for a cursor that no query of the same order returned
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PostQuery selects, orders and pages stored posts. Zero values don't filter.
+type PostQuery struct {
+	Filter   PostFilter // subreddit and creation range
+	Author   string
+	PostHint string // e.g. image, link, self or hosted:video
+	Domain   string
+	IsSelf   *bool
+	IsVideo  *bool
+	MinScore *int
+	MaxScore *int
+
+	Sort      models.PostSort // score unless set
+	Ascending bool            // lowest first rather than highest; ties are always by id
+	After     string          // the cursor returned with the previous page
+	Limit     int             // 25 unless set
+}
+
+const defaultPostsLimit = 25
+
+// minVelocitySpan is the fewest seconds a velocity is measured over, so a post observed moments
+// after it was created doesn't top the list
+const minVelocitySpan = 300
+
+// postSortKeys maps each order to the expression posts are sorted by
+var postSortKeys = map[models.PostSort]string{
+	models.SortPostsByScore:    "score",
+	models.SortPostsByComments: "num_comments",
+	models.SortPostsByCreated:  "created_utc",
+	models.SortPostsByVelocity: fmt.Sprintf(`CAST(score AS DOUBLE PRECISION) * 3600 / CASE
+		WHEN processed_time - created_utc > %[1]d THEN processed_time - created_utc ELSE %[1]d END`, minVelocitySpan),
+}
+
+// postSortValue is a post's value of the expression in postSortKeys
+func postSortValue(post models.Post, sort models.PostSort) float64 {
+	switch sort {
+	case models.SortPostsByComments:
+		return float64(post.NumComments)
+	case models.SortPostsByCreated:
+		return post.CreatedUTC
+	case models.SortPostsByVelocity:
+		span := float64(post.ProcessedTime.Unix()) - post.CreatedUTC
+		if !(span > minVelocitySpan) {
+			span = minVelocitySpan
+		}
+		return float64(post.Score) * 3600 / span
+	default:
+		return float64(post.Score)
+	}
+}
+
+// postCursor is where a page of posts ended: the sort value and id of its last post
+type postCursor struct {
+	Sort      models.PostSort `json:"s"`
+	Ascending bool            `json:"a,omitempty"`
+	Value     float64         `json:"v"`
+	ID        string          `json:"i"`
+}
+
+func newPostCursor(query PostQuery, last models.Post) string {
+	data, _ := json.Marshal(postCursor{
+		Sort:      query.Sort,
+		Ascending: query.Ascending,
+		Value:     postSortValue(last, query.Sort),
+		ID:        last.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePostCursor decodes the After cursor of a query, which must come from a query of the same order
+func decodePostCursor(query PostQuery) (postCursor, error) {
+	var cursor postCursor
+	data, err := base64.RawURLEncoding.DecodeString(query.After)
+	if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.ID == "" {
+		return cursor, ErrInvalidCursor
+	}
+	if cursor.Sort != query.Sort || cursor.Ascending != query.Ascending {
+		return cursor, fmt.Errorf("%w: it continues posts sorted by %s", ErrInvalidCursor, cursor.Sort)
+	}
+	return cursor, nil
+}
+
+// normalized fills in the default order and limit, and rejects an unknown order
+func (q PostQuery) normalized() (PostQuery, error) {
+	if q.Sort == "" {
+		q.Sort = models.SortPostsByScore
+	}
+	if _, ok := postSortKeys[q.Sort]; !ok {
+		return q, fmt.Errorf("unknown post sort %q", q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultPostsLimit
+	}
+	return q, nil
+}
+
+// queryBuilder collects the conditions of a WHERE clause and their arguments
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (b *queryBuilder) where(condition string, args ...interface{}) {
+	b.conditions = append(b.conditions, condition)
+	b.args = append(b.args, args...)
+}
+
+// build returns the SELECT of a page of the query: the posts after its cursor, one more than the
+// limit to tell whether another page follows
+func (q PostQuery) build() (string, []interface{}, error) {
+	var b queryBuilder
+	b.conditions, b.args = q.Filter.appendConditions(b.conditions, b.args)
+
+	if q.Author != "" {
+		b.where("author = ? COLLATE NOCASE", q.Author)
+	}
+	if q.PostHint != "" {
+		b.where("post_hint = ?", q.PostHint)
+	}
+	if q.Domain != "" {
+		b.where("domain = ? COLLATE NOCASE", q.Domain)
+	}
+	if q.IsSelf != nil {
+		b.where("is_self = ?", *q.IsSelf)
+	}
+	if q.IsVideo != nil {
+		b.where("is_video = ?", *q.IsVideo)
+	}
+	if q.MinScore != nil {
+		b.where("score >= ?", *q.MinScore)
+	}
+	if q.MaxScore != nil {
+		b.where("score <= ?", *q.MaxScore)
+	}
+
+	key := postSortKeys[q.Sort]
+	direction, beyond := "DESC", "<"
+	if q.Ascending {
+		direction, beyond = "ASC", ">"
+	}
+	if q.After != "" {
+		cursor, err := decodePostCursor(q)
+		if err != nil {
+			return "", nil, err
+		}
+		b.where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id > ?))", key, beyond), cursor.Value, cursor.Value, cursor.ID)
+	}
+
+	where, args := whereClause(b.conditions, b.args)
+	query := `
+	SELECT ` + postColumns + `
+	FROM posts
+	` + where + `
+	ORDER BY ` + key + ` ` + direction + `, id ASC
+	LIMIT ?
+	`
+	return query, append(args, q.Limit+1), nil
+}
+
+// GetPosts returns a page of the posts that match the query, and the cursor of the next page, or
+// an empty one after the last page
+func (d *Database) GetPosts(query PostQuery) ([]models.Post, string, error) {
+	query, err := query.normalized()
+	if err != nil {
+		return nil, "", err
+	}
+	selectPosts, args, err := query.build()
+	if err != nil {
+		return nil, "", err
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	posts, err := d.queryPosts(selectPosts, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query posts: %w", err)
+	}
+
+	posts, next := nextPage(query, posts)
+	return posts, next, nil
+}
+
+// nextPage trims the extra post a page was fetched with and returns the cursor after the page
+func nextPage(query PostQuery, posts []models.Post) ([]models.Post, string) {
+	if len(posts) <= query.Limit {
+		return posts, ""
+	}
+	posts = posts[:query.Limit]
+	return posts, newPostCursor(query, posts[len(posts)-1])
+}
+
+// matches reports whether a post passes the query's filters, as its WHERE clause does
+func (q PostQuery) matches(post models.Post) bool {
+	switch {
+	case !q.Filter.matches(post),
+		q.Author != "" && !strings.EqualFold(post.Author, q.Author),
+		q.PostHint != "" && post.PostHint != q.PostHint,
+		q.Domain != "" && !strings.EqualFold(post.Domain, q.Domain),
+		q.IsSelf != nil && post.IsSelf != *q.IsSelf,
+		q.IsVideo != nil && post.IsVideo != *q.IsVideo,
+		q.MinScore != nil && post.Score < *q.MinScore,
+		q.MaxScore != nil && post.Score > *q.MaxScore:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// ScheduleRefresh records when a post was last observed and when its score should next be refreshed.
+// A zero nextRefresh means the post is too old and should no longer be refreshed.
+func (d *Database) ScheduleRefresh(postID string, lastRefreshed, nextRefresh time.Time) error {
+	return d.ScheduleRefreshes([]models.PostRefresh{{PostID: postID, LastRefreshed: lastRefreshed, NextRefresh: nextRefresh}})
+}
+
+// ScheduleRefreshes records the refresh schedule of a batch of posts in a single transaction
+func (d *Database) ScheduleRefreshes(refreshes []models.PostRefresh) error {
+	if len(refreshes) == 0 {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := d.prepare(tx, `
+	INSERT INTO post_refresh (post_id, last_refreshed, next_refresh)
+	VALUES (?, ?, ?)
+	ON CONFLICT (post_id) DO UPDATE SET last_refreshed = excluded.last_refreshed, next_refresh = excluded.next_refresh
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare refresh schedule insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, refresh := range refreshes {
+		var next sql.NullInt64
+		if !refresh.NextRefresh.IsZero() {
+			next = sql.NullInt64{Int64: refresh.NextRefresh.Unix(), Valid: true}
+		}
+
+		if _, err := stmt.Exec(refresh.PostID, refresh.LastRefreshed.Unix(), next); err != nil {
+			return fmt.Errorf("failed to schedule refresh of post %s: %w", refresh.PostID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit refresh schedules: %w", err)
+	}
+
+	return nil
+}
+
+// GetPostsDueForRefresh returns the ids of up to limit posts whose refresh is due at the given time,
+// most overdue first
+func (d *Database) GetPostsDueForRefresh(now time.Time, limit int) ([]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	query := `
+	SELECT post_id
+	FROM post_refresh
+	WHERE next_refresh IS NOT NULL AND next_refresh <= ?
+	ORDER BY next_refresh ASC
+	LIMIT ?
+	`
+
+	rows, err := d.query(query, now.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posts due for refresh: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan post id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return ids, nil
+}
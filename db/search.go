@@ -0,0 +1,552 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// ErrInvalidSearch is returned for a search query without a word to match
+var ErrInvalidSearch = errors.New("search query has no words to match")
+
+// Page is one page of a paged result, numbered from 1
+type Page struct {
+	Number int // 1 unless set
+	Size   int // 20 unless set
+}
+
+const defaultSearchPageSize = 20
+
+func (p Page) normalized() Page {
+	if p.Number < 1 {
+		p.Number = 1
+	}
+	if p.Size < 1 {
+		p.Size = defaultSearchPageSize
+	}
+	return p
+}
+
+func (p Page) offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// searchTerm is one word, word* prefix or "quoted phrase" of a search query; a post matches when
+// every term of the query is found in one of its title, selftext, author or subreddit
+type searchTerm struct {
+	words  []string // lowercased, in order
+	prefix bool     // the last word also matches the longer words it starts
+}
+
+// parseSearchQuery splits a query into its terms. Anything but letters and numbers separates words,
+// as it does for the full-text index, so "error-handling" is the phrase "error handling".
+func parseSearchQuery(query string) ([]searchTerm, error) {
+	terms := make([]searchTerm, 0)
+	for i, part := range strings.Split(query, `"`) {
+		// odd parts were between quotes
+		if i%2 == 1 {
+			if words := searchWords(part); len(words) > 0 {
+				terms = append(terms, searchTerm{words: words})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			if words := searchWords(field); len(words) > 0 {
+				terms = append(terms, searchTerm{words: words, prefix: strings.HasSuffix(field, "*")})
+			}
+		}
+	}
+
+	if len(terms) == 0 {
+		return nil, ErrInvalidSearch
+	}
+	return terms, nil
+}
+
+// searchWords returns the lowercased words of a text
+func searchWords(text string) []string {
+	spans := wordSpans(text)
+	words := make([]string, len(spans))
+	for i, span := range spans {
+		words[i] = strings.ToLower(text[span[0]:span[1]])
+	}
+	return words
+}
+
+// wordSpans returns the byte offsets where each word of a text starts and ends
+func wordSpans(text string) [][2]int {
+	spans := make([][2]int, 0)
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// matchesAt reports whether the term is found at position i of words
+func (t searchTerm) matchesAt(words []string, i int) bool {
+	if i+len(t.words) > len(words) {
+		return false
+	}
+	last := len(t.words) - 1
+	for j, word := range t.words {
+		if j == last && t.prefix {
+			if !strings.HasPrefix(words[i+j], word) {
+				return false
+			}
+		} else if words[i+j] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// count returns how many times the term is found in words
+func (t searchTerm) count(words []string) int {
+	n := 0
+	for i := range words {
+		if t.matchesAt(words, i) {
+			n++
+		}
+	}
+	return n
+}
+
+// ftsQuery is the FTS5 MATCH expression of the terms: each one a quoted phrase, * for a prefix
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = `"` + strings.Join(term.words, " ") + `"`
+		if term.prefix {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// tsQuery is the PostgreSQL tsquery of the terms: the words of a phrase follow each other, :* for
+// a prefix
+func tsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = "(" + strings.Join(term.words, " <-> ")
+		if term.prefix {
+			parts[i] += ":*"
+		}
+		parts[i] += ")"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// searchedPost is a post split into the words of each of its searched columns
+type searchedPost struct {
+	post                               models.Post
+	title, selfText, author, subreddit []string
+}
+
+func newSearchedPost(post models.Post) searchedPost {
+	return searchedPost{
+		post:      post,
+		title:     searchWords(post.Title),
+		selfText:  searchWords(post.SelfText),
+		author:    searchWords(post.Author),
+		subreddit: searchWords(post.Subreddit),
+	}
+}
+
+// relevance weighs how often the terms are found, a title match the most; ok is false unless every
+// term is found. The weights are those the SQLite index ranks by.
+func (p searchedPost) relevance(terms []searchTerm) (score float64, ok bool) {
+	for _, term := range terms {
+		found := 10*float64(term.count(p.title)) + float64(term.count(p.selfText)) +
+			2*float64(term.count(p.author)) + 2*float64(term.count(p.subreddit))
+		if found == 0 {
+			return 0, false
+		}
+		score += found
+	}
+	return score, true
+}
+
+// searchPosts matches, orders and pages posts without an index, for the memory store and SQLite
+// builds without FTS5. It returns the page and the number of matches.
+func searchPosts(posts []models.Post, terms []searchTerm, order models.SearchSort, page Page) ([]models.Post, int) {
+	type match struct {
+		post  models.Post
+		score float64
+	}
+	matches := make([]match, 0)
+	for _, post := range posts {
+		if score, ok := newSearchedPost(post).relevance(terms); ok {
+			matches = append(matches, match{post, score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case order == models.SortSearchByRelevance && a.score != b.score:
+			return a.score > b.score
+		case order == models.SortSearchByTop && a.post.Score != b.post.Score:
+			return a.post.Score > b.post.Score
+		case order != models.SortSearchByTop && a.post.CreatedUTC != b.post.CreatedUTC:
+			return a.post.CreatedUTC > b.post.CreatedUTC
+		}
+		return a.post.ID < b.post.ID
+	})
+
+	found := make([]models.Post, 0, page.Size)
+	for i := page.offset(); i < len(matches) && len(found) < page.Size; i++ {
+		found = append(found, matches[i].post)
+	}
+	return found, len(matches)
+}
+
+// searchOrder maps each order to its ORDER BY clause after the relevance rank; ties go to the
+// newest post, then by id
+var searchOrder = map[models.SearchSort]string{
+	models.SortSearchByRelevance: "created_utc DESC, id ASC",
+	models.SortSearchByNew:       "created_utc DESC, id ASC",
+	models.SortSearchByTop:       "score DESC, id ASC",
+}
+
+// snippetWords is the length of a selftext snippet, of which snippetContext come before the first match
+const (
+	snippetWords   = 30
+	snippetContext = 8
+)
+
+// newSearchResults highlights the matches of a page of posts
+func newSearchResults(query string, order models.SearchSort, page Page, total int, indexed bool,
+	posts []models.Post, terms []searchTerm) *models.SearchResults {
+	results := make([]models.SearchResult, len(posts))
+	for i, post := range posts {
+		results[i] = models.SearchResult{
+			Post:           post,
+			TitleHighlight: highlight(post.Title, terms, 0, -1),
+			Snippet:        snippet(post.SelfText, terms),
+		}
+	}
+
+	return &models.SearchResults{
+		Query:    query,
+		Sort:     order,
+		Page:     page.Number,
+		PageSize: page.Size,
+		Total:    total,
+		Indexed:  indexed,
+		Results:  results,
+	}
+}
+
+// matchedWords marks the words that are part of a match of any term
+func matchedWords(words []string, terms []searchTerm) []bool {
+	marked := make([]bool, len(words))
+	for i := range words {
+		for _, term := range terms {
+			if term.matchesAt(words, i) {
+				for j := range term.words {
+					marked[i+j] = true
+				}
+			}
+		}
+	}
+	return marked
+}
+
+// highlight escapes the words from first up to last (-1 for the end) of a text, and what's between
+// them, wrapping the matched ones in <mark>
+func highlight(text string, terms []searchTerm, first, last int) string {
+	spans := wordSpans(text)
+	if len(spans) == 0 {
+		return html.EscapeString(text)
+	}
+	if last < 0 || last >= len(spans) {
+		last = len(spans) - 1
+	}
+	words := make([]string, len(spans))
+	for i, span := range spans {
+		words[i] = strings.ToLower(text[span[0]:span[1]])
+	}
+	marked := matchedWords(words, terms)
+
+	var b strings.Builder
+	from := 0
+	if first > 0 {
+		from = spans[first][0]
+	}
+	for i := first; i <= last; i++ {
+		b.WriteString(html.EscapeString(text[from:spans[i][0]]))
+		word := html.EscapeString(text[spans[i][0]:spans[i][1]])
+		if marked[i] {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+		from = spans[i][1]
+	}
+	if last == len(spans)-1 {
+		b.WriteString(html.EscapeString(text[from:]))
+	}
+	return b.String()
+}
+
+// snippet returns the words of a selftext around its first match, highlighted, with … where it was
+// cut; the start of the text when nothing in it matched
+func snippet(text string, terms []searchTerm) string {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	spans := wordSpans(text)
+	if len(spans) <= snippetWords {
+		return highlight(text, terms, 0, -1)
+	}
+
+	first := 0
+	for i, marked := range matchedWords(searchWords(text), terms) {
+		if marked {
+			first = max(i-snippetContext, 0)
+			break
+		}
+	}
+	first = min(first, len(spans)-snippetWords)
+	last := first + snippetWords - 1
+
+	s := highlight(text, terms, first, last)
+	if first > 0 {
+		s = "…" + s
+	}
+	if last < len(spans)-1 {
+		s += "…"
+	}
+	return s
+}
+
+// fullTextSearch is how a dialect's full-text index is queried
+type fullTextSearch struct {
+	from  string      // posts and the matches of the query, which takes arg
+	where string      // the condition keeping the matches, if from doesn't
+	rank  string      // the ORDER BY of the best matches first
+	arg   interface{} // the query built from the terms
+}
+
+func (dl dialect) fullTextSearch(terms []searchTerm) fullTextSearch {
+	if dl.name == postgresDialect.name {
+		return fullTextSearch{
+			from:  "posts, to_tsquery('simple', ?) AS search_query",
+			where: "search_vector @@ search_query",
+			rank:  "ts_rank(search_vector, search_query) DESC",
+			arg:   tsQuery(terms),
+		}
+	}
+	// bm25 ranks the best matches lowest; the weights are those of title, self_text, author and subreddit
+	return fullTextSearch{
+		from: `posts JOIN (
+			SELECT rowid AS match_rowid, bm25(posts_fts, 10.0, 1.0, 2.0, 2.0) AS match_rank
+			FROM posts_fts WHERE posts_fts MATCH ?
+		) AS matches ON posts.rowid = matches.match_rowid`,
+		rank: "match_rank ASC",
+		arg:  ftsQuery(terms),
+	}
+}
+
+// prepareSearch parses a search query and fills in the default order and page
+func prepareSearch(query string, order models.SearchSort, page Page) ([]searchTerm, models.SearchSort, Page, error) {
+	terms, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, order, page, err
+	}
+	if order == "" {
+		order = models.SortSearchByRelevance
+	}
+	if _, ok := searchOrder[order]; !ok {
+		return nil, order, page, fmt.Errorf("unknown search sort %q", order)
+	}
+	return terms, order, page.normalized(), nil
+}
+
+// SearchPosts returns one page of the posts that match a search query and the filter. The query
+// takes words, word* prefixes and "quoted phrases", all of which must match. Without a full-text
+// index, on SQLite built without FTS5, the posts are scanned instead.
+func (d *Database) SearchPosts(query string, filter PostFilter, order models.SearchSort, page Page) (*models.SearchResults, error) {
+	terms, order, page, err := prepareSearch(query, order, page)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var posts []models.Post
+	var total int
+	if d.searchIndexed {
+		posts, total, err = d.searchIndex(terms, filter, order, page)
+	} else {
+		posts, total, err = d.searchScan(terms, filter, order, page)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newSearchResults(query, order, page, total, d.searchIndexed, posts, terms), nil
+}
+
+// searchIndex runs a search on the full-text index; callers hold the mutex
+func (d *Database) searchIndex(terms []searchTerm, filter PostFilter, order models.SearchSort, page Page) ([]models.Post, int, error) {
+	search := d.dialect.fullTextSearch(terms)
+	conditions := make([]string, 0, 4)
+	if search.where != "" {
+		conditions = append(conditions, search.where)
+	}
+	conditions, args := filter.appendConditions(conditions, []interface{}{search.arg})
+	where, args := whereClause(conditions, args)
+
+	var total int
+	if err := d.queryRow("SELECT COUNT(*) FROM "+search.from+" "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	orderBy := searchOrder[order]
+	if order == models.SortSearchByRelevance {
+		orderBy = search.rank + ", " + orderBy
+	}
+	query := `
+	SELECT ` + postColumns + `
+	FROM ` + search.from + `
+	` + where + `
+	ORDER BY ` + orderBy + `
+	LIMIT ? OFFSET ?
+	`
+
+	posts, err := d.queryPosts(query, append(args, page.Size, page.offset())...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search posts: %w", err)
+	}
+	return posts, total, nil
+}
+
+// searchScan runs a search without an index: LIKE narrows the posts down to those containing every
+// term, and searchPosts keeps the ones where it's a whole word; callers hold the mutex
+func (d *Database) searchScan(terms []searchTerm, filter PostFilter, order models.SearchSort, page Page) ([]models.Post, int, error) {
+	conditions := make([]string, 0, len(terms))
+	args := make([]interface{}, 0, 4*len(terms))
+	for _, term := range terms {
+		conditions = append(conditions, "(title LIKE ? OR self_text LIKE ? OR author LIKE ? OR subreddit LIKE ?)")
+		pattern := "%" + strings.Join(term.words, "%") + "%"
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	conditions, args = filter.appendConditions(conditions, args)
+	where, args := whereClause(conditions, args)
+
+	candidates, err := d.queryPosts("SELECT "+postColumns+" FROM posts "+where, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search posts: %w", err)
+	}
+
+	posts, total := searchPosts(candidates, terms, order, page)
+	return posts, total, nil
+}
+
+// searchTriggers keep the SQLite full-text index in step with posts. posts_fts holds no copy of
+// the text; it indexes the posts rows by rowid, so a changed row is removed with its old values.
+var searchTriggers = map[string]string{
+	"posts_fts_insert": `AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts (rowid, title, self_text, author, subreddit)
+		VALUES (new.rowid, new.title, new.self_text, new.author, new.subreddit);
+	END`,
+	"posts_fts_update": `AFTER UPDATE OF title, self_text, author, subreddit ON posts BEGIN
+		INSERT INTO posts_fts (posts_fts, rowid, title, self_text, author, subreddit)
+		VALUES ('delete', old.rowid, old.title, old.self_text, old.author, old.subreddit);
+		INSERT INTO posts_fts (rowid, title, self_text, author, subreddit)
+		VALUES (new.rowid, new.title, new.self_text, new.author, new.subreddit);
+	END`,
+	"posts_fts_delete": `AFTER DELETE ON posts BEGIN
+		INSERT INTO posts_fts (posts_fts, rowid, title, self_text, author, subreddit)
+		VALUES ('delete', old.rowid, old.title, old.self_text, old.author, old.subreddit);
+	END`,
+}
+
+// ensureSearchIndex sets up the full-text index of posts. PostgreSQL's is a column its migrations
+// add. SQLite's is an FTS5 table, which only builds with the sqlite_fts5 tag can write, so it's
+// kept outside the migrations: a build with FTS5 creates it and its triggers, and fills it whenever
+// the triggers were missing; a build without drops the triggers, which would fail every post write,
+// and searches by scanning.
+func (d *Database) ensureSearchIndex() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.dialect.name != sqliteDialect.name {
+		d.searchIndexed = true
+		return nil
+	}
+
+	var available bool
+	if err := d.queryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available); err != nil {
+		return fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if !available {
+		for name := range searchTriggers {
+			if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return fmt.Errorf("failed to drop trigger %s: %w", name, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit search index: %w", err)
+		}
+		d.log.Warn("SQLite was built without FTS5, so searches scan the posts; build with -tags sqlite_fts5 to index them")
+		return nil
+	}
+
+	var existing int
+	err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'posts\\_fts\\_%' ESCAPE '\\'").Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("failed to check search triggers: %w", err)
+	}
+
+	_, err = tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+		title, self_text, author, subreddit, content = 'posts', content_rowid = 'rowid'
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	for name, trigger := range searchTriggers {
+		if _, err := tx.Exec("CREATE TRIGGER IF NOT EXISTS " + name + " " + trigger); err != nil {
+			return fmt.Errorf("failed to create trigger %s: %w", name, err)
+		}
+	}
+
+	// posts changed while a trigger was missing, or were there before the index
+	if existing < len(searchTriggers) {
+		if _, err := tx.Exec("INSERT INTO posts_fts (posts_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to rebuild search index: %w", err)
+		}
+		d.log.Info("Rebuilt the search index")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit search index: %w", err)
+	}
+	d.searchIndexed = true
+	return nil
+}
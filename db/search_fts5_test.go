@@ -0,0 +1,33 @@
+//go:build sqlite_fts5
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func TestSearchIndexRebuild(t *testing.T) {
+	database := newTestDatabase(t)
+	require.True(t, database.searchIndexed)
+
+	// posts saved by a build without FTS5, which drops the triggers
+	_, err := database.db.Exec("DROP TRIGGER posts_fts_insert")
+	require.NoError(t, err)
+	require.NoError(t, database.SavePost(&models.Post{ID: "r1", Title: "Rebuilt index", Author: "alice", Subreddit: "golang"}))
+
+	results, err := database.SearchPosts("rebuilt", PostFilter{}, "", Page{})
+	require.NoError(t, err)
+	assert.Empty(t, results.Results)
+
+	require.NoError(t, database.ensureSearchIndex())
+	results, err = database.SearchPosts("rebuilt", PostFilter{}, "", Page{})
+	require.NoError(t, err)
+	assert.True(t, results.Indexed)
+	require.Len(t, results.Results, 1)
+	assert.Equal(t, "r1", results.Results[0].Post.ID)
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	terms, err := parseSearchQuery(`Error-handling "wrap the  ERRORS" gener* *`)
+	require.NoError(t, err)
+	assert.Equal(t, []searchTerm{
+		{words: []string{"error", "handling"}},
+		{words: []string{"wrap", "the", "errors"}},
+		{words: []string{"gener"}, prefix: true},
+	}, terms)
+
+	assert.Equal(t, `"error handling" "wrap the errors" "gener"*`, ftsQuery(terms))
+	assert.Equal(t, `(error <-> handling) & (wrap <-> the <-> errors) & (gener:*)`, tsQuery(terms))
+
+	_, err = parseSearchQuery(`"" -- *`)
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+}
+
+func TestSnippet(t *testing.T) {
+	terms, err := parseSearchQuery("needle")
+	require.NoError(t, err)
+
+	short := "A needle & a thread."
+	assert.Equal(t, "A <mark>needle</mark> &amp; a thread.", snippet(short, terms))
+
+	words := make([]string, 60)
+	for i := range words {
+		words[i] = "hay"
+	}
+	words[20] = "needle"
+	s := snippet(strings.Join(words, " ")+".", terms)
+	assert.True(t, strings.HasPrefix(s, "…hay "))
+	assert.True(t, strings.HasSuffix(s, " hay…"))
+	assert.Equal(t, snippetWords, len(searchWords(strings.NewReplacer("<mark>", "", "</mark>", "").Replace(s))))
+	assert.Contains(t, s, strings.Repeat("hay ", snippetContext)+"<mark>needle</mark>")
+
+	// nothing matched: the start of the text
+	s = snippet(strings.Repeat("hay ", 40), terms)
+	assert.True(t, strings.HasPrefix(s, "hay hay"))
+	assert.True(t, strings.HasSuffix(s, "hay…"))
+
+	assert.Equal(t, "", snippet("  ", terms))
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,34 +14,83 @@ import (
 	"github.com/brettboylen/reddit-tracker/models"
 )
 
-// Database provides methods for storing and retrieving Reddit posts
+// ErrPostNotFound is returned when a requested post isn't stored
+var ErrPostNotFound = errors.New("post not found")
+
+// Database stores posts and everything derived from them in a SQL database, SQLite or PostgreSQL
 type Database struct {
-	db    *sql.DB
-	mutex sync.RWMutex
-	log   *logrus.Logger
+	db      *sql.DB
+	dialect dialect
+	mutex   sync.RWMutex
+	log     *logrus.Logger
+	// searchIndexed is set when searches can use a full-text index; see ensureSearchIndex
+	searchIndexed bool
 }
 
-// NewDatabase creates a new SQLite database connection
+// NewDatabase opens a SQLite database and applies any pending migrations
 func NewDatabase(dbPath string, log *logrus.Logger) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	return newMigratedDatabase(sqliteDialect.name, dbPath, log)
+}
+
+// newMigratedDatabase opens a database, applies any pending migrations and sets up its search index
+func newMigratedDatabase(driver, dsn string, log *logrus.Logger) (*Database, error) {
+	database, err := OpenDatabase(driver, dsn, log)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := database.MigrateUp(0); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	if err := database.ensureSearchIndex(); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return database, nil
+}
+
+// OpenDatabase opens a sqlite3 or postgres database without migrating it, for the migrate command
+func OpenDatabase(driver, dsn string, log *logrus.Logger) (*Database, error) {
+	dl, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(dl.name, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	database := &Database{
-		db:  db,
-		log: log,
-	}
+	return &Database{
+		db:      db,
+		dialect: dl,
+		log:     log,
+	}, nil
+}
 
-	if err := database.initTables(); err != nil {
-		return nil, fmt.Errorf("failed to initialize tables: %w", err)
-	}
+// query, queryRow, exec and prepare run a query with its placeholders rebound for the dialect
+func (d *Database) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.Query(d.dialect.rebind(query), args...)
+}
 
-	return database, nil
+func (d *Database) queryRow(query string, args ...interface{}) *sql.Row {
+	return d.db.QueryRow(d.dialect.rebind(query), args...)
+}
+
+func (d *Database) exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.db.Exec(d.dialect.rebind(query), args...)
+}
+
+func (d *Database) prepare(tx *sql.Tx, query string) (*sql.Stmt, error) {
+	return tx.Prepare(d.dialect.rebind(query))
 }
 
 // Close closes the database connection
@@ -49,63 +100,150 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
-// initTables creates the necessary tables if they don't exist
-func (d *Database) initTables() error {
+// SavePost saves a post to the database and records a snapshot of its score if it changed
+func (d *Database) SavePost(post *models.Post) error {
+	return d.SavePosts([]models.Post{*post})
+}
+
+// SavePosts saves a batch of posts, such as one fetched page, in a single transaction and records a
+// snapshot of each post's score if it changed. Either every post is saved or none is.
+func (d *Database) SavePosts(posts []models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	// note: in an ideal world, this would be a migration that we could just run once per environment (ie dev, staging, prod)
-	query := `
-	CREATE TABLE IF NOT EXISTS posts (
-		id TEXT PRIMARY KEY,
-		title TEXT NOT NULL,
-		author TEXT NOT NULL,
-		subreddit TEXT NOT NULL,
-		url TEXT,
-		created_utc REAL NOT NULL,
-		created_at TIMESTAMP NOT NULL,
-		upvotes INTEGER NOT NULL,
-		downvotes INTEGER NOT NULL,
-		score INTEGER NOT NULL,
-		num_comments INTEGER NOT NULL,
-		post_hint TEXT,
-		is_video BOOLEAN NOT NULL,
-		is_self BOOLEAN NOT NULL,
-		self_text TEXT,
-		permalink TEXT NOT NULL,
-		processed_time TIMESTAMP NOT NULL
-	);
-	CREATE INDEX IF NOT EXISTS idx_posts_upvotes ON posts(upvotes DESC);
-	CREATE INDEX IF NOT EXISTS idx_posts_author ON posts(author);
-	`
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	_, err := d.db.Exec(query)
-	return err
+	w, err := d.newPostWriter(tx)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+
+	for i := range posts {
+		if err := w.save(&posts[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit posts: %w", err)
+	}
+
+	return nil
 }
 
-// SavePost saves a post to the database
-func (d *Database) SavePost(post *models.Post) error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+// postWriter holds the prepared statements that save posts and their snapshots within a transaction
+type postWriter struct {
+	insertPost     *sql.Stmt
+	latestSnapshot *sql.Stmt
+	insertSnapshot *sql.Stmt
+}
 
-	query := `
-	INSERT OR REPLACE INTO posts (
+func (d *Database) newPostWriter(tx *sql.Tx) (*postWriter, error) {
+	w := &postWriter{}
+	var err error
+
+	w.insertPost, err = d.prepare(tx, `
+	INSERT INTO posts (
 		id, title, author, subreddit, url, created_utc, created_at,
-		upvotes, downvotes, score, num_comments, post_hint,
-		is_video, is_self, self_text, permalink, processed_time
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
+		upvotes, downvotes, score, num_comments, upvote_ratio, post_hint,
+		is_video, is_self, self_text, permalink, processed_time, over_18, domain
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET
+		title = excluded.title, author = excluded.author, subreddit = excluded.subreddit, url = excluded.url,
+		created_utc = excluded.created_utc, created_at = excluded.created_at, upvotes = excluded.upvotes,
+		downvotes = excluded.downvotes, score = excluded.score, num_comments = excluded.num_comments,
+		upvote_ratio = excluded.upvote_ratio, post_hint = excluded.post_hint, is_video = excluded.is_video,
+		is_self = excluded.is_self, self_text = excluded.self_text, permalink = excluded.permalink,
+		processed_time = excluded.processed_time, over_18 = excluded.over_18, domain = excluded.domain
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare post insert: %w", err)
+	}
+
+	w.latestSnapshot, err = d.prepare(tx, `
+	SELECT score, upvotes, num_comments, upvote_ratio
+	FROM post_snapshots
+	WHERE post_id = ?
+	ORDER BY observed_at DESC
+	LIMIT 1
+	`)
+	if err != nil {
+		w.close()
+		return nil, fmt.Errorf("failed to prepare snapshot query: %w", err)
+	}
+
+	w.insertSnapshot, err = d.prepare(tx, `
+	INSERT INTO post_snapshots (
+		post_id, observed_at, score, upvotes, num_comments, upvote_ratio
+	) VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (post_id, observed_at) DO UPDATE SET
+		score = excluded.score, upvotes = excluded.upvotes, num_comments = excluded.num_comments,
+		upvote_ratio = excluded.upvote_ratio
+	`)
+	if err != nil {
+		w.close()
+		return nil, fmt.Errorf("failed to prepare snapshot insert: %w", err)
+	}
+
+	return w, nil
+}
 
-	_, err := d.db.Exec(
-		query,
+func (w *postWriter) close() {
+	for _, stmt := range []*sql.Stmt{w.insertPost, w.latestSnapshot, w.insertSnapshot} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// save saves a post and records its current score in post_snapshots, unless nothing changed since
+// the latest snapshot
+func (w *postWriter) save(post *models.Post) error {
+	// the domain filter relies on every post having one, not just those the domain extractor saw
+	if post.Domain == "" {
+		post.Domain = post.LinkDomain()
+	}
+
+	_, err := w.insertPost.Exec(
 		post.ID, post.Title, post.Author, post.Subreddit, post.URL,
-		post.CreatedUTC, post.CreatedAt, post.Upvotes, post.Downvotes,
-		post.Score, post.NumComments, post.PostHint, post.IsVideo,
-		post.IsSelf, post.SelfText, post.Permalink, post.ProcessedTime,
+		post.CreatedUTC, post.CreatedAt.Unix(), post.Upvotes, post.Downvotes,
+		post.Score, post.NumComments, post.UpvoteRatio, post.PostHint, post.IsVideo,
+		post.IsSelf, post.SelfText, post.Permalink, post.ProcessedTime.Unix(), post.Over18, post.Domain,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to save post %s: %w", post.ID, err)
+	}
 
+	var score, upvotes, numComments int
+	var upvoteRatio float64
+	err = w.latestSnapshot.QueryRow(post.ID).Scan(&score, &upvotes, &numComments, &upvoteRatio)
+
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return fmt.Errorf("failed to get latest snapshot of post %s: %w", post.ID, err)
+	case score == post.Score && upvotes == post.Upvotes &&
+		numComments == post.NumComments && upvoteRatio == post.UpvoteRatio:
+		return nil
+	}
+
+	observedAt := post.ProcessedTime
+	if observedAt.IsZero() {
+		observedAt = time.Now()
+	}
+
+	_, err = w.insertSnapshot.Exec(post.ID, observedAt.Unix(), post.Score, post.Upvotes, post.NumComments, post.UpvoteRatio)
 	if err != nil {
-		return fmt.Errorf("failed to save post: %w", err)
+		return fmt.Errorf("failed to save snapshot of post %s: %w", post.ID, err)
 	}
 
 	return nil
@@ -113,142 +251,178 @@ func (d *Database) SavePost(post *models.Post) error {
 
 // GetTopPostsByUpvotes returns the top N posts by upvotes
 func (d *Database) GetTopPostsByUpvotes(limit int) ([]models.Post, error) {
+	return d.GetTopPostsInRange(PostFilter{}, limit)
+}
+
+// GetTopUsersByPostCount returns the top N users by post count, most posts first
+func (d *Database) GetTopUsersByPostCount(limit int) ([]models.UserPostCount, error) {
+	return d.GetTopUsersInRange(PostFilter{}, limit)
+}
+
+// GetTotalPosts returns the total number of posts in the database
+func (d *Database) GetTotalPosts() (int, error) {
+	return d.CountPostsInRange(PostFilter{})
+}
+
+// GetPostsBySubreddit returns the posts of a subreddit created in [since, until), most upvoted
+// first; zero times don't bound the range
+func (d *Database) GetPostsBySubreddit(subreddit string, since, until time.Time) ([]models.Post, error) {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
 
+	where, args := PostFilter{Subreddit: subreddit, Since: since, Until: until}.where()
 	query := `
-	SELECT id, title, author, subreddit, url, created_utc, created_at,
-		upvotes, downvotes, score, num_comments, post_hint,
-		is_video, is_self, self_text, permalink, processed_time
+	SELECT ` + postColumns + `
 	FROM posts
-	ORDER BY upvotes DESC
-	LIMIT ?
+	` + where + `
+	ORDER BY upvotes DESC, id ASC
 	`
 
-	rows, err := d.db.Query(query, limit)
+	posts, err := d.queryPosts(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posts for subreddit %s: %w", subreddit, err)
+	}
+
+	return posts, nil
+}
+
+// GetPostSummaries returns the statistics-relevant fields of every stored post
+func (d *Database) GetPostSummaries() ([]models.PostSummary, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	rows, err := d.query(`SELECT id, subreddit, author, upvotes FROM posts`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query top posts: %w", err)
+		return nil, fmt.Errorf("failed to query post summaries: %w", err)
 	}
 	defer rows.Close()
 
-	posts := make([]models.Post, 0, limit)
+	summaries := make([]models.PostSummary, 0)
 	for rows.Next() {
-		var post models.Post
-		var createdAt string
-		var processedTime string
-
-		err := rows.Scan(
-			&post.ID, &post.Title, &post.Author, &post.Subreddit, &post.URL,
-			&post.CreatedUTC, &createdAt, &post.Upvotes, &post.Downvotes,
-			&post.Score, &post.NumComments, &post.PostHint, &post.IsVideo,
-			&post.IsSelf, &post.SelfText, &post.Permalink, &processedTime,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan post: %w", err)
+		var summary models.PostSummary
+		if err := rows.Scan(&summary.ID, &summary.Subreddit, &summary.Author, &summary.Upvotes); err != nil {
+			return nil, fmt.Errorf("failed to scan post summary: %w", err)
 		}
-
-		post.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		post.ProcessedTime, _ = time.Parse(time.RFC3339, processedTime)
-		posts = append(posts, post)
+		summaries = append(summaries, summary)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 
-	return posts, nil
+	return summaries, nil
 }
 
-// GetTopUsersByPostCount returns the top N users by post count
-func (d *Database) GetTopUsersByPostCount(limit int) (map[string]int, error) {
+// GetPost returns a single post by id, or ErrPostNotFound
+func (d *Database) GetPost(id string) (*models.Post, error) {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
 
 	query := `
-	SELECT author, COUNT(*) as post_count
+	SELECT ` + postColumns + `
 	FROM posts
-	GROUP BY author
-	ORDER BY post_count DESC
-	LIMIT ?
+	WHERE id = ?
+	`
+
+	post, err := scanPost(d.queryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post %s: %w", id, err)
+	}
+
+	return &post, nil
+}
+
+// GetPostHistory returns the snapshots of a post observed in [since, until), oldest first; zero
+// times don't bound the range
+func (d *Database) GetPostHistory(postID string, since, until time.Time) ([]models.PostSnapshot, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	conditions, args := appendRange([]string{"post_id = ?"}, []interface{}{postID}, "observed_at", since, until)
+	query := `
+	SELECT post_id, observed_at, score, upvotes, num_comments, upvote_ratio
+	FROM post_snapshots
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY observed_at ASC
 	`
 
-	rows, err := d.db.Query(query, limit)
+	rows, err := d.query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query top users: %w", err)
+		return nil, fmt.Errorf("failed to query history of post %s: %w", postID, err)
 	}
 	defer rows.Close()
 
-	users := make(map[string]int)
+	snapshots := make([]models.PostSnapshot, 0)
 	for rows.Next() {
-		var author string
-		var count int
-		
-		if err := rows.Scan(&author, &count); err != nil {
-			return nil, fmt.Errorf("failed to scan user post count: %w", err)
+		var snapshot models.PostSnapshot
+		var observedAt int64
+
+		err := rows.Scan(
+			&snapshot.PostID, &observedAt, &snapshot.Score,
+			&snapshot.Upvotes, &snapshot.NumComments, &snapshot.UpvoteRatio,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
 		}
-		
-		users[author] = count
+
+		snapshot.ObservedAt = time.Unix(observedAt, 0).UTC()
+		snapshots = append(snapshots, snapshot)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 
-	return users, nil
+	return snapshots, nil
 }
 
-// GetTotalPosts returns the total number of posts in the database
-func (d *Database) GetTotalPosts() (int, error) {
-	d.mutex.RLock()
-	defer d.mutex.RUnlock()
+// postColumns is the column list selected by every query that returns full posts; see scanPost
+const postColumns = `id, title, author, subreddit, url, created_utc, created_at,
+		upvotes, downvotes, score, num_comments, upvote_ratio, post_hint,
+		is_video, is_self, self_text, permalink, processed_time, over_18, domain`
 
-	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count)
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPost scans a row selected with postColumns
+func scanPost(row rowScanner) (models.Post, error) {
+	var post models.Post
+	var createdAt, processedTime int64
+
+	err := row.Scan(
+		&post.ID, &post.Title, &post.Author, &post.Subreddit, &post.URL,
+		&post.CreatedUTC, &createdAt, &post.Upvotes, &post.Downvotes,
+		&post.Score, &post.NumComments, &post.UpvoteRatio, &post.PostHint, &post.IsVideo,
+		&post.IsSelf, &post.SelfText, &post.Permalink, &processedTime, &post.Over18, &post.Domain,
+	)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get total posts: %w", err)
+		return post, err
 	}
 
-	return count, nil
+	post.CreatedAt = time.Unix(createdAt, 0).UTC()
+	post.ProcessedTime = time.Unix(processedTime, 0).UTC()
+	return post, nil
 }
 
-// GetPostsBySubreddit returns posts from a specific subreddit
-func (d *Database) GetPostsBySubreddit(subreddit string) ([]models.Post, error) {
-	d.mutex.RLock()
-	defer d.mutex.RUnlock()
-
-	query := `
-	SELECT id, title, author, subreddit, url, created_utc, created_at,
-		upvotes, downvotes, score, num_comments, post_hint,
-		is_video, is_self, self_text, permalink, processed_time
-	FROM posts
-	WHERE subreddit = ?
-	ORDER BY upvotes DESC
-	`
-
-	rows, err := d.db.Query(query, subreddit)
+// queryPosts runs a query selecting postColumns and scans every row; callers hold the mutex
+func (d *Database) queryPosts(query string, args ...interface{}) ([]models.Post, error) {
+	rows, err := d.query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query posts for subreddit %s: %w", subreddit, err)
+		return nil, err
 	}
 	defer rows.Close()
 
 	posts := make([]models.Post, 0)
 	for rows.Next() {
-		var post models.Post
-		var createdAt string
-		var processedTime string
-
-		err := rows.Scan(
-			&post.ID, &post.Title, &post.Author, &post.Subreddit, &post.URL,
-			&post.CreatedUTC, &createdAt, &post.Upvotes, &post.Downvotes,
-			&post.Score, &post.NumComments, &post.PostHint, &post.IsVideo,
-			&post.IsSelf, &post.SelfText, &post.Permalink, &processedTime,
-		)
+		post, err := scanPost(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan post: %w", err)
 		}
-
-		post.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		post.ProcessedTime, _ = time.Parse(time.RFC3339, processedTime)
 		posts = append(posts, post)
 	}
 
@@ -257,4 +431,4 @@ func (d *Database) GetPostsBySubreddit(subreddit string) ([]models.Post, error)
 	}
 
 	return posts, nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,441 @@
+package db
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	database, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	return database
+}
+
+func testPost(id string, score int, observedAt time.Time) *models.Post {
+	return &models.Post{
+		ID:            id,
+		Title:         "title " + id,
+		Author:        "alice",
+		Subreddit:     "golang",
+		CreatedUTC:    1700000000,
+		CreatedAt:     time.Unix(1700000000, 0),
+		Upvotes:       score,
+		Score:         score,
+		UpvoteRatio:   0.9,
+		Permalink:     "/r/golang/comments/" + id,
+		ProcessedTime: observedAt,
+	}
+}
+
+func TestSavePostRecordsSnapshots(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+		require.NoError(t, database.SavePost(testPost("p1", 10, start)))
+		// unchanged observation is deduplicated
+		require.NoError(t, database.SavePost(testPost("p1", 10, start.Add(time.Minute))))
+		require.NoError(t, database.SavePost(testPost("p1", 25, start.Add(2*time.Minute))))
+
+		post := testPost("p1", 25, start.Add(3*time.Minute))
+		post.NumComments = 4
+		require.NoError(t, database.SavePost(post))
+
+		history, err := database.GetPostHistory("p1", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+
+		assert.Equal(t, 10, history[0].Score)
+		assert.True(t, history[0].ObservedAt.Equal(start))
+		assert.Equal(t, 25, history[1].Score)
+		assert.True(t, history[1].ObservedAt.Equal(start.Add(2*time.Minute)))
+		assert.Equal(t, 4, history[2].NumComments)
+		assert.Equal(t, 0.9, history[2].UpvoteRatio)
+
+		// the posts table only keeps the latest values
+		stored, err := database.GetPost("p1")
+		require.NoError(t, err)
+		assert.Equal(t, 25, stored.Score)
+		assert.Equal(t, 4, stored.NumComments)
+	})
+}
+
+func TestGetPostNotFound(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+
+		_, err := database.GetPost("missing")
+		assert.ErrorIs(t, err, ErrPostNotFound)
+	})
+}
+
+func TestRangeQueries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		now := time.Now()
+
+		for i, p := range []struct {
+			id, author, subreddit string
+			upvotes               int
+			age                   time.Duration
+		}{
+			{"r1", "alice", "golang", 50, 48 * time.Hour},
+			{"r2", "bob", "golang", 10, 2 * time.Hour},
+			{"r3", "bob", "GoLang", 20, time.Hour},
+			{"r4", "carol", "rust", 30, 30 * time.Minute},
+		} {
+			post := testPost(p.id, p.upvotes, now)
+			post.Author = p.author
+			post.Subreddit = p.subreddit
+			post.CreatedUTC = float64(now.Add(-p.age).Unix() + int64(i))
+			require.NoError(t, database.SavePost(post))
+		}
+
+		lastDay := PostFilter{Since: now.Add(-24 * time.Hour)}
+
+		count, err := database.CountPostsInRange(lastDay)
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		posts, err := database.GetTopPostsInRange(lastDay, 10)
+		require.NoError(t, err)
+		require.Len(t, posts, 3)
+		assert.Equal(t, "r4", posts[0].ID)
+
+		users, err := database.GetTopUsersInRange(lastDay, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []models.UserPostCount{{Author: "bob", PostCount: 2}, {Author: "carol", PostCount: 1}}, users)
+
+		// subreddits match regardless of case, and until is exclusive
+		golang := PostFilter{Subreddit: "golang", Since: now.Add(-24 * time.Hour), Until: now.Add(-90 * time.Minute)}
+		posts, err = database.GetTopPostsInRange(golang, 10)
+		require.NoError(t, err)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "r2", posts[0].ID)
+
+		// an empty filter covers everything
+		count, err = database.CountPostsInRange(PostFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, 4, count)
+	})
+}
+
+func TestSavePostsBatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		observedAt := time.Now().Truncate(time.Second)
+
+		page := make([]models.Post, 0, 3)
+		for _, id := range []string{"b1", "b2", "b3"} {
+			page = append(page, *testPost(id, 10, observedAt))
+		}
+		require.NoError(t, database.SavePosts(page))
+		require.NoError(t, database.SavePosts(nil))
+
+		count, err := database.CountPostsInRange(PostFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		// the second page only records snapshots for the post that changed
+		page[1].Score = 20
+		for i := range page {
+			page[i].ProcessedTime = observedAt.Add(time.Minute)
+		}
+		require.NoError(t, database.SavePosts(page))
+
+		for id, want := range map[string]int{"b1": 1, "b2": 2, "b3": 1} {
+			history, err := database.GetPostHistory(id, time.Time{}, time.Time{})
+			require.NoError(t, err)
+			assert.Len(t, history, want, id)
+		}
+	})
+}
+
+func TestAlerts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		firedAt := time.Now().Truncate(time.Second)
+
+		alert := func(rule, postID, subreddit string, at time.Time) models.Alert {
+			return models.Alert{Rule: rule, PostID: postID, Subreddit: subreddit, Title: "title " + postID, FiredAt: at}
+		}
+
+		fired, err := database.SaveAlerts([]models.Alert{
+			alert("mentions", "a1", "golang", firedAt.Add(-time.Hour)),
+			alert("mentions", "a2", "golang", firedAt),
+			alert("hot", "a1", "news", firedAt),
+		})
+		require.NoError(t, err)
+		require.Len(t, fired, 3)
+		assert.NotZero(t, fired[0].ID)
+
+		// a rule fires once per post; only the new alert is returned
+		fired, err = database.SaveAlerts([]models.Alert{
+			alert("mentions", "a1", "golang", firedAt),
+			alert("mentions", "a3", "golang", firedAt),
+		})
+		require.NoError(t, err)
+		require.Len(t, fired, 1)
+		assert.Equal(t, "a3", fired[0].PostID)
+
+		all, err := database.GetAlerts(models.AlertFilter{})
+		require.NoError(t, err)
+		require.Len(t, all, 4)
+		assert.Equal(t, "a1", all[3].PostID, "newest first")
+
+		byRule, err := database.GetAlerts(models.AlertFilter{Rule: "mentions", Since: firedAt, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, byRule, 1)
+		assert.Equal(t, "a3", byRule[0].PostID)
+
+		bySubreddit, err := database.GetAlerts(models.AlertFilter{Subreddit: "NEWS"})
+		require.NoError(t, err)
+		require.Len(t, bySubreddit, 1)
+		assert.Equal(t, "hot", bySubreddit[0].Rule)
+	})
+}
+
+func TestAuthors(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		start := time.Unix(1700000000, 0)
+
+		posts := []struct {
+			id, author, subreddit string
+			score, comments       int
+			at                    time.Duration
+		}{
+			{"u1", "alice", "golang", 10, 1, 0},
+			{"u2", "alice", "golang", 20, 3, 24 * time.Hour},
+			{"u3", "alice", "rust", 30, 5, 48 * time.Hour},
+			{"u4", "bob", "golang", 500, 40, time.Hour},
+			{"u5", "[deleted]", "golang", 1000, 0, time.Hour},
+			{"u6", "AutoModerator", "golang", 1, 0, time.Hour},
+			{"u7", "carol", "golang", 5, 100, 2 * time.Hour},
+			{"u8", "carol", "golang", 5, 100, 3 * time.Hour},
+		}
+		for _, p := range posts {
+			post := testPost(p.id, p.score, start)
+			post.Author, post.Subreddit, post.NumComments = p.author, p.subreddit, p.comments
+			post.CreatedUTC = float64(start.Add(p.at).Unix())
+			require.NoError(t, database.SavePost(post))
+		}
+
+		ranked := func(query AuthorQuery) []string {
+			authors, err := database.GetAuthors(query)
+			require.NoError(t, err)
+			names := make([]string, 0, len(authors))
+			for i, author := range authors {
+				assert.Equal(t, i+1, author.Rank)
+				names = append(names, author.Author)
+			}
+			return names
+		}
+
+		assert.Equal(t, []string{"alice", "carol", "bob"}, ranked(AuthorQuery{}))
+		assert.Equal(t, []string{"bob", "alice", "carol"}, ranked(AuthorQuery{Sort: models.SortAuthorsByScore}))
+		assert.Equal(t, []string{"carol", "bob", "alice"}, ranked(AuthorQuery{Sort: models.SortAuthorsByComments}))
+		assert.Equal(t, []string{"alice", "carol"}, ranked(AuthorQuery{Sort: models.SortAuthorsByAvgScore, MinPosts: 2}))
+		assert.Equal(t, []string{"alice"}, ranked(AuthorQuery{Filter: PostFilter{Subreddit: "RUST"}}))
+		assert.Equal(t, []string{"carol", "bob"}, ranked(AuthorQuery{Filter: PostFilter{Since: start.Add(time.Hour), Until: start.Add(4 * time.Hour)}, Limit: 2}))
+
+		_, err := database.GetAuthors(AuthorQuery{Sort: "karma"})
+		assert.Error(t, err)
+
+		alice, err := database.GetAuthor("ALICE", PostFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, "alice", alice.Author)
+		assert.Equal(t, 3, alice.PostCount)
+		assert.Equal(t, 60, alice.TotalScore)
+		assert.Equal(t, 20.0, alice.AvgScore)
+		assert.Equal(t, 9, alice.TotalComments)
+		assert.Equal(t, 2, alice.SubredditCount)
+		assert.Equal(t, []models.SubredditPostCount{
+			{Subreddit: "golang", PostCount: 2, TotalScore: 30},
+			{Subreddit: "rust", PostCount: 1, TotalScore: 30},
+		}, alice.Subreddits)
+		assert.True(t, alice.FirstSeen.Equal(start))
+		assert.True(t, alice.LastSeen.Equal(start.Add(48*time.Hour)))
+		assert.Equal(t, 1.5, alice.PostsPerDay)
+		assert.Equal(t, 24.0, alice.AvgHoursBetweenPosts)
+
+		// a single post's cadence is measured over at least a day
+		bob, err := database.GetAuthor("bob", PostFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, bob.PostsPerDay)
+		assert.Zero(t, bob.AvgHoursBetweenPosts)
+
+		for _, name := range []string{"[deleted]", "automoderator", "nobody"} {
+			_, err = database.GetAuthor(name, PostFilter{})
+			assert.ErrorIs(t, err, ErrAuthorNotFound, name)
+		}
+
+		_, err = database.GetAuthor("alice", PostFilter{Subreddit: "python"})
+		assert.ErrorIs(t, err, ErrAuthorNotFound)
+
+		users, err := database.GetTopUsersByPostCount(10)
+		require.NoError(t, err)
+		assert.Equal(t, []models.UserPostCount{
+			{Author: "alice", PostCount: 3}, {Author: "carol", PostCount: 2}, {Author: "bob", PostCount: 1},
+		}, users)
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	database := newTestDatabase(t)
+
+	migrations, err := loadMigrations(sqliteDialect)
+	require.NoError(t, err)
+	latest := migrations[len(migrations)-1].version
+
+	version, err := database.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+
+	// already up to date
+	applied, err := database.MigrateUp(0)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	reverted, err := database.MigrateDown(len(migrations))
+	require.NoError(t, err)
+	assert.Len(t, reverted, len(migrations))
+	assert.Equal(t, latest, reverted[0].Version)
+
+	statuses, err := database.MigrationStatus()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.False(t, status.Applied, status.Name)
+	}
+	_, err = database.GetPost("abc")
+	assert.Error(t, err, "the posts table should be gone")
+
+	applied, err = database.MigrateUp(0)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+	require.NoError(t, database.SavePost(testPost("abc", 1, time.Now())))
+}
+
+// TestPostgresMigrations checks the PostgreSQL migrations without a server: they run against one
+// only when TEST_DATABASE_URL is set
+func TestPostgresMigrations(t *testing.T) {
+	postgres, err := loadMigrations(postgresDialect)
+	require.NoError(t, err)
+	sqlite, err := loadMigrations(sqliteDialect)
+	require.NoError(t, err)
+
+	require.Len(t, postgres, len(sqlite))
+	for i, m := range postgres {
+		assert.Equal(t, sqlite[i].version, m.version)
+		assert.Equal(t, sqlite[i].name, m.name, "version %d", m.version)
+		// times are unix seconds from the first version on, as the stores write them
+		assert.NotContains(t, strings.ToUpper(m.up), "TIMESTAMP", "%04d_%s", m.version, m.name)
+	}
+}
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// a posts table from before upvote_ratio, over_18 and domain were added
+	legacy, err := OpenDatabase("sqlite3", path, log)
+	require.NoError(t, err)
+	_, err = legacy.db.Exec(`
+	CREATE TABLE posts (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		author TEXT NOT NULL,
+		subreddit TEXT NOT NULL,
+		url TEXT,
+		created_utc REAL NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		upvotes INTEGER NOT NULL,
+		downvotes INTEGER NOT NULL,
+		score INTEGER NOT NULL,
+		num_comments INTEGER NOT NULL,
+		post_hint TEXT,
+		is_video BOOLEAN NOT NULL,
+		is_self BOOLEAN NOT NULL,
+		self_text TEXT,
+		permalink TEXT NOT NULL,
+		processed_time TIMESTAMP NOT NULL
+	);
+	INSERT INTO posts VALUES ('old', 'Old post', 'gopher', 'golang', '', 1700000000, '2023-11-14T22:13:20Z',
+		5, 0, 5, 1, '', 0, 1, '', '/r/golang/comments/old', '2023-11-14T22:13:20Z');
+	-- times as the driver wrote a Go time before they were unix seconds
+	INSERT INTO posts VALUES ('local', 'Local post', 'gopher', 'golang', '', 1700000000, '2023-11-14 23:13:20+01:00',
+		5, 0, 5, 1, '', 0, 1, '', '/r/golang/comments/local', '2023-11-14 22:30:00.123456789+00:00');
+	`)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	database, err := NewDatabase(path, log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	post, err := database.GetPost("old")
+	require.NoError(t, err)
+	assert.Equal(t, "Old post", post.Title)
+	assert.Equal(t, "self.golang", post.Domain, "posts saved without a domain get one")
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), post.CreatedAt)
+
+	post, err = database.GetPost("local")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), post.CreatedAt)
+	assert.Equal(t, time.Date(2023, 11, 14, 22, 30, 0, 0, time.UTC), post.ProcessedTime)
+
+	require.NoError(t, database.SavePost(testPost("new", 1, time.Now())))
+}
+
+func TestMigrateRejectsUnrecognizedTimes(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	path := filepath.Join(t.TempDir(), "times.db")
+
+	database, err := OpenDatabase("sqlite3", path, log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	_, err = database.MigrateUp(1)
+	require.NoError(t, err)
+	_, err = database.db.Exec(`
+	INSERT INTO posts (id, title, author, subreddit, created_utc, created_at, upvotes, downvotes, score,
+		num_comments, is_video, is_self, permalink, processed_time)
+	VALUES ('bad', 'Bad', 'gopher', 'golang', 1700000000, 'last tuesday', 0, 0, 0, 0, 0, 1, '', '2023-11-14T22:13:20Z')
+	`)
+	require.NoError(t, err)
+
+	_, err = database.MigrateUp(0)
+	assert.ErrorContains(t, err, `posts bad: created_at: unrecognized time "last tuesday"`)
+
+	// the failed migration left the table as it was
+	version, err := database.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+	var createdAt string
+	require.NoError(t, database.db.QueryRow("SELECT CAST(created_at AS TEXT) FROM posts WHERE id = 'bad'").Scan(&createdAt))
+	assert.Equal(t, "last tuesday", createdAt)
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	database := newTestDatabase(t)
+
+	_, err := database.db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'from_the_future', 0)")
+	require.NoError(t, err)
+
+	_, err = database.MigrateUp(0)
+	assert.ErrorContains(t, err, "newer than this build")
+	_, err = database.MigrateDown(1)
+	assert.ErrorContains(t, err, "newer release")
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// Store is every query the collector, the webhook dispatcher and the API make. Database implements
+// it on SQLite and PostgreSQL, and MemoryStore keeps everything in memory.
+type Store interface {
+	// posts
+	SavePost(post *models.Post) error
+	SavePosts(posts []models.Post) error
+	GetPost(id string) (*models.Post, error)
+	GetPostHistory(postID string, since, until time.Time) ([]models.PostSnapshot, error)
+	GetPostSummaries() ([]models.PostSummary, error)
+	GetPostsBySubreddit(subreddit string, since, until time.Time) ([]models.Post, error)
+	GetPosts(query PostQuery) ([]models.Post, string, error)
+	GetTotalPosts() (int, error)
+	GetTopPostsByUpvotes(limit int) ([]models.Post, error)
+	GetTopUsersByPostCount(limit int) ([]models.UserPostCount, error)
+	GetTopPostsInRange(filter PostFilter, limit int) ([]models.Post, error)
+	GetTopUsersInRange(filter PostFilter, limit int) ([]models.UserPostCount, error)
+	CountPostsInRange(filter PostFilter) (int, error)
+	GetAuthors(query AuthorQuery) ([]models.AuthorStats, error)
+	GetAuthor(name string, filter PostFilter) (*models.AuthorStats, error)
+	SearchPosts(query string, filter PostFilter, order models.SearchSort, page Page) (*models.SearchResults, error)
+
+	// comments and listings
+	SaveComments(comments []models.Comment) error
+	GetCommentsByPost(postID string, since, until time.Time) ([]models.Comment, error)
+	GetActivePosts(since time.Time, limit int) ([]models.Post, error)
+	SaveListingRanks(ranks []models.ListingRank) error
+	GetPostRanks(postID string) ([]models.ListingRank, error)
+
+	// score refreshes
+	ScheduleRefresh(postID string, lastRefreshed, nextRefresh time.Time) error
+	ScheduleRefreshes(refreshes []models.PostRefresh) error
+	GetPostsDueForRefresh(now time.Time, limit int) ([]string, error)
+
+	// polling and coverage
+	GetFrontier(subreddit string) (string, error)
+	SaveFrontier(subreddit, newestID string, updatedAt time.Time) error
+	GetTrackedSubreddits() ([]models.TrackedSubreddit, error)
+	SaveTrackedSubreddit(sub models.TrackedSubreddit) error
+	LogPoll(record models.PollRecord) error
+	PrunePollLog(before time.Time) error
+	GetPollLog(subreddit string, since time.Time) ([]models.PollRecord, error)
+	GetPostTimeline(subreddit string, since time.Time) ([]models.PostRef, error)
+
+	// backfills
+	ScheduleBackfill(job *models.BackfillJob) error
+	ClaimNextBackfillJob(now time.Time, lease time.Duration) (*models.BackfillJob, error)
+	ClaimBackfillJob(id int64, now time.Time, lease time.Duration) (*models.BackfillJob, error)
+	UpdateBackfillJob(job *models.BackfillJob, lease time.Duration) error
+	GetBackfillJobs(status models.BackfillStatus) ([]models.BackfillJob, error)
+
+	// alerts and webhooks
+	SaveAlerts(alerts []models.Alert) ([]models.Alert, error)
+	GetAlerts(filter models.AlertFilter) ([]models.Alert, error)
+	EnqueueWebhookDeliveries(deliveries []models.WebhookDelivery) (int, error)
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error)
+	GetWebhookDeliveries(webhook string, limit int) ([]models.WebhookDelivery, error)
+	SaveScoreCrossings(crossings []models.ScoreCrossing) ([]models.ScoreCrossing, error)
+	UpdateWebhookDelivery(delivery *models.WebhookDelivery) error
+	PruneWebhookDeliveries(before time.Time) error
+
+	Close() error
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Drivers accepted by NewStore
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// NewStore opens the store of a driver and brings its schema up to date. dsn is the SQLite file
+// path or the PostgreSQL connection URL; the memory store ignores it.
+func NewStore(driver, dsn string, log *logrus.Logger) (Store, error) {
+	switch driver {
+	case DriverMemory:
+		return NewMemoryStore(), nil
+	case DriverSQLite, DriverPostgres:
+		return newMigratedDatabase(driver, dsn, log)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
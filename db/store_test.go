@@ -0,0 +1,535 @@
+package db
+
+import (
+	"io"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// forEachStore runs a test against an empty store of every backend. PostgreSQL is included when
+// TEST_DATABASE_URL points at a database the tests may wipe.
+func forEachStore(t *testing.T, test func(t *testing.T, database Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, newTestDatabase(t))
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		return
+	}
+	t.Run("postgres", func(t *testing.T) {
+		log := logrus.New()
+		log.SetOutput(io.Discard)
+
+		database, err := OpenDatabase(DriverPostgres, url, log)
+		require.NoError(t, err)
+		t.Cleanup(func() { database.Close() })
+
+		_, err = database.MigrateDown(math.MaxInt)
+		require.NoError(t, err)
+		_, err = database.MigrateUp(0)
+		require.NoError(t, err)
+
+		test(t, database)
+	})
+}
+
+func TestStoreCollectorQueries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		now := time.Now().Truncate(time.Second)
+
+		// comments and the posts that have them
+		post := testPost("c1", 5, now)
+		post.CreatedUTC = float64(now.Add(-time.Hour).Unix())
+		post.NumComments = 2
+		require.NoError(t, database.SavePost(post))
+		require.NoError(t, database.SaveComments([]models.Comment{
+			{ID: "k2", PostID: "c1", ParentID: "t1_k1", Author: "bob", Body: "reply", CreatedUTC: 20},
+			{ID: "k1", PostID: "c1", ParentID: "t3_c1", Author: "alice", Body: "first", CreatedUTC: 10},
+		}))
+		require.NoError(t, database.SaveComments([]models.Comment{{ID: "k1", PostID: "c1", ParentID: "t3_c1", Body: "edited", CreatedUTC: 10}}))
+
+		comments, err := database.GetCommentsByPost("c1", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, comments, 2)
+		assert.Equal(t, "edited", comments[0].Body)
+		assert.Equal(t, "k2", comments[1].ID)
+
+		active, err := database.GetActivePosts(now.Add(-2*time.Hour), 10)
+		require.NoError(t, err)
+		require.Len(t, active, 1)
+		assert.Equal(t, "c1", active[0].ID)
+
+		// listing positions
+		require.NoError(t, database.SaveListingRanks([]models.ListingRank{
+			{Subreddit: "golang", Listing: models.ListingHot, SnapshotAt: now, Rank: 3, PostID: "c1", Score: 5},
+			{Subreddit: "golang", Listing: models.ListingHot, SnapshotAt: now.Add(-time.Minute), Rank: 7, PostID: "c1", Score: 2},
+		}))
+		ranks, err := database.GetPostRanks("c1")
+		require.NoError(t, err)
+		require.Len(t, ranks, 2)
+		assert.Equal(t, 7, ranks[0].Rank)
+		assert.True(t, ranks[1].SnapshotAt.Equal(now))
+
+		// score refreshes
+		require.NoError(t, database.ScheduleRefreshes([]models.PostRefresh{
+			{PostID: "r1", LastRefreshed: now, NextRefresh: now.Add(-time.Minute)},
+			{PostID: "r2", LastRefreshed: now, NextRefresh: now.Add(-time.Hour)},
+			{PostID: "r3", LastRefreshed: now, NextRefresh: now.Add(time.Hour)},
+		}))
+		require.NoError(t, database.ScheduleRefresh("r1", now, time.Time{}))
+		due, err := database.GetPostsDueForRefresh(now, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"r2"}, due)
+
+		// frontiers and tracked subreddits
+		frontier, err := database.GetFrontier("golang")
+		require.NoError(t, err)
+		assert.Empty(t, frontier)
+		require.NoError(t, database.SaveFrontier("golang", "abc", now))
+		require.NoError(t, database.SaveFrontier("golang", "abd", now))
+		frontier, err = database.GetFrontier("golang")
+		require.NoError(t, err)
+		assert.Equal(t, "abd", frontier)
+
+		require.NoError(t, database.SaveTrackedSubreddit(models.TrackedSubreddit{Name: "rust", Tracked: true, UpdatedAt: now}))
+		require.NoError(t, database.SaveTrackedSubreddit(models.TrackedSubreddit{Name: "Rust", Tracked: false, UpdatedAt: now.Add(time.Second)}))
+		tracked, err := database.GetTrackedSubreddits()
+		require.NoError(t, err)
+		require.Len(t, tracked, 1)
+		assert.Equal(t, "Rust", tracked[0].Name)
+		assert.False(t, tracked[0].Tracked)
+
+		// the poll log and post timeline
+		for i, age := range []time.Duration{3 * time.Hour, time.Hour, 10 * time.Minute} {
+			require.NoError(t, database.LogPoll(models.PollRecord{
+				Subreddit: "golang", PolledAt: now.Add(-age), Pages: i + 1, CaughtUp: i > 0, CoveredFrom: now.Add(-age - time.Hour),
+			}))
+		}
+		require.NoError(t, database.PrunePollLog(now.Add(-2*time.Hour)))
+		polls, err := database.GetPollLog("GOLANG", now.Add(-24*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, polls, 2)
+		assert.Equal(t, 2, polls[0].Pages)
+		assert.True(t, polls[0].CaughtUp)
+		assert.True(t, polls[0].CoveredFrom.Equal(now.Add(-2*time.Hour)))
+		assert.True(t, polls[1].GapStart.IsZero())
+
+		timeline, err := database.GetPostTimeline("golang", now.Add(-2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []models.PostRef{{ID: "c1", CreatedUTC: post.CreatedUTC}}, timeline)
+	})
+}
+
+func TestStoreTimes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		processed := created.Add(90*time.Minute + 500*time.Millisecond)
+
+		for i, id := range []string{"t1", "t2", "t3"} {
+			post := testPost(id, 10+i, processed.Add(time.Duration(i)*time.Hour))
+			post.CreatedUTC = float64(created.Add(time.Duration(i) * time.Hour).Unix())
+			post.CreatedAt = created.Add(time.Duration(i) * time.Hour)
+			require.NoError(t, database.SavePost(post))
+		}
+
+		// times come back at second precision, in UTC
+		post, err := database.GetPost("t1")
+		require.NoError(t, err)
+		assert.Equal(t, created.UTC(), post.CreatedAt)
+		assert.Equal(t, processed.Truncate(time.Second).UTC(), post.ProcessedTime)
+
+		posts, err := database.GetPostsBySubreddit("golang", created.Add(time.Hour), created.Add(2*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "t2", posts[0].ID)
+
+		posts, err = database.GetPostsBySubreddit("GoLang", created.Add(time.Hour), time.Time{})
+		require.NoError(t, err)
+		require.Len(t, posts, 2)
+		assert.Equal(t, "t3", posts[0].ID)
+
+		require.NoError(t, database.SavePost(testPost("t1", 20, processed.Add(time.Hour))))
+		require.NoError(t, database.SavePost(testPost("t1", 30, processed.Add(2*time.Hour))))
+		// snapshots are observed at second precision too
+		observed := processed.Truncate(time.Second)
+		history, err := database.GetPostHistory("t1", observed.Add(time.Hour), time.Time{})
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, 20, history[0].Score)
+		history, err = database.GetPostHistory("t1", time.Time{}, observed.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, 10, history[0].Score)
+
+		require.NoError(t, database.SaveComments([]models.Comment{
+			{ID: "k1", PostID: "t1", CreatedUTC: float64(created.Unix()), CreatedAt: created, ProcessedTime: processed},
+			{ID: "k2", PostID: "t1", CreatedUTC: float64(created.Add(time.Hour).Unix()), CreatedAt: created.Add(time.Hour), ProcessedTime: processed},
+		}))
+		comments, err := database.GetCommentsByPost("t1", created.Add(time.Minute), time.Time{})
+		require.NoError(t, err)
+		require.Len(t, comments, 1)
+		assert.Equal(t, "k2", comments[0].ID)
+		assert.Equal(t, created.Add(time.Hour).UTC(), comments[0].CreatedAt)
+		assert.Equal(t, processed.Truncate(time.Second).UTC(), comments[0].ProcessedTime)
+	})
+}
+
+func TestStoreGetPosts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		observed := time.Unix(100000, 0)
+		posts := []models.Post{
+			{ID: "q1", Author: "alice", Subreddit: "golang", Score: 100, NumComments: 5, CreatedUTC: 100000 - 7200, PostHint: "link", URL: "https://www.Go.dev/doc"},
+			{ID: "q2", Author: "Bob", Subreddit: "golang", Score: 30, NumComments: 40, CreatedUTC: 100000 - 600, IsSelf: true, PostHint: "self"},
+			{ID: "q3", Author: "alice", Subreddit: "rust", Score: 30, NumComments: 1, CreatedUTC: 100000 - 60, IsVideo: true, PostHint: "hosted:video", Domain: "v.redd.it"},
+			{ID: "q4", Author: "carol", Subreddit: "GoLang", Score: 5, NumComments: 0, CreatedUTC: 100000 - 86400, PostHint: "link", Domain: "GO.dev"},
+		}
+		for i := range posts {
+			posts[i].Title = "post " + posts[i].ID
+			posts[i].Permalink = "/r/" + posts[i].Subreddit + "/comments/" + posts[i].ID
+			posts[i].ProcessedTime = observed
+		}
+		require.NoError(t, database.SavePosts(posts))
+
+		ids := func(posts []models.Post) []string {
+			found := make([]string, len(posts))
+			for i, post := range posts {
+				found[i] = post.ID
+			}
+			return found
+		}
+		yes, no := true, false
+		ten, fifty := 10, 50
+
+		filters := []struct {
+			query PostQuery
+			want  []string
+		}{
+			{PostQuery{}, []string{"q1", "q2", "q3", "q4"}},
+			{PostQuery{Filter: PostFilter{Subreddit: "golang"}}, []string{"q1", "q2", "q4"}},
+			{PostQuery{Author: "BOB"}, []string{"q2"}},
+			{PostQuery{PostHint: "link"}, []string{"q1", "q4"}},
+			{PostQuery{Domain: "go.dev"}, []string{"q1", "q4"}}, // q1 got its domain on save
+			{PostQuery{Domain: "self.golang"}, []string{"q2"}},
+			{PostQuery{IsSelf: &yes}, []string{"q2"}},
+			{PostQuery{IsVideo: &no, IsSelf: &no}, []string{"q1", "q4"}},
+			{PostQuery{MinScore: &ten, MaxScore: &fifty}, []string{"q2", "q3"}},
+			{PostQuery{Filter: PostFilter{Since: time.Unix(100000-3600, 0), Until: time.Unix(100000-60, 0)}}, []string{"q2"}},
+			{PostQuery{Sort: models.SortPostsByComments}, []string{"q2", "q1", "q3", "q4"}},
+			{PostQuery{Sort: models.SortPostsByCreated}, []string{"q3", "q2", "q1", "q4"}},
+			{PostQuery{Sort: models.SortPostsByCreated, Ascending: true}, []string{"q4", "q1", "q2", "q3"}},
+			// score per hour: q3 is floored to 5 minutes, 360; q2 180; q1 50; q4 about 0.2
+			{PostQuery{Sort: models.SortPostsByVelocity}, []string{"q3", "q2", "q1", "q4"}},
+		}
+		for _, f := range filters {
+			found, next, err := database.GetPosts(f.query)
+			require.NoError(t, err)
+			assert.Equal(t, f.want, ids(found), "%+v", f.query)
+			assert.Empty(t, next)
+		}
+
+		// every page of every order continues where the previous one ended, ties by id
+		for _, sort := range []models.PostSort{models.SortPostsByScore, models.SortPostsByComments, models.SortPostsByCreated, models.SortPostsByVelocity} {
+			for _, ascending := range []bool{false, true} {
+				all, _, err := database.GetPosts(PostQuery{Sort: sort, Ascending: ascending})
+				require.NoError(t, err)
+
+				paged := make([]models.Post, 0)
+				query := PostQuery{Sort: sort, Ascending: ascending, Limit: 1}
+				for {
+					page, next, err := database.GetPosts(query)
+					require.NoError(t, err)
+					paged = append(paged, page...)
+					if next == "" {
+						break
+					}
+					query.After = next
+				}
+				assert.Equal(t, ids(all), ids(paged), "%s ascending=%v", sort, ascending)
+			}
+		}
+
+		_, next, err := database.GetPosts(PostQuery{Limit: 2})
+		require.NoError(t, err)
+		_, _, err = database.GetPosts(PostQuery{Sort: models.SortPostsByCreated, After: next})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		_, _, err = database.GetPosts(PostQuery{After: "not a cursor"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+}
+
+func TestStoreSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		posts := []models.Post{
+			{ID: "s1", Title: "Better error handling in Go", SelfText: "Wrapping errors with %w keeps the cause.", Author: "alice", Subreddit: "golang", Score: 50, CreatedUTC: 1000},
+			{ID: "s2", Title: "Generics one year on", SelfText: "Are generic constraints worth it? Error handling isn't affected.", Author: "bob", Subreddit: "golang", Score: 80, CreatedUTC: 2000},
+			{ID: "s3", Title: "Handling error <codes> in Rust", Author: "carol", Subreddit: "rust", Score: 10, CreatedUTC: 3000},
+			{ID: "s4", Title: "Google's new search", SelfText: "Nothing about the language.", Author: "dave", Subreddit: "technology", Score: 5, CreatedUTC: 4000},
+		}
+		for i := range posts {
+			posts[i].Permalink = "/r/" + posts[i].Subreddit + "/comments/" + posts[i].ID
+		}
+		require.NoError(t, database.SavePosts(posts))
+
+		ids := func(results *models.SearchResults) []string {
+			found := make([]string, len(results.Results))
+			for i, result := range results.Results {
+				found[i] = result.Post.ID
+			}
+			return found
+		}
+
+		// a phrase matches its words in order, in the title or the selftext
+		results, err := database.SearchPosts(`"error handling"`, PostFilter{}, "", Page{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, results.Total)
+		assert.Equal(t, []string{"s1", "s2"}, ids(results))
+		assert.Equal(t, models.SortSearchByRelevance, results.Sort)
+		assert.Equal(t, "Better <mark>error</mark> <mark>handling</mark> in Go", results.Results[0].TitleHighlight)
+		assert.Contains(t, results.Results[1].Snippet, "<mark>Error</mark> <mark>handling</mark> isn&#39;t")
+
+		// words match anywhere, but only whole: go doesn't match google
+		results, err = database.SearchPosts("handling error", PostFilter{}, models.SortSearchByNew, Page{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s3", "s2", "s1"}, ids(results))
+		assert.Equal(t, "<mark>Handling</mark> <mark>error</mark> &lt;codes&gt; in Rust", results.Results[0].TitleHighlight)
+
+		results, err = database.SearchPosts("go", PostFilter{}, "", Page{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s1"}, ids(results))
+
+		results, err = database.SearchPosts("gener*", PostFilter{}, models.SortSearchByTop, Page{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s2"}, ids(results))
+		assert.Equal(t, "<mark>Generics</mark> one year on", results.Results[0].TitleHighlight)
+
+		// authors and subreddits are searched too, and filters apply
+		results, err = database.SearchPosts("golang error", PostFilter{Subreddit: "GoLang", Since: time.Unix(1500, 0)}, "", Page{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s2"}, ids(results))
+
+		// pages
+		results, err = database.SearchPosts("error", PostFilter{}, models.SortSearchByTop, Page{Number: 2, Size: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 3, results.Total)
+		assert.Equal(t, 2, results.Page)
+		assert.Equal(t, []string{"s3"}, ids(results))
+
+		// an updated post is found by its new title only
+		posts[3].Title = "Go at Google"
+		require.NoError(t, database.SavePost(&posts[3]))
+		results, err = database.SearchPosts("search", PostFilter{}, "", Page{})
+		require.NoError(t, err)
+		assert.Empty(t, results.Results)
+		results, err = database.SearchPosts("go", PostFilter{}, models.SortSearchByNew, Page{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s4", "s1"}, ids(results))
+
+		_, err = database.SearchPosts(` "" * `, PostFilter{}, "", Page{})
+		assert.ErrorIs(t, err, ErrInvalidSearch)
+	})
+}
+
+func TestStoreBackfillJobs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		now := time.Now().Truncate(time.Second)
+
+		_, err := database.ClaimNextBackfillJob(now, time.Minute)
+		assert.ErrorIs(t, err, ErrNoBackfillJobs)
+
+		first := models.BackfillJob{Subreddit: "golang", Since: now.Add(-48 * time.Hour), Until: now, Reason: "test", MaxPages: 5}
+		require.NoError(t, database.ScheduleBackfill(&first))
+		assert.NotZero(t, first.ID)
+		assert.Equal(t, models.BackfillPending, first.Status)
+		assert.Equal(t, models.BackfillFromListing, first.Source)
+
+		// the same range isn't queued twice
+		again := models.BackfillJob{Subreddit: "golang", Since: now.Add(-48 * time.Hour), Until: now, Reason: "again"}
+		require.NoError(t, database.ScheduleBackfill(&again))
+		assert.Equal(t, first.ID, again.ID)
+		assert.Equal(t, "test", again.Reason)
+
+		second := models.BackfillJob{Subreddit: "rust", Since: now.Add(-time.Hour), Until: now, Reason: "test"}
+		require.NoError(t, database.ScheduleBackfill(&second))
+
+		// a worker claims a job and checkpoints it, which renews its lease
+		claimed, err := database.ClaimBackfillJob(second.ID, now, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, models.BackfillRunning, claimed.Status)
+		assert.True(t, claimed.LeaseUntil.Equal(now.Add(time.Minute)))
+
+		_, err = database.ClaimBackfillJob(second.ID, now, time.Minute)
+		assert.ErrorIs(t, err, ErrBackfillJobHeld)
+
+		claimed.Source = models.BackfillFromSearch
+		claimed.Cursor = "t3_x"
+		claimed.Pages = 2
+		claimed.Oldest = now.Add(-30 * time.Minute)
+		require.NoError(t, database.UpdateBackfillJob(claimed, time.Hour))
+		assert.True(t, claimed.LeaseUntil.After(now.Add(59*time.Minute)))
+
+		// the held job is skipped for the pending one
+		next, err := database.ClaimNextBackfillJob(now, 24*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, first.ID, next.ID)
+		_, err = database.ClaimNextBackfillJob(now, time.Minute)
+		assert.ErrorIs(t, err, ErrNoBackfillJobs)
+
+		// once its lease runs out, an abandoned job comes first, and its old worker can no longer
+		// checkpoint it
+		later := now.Add(2 * time.Hour)
+		taken, err := database.ClaimNextBackfillJob(later, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, second.ID, taken.ID)
+		assert.Equal(t, models.BackfillFromSearch, taken.Source)
+		assert.Equal(t, "t3_x", taken.Cursor)
+		assert.Equal(t, 2, taken.Pages)
+		assert.True(t, taken.Oldest.Equal(claimed.Oldest))
+		assert.ErrorIs(t, database.UpdateBackfillJob(claimed, time.Hour), ErrBackfillLeaseLost)
+
+		// a finished job drops its lease and can't be claimed again
+		taken.Status = models.BackfillDone
+		require.NoError(t, database.UpdateBackfillJob(taken, time.Hour))
+		assert.True(t, taken.LeaseUntil.IsZero())
+		_, err = database.ClaimBackfillJob(second.ID, later.Add(time.Hour), time.Minute)
+		assert.ErrorIs(t, err, ErrBackfillJobHeld)
+
+		// scheduling a failed job's range again queues it again from the start
+		failed := models.BackfillJob{Subreddit: "rust", Since: now.Add(-2 * time.Hour), Until: now, Reason: "test"}
+		require.NoError(t, database.ScheduleBackfill(&failed))
+		failed.Status = models.BackfillFailed
+		failed.Pages = 3
+		failed.Error = "forbidden"
+		require.NoError(t, database.UpdateBackfillJob(&failed, 0))
+
+		retried := models.BackfillJob{Subreddit: "rust", Since: now.Add(-2 * time.Hour), Until: now, Reason: "retry", MaxPages: 7}
+		require.NoError(t, database.ScheduleBackfill(&retried))
+		assert.Equal(t, failed.ID, retried.ID)
+		assert.Equal(t, models.BackfillPending, retried.Status)
+		assert.Equal(t, "retry", retried.Reason)
+		assert.Equal(t, 7, retried.MaxPages)
+		assert.Zero(t, retried.Pages)
+		assert.Empty(t, retried.Error)
+
+		redone := models.BackfillJob{Subreddit: "rust", Since: now.Add(-time.Hour), Until: now, Reason: "again"}
+		require.NoError(t, database.ScheduleBackfill(&redone))
+		assert.Equal(t, models.BackfillDone, redone.Status, "a done job isn't queued again")
+
+		running, err := database.GetBackfillJobs(models.BackfillRunning)
+		require.NoError(t, err)
+		require.Len(t, running, 1)
+		assert.Equal(t, first.ID, running[0].ID)
+		assert.Equal(t, 5, running[0].MaxPages)
+
+		all, err := database.GetBackfillJobs("")
+		require.NoError(t, err)
+		assert.Len(t, all, 3)
+	})
+}
+
+func TestStoreWebhookOutbox(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		now := time.Now().Truncate(time.Second)
+
+		delivery := func(webhook, key string, next time.Time) models.WebhookDelivery {
+			return models.WebhookDelivery{Webhook: webhook, Event: models.EventPostMatched, DedupKey: key,
+				Payload: `{}`, NextAttemptAt: next, CreatedAt: now.Add(-time.Hour)}
+		}
+
+		queued, err := database.EnqueueWebhookDeliveries([]models.WebhookDelivery{
+			delivery("slack", "a", now.Add(-time.Minute)),
+			delivery("slack", "b", now.Add(-2*time.Minute)),
+			delivery("ops", "a", now.Add(time.Hour)),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, queued)
+
+		queued, err = database.EnqueueWebhookDeliveries([]models.WebhookDelivery{delivery("slack", "a", now)})
+		require.NoError(t, err)
+		assert.Zero(t, queued)
+
+		// claimed deliveries aren't due again until their lease runs out
+		due, err := database.ClaimWebhookDeliveries(now, time.Minute, 1)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		assert.Equal(t, "b", due[0].DedupKey, "due longest first")
+		assert.Equal(t, models.DeliveryPending, due[0].Status)
+
+		rest, err := database.ClaimWebhookDeliveries(now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Equal(t, "a", rest[0].DedupKey)
+
+		none, err := database.ClaimWebhookDeliveries(now.Add(59*time.Second), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, none)
+
+		again, err := database.ClaimWebhookDeliveries(now.Add(time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Len(t, again, 2)
+
+		due[0].Status = models.DeliveryDelivered
+		due[0].Attempts = 1
+		due[0].DeliveredAt = now
+		require.NoError(t, database.UpdateWebhookDelivery(&due[0]))
+
+		slack, err := database.GetWebhookDeliveries("slack", 10)
+		require.NoError(t, err)
+		require.Len(t, slack, 2)
+		assert.Equal(t, "b", slack[0].DedupKey, "newest first")
+		assert.Equal(t, models.DeliveryDelivered, slack[0].Status)
+		assert.True(t, slack[0].DeliveredAt.Equal(now))
+
+		// delivered deliveries are pruned, pending ones are kept
+		require.NoError(t, database.PruneWebhookDeliveries(now))
+		all, err := database.GetWebhookDeliveries("", 0)
+		require.NoError(t, err)
+		assert.Len(t, all, 2, "no limit returns the default number")
+	})
+}
+
+func TestStoreScoreCrossings(t *testing.T) {
+	forEachStore(t, func(t *testing.T, database Store) {
+		observed := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, database.SavePosts([]models.Post{*testPost("low", 50, observed), *testPost("high", 5000, observed)}))
+		require.NoError(t, database.SavePosts([]models.Post{*testPost("low", 150, observed.Add(time.Minute))}))
+
+		crossing := func(webhook, postID string, threshold int) models.ScoreCrossing {
+			return models.ScoreCrossing{Webhook: webhook, PostID: postID, Threshold: threshold, Score: 150, CrossedAt: observed}
+		}
+		crossed, err := database.SaveScoreCrossings([]models.ScoreCrossing{
+			crossing("slack", "low", 100),
+			crossing("ops", "low", 100),
+			crossing("slack", "high", 1000), // never observed below
+		})
+		require.NoError(t, err)
+		require.Len(t, crossed, 2)
+		assert.NotZero(t, crossed[0].ID)
+		assert.Equal(t, "slack", crossed[0].Webhook)
+		assert.Equal(t, "ops", crossed[1].Webhook)
+
+		crossed, err = database.SaveScoreCrossings([]models.ScoreCrossing{crossing("slack", "low", 100)})
+		require.NoError(t, err)
+		assert.Empty(t, crossed)
+	})
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT * FROM posts WHERE subreddit = ? AND title != 'why?' AND score > ? LIMIT ?"
+
+	assert.Equal(t, query, sqliteDialect.rebind(query))
+	assert.Equal(t,
+		"SELECT * FROM posts WHERE subreddit = $1 AND title != 'why?' AND score > $2 LIMIT $3",
+		postgresDialect.rebind(query))
+}
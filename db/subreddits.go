@@ -0,0 +1,58 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// GetTrackedSubreddits returns the subreddits added or removed at runtime, oldest change first
+func (d *Database) GetTrackedSubreddits() ([]models.TrackedSubreddit, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	rows, err := d.query(`
+	SELECT name, tracked, updated_at
+	FROM tracked_subreddits
+	ORDER BY updated_at, name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tracked subreddits: %w", err)
+	}
+	defer rows.Close()
+
+	subreddits := make([]models.TrackedSubreddit, 0)
+	for rows.Next() {
+		var sub models.TrackedSubreddit
+		var updatedAt int64
+		if err := rows.Scan(&sub.Name, &sub.Tracked, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tracked subreddit: %w", err)
+		}
+		sub.UpdatedAt = time.Unix(updatedAt, 0)
+		subreddits = append(subreddits, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return subreddits, nil
+}
+
+// SaveTrackedSubreddit records that a subreddit was added to or removed from the tracked set
+func (d *Database) SaveTrackedSubreddit(sub models.TrackedSubreddit) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, err := d.exec(`
+	INSERT INTO tracked_subreddits (name, tracked, updated_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT (name) DO UPDATE SET name = excluded.name, tracked = excluded.tracked, updated_at = excluded.updated_at
+	`, sub.Name, sub.Tracked, sub.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save tracked subreddit %s: %w", sub.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const defaultDeliveriesLimit = 100
+
+const webhookDeliveryColumns = `id, webhook, event, dedup_key, payload, status, attempts, next_attempt_at,
+	last_error, created_at, delivered_at`
+
+// EnqueueWebhookDeliveries adds deliveries to the outbox in one transaction and returns how many
+// were new. A delivery whose webhook and dedup key are already in the outbox is skipped.
+func (d *Database) EnqueueWebhookDeliveries(deliveries []models.WebhookDelivery) (int, error) {
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := d.prepare(tx, `
+	INSERT INTO webhook_outbox (
+		webhook, event, dedup_key, payload, status, attempts, next_attempt_at, created_at
+	) VALUES (?, ?, ?, ?, ?, 0, ?, ?)
+	ON CONFLICT DO NOTHING
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare webhook delivery insert: %w", err)
+	}
+	defer stmt.Close()
+
+	queued := 0
+	for _, delivery := range deliveries {
+		result, err := stmt.Exec(
+			delivery.Webhook, delivery.Event, delivery.DedupKey, delivery.Payload, string(models.DeliveryPending),
+			delivery.NextAttemptAt.Unix(), delivery.CreatedAt.Unix(),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to queue %s delivery to %s: %w", delivery.Event, delivery.Webhook, err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			queued++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit webhook deliveries: %w", err)
+	}
+
+	return queued, nil
+}
+
+// ClaimWebhookDeliveries claims up to limit pending deliveries whose next attempt is due, those due
+// longest first, by putting their next attempt off until the lease runs out, and returns them in
+// the order they were queued. Dispatchers sharing the outbox claim different deliveries; one that
+// isn't settled within the lease, e.g. because its dispatcher stopped, is due again.
+func (d *Database) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	rows, err := d.query(`
+	UPDATE webhook_outbox
+	SET next_attempt_at = ?
+	WHERE id IN (
+		SELECT id
+		FROM webhook_outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC, id ASC
+		LIMIT ?
+		`+d.dialect.skipLocked+`
+	)
+	RETURNING `+webhookDeliveryColumns,
+		now.Add(lease).Unix(), string(models.DeliveryPending), now.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries, err := scanWebhookDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+// GetWebhookDeliveries returns the deliveries of a webhook, newest first; an empty webhook returns every one
+func (d *Database) GetWebhookDeliveries(webhook string, limit int) ([]models.WebhookDelivery, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if limit <= 0 {
+		limit = defaultDeliveriesLimit
+	}
+
+	rows, err := d.query(`
+	SELECT `+webhookDeliveryColumns+`
+	FROM webhook_outbox
+	WHERE ? = '' OR webhook = ?
+	ORDER BY id DESC
+	LIMIT ?
+	`, webhook, webhook, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+// UpdateWebhookDelivery saves the outcome of a delivery attempt
+func (d *Database) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var deliveredAt interface{}
+	if !delivery.DeliveredAt.IsZero() {
+		deliveredAt = delivery.DeliveredAt.Unix()
+	}
+
+	_, err := d.exec(`
+	UPDATE webhook_outbox
+	SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, delivered_at = ?
+	WHERE id = ?
+	`,
+		string(delivery.Status), delivery.Attempts, delivery.NextAttemptAt.Unix(), delivery.LastError, deliveredAt,
+		delivery.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery %d: %w", delivery.ID, err)
+	}
+
+	return nil
+}
+
+// PruneWebhookDeliveries deletes the delivered and failed deliveries created before the given time;
+// pending ones are kept however old they are
+func (d *Database) PruneWebhookDeliveries(before time.Time) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, err := d.exec(`
+	DELETE FROM webhook_outbox WHERE status != ? AND created_at < ?
+	`, string(models.DeliveryPending), before.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to prune webhook outbox: %w", err)
+	}
+
+	return nil
+}
+
+// SaveScoreCrossings records score crossings in one transaction and returns the ones that are new.
+// A crossing only counts if the post was observed below the threshold before, so a post first
+// seen above it, e.g. in a top listing or a backfill, never crossed it; and each webhook hears
+// about each post and threshold at most once.
+func (d *Database) SaveScoreCrossings(crossings []models.ScoreCrossing) ([]models.ScoreCrossing, error) {
+	if len(crossings) == 0 {
+		return nil, nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	below, err := d.prepare(tx, `
+	SELECT COUNT(*) FROM post_snapshots WHERE post_id = ? AND score < ?
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare snapshot query: %w", err)
+	}
+	defer below.Close()
+
+	insert, err := d.prepare(tx, `
+	INSERT INTO webhook_score_crossings (webhook, post_id, threshold, score, crossed_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT DO NOTHING
+	RETURNING id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare score crossing insert: %w", err)
+	}
+	defer insert.Close()
+
+	crossed := make([]models.ScoreCrossing, 0, len(crossings))
+	for _, crossing := range crossings {
+		var observedBelow int
+		if err := below.QueryRow(crossing.PostID, crossing.Threshold).Scan(&observedBelow); err != nil {
+			return nil, fmt.Errorf("failed to query snapshots of post %s: %w", crossing.PostID, err)
+		}
+		if observedBelow == 0 {
+			continue
+		}
+
+		err := insert.QueryRow(
+			crossing.Webhook, crossing.PostID, crossing.Threshold, crossing.Score, crossing.CrossedAt.Unix(),
+		).Scan(&crossing.ID)
+		// no row comes back when the webhook already heard about the crossing
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save score crossing of post %s: %w", crossing.PostID, err)
+		}
+		crossed = append(crossed, crossing)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit score crossings: %w", err)
+	}
+
+	return crossed, nil
+}
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]models.WebhookDelivery, error) {
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var status string
+		var nextAttemptAt, createdAt int64
+		var deliveredAt sql.NullInt64
+		if err := rows.Scan(
+			&delivery.ID, &delivery.Webhook, &delivery.Event, &delivery.DedupKey, &delivery.Payload, &status,
+			&delivery.Attempts, &nextAttemptAt, &delivery.LastError, &createdAt, &deliveredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		delivery.Status = models.WebhookDeliveryStatus(status)
+		delivery.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		delivery.CreatedAt = time.Unix(createdAt, 0)
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = time.Unix(deliveredAt.Int64, 0)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// PostFilter restricts post queries to one subreddit and to posts created in [Since, Until).
+// Zero values don't filter.
+type PostFilter struct {
+	Subreddit string
+	Since     time.Time
+	Until     time.Time
+}
+
+// where returns the WHERE clause (empty when nothing is filtered) and its arguments
+func (f PostFilter) where() (string, []interface{}) {
+	return whereClause(f.appendConditions(nil, nil))
+}
+
+// appendConditions appends the filter's conditions and their arguments
+func (f PostFilter) appendConditions(conditions []string, args []interface{}) ([]string, []interface{}) {
+	if f.Subreddit != "" {
+		conditions = append(conditions, "subreddit = ? COLLATE NOCASE")
+		args = append(args, f.Subreddit)
+	}
+	return appendRange(conditions, args, "created_utc", f.Since, f.Until)
+}
+
+// whereClause joins conditions into a WHERE clause, empty when there are none
+func whereClause(conditions []string, args []interface{}) (string, []interface{}) {
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// appendRange appends the conditions that bound a column of unix seconds to [since, until); zero
+// times don't bound it
+func appendRange(conditions []string, args []interface{}, column string, since, until time.Time) ([]string, []interface{}) {
+	if !since.IsZero() {
+		conditions = append(conditions, column+" >= ?")
+		args = append(args, unixSeconds(since))
+	}
+	if !until.IsZero() {
+		conditions = append(conditions, column+" < ?")
+		args = append(args, unixSeconds(until))
+	}
+	return conditions, args
+}
+
+// unixSeconds converts a time to the fractional seconds stored in created_utc, so a range ending
+// now includes the posts created earlier in the current second
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// GetTopPostsInRange returns the top N posts by upvotes that match the filter
+func (d *Database) GetTopPostsInRange(filter PostFilter, limit int) ([]models.Post, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	where, args := filter.where()
+	query := `
+	SELECT ` + postColumns + `
+	FROM posts
+	` + where + `
+	ORDER BY upvotes DESC
+	LIMIT ?
+	`
+
+	posts, err := d.queryPosts(query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top posts: %w", err)
+	}
+
+	return posts, nil
+}
+
+// GetTopUsersInRange returns the top N users by the number of their posts that match the filter,
+// most posts first; [deleted], AutoModerator and the like aren't ranked
+func (d *Database) GetTopUsersInRange(filter PostFilter, limit int) ([]models.UserPostCount, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	where, args := authorWhere(filter)
+	query := `
+	SELECT author, COUNT(*) as post_count
+	FROM posts
+	` + where + `
+	GROUP BY author
+	ORDER BY post_count DESC, author ASC
+	LIMIT ?
+	`
+
+	rows, err := d.query(query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.UserPostCount, 0, limit)
+	for rows.Next() {
+		var user models.UserPostCount
+		if err := rows.Scan(&user.Author, &user.PostCount); err != nil {
+			return nil, fmt.Errorf("failed to scan user post count: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return users, nil
+}
+
+// CountPostsInRange returns the number of posts that match the filter
+func (d *Database) CountPostsInRange(filter PostFilter) (int, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	where, args := filter.where()
+
+	var count int
+	if err := d.queryRow("SELECT COUNT(*) FROM posts "+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count posts: %w", err)
+	}
+
+	return count, nil
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,8 +22,11 @@ import (
 
 	"github.com/brettboylen/reddit-tracker/api"
 	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/pipeline"
 	"github.com/brettboylen/reddit-tracker/stats"
 	"github.com/brettboylen/reddit-tracker/utils"
+	"github.com/brettboylen/reddit-tracker/webhook"
 )
 
 func main() {
@@ -40,12 +48,20 @@ func main() {
 		"server_port":      config.Server.Port,
 	}).Info("Configuration loaded")
 
-	database, err := db.NewDatabase(config.Database.Path, log)
+	database, err := openStore(config.Database, flag.Arg(0) == "migrate", log)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to connect to database")
 	}
 	defer database.Close()
 
+	// one-off commands such as `coverage` run against the database and exit
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:], config, database, log, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Command failed")
+		}
+		return
+	}
+
 	redditAPI := api.NewRedditAPI(
 		config.Reddit.ClientID,
 		config.Reddit.ClientSecret,
@@ -54,18 +70,73 @@ func main() {
 		log,
 	)
 
+	processors, err := pipeline.Build(config.Pipeline.Processors, pipeline.Settings{JSONLPath: config.Pipeline.JSONLPath})
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up post processors")
+	}
+	defer processors.Close()
+	if len(processors) > 0 {
+		log.WithField("processors", processors.Names()).Info("Post processors configured")
+	}
+
+	collectorOpts := []stats.Option{
+		stats.WithCommentRefresh(
+			time.Duration(config.Reddit.CommentRefreshInterval)*time.Second,
+			config.Reddit.CommentPostsPerRefresh,
+			time.Duration(config.Reddit.CommentMaxPostAgeHours)*time.Hour,
+		),
+		stats.WithListings(
+			config.Reddit.Listings,
+			time.Duration(config.Reddit.ListingPollInterval)*time.Second,
+		),
+		stats.WithScoreRefresh(config.Reddit.RefreshBudgetShare, stats.DefaultRefreshSchedule),
+		stats.WithBackfill(
+			config.Reddit.BackfillBudgetShare,
+			config.Reddit.BackfillMaxPages,
+			time.Duration(config.Reddit.BackfillNewSubredditDays)*24*time.Hour,
+		),
+		stats.WithTrending(config.Reddit.TrendingWindows, config.Reddit.TrendingLimit),
+		stats.WithPollSchedule(
+			config.Reddit.SubredditSchedules,
+			time.Duration(config.Reddit.MinPollInterval)*time.Second,
+			time.Duration(config.Reddit.MaxPollInterval)*time.Second,
+			config.Reddit.AdaptivePolling,
+		),
+		stats.WithWriteWorkers(config.Reddit.WriteWorkers),
+		stats.WithProcessors(processors),
+		stats.WithAlerts(config.Alerts.Rules),
+	}
+
+	var dispatcher *webhook.Dispatcher
+	if len(config.Webhooks.Webhooks) > 0 {
+		dispatcher, err = webhook.NewDispatcher(database, config.Webhooks.Webhooks, log)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to set up webhooks")
+		}
+		collectorOpts = append(collectorOpts, stats.WithNotifier(dispatcher))
+	}
+
 	collector := stats.NewCollector(
 		redditAPI,
 		database,
 		config.Reddit.Subreddits,
 		config.Reddit.PollingInterval,
 		log,
+		collectorOpts...,
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go startEchoServer(ctx, config.Server.Port, collector, log, config.Reddit.MaxRequestsPerMinute)
+	if dispatcher != nil {
+		go func() {
+			if err := dispatcher.Run(ctx); err != nil && err != context.Canceled {
+				log.WithError(err).Error("Webhook dispatcher stopped unexpectedly")
+			}
+		}()
+	}
+
+	go startEchoServer(ctx, config.Server, collector, database, log, config.Reddit.MaxRequestsPerMinute)
 
 	go func() {
 		if err := collector.Start(ctx); err != nil && err != context.Canceled {
@@ -100,8 +171,52 @@ func setupLogger(level string) *logrus.Logger {
 	return log
 }
 
+// openStore opens the configured store and brings its schema up to date, except for the migrate
+// command, which manages the schema itself and gets the database as it is
+func openStore(config utils.DatabaseConfig, migrate bool, log *logrus.Logger) (db.Store, error) {
+	if migrate && config.Driver != db.DriverMemory {
+		database, err := db.OpenDatabase(config.Driver, config.DSN(), log)
+		if err != nil {
+			return nil, err
+		}
+		return database, nil
+	}
+	return db.NewStore(config.Driver, config.DSN(), log)
+}
+
 // startEchoServer starts the Echo HTTP API server
-func startEchoServer(ctx context.Context, port int, collector *stats.Collector, log *logrus.Logger, maxRequestsPerMinute int) {
+func startEchoServer(ctx context.Context, server utils.ServerConfig, collector *stats.Collector, database db.Store, log *logrus.Logger, maxRequestsPerMinute int) {
+	port := server.Port
+	if server.AdminToken == "" {
+		log.Warn("ADMIN_TOKEN is not set; the endpoints that change the tracked subreddits and queue backfills are disabled")
+	}
+
+	e := newEchoServer(collector, database, maxRequestsPerMinute, server.AdminToken)
+	
+	// start the server!
+	go func() {
+		serverAddr := fmt.Sprintf(":%d", port)
+		log.WithField("port", port).Info("Starting API server")
+		if err := e.Start(serverAddr); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Fatal("API server failed")
+		}
+	}()
+	
+	// wait for context cancellation to shut down server
+	<-ctx.Done()
+	log.Info("Shutting down API server")
+	
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("API server shutdown failed")
+	}
+}
+
+// newEchoServer creates the Echo instance with middleware and routes registered
+func newEchoServer(collector *stats.Collector, database db.Store, maxRequestsPerMinute int, adminToken string) *echo.Echo {
 	e := echo.New()
 	
 	// middleware
@@ -137,14 +252,50 @@ func startEchoServer(ctx context.Context, port int, collector *stats.Collector,
 		},
 	}
 	e.Use(middleware.RateLimiterWithConfig(rateLimiterConfig))
+
+	// endpoints that change state require the admin token, and are disabled when none is configured
+	admin := requireAdminToken(adminToken)
 	
+	// all-time statistics, or those of the posts created in a range with ?window=24h or ?since=...&until=...
 	e.GET("/api/stats", func(c echo.Context) error {
+		since, until, windowed, err := windowParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if windowed {
+			stats, err := collector.GetWindowStatistics("", since, until)
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, stats)
+		}
+
 		stats := collector.GetStatistics()
 		return c.JSON(http.StatusOK, stats)
 	})
 	
+	// all-time statistics of a subreddit, or those of its posts created in a range, as for /api/stats
 	e.GET("/api/stats/:subreddit", func(c echo.Context) error {
 		subreddit := c.Param("subreddit")
+
+		since, until, windowed, err := windowParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if windowed {
+			if !isTracked(collector, subreddit) {
+				return c.JSON(http.StatusNotFound, map[string]string{
+					"error": fmt.Sprintf("Subreddit %s is not being tracked", subreddit),
+				})
+			}
+
+			stats, err := collector.GetWindowStatistics(subreddit, since, until)
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, stats)
+		}
+
 		stats := collector.GetStatistics()
 		
 		// check if the subreddit exists in our stats
@@ -157,34 +308,621 @@ func startEchoServer(ctx context.Context, port int, collector *stats.Collector,
 		
 		return c.JSON(http.StatusOK, subredditStats)
 	})
+
+	// stored posts, filtered, sorted and paged by cursor;
+	// ?subreddit=golang&is_self=false&min_score=100&sort=velocity&fields=id,title,score&limit=50&cursor=...
+	e.GET("/api/posts", func(c echo.Context) error {
+		query, fields, err := postQueryParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		posts, next, err := database.GetPosts(query)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			return err
+		}
+
+		selected := make([]map[string]json.RawMessage, len(posts))
+		for i, post := range posts {
+			if selected[i], err = post.SelectFields(fields); err != nil {
+				return err
+			}
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"sort":        query.Sort,
+			"order":       sortOrder(query.Ascending),
+			"posts":       selected,
+			"next_cursor": next,
+		})
+	})
+
+	// a post's score history, optionally the snapshots of a range with ?window=24h or ?since=...&until=...
+	e.GET("/api/posts/:id/history", func(c echo.Context) error {
+		id := c.Param("id")
+		since, until, _, err := windowParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		post, err := database.GetPost(id)
+		if errors.Is(err, db.ErrPostNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": fmt.Sprintf("Post %s is not being tracked", id),
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		snapshots, err := database.GetPostHistory(id, since, until)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, models.PostHistory{Post: *post, Snapshots: snapshots})
+	})
+
+	// trending posts ranked by score and comment velocity; ?window=1h&subreddit=golang&limit=10
+	e.GET("/api/trending", func(c echo.Context) error {
+		window := collector.DefaultTrendingWindow()
+		if w := c.QueryParam("window"); w != "" {
+			d, err := time.ParseDuration(w)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": fmt.Sprintf("Invalid window %q", w),
+				})
+			}
+			window = d
+		}
+
+		limit := 0
+		if l := c.QueryParam("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": fmt.Sprintf("Invalid limit %q", l),
+				})
+			}
+			limit = n
+		}
+
+		subreddit := c.QueryParam("subreddit")
+		posts, err := collector.GetTrending(subreddit, window, limit)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"window":    window.String(),
+			"subreddit": subreddit,
+			"posts":     posts,
+		})
+	})
 	
 	
+	// coverage gaps of one or all tracked subreddits; ?subreddit=golang&window=168h
+	e.GET("/api/coverage", func(c echo.Context) error {
+		subreddits, opts, err := coverageParams(c, collector)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		gaps, err := analyzeCoverage(database, subreddits, opts)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"since": opts.Since,
+			"gaps":  gaps,
+		})
+	})
+
+	// queue a backfill job for each coverage gap; takes the same parameters as GET /api/coverage
+	e.POST("/api/coverage/backfill", func(c echo.Context) error {
+		subreddits, opts, err := coverageParams(c, collector)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		gaps, err := analyzeCoverage(database, subreddits, opts)
+		if err != nil {
+			return err
+		}
+
+		jobs, err := stats.ScheduleCoverageBackfills(database, gaps)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"jobs": jobs,
+		})
+	}, admin)
+
+	// full-text search of post titles, selftext, authors and subreddits;
+	// ?q="error handling" gener*&subreddit=golang&since=...&sort=relevance&page=2&page_size=20
+	e.GET("/api/search", func(c echo.Context) error {
+		since, until, windowed, err := windowParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		filter := db.PostFilter{Subreddit: c.QueryParam("subreddit")}
+		if windowed {
+			filter.Since, filter.Until = since, until
+		}
+
+		sort, ok := models.ParseSearchSort(c.QueryParam("sort"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("invalid sort %q: must be relevance, new or top", c.QueryParam("sort")),
+			})
+		}
+
+		var page db.Page
+		if p := c.QueryParam("page"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid page %q", p)})
+			}
+			page.Number = n
+		}
+		if s := c.QueryParam("page_size"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxSearchPageSize {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": fmt.Sprintf("invalid page_size %q: must be between 1 and %d", s, maxSearchPageSize),
+				})
+			}
+			page.Size = n
+		}
+
+		results, err := database.SearchPosts(c.QueryParam("q"), filter, sort, page)
+		if errors.Is(err, db.ErrInvalidSearch) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "q must contain at least one word to search for"})
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, results)
+	})
+
+	// authors ranked by their posts; ?sort=score&subreddit=golang&window=168h&min_posts=3&limit=25
+	e.GET("/api/authors", func(c echo.Context) error {
+		query, err := authorParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		sort, ok := models.ParseAuthorSort(c.QueryParam("sort"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("invalid sort %q: must be posts, score, comments or avg_score", c.QueryParam("sort")),
+			})
+		}
+		query.Sort = sort
+
+		if m := c.QueryParam("min_posts"); m != "" {
+			n, err := strconv.Atoi(m)
+			if err != nil || n < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid min_posts %q", m)})
+			}
+			query.MinPosts = n
+		}
+
+		if l := c.QueryParam("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxAuthorsLimit {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": fmt.Sprintf("invalid limit %q: must be between 1 and %d", l, maxAuthorsLimit),
+				})
+			}
+			query.Limit = n
+		}
+
+		authors, err := database.GetAuthors(query)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"sort":    query.Sort,
+			"authors": authors,
+		})
+	})
+
+	// one author's statistics with a breakdown by subreddit; takes the same subreddit and time range as /api/authors
+	e.GET("/api/authors/:name", func(c echo.Context) error {
+		query, err := authorParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		author, err := database.GetAuthor(c.Param("name"), query.Filter)
+		if errors.Is(err, db.ErrAuthorNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": fmt.Sprintf("No posts by %s", c.Param("name")),
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, author)
+	})
+
+	// alert history, newest first; ?rule=mentions&subreddit=golang&window=24h&limit=50
+	e.GET("/api/alerts", func(c echo.Context) error {
+		filter, err := alertParams(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		alerts, err := database.GetAlerts(filter)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"rules":  collector.AlertRules(),
+			"alerts": alerts,
+		})
+	})
+
+	// recent webhook deliveries and their status, newest first; ?webhook=slack&limit=50
+	e.GET("/api/webhooks/deliveries", func(c echo.Context) error {
+		var limit int // the store's default
+		if l := c.QueryParam("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxDeliveriesLimit {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": fmt.Sprintf("invalid limit %q: must be between 1 and %d", l, maxDeliveriesLimit),
+				})
+			}
+			limit = n
+		}
+
+		deliveries, err := database.GetWebhookDeliveries(c.QueryParam("webhook"), limit)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"deliveries": deliveries,
+		})
+	})
+
+	// subreddits being tracked and their polling schedule; they can be added and removed without a restart
+	e.GET("/api/subreddits", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"subreddits": collector.Subreddits(),
+			"schedule":   collector.PollSchedule(),
+		})
+	})
+
+	// start tracking a subreddit; the body is {"name": "golang"}
+	e.POST("/api/subreddits", func(c echo.Context) error {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		}
+
+		name, err := collector.AddSubreddit(body.Name)
+		switch {
+		case errors.Is(err, stats.ErrInvalidSubreddit):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, stats.ErrSubredditTracked):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"added":      name,
+			"subreddits": collector.Subreddits(),
+		})
+	}, admin)
+
+	// stop tracking a subreddit; its posts are kept
+	e.DELETE("/api/subreddits/:name", func(c echo.Context) error {
+		name, err := collector.RemoveSubreddit(c.Param("name"))
+		if errors.Is(err, stats.ErrSubredditNotTracked) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"removed":    name,
+			"subreddits": collector.Subreddits(),
+		})
+	}, admin)
+
 	// health check endpoint; useful for k8s liveliness probes but not strictly required in this case;
 	// should also add readiness probe, etc if we had a full k8s use case here
 	e.GET("/healthz", func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
 	})
-	
-	// start the server!
-	go func() {
-		serverAddr := fmt.Sprintf(":%d", port)
-		log.WithField("port", port).Info("Starting API server")
-		if err := e.Start(serverAddr); err != nil && err != http.ErrServerClosed {
-			log.WithError(err).Fatal("API server failed")
+
+	return e
+}
+
+// requireAdminToken rejects requests without an "Authorization: Bearer <token>" header carrying the
+// admin token; an empty token rejects every request
+func requireAdminToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token == "" {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Admin endpoints are disabled; set ADMIN_TOKEN to enable them"})
+			}
+
+			given := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Missing or invalid admin token"})
+			}
+			return next(c)
 		}
-	}()
-	
-	// wait for context cancellation to shut down server
-	<-ctx.Done()
-	log.Info("Shutting down API server")
-	
+	}
+}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	if err := e.Shutdown(shutdownCtx); err != nil {
-		log.WithError(err).Error("API server shutdown failed")
+// windowParams reads the time range of the stats endpoints: either window, a duration ending now,
+// or since and until, each RFC3339 or unix seconds. until defaults to now. windowed is false when
+// none of them are set.
+func windowParams(c echo.Context) (since, until time.Time, windowed bool, err error) {
+	window, s, u := c.QueryParam("window"), c.QueryParam("since"), c.QueryParam("until")
+	if window == "" && s == "" && u == "" {
+		return since, until, false, nil
+	}
+
+	until = time.Now()
+	if window != "" {
+		if s != "" || u != "" {
+			return since, until, true, errors.New("window can't be combined with since or until")
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return since, until, true, fmt.Errorf("invalid window %q", window)
+		}
+		return until.Add(-d), until, true, nil
+	}
+
+	if s != "" {
+		if since, err = parseTimeParam(s); err != nil {
+			return since, until, true, fmt.Errorf("invalid since %q", s)
+		}
+	}
+	if u != "" {
+		if until, err = parseTimeParam(u); err != nil {
+			return since, until, true, fmt.Errorf("invalid until %q", u)
+		}
+	}
+	if !since.Before(until) {
+		return since, until, true, errors.New("since must be before until")
 	}
+
+	return since, until, true, nil
+}
+
+// sinceParam reads the start of an open-ended range: window, a duration back from now, or since,
+// RFC3339 or unix seconds. ok is false when neither is set.
+func sinceParam(c echo.Context) (since time.Time, ok bool, err error) {
+	window, s := c.QueryParam("window"), c.QueryParam("since")
+	switch {
+	case window != "" && s != "":
+		return since, true, errors.New("window can't be combined with since")
+	case window != "":
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return since, true, fmt.Errorf("invalid window %q", window)
+		}
+		return time.Now().Add(-d), true, nil
+	case s != "":
+		if since, err = parseTimeParam(s); err != nil {
+			return since, true, fmt.Errorf("invalid since %q", s)
+		}
+		return since, true, nil
+	}
+	return since, false, nil
+}
+
+// parseTimeParam parses an RFC3339 time or unix seconds
+func parseTimeParam(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// isTracked reports whether the subreddit is tracked, ignoring case
+func isTracked(collector *stats.Collector, subreddit string) bool {
+	for _, sr := range collector.Subreddits() {
+		if strings.EqualFold(sr, subreddit) {
+			return true
+		}
+	}
+	return false
+}
+
+// coverageParams reads the subreddit and the window or since query parameters of the coverage endpoints
+func coverageParams(c echo.Context, collector *stats.Collector) ([]string, stats.CoverageOptions, error) {
+	opts := stats.DefaultCoverageOptions()
+	since, ok, err := sinceParam(c)
+	if err != nil {
+		return nil, opts, err
+	}
+	if ok {
+		opts.Since = since
+	}
+
+	subreddits := collector.Subreddits()
+	if s := c.QueryParam("subreddit"); s != "" {
+		subreddits = []string{s}
+	}
+
+	return subreddits, opts, nil
+}
+
+// maxPostsLimit caps the number of posts on one page of /api/posts
+const maxPostsLimit = 100
+
+// postQueryParams reads the filters, order, page and fields of /api/posts: subreddit, author,
+// post_hint, domain, is_self, is_video, min_score, max_score, a creation range as window or
+// since/until, sort, order (asc or desc), cursor, limit and fields
+func postQueryParams(c echo.Context) (db.PostQuery, []string, error) {
+	since, until, windowed, err := windowParams(c)
+	if err != nil {
+		return db.PostQuery{}, nil, err
+	}
+
+	query := db.PostQuery{
+		Filter:   db.PostFilter{Subreddit: c.QueryParam("subreddit")},
+		Author:   c.QueryParam("author"),
+		PostHint: c.QueryParam("post_hint"),
+		Domain:   c.QueryParam("domain"),
+		After:    c.QueryParam("cursor"),
+	}
+	if windowed {
+		query.Filter.Since, query.Filter.Until = since, until
+	}
+
+	// in a fixed order, so a request with several invalid parameters always gets the same error
+	if query.IsSelf, err = boolParam(c, "is_self"); err != nil {
+		return query, nil, err
+	}
+	if query.IsVideo, err = boolParam(c, "is_video"); err != nil {
+		return query, nil, err
+	}
+	if query.MinScore, err = intParam(c, "min_score"); err != nil {
+		return query, nil, err
+	}
+	if query.MaxScore, err = intParam(c, "max_score"); err != nil {
+		return query, nil, err
+	}
+
+	sort, ok := models.ParsePostSort(c.QueryParam("sort"))
+	if !ok {
+		return query, nil, fmt.Errorf("invalid sort %q: must be score, comments, created or velocity", c.QueryParam("sort"))
+	}
+	query.Sort = sort
+
+	switch order := c.QueryParam("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, nil, fmt.Errorf("invalid order %q: must be asc or desc", order)
+	}
+
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxPostsLimit {
+			return query, nil, fmt.Errorf("invalid limit %q: must be between 1 and %d", l, maxPostsLimit)
+		}
+		query.Limit = n
+	}
+
+	fields, err := models.ParsePostFields(c.QueryParam("fields"))
+	if err != nil {
+		return query, nil, err
+	}
+	return query, fields, nil
+}
+
+// boolParam reads an optional true or false query parameter; it's nil when the parameter isn't set
+func boolParam(c echo.Context, name string) (*bool, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: must be true or false", name, v)
+	}
+	return &b, nil
+}
+
+// intParam reads an optional integer query parameter; it's nil when the parameter isn't set
+func intParam(c echo.Context, name string) (*int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return &n, nil
+}
+
+// sortOrder names the direction of a sort
+func sortOrder(ascending bool) string {
+	if ascending {
+		return "asc"
+	}
+	return "desc"
+}
+
+// maxSearchPageSize caps the number of results on one page of /api/search
+const maxSearchPageSize = 100
+
+// maxAuthorsLimit caps the number of authors one /api/authors request returns
+const maxAuthorsLimit = 100
+
+// authorParams reads the posts authors are measured by: subreddit, and window or since/until
+func authorParams(c echo.Context) (db.AuthorQuery, error) {
+	since, until, windowed, err := windowParams(c)
+	if err != nil {
+		return db.AuthorQuery{}, err
+	}
+
+	query := db.AuthorQuery{Filter: db.PostFilter{Subreddit: c.QueryParam("subreddit")}}
+	if windowed {
+		query.Filter.Since, query.Filter.Until = since, until
+	}
+	return query, nil
+}
+
+// maxAlertsLimit caps the number of alerts one request returns
+const maxAlertsLimit = 1000
+
+// maxDeliveriesLimit caps the number of webhook deliveries one request returns
+const maxDeliveriesLimit = 1000
+
+// alertParams reads the alert history filter: rule, subreddit, window or since (see sinceParam) and
+// limit (at most 1000)
+func alertParams(c echo.Context) (models.AlertFilter, error) {
+	filter := models.AlertFilter{
+		Rule:      c.QueryParam("rule"),
+		Subreddit: c.QueryParam("subreddit"),
+	}
+
+	since, ok, err := sinceParam(c)
+	if err != nil {
+		return filter, err
+	}
+	if ok {
+		filter.Since = since
+	}
+
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxAlertsLimit {
+			return filter, fmt.Errorf("invalid limit %q: must be between 1 and %d", l, maxAlertsLimit)
+		}
+		filter.Limit = n
+	}
+
+	return filter, nil
 }
 
 // waitForShutdown waits for a shutdown signal
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/api/redditfake"
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/stats"
+)
+
+// serve performs a GET request against the echo instance
+func serve(e *echo.Echo, target string) *httptest.ResponseRecorder {
+	return serveRequest(e, http.MethodGet, target, "", "")
+}
+
+// serveRequest performs a request with an optional JSON body and admin token; each call uses its own
+// client address so the per-IP rate limiter doesn't reject back-to-back requests
+func serveRequest(e *echo.Echo, method, target, body, token string) *httptest.ResponseRecorder {
+	requestSeq++
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", requestSeq%250+1)
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+var requestSeq int
+
+// TestEndToEnd runs the collector against the fake Reddit server and reads the results back through the API
+func TestEndToEnd(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	fake := redditfake.NewServer()
+	defer fake.Close()
+
+	fake.AddPosts("golang",
+		models.Post{ID: "a1", Title: "first", Author: "alice", CreatedUTC: 1700000001, Upvotes: 5, Score: 5},
+		models.Post{ID: "a2", Title: "second", Author: "bob", CreatedUTC: float64(time.Now().Unix()), Upvotes: 42, Score: 42, NumComments: 2},
+		models.Post{ID: "a3", Title: "third", Author: "alice", CreatedUTC: 1700000003, Upvotes: 7, Score: 7},
+	)
+
+	fake.AddComments("a2",
+		models.Comment{ID: "c1", Author: "carol", Body: "nice", CreatedUTC: 1700000100},
+		models.Comment{ID: "c2", ParentID: "t1_c1", Author: "bob", Body: "thanks", CreatedUTC: 1700000200},
+	)
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	redditAPI := api.NewRedditAPI("id", "secret", "test-agent", 60000, log,
+		api.WithBaseURL(fake.URL()),
+		api.WithAuthURL(fake.AuthURL()),
+	)
+	collector := stats.NewCollector(redditAPI, database, []string{"golang"}, 1, log,
+		stats.WithCommentRefresh(50*time.Millisecond, 5, time.Hour),
+		stats.WithListings([]models.Feed{{Subreddit: "golang", Listing: models.ListingHot}}, time.Hour),
+		stats.WithAlerts([]models.AlertRule{{Name: "popular", MinScore: 40}}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- collector.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return collector.GetStatistics().TotalPosts == 3
+	}, 5*time.Second, 50*time.Millisecond)
+
+	e := newEchoServer(collector, database, 60000, "")
+
+	rec := serve(e, "/api/stats")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var statistics models.Statistics
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statistics))
+	assert.Equal(t, 3, statistics.TotalPosts)
+	require.NotEmpty(t, statistics.TopPostsByUpvotes)
+	assert.Equal(t, "a2", statistics.TopPostsByUpvotes[0].ID)
+	require.NotEmpty(t, statistics.TopUsersByPostCount)
+	assert.Equal(t, models.UserPostCount{Author: "alice", PostCount: 2}, statistics.TopUsersByPostCount[0])
+
+	rec = serve(e, "/api/stats/golang")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var subredditStats models.SubredditStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &subredditStats))
+	assert.Equal(t, 3, subredditStats.PostCount)
+	assert.Equal(t, "a2", subredditStats.HighestUpvotedPost.ID)
+
+	// only a2 was created in the last day
+	rec = serve(e, "/api/stats?window=24h")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var windowStats models.WindowStatistics
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &windowStats))
+	assert.Equal(t, 1, windowStats.TotalPosts)
+	require.Len(t, windowStats.TopPostsByUpvotes, 1)
+	assert.Equal(t, "a2", windowStats.TopPostsByUpvotes[0].ID)
+	assert.Equal(t, []models.UserPostCount{{Author: "bob", PostCount: 1}}, windowStats.TopUsersByPostCount)
+	assert.Equal(t, 1, windowStats.SubredditStats["golang"].PostCount)
+
+	rec = serve(e, "/api/stats/golang?since=1700000000&until=2023-11-14T22:13:24Z")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	windowStats = models.WindowStatistics{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &windowStats))
+	assert.Equal(t, "golang", windowStats.Subreddit)
+	assert.Equal(t, 2, windowStats.TotalPosts)
+	assert.Equal(t, []models.UserPostCount{{Author: "alice", PostCount: 2}}, windowStats.TopUsersByPostCount)
+
+	rec = serve(e, "/api/stats/golang?window=24h")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	windowStats = models.WindowStatistics{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &windowStats))
+	assert.Equal(t, "golang", windowStats.Subreddit)
+	assert.Equal(t, 1, windowStats.TotalPosts)
+
+	rec = serve(e, "/api/authors?sort=score")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var authors struct {
+		Sort    string               `json:"sort"`
+		Authors []models.AuthorStats `json:"authors"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &authors))
+	assert.Equal(t, "score", authors.Sort)
+	require.Len(t, authors.Authors, 2)
+	assert.Equal(t, "bob", authors.Authors[0].Author)
+	assert.Equal(t, 1, authors.Authors[0].Rank)
+
+	rec = serve(e, "/api/authors/alice?subreddit=golang")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var alice models.AuthorStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &alice))
+	assert.Equal(t, 2, alice.PostCount)
+	assert.Equal(t, 12, alice.TotalScore)
+
+	rec = serve(e, "/api/authors/nobody")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(e, "/api/authors?sort=karma")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(e, "/api/search?q=alice&subreddit=golang&since=1700000002&sort=new")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var search models.SearchResults
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &search))
+	assert.Equal(t, 1, search.Total)
+	require.Len(t, search.Results, 1)
+	assert.Equal(t, "a3", search.Results[0].Post.ID)
+
+	for _, target := range []string{"/api/search", "/api/search?q=%22%22", "/api/search?q=go&sort=best", "/api/search?q=go&page_size=1000"} {
+		rec = serve(e, target)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	rec = serve(e, "/api/posts?author=alice&sort=created&order=asc&limit=1&fields=id,score")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Sort       string                       `json:"sort"`
+		Order      string                       `json:"order"`
+		Posts      []map[string]json.RawMessage `json:"posts"`
+		NextCursor string                       `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, "created", page.Sort)
+	assert.Equal(t, "asc", page.Order)
+	require.Len(t, page.Posts, 1)
+	assert.Equal(t, map[string]json.RawMessage{"id": json.RawMessage(`"a1"`), "score": json.RawMessage(`5`)}, page.Posts[0])
+	require.NotEmpty(t, page.NextCursor)
+
+	rec = serve(e, "/api/posts?author=alice&sort=created&order=asc&limit=1&fields=id&cursor="+page.NextCursor)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	page.Posts, page.NextCursor = nil, ""
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Posts, 1)
+	assert.Equal(t, json.RawMessage(`"a3"`), page.Posts[0]["id"])
+	assert.Empty(t, page.NextCursor)
+
+	for _, target := range []string{"/api/posts?sort=hot", "/api/posts?fields=id,karma", "/api/posts?is_self=maybe",
+		"/api/posts?min_score=high", "/api/posts?order=up", "/api/posts?cursor=nope", "/api/posts?limit=1000"} {
+		rec = serve(e, target)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	// with several invalid parameters, the first in parsing order is always the one reported
+	for i := 0; i < 10; i++ {
+		rec = serve(e, "/api/posts?max_score=x&min_score=y&is_video=z&is_self=w")
+		assert.Contains(t, rec.Body.String(), "invalid is_self")
+	}
+
+	rec = serve(e, "/api/stats?window=yesterday")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(e, "/api/stats/golang?since=2024-01-01T00:00:00Z&until=2023-01-01T00:00:00Z")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(e, "/api/stats/rust?window=24h")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.Eventually(t, func() bool {
+		comments, err := database.GetCommentsByPost("a2", time.Time{}, time.Time{})
+		return err == nil && len(comments) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	rec = serve(e, "/api/posts/a2/history")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var history models.PostHistory
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	assert.Equal(t, "a2", history.Post.ID)
+	require.NotEmpty(t, history.Snapshots)
+	assert.Equal(t, 42, history.Snapshots[0].Score)
+
+	rec = serve(e, "/api/posts/a2/history?since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(e, "/api/posts/missing/history")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(e, "/api/trending?subreddit=golang&limit=2")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var trending struct {
+		Window string                `json:"window"`
+		Posts  []models.TrendingPost `json:"posts"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &trending))
+	assert.Equal(t, "1h0m0s", trending.Window)
+	// a1 and a3 are too old to trend
+	require.Len(t, trending.Posts, 1)
+	assert.Equal(t, "a2", trending.Posts[0].Post.ID)
+
+	rec = serve(e, "/api/trending?window=48h")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(e, "/api/coverage?window=24h")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var coverage struct {
+		Gaps []models.CoverageGap `json:"gaps"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &coverage))
+	assert.Empty(t, coverage.Gaps)
+
+	// a2 is saved by every poll and listing snapshot, but the rule fires for it once
+	rec = serve(e, "/api/alerts?rule=popular&window=1h")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var alerts struct {
+		Rules  []models.AlertRule `json:"rules"`
+		Alerts []models.Alert     `json:"alerts"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &alerts))
+	require.Len(t, alerts.Rules, 1)
+	require.Len(t, alerts.Alerts, 1)
+	assert.Equal(t, "a2", alerts.Alerts[0].PostID)
+	assert.Equal(t, 42, alerts.Alerts[0].Score)
+
+	// durations go in window, since is a time
+	for _, target := range []string{"/api/alerts?limit=0", "/api/alerts?window=-1h", "/api/alerts?since=1h",
+		"/api/alerts?window=1h&since=1700000000", "/api/coverage?since=24h", "/api/coverage?window=0s", "/api/coverage?window=1h&since=1700000000"} {
+		rec = serve(e, target)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+	rec = serve(e, "/api/coverage?since=1700000000")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// a2 has the highest score, so it tops the hot listing
+	var ranks []models.ListingRank
+	require.Eventually(t, func() bool {
+		ranks, err = database.GetPostRanks("a2")
+		return err == nil && len(ranks) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, models.ListingHot, ranks[0].Listing)
+	assert.Equal(t, 1, ranks[0].Rank)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector did not stop after cancellation")
+	}
+}
+
+func TestSubredditEndpoints(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	redditAPI := api.NewRedditAPI("id", "secret", "test-agent", 60000, log)
+	collector := stats.NewCollector(redditAPI, database, []string{"golang"}, 1, log)
+	e := newEchoServer(collector, database, 60000, "secret-token")
+
+	var listing struct {
+		Subreddits []string `json:"subreddits"`
+	}
+
+	rec := serveRequest(e, http.MethodPost, "/api/subreddits", `{"name": "rust"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serveRequest(e, http.MethodPost, "/api/subreddits", `{"name": "rust"}`, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serveRequest(e, http.MethodPost, "/api/subreddits", `{"name": "r/rust"}`, "secret-token")
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = serveRequest(e, http.MethodPost, "/api/subreddits", `{"name": "Rust"}`, "secret-token")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serveRequest(e, http.MethodPost, "/api/subreddits", `{"name": "no such thing"}`, "secret-token")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serveRequest(e, http.MethodDelete, "/api/subreddits/golang", "", "secret-token")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveRequest(e, http.MethodDelete, "/api/subreddits/golang", "", "secret-token")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// reading needs no token
+	rec = serve(e, "/api/subreddits")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listing))
+	assert.Equal(t, []string{"rust"}, listing.Subreddits)
+
+	// the changes are persisted
+	tracked, err := database.GetTrackedSubreddits()
+	require.NoError(t, err)
+	require.Len(t, tracked, 2)
+}
+
+func TestAdminEndpointsDisabledWithoutToken(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer database.Close()
+
+	redditAPI := api.NewRedditAPI("id", "secret", "test-agent", 60000, log)
+	collector := stats.NewCollector(redditAPI, database, []string{"golang"}, 1, log)
+	e := newEchoServer(collector, database, 60000, "")
+
+	rec := serveRequest(e, http.MethodPost, "/api/subreddits", `{"name": "rust"}`, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveRequest(e, http.MethodDelete, "/api/subreddits/golang", "", "anything")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveRequest(e, http.MethodPost, "/api/coverage/backfill", "", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	assert.Equal(t, []string{"golang"}, collector.Subreddits())
+	rec = serve(e, "/api/subreddits")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
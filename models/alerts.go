@@ -0,0 +1,89 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AlertRule fires when a post meets every condition it sets; conditions left empty or zero match any post
+type AlertRule struct {
+	Name        string   `json:"name"`
+	Subreddits  []string `json:"subreddits,omitempty"`   // case-insensitive
+	Pattern     string   `json:"pattern,omitempty"`      // regular expression matched against the title and selftext
+	Keywords    []string `json:"keywords,omitempty"`     // any of these words or phrases in the title or selftext, case-insensitive
+	Authors     []string `json:"authors,omitempty"`      // case-insensitive
+	MinScore    int      `json:"min_score,omitempty"`    // score at least this
+	MinComments int      `json:"min_comments,omitempty"` // comment count at least this
+}
+
+// Validate checks that a rule has a name, at least one condition and a valid pattern
+func (r AlertRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("alert rule needs a name")
+	}
+	if len(r.Subreddits) == 0 && r.Pattern == "" && len(r.Keywords) == 0 && len(r.Authors) == 0 &&
+		r.MinScore == 0 && r.MinComments == 0 {
+		return fmt.Errorf("alert rule %s has no conditions", r.Name)
+	}
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("alert rule %s has an invalid pattern: %w", r.Name, err)
+		}
+	}
+	for _, keyword := range r.Keywords {
+		if strings.TrimSpace(keyword) == "" {
+			return fmt.Errorf("alert rule %s has an empty keyword", r.Name)
+		}
+	}
+	if r.MinScore < 0 || r.MinComments < 0 {
+		return fmt.Errorf("alert rule %s has a negative threshold", r.Name)
+	}
+	return nil
+}
+
+// ParseAlertRules parses a JSON array of alert rules and validates each one; rule names must be unique
+func ParseAlertRules(data []byte) ([]AlertRule, error) {
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules: %w", err)
+	}
+
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return rules, nil
+}
+
+// Alert records a rule firing for a post. A rule fires at most once per post.
+type Alert struct {
+	ID          int64     `json:"id"`
+	Rule        string    `json:"rule"`
+	PostID      string    `json:"post_id"`
+	Subreddit   string    `json:"subreddit"`
+	Title       string    `json:"title"`
+	Author      string    `json:"author"`
+	Permalink   string    `json:"permalink"`
+	Score       int       `json:"score"`             // when the rule fired
+	NumComments int       `json:"num_comments"`      // when the rule fired
+	Matched     string    `json:"matched,omitempty"` // text the pattern or a keyword matched
+	FiredAt     time.Time `json:"fired_at"`
+}
+
+// AlertFilter selects alerts from the history; zero fields don't filter
+type AlertFilter struct {
+	Rule      string
+	Subreddit string
+	Since     time.Time
+	Limit     int
+}
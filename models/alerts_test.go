@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlertRules(t *testing.T) {
+	rules, err := ParseAlertRules([]byte(`[
+		{"name": "mentions", "subreddits": ["golang"], "keywords": ["reddit tracker"]},
+		{"name": "hot", "min_score": 500, "pattern": "(?i)release"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"reddit tracker"}, rules[0].Keywords)
+	assert.Equal(t, 500, rules[1].MinScore)
+
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{"not json", `{`, "failed to parse alert rules"},
+		{"no name", `[{"keywords": ["go"]}]`, "needs a name"},
+		{"no conditions", `[{"name": "empty"}]`, "has no conditions"},
+		{"bad pattern", `[{"name": "bad", "pattern": "("}]`, "invalid pattern"},
+		{"empty keyword", `[{"name": "blank", "keywords": [" "]}]`, "empty keyword"},
+		{"negative threshold", `[{"name": "neg", "min_score": -1}]`, "negative threshold"},
+		{"duplicate", `[{"name": "a", "min_score": 1}, {"name": "a", "min_score": 2}]`, "defined twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAlertRules([]byte(tt.rules))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// excludedAuthors are accounts left out of author rankings: removed accounts and reddit's own bots
+var excludedAuthors = map[string]bool{
+	"":              true,
+	"[deleted]":     true,
+	"[removed]":     true,
+	"automoderator": true,
+}
+
+// IsExcludedAuthor reports whether an author is left out of author rankings, such as [deleted] or
+// AutoModerator
+func IsExcludedAuthor(author string) bool {
+	return excludedAuthors[strings.ToLower(author)]
+}
+
+// ExcludedAuthors returns the lowercased names of the accounts left out of author rankings
+func ExcludedAuthors() []string {
+	names := make([]string, 0, len(excludedAuthors))
+	for name := range excludedAuthors {
+		names = append(names, name)
+	}
+	return names
+}
+
+// UserPostCount is an author's number of posts, as ranked in the statistics
+type UserPostCount struct {
+	Author    string `json:"author"`
+	PostCount int    `json:"post_count"`
+}
+
+// AuthorStats is what an author's posts add up to
+type AuthorStats struct {
+	Rank                 int                  `json:"rank,omitempty"` // position in a ranking; 0 outside one
+	Author               string               `json:"author"`
+	PostCount            int                  `json:"post_count"`
+	TotalScore           int                  `json:"total_score"`
+	AvgScore             float64              `json:"avg_score"`
+	TotalComments        int                  `json:"total_comments"` // comments received on their posts
+	AvgComments          float64              `json:"avg_comments"`
+	SubredditCount       int                  `json:"subreddit_count"`
+	Subreddits           []SubredditPostCount `json:"subreddits,omitempty"` // only for a single author, most posts first
+	FirstSeen            time.Time            `json:"first_seen"`           // creation time of their first post
+	LastSeen             time.Time            `json:"last_seen"`            // creation time of their latest post
+	PostsPerDay          float64              `json:"posts_per_day"`        // over the days between first and last seen, at least one
+	AvgHoursBetweenPosts float64              `json:"avg_hours_between_posts,omitempty"`
+}
+
+// SubredditPostCount is the number of posts an author made in a subreddit
+type SubredditPostCount struct {
+	Subreddit  string `json:"subreddit"`
+	PostCount  int    `json:"post_count"`
+	TotalScore int    `json:"total_score"`
+}
+
+// AuthorSort is what authors are ranked by
+type AuthorSort string
+
+const (
+	SortAuthorsByPosts    AuthorSort = "posts"
+	SortAuthorsByScore    AuthorSort = "score"
+	SortAuthorsByComments AuthorSort = "comments"
+	SortAuthorsByAvgScore AuthorSort = "avg_score"
+)
+
+// ParseAuthorSort parses an author ranking; an empty string ranks by posts
+func ParseAuthorSort(s string) (AuthorSort, bool) {
+	switch sort := AuthorSort(s); sort {
+	case "":
+		return SortAuthorsByPosts, true
+	case SortAuthorsByPosts, SortAuthorsByScore, SortAuthorsByComments, SortAuthorsByAvgScore:
+		return sort, true
+	}
+	return "", false
+}
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// PollRecord is the outcome of one poll of a subreddit's new listing
+type PollRecord struct {
+	Subreddit string    `json:"subreddit"`
+	PolledAt  time.Time `json:"polled_at"`
+	Pages     int       `json:"pages"`
+	Posts     int       `json:"posts"`     // posts fetched, including already-seen ones
+	NewPosts  int       `json:"new_posts"` // posts newer than the frontier
+	CaughtUp  bool      `json:"caught_up"` // whether the poll paged back far enough to reach the frontier
+	// creation time of the oldest post fetched; the poll saw every post created since then
+	CoveredFrom time.Time `json:"covered_from,omitempty"`
+	// when the poll hit the page limit, the range of creation times it couldn't reach
+	GapStart time.Time `json:"gap_start,omitempty"`
+	GapEnd   time.Time `json:"gap_end,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// GapReason explains why a time range is thought to be missing posts
+type GapReason string
+
+const (
+	GapPollTruncated GapReason = "poll_truncated" // a poll hit its page limit before reaching the frontier
+	GapSparsePosts   GapReason = "sparse_posts"   // an unusually long silence between posts no caught-up poll vouches for
+)
+
+// CoverageGap is a time range of a subreddit where ingestion was probably incomplete
+type CoverageGap struct {
+	Subreddit        string    `json:"subreddit"`
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	Reason           GapReason `json:"reason"`
+	BeforeID         string    `json:"before_id,omitempty"` // newest stored post before the gap
+	AfterID          string    `json:"after_id,omitempty"`  // oldest stored post after the gap
+	EstimatedMissing int       `json:"estimated_missing"`   // based on the subreddit's usual post rate
+}
+
+// PostRef is the id and creation time of a stored post
+type PostRef struct {
+	ID         string  `json:"id"`
+	CreatedUTC float64 `json:"created_utc"`
+}
+
+// BackfillStatus is the state of a backfill job
+type BackfillStatus string
+
+const (
+	BackfillPending BackfillStatus = "pending"
+	BackfillRunning BackfillStatus = "running"
+	BackfillDone    BackfillStatus = "done"
+	BackfillFailed  BackfillStatus = "failed"
+)
+
+// BackfillSource is where a backfill job is reading posts from
+type BackfillSource string
+
+const (
+	BackfillFromListing BackfillSource = "listing" // walking the new listing's after cursors
+	BackfillFromSearch  BackfillSource = "search"  // the listing ran out; searching by timestamp
+)
+
+// BackfillJob asks for the posts of a subreddit created between Since and Until to be fetched.
+// The fields after UpdatedAt are the job's checkpoint, saved after every page.
+type BackfillJob struct {
+	ID        int64          `json:"id"`
+	Subreddit string         `json:"subreddit"`
+	Since     time.Time      `json:"since"`
+	Until     time.Time      `json:"until"`
+	Reason    string         `json:"reason"`
+	Status    BackfillStatus `json:"status"`
+	MaxPages  int            `json:"max_pages"` // 0 for no limit
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+
+	Source BackfillSource `json:"source"`
+	Cursor string         `json:"cursor,omitempty"`
+	Pages  int            `json:"pages"`
+	Posts  int            `json:"posts"`            // posts saved within the job's range
+	Oldest time.Time      `json:"oldest,omitempty"` // creation time of the oldest post the listing reached
+	Error  string         `json:"error,omitempty"`
+
+	LeaseUntil time.Time `json:"lease_until,omitempty"` // when the worker running the job must next checkpoint it by
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Listing identifies the sort order of a subreddit listing
+type Listing string
+
+const (
+	ListingNew           Listing = "new"
+	ListingHot           Listing = "hot"
+	ListingTop           Listing = "top"
+	ListingRising        Listing = "rising"
+	ListingControversial Listing = "controversial"
+)
+
+// TimeWindow limits the top and controversial listings to posts from a period of time
+type TimeWindow string
+
+const (
+	TimeHour  TimeWindow = "hour"
+	TimeDay   TimeWindow = "day"
+	TimeWeek  TimeWindow = "week"
+	TimeMonth TimeWindow = "month"
+	TimeYear  TimeWindow = "year"
+	TimeAll   TimeWindow = "all"
+)
+
+// Valid reports whether l is a listing reddit supports
+func (l Listing) Valid() bool {
+	switch l {
+	case ListingNew, ListingHot, ListingTop, ListingRising, ListingControversial:
+		return true
+	}
+	return false
+}
+
+// HasTimeWindow reports whether the listing accepts a time window (the t= parameter)
+func (l Listing) HasTimeWindow() bool {
+	return l == ListingTop || l == ListingControversial
+}
+
+// Valid reports whether t is a time window reddit supports
+func (t TimeWindow) Valid() bool {
+	switch t {
+	case TimeHour, TimeDay, TimeWeek, TimeMonth, TimeYear, TimeAll:
+		return true
+	}
+	return false
+}
+
+// Feed identifies one listing of one subreddit
+type Feed struct {
+	Subreddit string     `json:"subreddit"`
+	Listing   Listing    `json:"listing"`
+	Time      TimeWindow `json:"time,omitempty"`
+}
+
+// ParseFeed parses a feed in the form subreddit:listing or subreddit:listing/time, for example
+// AskReddit:hot or AskReddit:top/week; top and controversial default to the day window
+func ParseFeed(spec string) (Feed, error) {
+	subreddit, listingSpec, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	subreddit = strings.TrimSpace(subreddit)
+	if !ok || subreddit == "" {
+		return Feed{}, fmt.Errorf("invalid feed %q: expected subreddit:listing", spec)
+	}
+
+	listing, window, _ := strings.Cut(strings.TrimSpace(listingSpec), "/")
+	feed := Feed{
+		Subreddit: subreddit,
+		Listing:   Listing(strings.ToLower(strings.TrimSpace(listing))),
+		Time:      TimeWindow(strings.ToLower(strings.TrimSpace(window))),
+	}
+
+	if !feed.Listing.Valid() {
+		return Feed{}, fmt.Errorf("invalid feed %q: unknown listing %q", spec, listing)
+	}
+	if feed.Listing.HasTimeWindow() {
+		if feed.Time == "" {
+			feed.Time = TimeDay
+		}
+		if !feed.Time.Valid() {
+			return Feed{}, fmt.Errorf("invalid feed %q: unknown time window %q", spec, window)
+		}
+	} else if feed.Time != "" {
+		return Feed{}, fmt.Errorf("invalid feed %q: %s listings don't take a time window", spec, feed.Listing)
+	}
+
+	return feed, nil
+}
+
+// String formats the feed in the form accepted by ParseFeed
+func (f Feed) String() string {
+	if f.Time != "" {
+		return fmt.Sprintf("%s:%s/%s", f.Subreddit, f.Listing, f.Time)
+	}
+	return fmt.Sprintf("%s:%s", f.Subreddit, f.Listing)
+}
+
+// ListingRank records the position of a post in one snapshot of a listing
+type ListingRank struct {
+	Subreddit  string     `json:"subreddit"`
+	Listing    Listing    `json:"listing"`
+	Time       TimeWindow `json:"time,omitempty"`
+	SnapshotAt time.Time  `json:"snapshot_at"`
+	Rank       int        `json:"rank"` // 1-based position in the listing
+	PostID     string     `json:"post_id"`
+	Score      int        `json:"score"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Feed
+		wantErr  bool
+	}{
+		{
+			name:     "Listing without time window",
+			input:    "AskReddit:hot",
+			expected: Feed{Subreddit: "AskReddit", Listing: ListingHot},
+		},
+		{
+			name:     "Top with time window",
+			input:    "news:top/week",
+			expected: Feed{Subreddit: "news", Listing: ListingTop, Time: TimeWeek},
+		},
+		{
+			name:     "Controversial defaults to day",
+			input:    "news:controversial",
+			expected: Feed{Subreddit: "news", Listing: ListingControversial, Time: TimeDay},
+		},
+		{
+			name:     "Whitespace and case are ignored",
+			input:    "  golang : Rising ",
+			expected: Feed{Subreddit: "golang", Listing: ListingRising},
+		},
+		{
+			name:    "Missing listing",
+			input:   "golang",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown listing",
+			input:   "golang:best",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown time window",
+			input:   "golang:top/decade",
+			wantErr: true,
+		},
+		{
+			name:    "Time window on a listing that doesn't take one",
+			input:   "golang:hot/day",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			feed, err := ParseFeed(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, feed)
+
+			// the string form parses back to the same feed
+			reparsed, err := ParseFeed(feed.String())
+			assert.NoError(t, err)
+			assert.Equal(t, feed, reparsed)
+		})
+	}
+}
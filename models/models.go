@@ -1,6 +1,8 @@
 package models
 
 import (
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -17,18 +19,93 @@ type Post struct {
 	Downvotes     int       `json:"downvotes"`
 	Score         int       `json:"score"`
 	NumComments   int       `json:"num_comments"`
+	UpvoteRatio   float64   `json:"upvote_ratio"`
 	PostHint      string    `json:"post_hint"`
 	IsVideo       bool      `json:"is_video"`
 	IsSelf        bool      `json:"is_self"`
 	SelfText      string    `json:"selftext"`
 	Permalink     string    `json:"permalink"`
+	Over18        bool      `json:"over_18"`
+	Domain        string    `json:"domain"` // host of the linked URL, or self.<subreddit>; see LinkDomain
 	ProcessedTime time.Time `json:"processed_time"`
 }
 
+// LinkDomain returns the domain a post links to: the lowercased host of its URL without a www.,
+// or self.<subreddit> for a self post. The stores save it with every post that has no domain set.
+func (p Post) LinkDomain() string {
+	if p.IsSelf {
+		return "self." + p.Subreddit
+	}
+
+	u, err := url.Parse(strings.TrimSpace(p.URL))
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// PostSummary holds the fields of a post that the statistics are computed from
+type PostSummary struct {
+	ID        string `json:"id"`
+	Subreddit string `json:"subreddit"`
+	Author    string `json:"author"`
+	Upvotes   int    `json:"upvotes"`
+}
+
+// PostSnapshot is one observation of a post's score and comment count
+type PostSnapshot struct {
+	PostID      string    `json:"post_id"`
+	ObservedAt  time.Time `json:"observed_at"`
+	Score       int       `json:"score"`
+	Upvotes     int       `json:"upvotes"`
+	NumComments int       `json:"num_comments"`
+	UpvoteRatio float64   `json:"upvote_ratio"`
+}
+
+// PostRefresh is when a post was last observed and when its score should next be refreshed;
+// a zero NextRefresh means it's no longer refreshed
+type PostRefresh struct {
+	PostID        string    `json:"post_id"`
+	LastRefreshed time.Time `json:"last_refreshed"`
+	NextRefresh   time.Time `json:"next_refresh"`
+}
+
+// PostHistory is a post with its time series of observations, oldest first
+type PostHistory struct {
+	Post      Post           `json:"post"`
+	Snapshots []PostSnapshot `json:"snapshots"`
+}
+
+// Comment represents a Reddit comment
+type Comment struct {
+	ID            string    `json:"id"`
+	PostID        string    `json:"post_id"`
+	ParentID      string    `json:"parent_id"` // fullname of the parent; t3_ for top-level comments, t1_ for replies
+	Subreddit     string    `json:"subreddit"`
+	Author        string    `json:"author"`
+	Body          string    `json:"body"`
+	Score         int       `json:"score"`
+	Depth         int       `json:"depth"`
+	CreatedUTC    float64   `json:"created_utc"`
+	CreatedAt     time.Time `json:"created_at"`
+	Permalink     string    `json:"permalink"`
+	ProcessedTime time.Time `json:"processed_time"`
+}
+
+// TrendingPost is a post ranked by how fast it is gaining score and comments
+type TrendingPost struct {
+	Post            Post    `json:"post"`
+	ScoreVelocity   float64 `json:"score_velocity"`   // score gained per hour
+	CommentVelocity float64 `json:"comment_velocity"` // comments gained per hour
+	AgeHours        float64 `json:"age_hours"`
+	TrendScore      float64 `json:"trend_score"` // combined velocity, normalised by age
+}
+
 // SubredditStats holds statistics for a single subreddit
 type SubredditStats struct {
 	PostCount         int  `json:"post_count"`
 	HighestUpvotedPost Post `json:"highest_upvoted_post"`
+	Trending          []TrendingPost `json:"trending"`
 }
 
 // Statistics holds statistics about the Reddit posts
@@ -36,8 +113,20 @@ type Statistics struct {
 	TotalPosts         int                       `json:"total_posts"`
 	ProcessedPostCount int                       `json:"processed_post_count"`
 	TopPostsByUpvotes  []Post                    `json:"top_posts_by_upvotes"`
-	TopUsersByPostCount map[string]int           `json:"top_users_by_post_count"`
+	TopUsersByPostCount []UserPostCount          `json:"top_users_by_post_count"`
 	StartTime          time.Time                 `json:"start_time"`
 	LastUpdated        time.Time                 `json:"last_updated"`
 	SubredditStats     map[string]SubredditStats `json:"subreddit_stats"`
-} 
\ No newline at end of file
+	Trending           []TrendingPost            `json:"trending"`
+} 
+
+// WindowStatistics holds statistics about the posts created within a time range
+type WindowStatistics struct {
+	Since               time.Time                 `json:"since"`
+	Until               time.Time                 `json:"until"`
+	Subreddit           string                    `json:"subreddit,omitempty"`
+	TotalPosts          int                       `json:"total_posts"`
+	TopPostsByUpvotes   []Post                    `json:"top_posts_by_upvotes"`
+	TopUsersByPostCount []UserPostCount           `json:"top_users_by_post_count"`
+	SubredditStats      map[string]SubredditStats `json:"subreddit_stats,omitempty"`
+}
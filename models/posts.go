@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PostSort is what a list of posts is ordered by
+type PostSort string
+
+const (
+	SortPostsByScore    PostSort = "score"
+	SortPostsByComments PostSort = "comments"
+	SortPostsByCreated  PostSort = "created"
+	// SortPostsByVelocity orders posts by score gained per hour between their creation and the
+	// latest observation of their score
+	SortPostsByVelocity PostSort = "velocity"
+)
+
+// ParsePostSort parses a post order; an empty string orders by score
+func ParsePostSort(s string) (PostSort, bool) {
+	switch sort := PostSort(s); sort {
+	case "":
+		return SortPostsByScore, true
+	case SortPostsByScore, SortPostsByComments, SortPostsByCreated, SortPostsByVelocity:
+		return sort, true
+	}
+	return "", false
+}
+
+// postFields is the JSON name of every field of a post
+var postFields = func() map[string]bool {
+	var fields map[string]json.RawMessage
+	data, _ := json.Marshal(Post{})
+	json.Unmarshal(data, &fields)
+
+	names := make(map[string]bool, len(fields))
+	for name := range fields {
+		names[name] = true
+	}
+	return names
+}()
+
+// PostFieldNames returns the JSON names of the fields of a post, sorted
+func PostFieldNames() []string {
+	names := make([]string, 0, len(postFields))
+	for name := range postFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePostFields parses a comma-separated list of post fields by their JSON names, e.g.
+// "id,title,score"; an empty list selects every field
+func ParsePostFields(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	fields := make([]string, 0)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !postFields[name] {
+			return nil, fmt.Errorf("unknown post field %q", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// SelectFields returns the post as a JSON object of only the given fields, or of every field when
+// there are none
+func (p Post) SelectFields(fields []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return all, nil
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, name := range fields {
+		selected[name] = all[name]
+	}
+	return selected, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostFields(t *testing.T) {
+	fields, err := ParsePostFields(" id, title ,score")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "title", "score"}, fields)
+
+	fields, err = ParsePostFields("")
+	require.NoError(t, err)
+	assert.Empty(t, fields)
+
+	_, err = ParsePostFields("id,karma")
+	assert.Error(t, err)
+	_, err = ParsePostFields("id,")
+	assert.Error(t, err)
+
+	assert.Contains(t, PostFieldNames(), "post_hint")
+}
+
+func TestPostSelectFields(t *testing.T) {
+	post := Post{ID: "p1", Title: "hello", Score: 3}
+
+	selected, err := post.SelectFields([]string{"id", "score"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{"id": json.RawMessage(`"p1"`), "score": json.RawMessage(`3`)}, selected)
+
+	all, err := post.SelectFields(nil)
+	require.NoError(t, err)
+	assert.Len(t, all, len(PostFieldNames()))
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubredditSchedule sets how a subreddit's new listing is polled. A subreddit gets a share of the
+// polling budget proportional to its weight; zero intervals fall back to the global bounds.
+type SubredditSchedule struct {
+	Subreddit   string        `json:"subreddit"`
+	Weight      float64       `json:"weight"`
+	MinInterval time.Duration `json:"min_interval,omitempty"` // never poll more often than this
+	MaxInterval time.Duration `json:"max_interval,omitempty"` // always poll at least this often
+}
+
+// ParseSubredditSchedule parses a schedule in the form subreddit:weight[:min[:max]], for example
+// AskReddit:5 or golang:0.5:1m:30m; an empty min keeps the global bound, as in news:2::10m
+func ParseSubredditSchedule(spec string) (SubredditSchedule, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	subreddit := strings.TrimSpace(parts[0])
+	if len(parts) < 2 || len(parts) > 4 || subreddit == "" {
+		return SubredditSchedule{}, fmt.Errorf("invalid schedule %q: expected subreddit:weight[:min[:max]]", spec)
+	}
+
+	weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || weight <= 0 {
+		return SubredditSchedule{}, fmt.Errorf("invalid schedule %q: weight must be a positive number", spec)
+	}
+	schedule := SubredditSchedule{Subreddit: subreddit, Weight: weight}
+
+	intervals := []*time.Duration{&schedule.MinInterval, &schedule.MaxInterval}
+	for i, part := range parts[2:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return SubredditSchedule{}, fmt.Errorf("invalid schedule %q: %q is not a positive duration", spec, part)
+		}
+		*intervals[i] = d
+	}
+
+	if schedule.MinInterval > 0 && schedule.MaxInterval > 0 && schedule.MinInterval > schedule.MaxInterval {
+		return SubredditSchedule{}, fmt.Errorf("invalid schedule %q: min interval is longer than max interval", spec)
+	}
+
+	return schedule, nil
+}
+
+// PollStatus is the current polling schedule of a subreddit
+type PollStatus struct {
+	Subreddit      string    `json:"subreddit"`
+	Weight         float64   `json:"weight"`
+	IntervalSec    float64   `json:"interval_sec"`
+	ArrivalPerHour float64   `json:"arrival_per_hour"` // estimated new posts per hour; 0 until measured
+	LastPoll       time.Time `json:"last_poll,omitempty"`
+	NextPoll       time.Time `json:"next_poll"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubredditSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected SubredditSchedule
+		wantErr  bool
+	}{
+		{
+			name:     "Weight only",
+			input:    "AskReddit:5",
+			expected: SubredditSchedule{Subreddit: "AskReddit", Weight: 5},
+		},
+		{
+			name:     "Weight and both intervals",
+			input:    " golang : 0.5 : 1m : 30m ",
+			expected: SubredditSchedule{Subreddit: "golang", Weight: 0.5, MinInterval: time.Minute, MaxInterval: 30 * time.Minute},
+		},
+		{
+			name:     "Max interval only",
+			input:    "news:2::10m",
+			expected: SubredditSchedule{Subreddit: "news", Weight: 2, MaxInterval: 10 * time.Minute},
+		},
+		{
+			name:    "Missing weight",
+			input:   "golang",
+			wantErr: true,
+		},
+		{
+			name:    "Zero weight",
+			input:   "golang:0",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid duration",
+			input:   "golang:1:soon",
+			wantErr: true,
+		},
+		{
+			name:    "Min longer than max",
+			input:   "golang:1:10m:1m",
+			wantErr: true,
+		},
+		{
+			name:    "Too many parts",
+			input:   "golang:1:1m:2m:3m",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := ParseSubredditSchedule(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, schedule)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+// SearchSort is what search results are ordered by
+type SearchSort string
+
+const (
+	SortSearchByRelevance SearchSort = "relevance"
+	SortSearchByNew       SearchSort = "new"
+	SortSearchByTop       SearchSort = "top"
+)
+
+// ParseSearchSort parses a search result order; an empty string orders by relevance
+func ParseSearchSort(s string) (SearchSort, bool) {
+	switch sort := SearchSort(s); sort {
+	case "":
+		return SortSearchByRelevance, true
+	case SortSearchByRelevance, SortSearchByNew, SortSearchByTop:
+		return sort, true
+	}
+	return "", false
+}
+
+// SearchResult is a post that matched a search. TitleHighlight and Snippet are HTML: the text is
+// escaped and the matched words are wrapped in <mark>.
+type SearchResult struct {
+	Post           Post   `json:"post"`
+	TitleHighlight string `json:"title_highlight"`
+	Snippet        string `json:"snippet"` // the part of the selftext around its first match
+}
+
+// SearchResults is one page of the posts that matched a search
+type SearchResults struct {
+	Query    string         `json:"query"`
+	Sort     SearchSort     `json:"sort"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int            `json:"total"`   // matches on every page
+	Indexed  bool           `json:"indexed"` // false when the store had no full-text index and scanned the posts
+	Results  []SearchResult `json:"results"`
+}
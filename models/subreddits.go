@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// TrackedSubreddit records a subreddit being added to or removed from the tracked set at runtime
+type TrackedSubreddit struct {
+	Name      string    `json:"name"`
+	Tracked   bool      `json:"tracked"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
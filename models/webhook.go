@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Webhook event types
+const (
+	EventPostMatched    = "post.matched"         // an alert rule fired for a post
+	EventScoreThreshold = "post.score_threshold" // a post's score reached one of the webhook's thresholds
+	EventCollectorError = "collector.error"      // a poll, refresh, listing snapshot or backfill failed
+)
+
+// Built-in payload templates
+const (
+	TemplateJSON  = "json"  // the event as JSON; the default
+	TemplateSlack = "slack" // a Slack incoming webhook message
+)
+
+// Webhook is an endpoint that events are POSTed to as JSON
+type Webhook struct {
+	Name            string   `json:"name"`
+	URL             string   `json:"url"`
+	Events          []string `json:"events"`                     // event types to send
+	Rules           []string `json:"rules,omitempty"`            // alert rules whose post.matched events are sent; empty sends every rule's
+	ScoreThresholds []int    `json:"score_thresholds,omitempty"` // scores that trigger post.score_threshold
+	Template        string   `json:"template,omitempty"`         // json, slack, or a Go text/template producing the body
+	Secret          string   `json:"secret,omitempty"`           // signs the body with HMAC-SHA256 when set
+	MaxAttempts     int      `json:"max_attempts,omitempty"`     // deliveries are given up after this many attempts; 0 uses the default
+}
+
+// Sends reports whether the webhook subscribes to an event type
+func (w Webhook) Sends(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a webhook's name, URL and events; custom templates are parsed by the dispatcher
+func (w Webhook) Validate() error {
+	if strings.TrimSpace(w.Name) == "" {
+		return fmt.Errorf("webhook needs a name")
+	}
+
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook %s needs an http or https url", w.Name)
+	}
+
+	if len(w.Events) == 0 {
+		return fmt.Errorf("webhook %s has no events", w.Name)
+	}
+	for _, event := range w.Events {
+		switch event {
+		case EventPostMatched, EventCollectorError:
+		case EventScoreThreshold:
+			if len(w.ScoreThresholds) == 0 {
+				return fmt.Errorf("webhook %s sends %s but has no score_thresholds", w.Name, event)
+			}
+		default:
+			return fmt.Errorf("webhook %s has an unknown event %q", w.Name, event)
+		}
+	}
+
+	if w.MaxAttempts < 0 {
+		return fmt.Errorf("webhook %s has a negative max_attempts", w.Name)
+	}
+	return nil
+}
+
+// ParseWebhooks parses a JSON array of webhooks and validates each one; names must be unique
+func ParseWebhooks(data []byte) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks: %w", err)
+	}
+
+	names := make(map[string]bool, len(webhooks))
+	for _, webhook := range webhooks {
+		if err := webhook.Validate(); err != nil {
+			return nil, err
+		}
+		if names[webhook.Name] {
+			return nil, fmt.Errorf("webhook %s is defined twice", webhook.Name)
+		}
+		names[webhook.Name] = true
+	}
+
+	return webhooks, nil
+}
+
+// WebhookEvent is something that happened in the collector; it's what payload templates render
+type WebhookEvent struct {
+	Type       string    `json:"type"`
+	Webhook    string    `json:"webhook"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Alert      *Alert    `json:"alert,omitempty"`     // post.matched
+	Post       *Post     `json:"post,omitempty"`      // post.score_threshold
+	Threshold  int       `json:"threshold,omitempty"` // post.score_threshold
+	Source     string    `json:"source,omitempty"`    // collector.error: what failed, e.g. poll/golang
+	Error      string    `json:"error,omitempty"`     // collector.error
+}
+
+// WebhookDeliveryStatus is the state of a delivery in the outbox
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+	DeliveryFailed    WebhookDeliveryStatus = "failed" // gave up after the last attempt or a permanent error
+)
+
+// WebhookDelivery is a rendered event waiting in, or sent from, the outbox
+type WebhookDelivery struct {
+	ID            int64                 `json:"id"`
+	Webhook       string                `json:"webhook"`
+	Event         string                `json:"event"`
+	DedupKey      string                `json:"dedup_key"` // an event with the same key is only queued once per webhook
+	Payload       string                `json:"payload"`
+	Status        WebhookDeliveryStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt time.Time             `json:"next_attempt_at"`
+	LastError     string                `json:"last_error,omitempty"`
+	CreatedAt     time.Time             `json:"created_at"`
+	DeliveredAt   time.Time             `json:"delivered_at,omitempty"`
+}
+
+// ScoreCrossing is a post's score rising past one of a webhook's thresholds
+type ScoreCrossing struct {
+	ID        int64     `json:"id"`
+	Webhook   string    `json:"webhook"`
+	PostID    string    `json:"post_id"`
+	Threshold int       `json:"threshold"`
+	Score     int       `json:"score"` // the score it was seen at after crossing
+	CrossedAt time.Time `json:"crossed_at"`
+}
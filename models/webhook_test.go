@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWebhooks(t *testing.T) {
+	webhooks, err := ParseWebhooks([]byte(`[
+		{"name": "slack", "url": "https://hooks.slack.com/services/x", "events": ["post.matched"], "template": "slack"},
+		{"name": "scores", "url": "http://localhost:9000/hook", "events": ["post.score_threshold"], "score_thresholds": [100]}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	assert.True(t, webhooks[0].Sends(EventPostMatched))
+	assert.False(t, webhooks[0].Sends(EventCollectorError))
+
+	tests := []struct {
+		name     string
+		webhooks string
+		want     string
+	}{
+		{"not json", `{`, "failed to parse webhooks"},
+		{"no name", `[{"url": "https://example.com", "events": ["collector.error"]}]`, "needs a name"},
+		{"bad url", `[{"name": "a", "url": "example.com", "events": ["collector.error"]}]`, "http or https url"},
+		{"no events", `[{"name": "a", "url": "https://example.com"}]`, "has no events"},
+		{"unknown event", `[{"name": "a", "url": "https://example.com", "events": ["post.deleted"]}]`, "unknown event"},
+		{"no thresholds", `[{"name": "a", "url": "https://example.com", "events": ["post.score_threshold"]}]`, "no score_thresholds"},
+		{"negative attempts", `[{"name": "a", "url": "https://example.com", "events": ["collector.error"], "max_attempts": -1}]`, "negative max_attempts"},
+		{"duplicate", `[{"name": "a", "url": "https://example.com", "events": ["collector.error"]},
+			{"name": "a", "url": "https://example.com", "events": ["collector.error"]}]`, "defined twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseWebhooks([]byte(tt.webhooks))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// Names of the built-in processors, as used in POST_PROCESSORS
+const (
+	NSFWFilterName      = "nsfw_filter"
+	DomainExtractorName = "domain"
+	JSONLSinkName       = "jsonl_sink"
+)
+
+// Settings configures the built-in processors
+type Settings struct {
+	JSONLPath string // file the JSONL sink appends to
+}
+
+// Build creates the chain of built-in processors with the given names, in order
+func Build(names []string, settings Settings) (Chain, error) {
+	chain := make(Chain, 0, len(names))
+	for _, name := range names {
+		var p PostProcessor
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case NSFWFilterName:
+			p = NSFWFilter()
+		case DomainExtractorName:
+			p = DomainExtractor()
+		case JSONLSinkName:
+			sink, err := NewJSONLSink(settings.JSONLPath)
+			if err != nil {
+				chain.Close()
+				return nil, err
+			}
+			p = sink
+		default:
+			chain.Close()
+			return nil, fmt.Errorf("unknown post processor %q", name)
+		}
+		chain = append(chain, p)
+	}
+	return chain, nil
+}
+
+// NSFWFilter drops posts marked over 18
+func NSFWFilter() PostProcessor {
+	return NewFilter(NSFWFilterName, func(post models.Post) bool { return !post.Over18 })
+}
+
+// DomainExtractor sets the domain of each post: the lowercased host of its URL without a www.
+// prefix or port, or self.<subreddit> for self posts, as reddit itself reports them
+func DomainExtractor() PostProcessor {
+	return NewEnricher(DomainExtractorName, func(post *models.Post) {
+		post.Domain = Domain(*post)
+	})
+}
+
+// Domain returns the domain a post links to; see DomainExtractor
+func Domain(post models.Post) string {
+	return post.LinkDomain()
+}
+
+// JSONLSink appends every post it sees to a file as one JSON object per line, and passes the posts on
+type JSONLSink struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// NewJSONLSink opens, or creates, the file at path for appending
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the JSONL sink needs a file path")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSONL sink: %w", err)
+	}
+	return &JSONLSink{file: file}, nil
+}
+
+// Name implements PostProcessor
+func (s *JSONLSink) Name() string { return JSONLSinkName }
+
+// Process writes posts as a single append, so that concurrent batches don't interleave lines
+func (s *JSONLSink) Process(_ context.Context, posts []models.Post) ([]models.Post, error) {
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	for _, post := range posts {
+		if err := enc.Encode(post); err != nil {
+			return nil, fmt.Errorf("failed to encode post %s: %w", post.ID, err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.file.WriteString(buf.String()); err != nil {
+		return nil, fmt.Errorf("failed to write posts: %w", err)
+	}
+	return posts, nil
+}
+
+// Close closes the sink's file
+func (s *JSONLSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,105 @@
+// Package pipeline runs fetched posts through an ordered chain of processors before they are
+// saved: filters drop posts, enrichers fill in derived fields and sinks publish posts elsewhere.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// PostProcessor processes one batch of posts and returns the posts to hand to the next processor
+type PostProcessor interface {
+	// Name identifies the processor in configuration and errors
+	Name() string
+	// Process returns the posts to keep, possibly modified; it must not modify the slice it's given
+	Process(ctx context.Context, posts []models.Post) ([]models.Post, error)
+}
+
+// Chain runs processors in order; it's empty, and passes posts through untouched, by default
+type Chain []PostProcessor
+
+// Process runs posts through every processor in turn, stopping early once none are left
+func (ch Chain) Process(ctx context.Context, posts []models.Post) ([]models.Post, error) {
+	for _, p := range ch {
+		if len(posts) == 0 {
+			break
+		}
+
+		var err error
+		posts, err = p.Process(ctx, posts)
+		if err != nil {
+			return nil, fmt.Errorf("post processor %s failed: %w", p.Name(), err)
+		}
+	}
+	return posts, nil
+}
+
+// Names returns the names of the processors in order
+func (ch Chain) Names() []string {
+	names := make([]string, 0, len(ch))
+	for _, p := range ch {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Close closes every processor that holds resources, such as a sink's file
+func (ch Chain) Close() error {
+	var first error
+	for _, p := range ch {
+		if closer, ok := p.(io.Closer); ok {
+			if err := closer.Close(); err != nil && first == nil {
+				first = fmt.Errorf("failed to close post processor %s: %w", p.Name(), err)
+			}
+		}
+	}
+	return first
+}
+
+// filter drops the posts keep returns false for
+type filter struct {
+	name string
+	keep func(models.Post) bool
+}
+
+// NewFilter returns a processor that keeps only the posts keep returns true for
+func NewFilter(name string, keep func(models.Post) bool) PostProcessor {
+	return &filter{name: name, keep: keep}
+}
+
+func (f *filter) Name() string { return f.name }
+
+func (f *filter) Process(_ context.Context, posts []models.Post) ([]models.Post, error) {
+	kept := make([]models.Post, 0, len(posts))
+	for _, post := range posts {
+		if f.keep(post) {
+			kept = append(kept, post)
+		}
+	}
+	return kept, nil
+}
+
+// enricher sets derived fields on every post
+type enricher struct {
+	name   string
+	enrich func(*models.Post)
+}
+
+// NewEnricher returns a processor that calls enrich on a copy of every post
+func NewEnricher(name string, enrich func(*models.Post)) PostProcessor {
+	return &enricher{name: name, enrich: enrich}
+}
+
+func (e *enricher) Name() string { return e.name }
+
+func (e *enricher) Process(_ context.Context, posts []models.Post) ([]models.Post, error) {
+	enriched := make([]models.Post, len(posts))
+	copy(enriched, posts)
+	for i := range enriched {
+		e.enrich(&enriched[i])
+	}
+	return enriched, nil
+}
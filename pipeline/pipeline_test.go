@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func TestDomain(t *testing.T) {
+	tests := []struct {
+		name string
+		post models.Post
+		want string
+	}{
+		{"link", models.Post{URL: "https://github.com/golang/go"}, "github.com"},
+		{"www and port", models.Post{URL: "http://WWW.Example.com:8080/a?b=c"}, "example.com"},
+		{"subdomain", models.Post{URL: "https://i.redd.it/abc.png"}, "i.redd.it"},
+		{"self post", models.Post{IsSelf: true, Subreddit: "golang", URL: "https://www.reddit.com/r/golang/comments/x"}, "self.golang"},
+		{"no url", models.Post{}, ""},
+		{"not a url", models.Post{URL: "::nonsense"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Domain(tt.post))
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	posts := []models.Post{
+		{ID: "a", URL: "https://github.com/x"},
+		{ID: "b", URL: "https://example.com/nsfw", Over18: true},
+		{ID: "c", IsSelf: true, Subreddit: "golang"},
+	}
+
+	path := filepath.Join(t.TempDir(), "posts.jsonl")
+	chain, err := Build([]string{"nsfw_filter", "domain", "jsonl_sink"}, Settings{JSONLPath: path})
+	require.NoError(t, err)
+	assert.Equal(t, []string{NSFWFilterName, DomainExtractorName, JSONLSinkName}, chain.Names())
+
+	out, err := chain.Process(context.Background(), posts)
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+	assert.Equal(t, "github.com", out[0].Domain)
+	assert.Equal(t, "self.golang", out[1].Domain)
+	assert.Empty(t, posts[0].Domain, "processors must not modify their input")
+
+	// a second batch is appended to the same file
+	_, err = chain.Process(context.Background(), []models.Post{{ID: "d"}})
+	require.NoError(t, err)
+	require.NoError(t, chain.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var post models.Post
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &post))
+		ids = append(ids, post.ID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"a", "c", "d"}, ids)
+}
+
+func TestChainStopsWhenEmpty(t *testing.T) {
+	calls := 0
+	chain := Chain{
+		NewFilter("drop_all", func(models.Post) bool { return false }),
+		NewEnricher("count", func(*models.Post) { calls++ }),
+	}
+
+	out, err := chain.Process(context.Background(), []models.Post{{ID: "a"}})
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	assert.Zero(t, calls)
+}
+
+type failing struct{}
+
+func (failing) Name() string { return "failing" }
+
+func (failing) Process(context.Context, []models.Post) ([]models.Post, error) {
+	return nil, errors.New("boom")
+}
+
+func TestChainError(t *testing.T) {
+	_, err := Chain{failing{}}.Process(context.Background(), []models.Post{{ID: "a"}})
+	assert.ErrorContains(t, err, "post processor failing failed: boom")
+}
+
+func TestBuildUnknown(t *testing.T) {
+	_, err := Build([]string{"domain", "nope"}, Settings{})
+	assert.ErrorContains(t, err, `unknown post processor "nope"`)
+}
@@ -0,0 +1,182 @@
+package stats
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// alertRule is an alert rule with its patterns compiled and its lists turned into lookups
+type alertRule struct {
+	models.AlertRule
+	subreddits map[string]bool // lowercased
+	authors    map[string]bool // lowercased
+	pattern    *regexp.Regexp
+	keywords   *regexp.Regexp // any keyword, as a whole word, case-insensitive
+}
+
+// WithAlerts evaluates rules against every post that is saved, whether it's new or refreshed. A rule
+// that doesn't compile is logged and skipped; rules from the configuration are validated already.
+func WithAlerts(rules []models.AlertRule) Option {
+	return func(c *Collector) {
+		c.alertRules = c.alertRules[:0]
+		for _, rule := range rules {
+			compiled, err := compileAlertRule(rule)
+			if err != nil {
+				c.log.WithError(err).WithField("rule", rule.Name).Error("Skipping invalid alert rule")
+				continue
+			}
+			c.alertRules = append(c.alertRules, compiled)
+		}
+	}
+}
+
+func compileAlertRule(rule models.AlertRule) (*alertRule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	compiled := &alertRule{AlertRule: rule, subreddits: lowerSet(rule.Subreddits), authors: lowerSet(rule.Authors)}
+	if rule.Pattern != "" {
+		compiled.pattern = regexp.MustCompile(rule.Pattern) // Validate compiled it already
+	}
+
+	if len(rule.Keywords) > 0 {
+		alternatives := make([]string, 0, len(rule.Keywords))
+		for _, keyword := range rule.Keywords {
+			alternatives = append(alternatives, keywordPattern(strings.TrimSpace(keyword)))
+		}
+		compiled.keywords = regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+	}
+
+	return compiled, nil
+}
+
+// keywordPattern matches keyword literally, as a whole word: "go" doesn't match "google", while
+// "c++" still matches in "I like c++."
+func keywordPattern(keyword string) string {
+	pattern := regexp.QuoteMeta(keyword)
+	if first, _ := utf8.DecodeRuneInString(keyword); isWordRune(first) {
+		pattern = `\b` + pattern
+	}
+	if last, _ := utf8.DecodeLastRuneInString(keyword); isWordRune(last) {
+		pattern += `\b`
+	}
+	return pattern
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lowerSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[strings.ToLower(strings.TrimSpace(item))] = true
+	}
+	return set
+}
+
+// match reports whether post meets every condition of the rule, along with the text the pattern or
+// a keyword matched
+func (r *alertRule) match(post models.Post) (string, bool) {
+	if len(r.subreddits) > 0 && !r.subreddits[strings.ToLower(post.Subreddit)] {
+		return "", false
+	}
+	if len(r.authors) > 0 && !r.authors[strings.ToLower(post.Author)] {
+		return "", false
+	}
+	if post.Score < r.MinScore || post.NumComments < r.MinComments {
+		return "", false
+	}
+
+	text := post.Title + "\n" + post.SelfText
+	matched := ""
+	if r.pattern != nil {
+		loc := r.pattern.FindStringIndex(text)
+		if loc == nil {
+			return "", false
+		}
+		matched = text[loc[0]:loc[1]]
+	}
+	if r.keywords != nil {
+		loc := r.keywords.FindStringIndex(text)
+		if loc == nil {
+			return "", false
+		}
+		matched = text[loc[0]:loc[1]]
+	}
+
+	return matched, true
+}
+
+// AlertRules returns the alert rules being evaluated
+func (c *Collector) AlertRules() []models.AlertRule {
+	rules := make([]models.AlertRule, 0, len(c.alertRules))
+	for _, rule := range c.alertRules {
+		rules = append(rules, rule.AlertRule)
+	}
+	return rules
+}
+
+// evaluateAlerts checks saved posts against the alert rules and records the firings. A rule fires
+// once per post, so a post that's refreshed again and again doesn't repeat its alerts, but a post
+// that crosses a threshold on a later refresh does fire then.
+func (c *Collector) evaluateAlerts(posts []models.Post) error {
+	if len(c.alertRules) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var matches []models.Alert
+	for _, post := range posts {
+		for _, rule := range c.alertRules {
+			matched, ok := rule.match(post)
+			if !ok {
+				continue
+			}
+			matches = append(matches, models.Alert{
+				Rule:        rule.Name,
+				PostID:      post.ID,
+				Subreddit:   post.Subreddit,
+				Title:       post.Title,
+				Author:      post.Author,
+				Permalink:   post.Permalink,
+				Score:       post.Score,
+				NumComments: post.NumComments,
+				Matched:     matched,
+				FiredAt:     now,
+			})
+		}
+	}
+
+	fired, err := c.database.SaveAlerts(matches)
+	if err != nil {
+		return err
+	}
+
+	if c.notifier != nil && len(fired) > 0 {
+		c.notifier.AlertsFired(fired)
+	}
+
+	for _, alert := range fired {
+		c.log.WithFields(logrus.Fields{
+			"rule":         alert.Rule,
+			"post_id":      alert.PostID,
+			"subreddit":    alert.Subreddit,
+			"title":        alert.Title,
+			"score":        alert.Score,
+			"num_comments": alert.NumComments,
+			"matched":      alert.Matched,
+			"permalink":    alert.Permalink,
+		}).Warn("Alert fired")
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func TestAlertRuleMatch(t *testing.T) {
+	post := models.Post{
+		Subreddit:   "golang",
+		Author:      "Alice",
+		Title:       "Reddit Tracker 2.0 released",
+		SelfText:    "Now with C++ bindings",
+		Score:       120,
+		NumComments: 8,
+	}
+
+	tests := []struct {
+		name    string
+		rule    models.AlertRule
+		matched string
+		ok      bool
+	}{
+		{"keyword", models.AlertRule{Keywords: []string{"reddit tracker"}}, "Reddit Tracker", true},
+		{"keyword in selftext", models.AlertRule{Keywords: []string{"nothing", "c++"}}, "C++", true},
+		{"keyword is a whole word", models.AlertRule{Keywords: []string{"release"}}, "", false},
+		{"pattern", models.AlertRule{Pattern: `\d+\.\d+`}, "2.0", true},
+		{"pattern is case-sensitive", models.AlertRule{Pattern: `reddit`}, "", false},
+		{"pattern and keywords", models.AlertRule{Pattern: `(?i)tracker`, Keywords: []string{"missing"}}, "", false},
+		{"subreddit", models.AlertRule{Subreddits: []string{"GoLang"}}, "", true},
+		{"other subreddit", models.AlertRule{Subreddits: []string{"rust"}, Keywords: []string{"tracker"}}, "", false},
+		{"author", models.AlertRule{Authors: []string{"alice"}}, "", true},
+		{"other author", models.AlertRule{Authors: []string{"bob"}}, "", false},
+		{"thresholds met", models.AlertRule{MinScore: 100, MinComments: 8}, "", true},
+		{"score below threshold", models.AlertRule{MinScore: 121}, "", false},
+		{"comments below threshold", models.AlertRule{MinComments: 9}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			rule, err := compileAlertRule(tt.rule)
+			require.NoError(t, err)
+
+			matched, ok := rule.match(post)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.matched, matched)
+		})
+	}
+}
+
+func TestProcessPostsFiresAlerts(t *testing.T) {
+	c, _, database := newTestCollector(t, []string{"golang"}, WithAlerts([]models.AlertRule{
+		{Name: "mentions", Keywords: []string{"tracker"}},
+		{Name: "popular", MinScore: 100},
+		{Name: "invalid", Pattern: "("},
+	}))
+	assert.Len(t, c.AlertRules(), 2, "the invalid rule is skipped")
+
+	post := models.Post{ID: "p1", Title: "a tracker", Author: "alice", Subreddit: "golang", Score: 10, CreatedUTC: 1700000000}
+	require.NoError(t, c.processPosts(context.Background(), []models.Post{post}))
+
+	alerts, err := database.GetAlerts(models.AlertFilter{})
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "mentions", alerts[0].Rule)
+	assert.Equal(t, "tracker", alerts[0].Matched)
+
+	// a refresh doesn't repeat the mention, but the post crossing the score threshold fires
+	post.Score = 150
+	require.NoError(t, c.processPosts(context.Background(), []models.Post{post}))
+	require.NoError(t, c.processPosts(context.Background(), []models.Post{post}))
+
+	alerts, err = database.GetAlerts(models.AlertFilter{})
+	require.NoError(t, err)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "popular", alerts[0].Rule)
+	assert.Equal(t, 150, alerts[0].Score)
+}
+
+// recordingNotifier records what the collector tells it
+type recordingNotifier struct {
+	mutex  sync.Mutex
+	alerts []models.Alert
+	posts  []models.Post
+	errors []string
+}
+
+func (n *recordingNotifier) AlertsFired(alerts []models.Alert) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.alerts = append(n.alerts, alerts...)
+}
+
+func (n *recordingNotifier) PostsSaved(posts []models.Post) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.posts = append(n.posts, posts...)
+}
+
+func (n *recordingNotifier) CollectorError(source string, err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.errors = append(n.errors, source)
+}
+
+func TestNotifier(t *testing.T) {
+	notifier := &recordingNotifier{}
+	c, _, _ := newTestCollector(t, []string{"golang"},
+		WithAlerts([]models.AlertRule{{Name: "mentions", Keywords: []string{"tracker"}}}),
+		WithNotifier(notifier),
+	)
+
+	post := models.Post{ID: "p1", Title: "a tracker", Author: "alice", Subreddit: "golang", CreatedUTC: 1700000000}
+	require.NoError(t, c.processPosts(context.Background(), []models.Post{post}))
+	require.NoError(t, c.processPosts(context.Background(), []models.Post{post}))
+
+	assert.Len(t, notifier.posts, 2)
+	require.Len(t, notifier.alerts, 1, "an alert is only passed on when it first fires")
+
+	backfilled := models.Post{ID: "p0", Title: "old news", Author: "bob", Subreddit: "golang", CreatedUTC: 1600000000}
+	require.NoError(t, c.processBackfilledPosts(context.Background(), []models.Post{backfilled}))
+	assert.Len(t, notifier.posts, 2, "backfilled posts aren't passed on")
+	assert.Equal(t, "p1", notifier.alerts[0].PostID)
+
+	c.reportError("poll/golang", errors.New("boom"))
+	assert.Equal(t, []string{"poll/golang"}, notifier.errors)
+}
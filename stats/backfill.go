@@ -0,0 +1,252 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// DefaultBackfillMaxPages caps jobs that don't set their own page limit
+const DefaultBackfillMaxPages = 50
+
+// WithBackfill works through queued backfill jobs in the background, spending at most share (0-1)
+// of the API request rate on them. Jobs without their own page limit stop after maxPages pages.
+// When newSubredditHistory is positive, a subreddit polled for the first time gets a backfill
+// job for that much of its history.
+func WithBackfill(share float64, maxPages int, newSubredditHistory time.Duration) Option {
+	return func(c *Collector) {
+		c.backfillShare = share
+		if maxPages > 0 {
+			c.backfillMaxPages = maxPages
+		}
+		c.backfillHistory = newSubredditHistory
+	}
+}
+
+// minBackfillLease is how long a claimed backfill job is held without a checkpoint on top of the
+// pause between its pages; a worker that stops for longer loses the job to another
+const minBackfillLease = 5 * time.Minute
+
+// runBackfill claims a backfill job and fetches one page of it at a time until it's finished, then
+// claims the next, until ctx is done
+func (c *Collector) runBackfill(ctx context.Context) {
+	c.log.WithFields(logrus.Fields{
+		"budget_share": c.backfillShare,
+		"max_pages":    c.backfillMaxPages,
+	}).Info("Backfill configured")
+
+	var job *models.BackfillJob
+	for {
+		select {
+		case <-ctx.Done():
+			c.releaseBackfill(job)
+			return
+		case <-time.After(c.budgetInterval(c.backfillShare)):
+			if job == nil {
+				claimed, err := c.database.ClaimNextBackfillJob(time.Now(), c.backfillLease())
+				if errors.Is(err, db.ErrNoBackfillJobs) {
+					continue
+				}
+				if err != nil {
+					c.log.WithError(err).Error("Failed to claim next backfill job")
+					continue
+				}
+				job = claimed
+			}
+
+			err := c.backfillPage(ctx, job)
+			if err != nil && ctx.Err() == nil {
+				c.log.WithError(err).WithField("job_id", job.ID).Error("Backfill page failed")
+				c.reportError("backfill/"+job.Subreddit, err)
+			}
+			if errors.Is(err, db.ErrBackfillLeaseLost) || backfillFinished(job) {
+				job = nil
+			}
+		}
+	}
+}
+
+// backfillLease is how long a job is claimed for, renewed with every page
+func (c *Collector) backfillLease() time.Duration {
+	return minBackfillLease + 2*c.budgetInterval(c.backfillShare)
+}
+
+// releaseBackfill hands an unfinished job back, so any worker can claim it right away rather than
+// when its lease runs out
+func (c *Collector) releaseBackfill(job *models.BackfillJob) {
+	if job == nil || backfillFinished(job) {
+		return
+	}
+	if err := c.database.UpdateBackfillJob(job, 0); err != nil {
+		c.log.WithError(err).WithField("job_id", job.ID).Warn("Failed to release backfill job")
+	}
+}
+
+func backfillFinished(job *models.BackfillJob) bool {
+	return job.Status == models.BackfillDone || job.Status == models.BackfillFailed
+}
+
+// RunBackfill claims a backfill job, so no collector works on it meanwhile, and works through it
+// until it's finished, spending the same share of the request rate as the collector's backfills,
+// or all of it when that share is 0. It fails with db.ErrBackfillJobHeld when the job is finished
+// or another worker holds it. An interrupted job is released, to be resumed by any worker.
+func (c *Collector) RunBackfill(ctx context.Context, job *models.BackfillJob) error {
+	claimed, err := c.database.ClaimBackfillJob(job.ID, time.Now(), c.backfillLease())
+	if err != nil {
+		return err
+	}
+	*job = *claimed
+
+	var pause time.Duration
+	if c.backfillShare > 0 {
+		pause = c.budgetInterval(c.backfillShare)
+	}
+	for !backfillFinished(job) {
+		if err := c.backfillPage(ctx, job); err != nil {
+			if !errors.Is(err, db.ErrBackfillLeaseLost) {
+				c.releaseBackfill(job)
+			}
+			return err
+		}
+		if backfillFinished(job) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			c.releaseBackfill(job)
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+
+	if job.Status == models.BackfillFailed {
+		return fmt.Errorf("backfill of %s failed: %s", job.Subreddit, job.Error)
+	}
+	return nil
+}
+
+// scheduleNewSubredditBackfill queues a backfill of a newly tracked subreddit's recent history
+func (c *Collector) scheduleNewSubredditBackfill(sr string) {
+	if c.backfillShare <= 0 || c.backfillHistory <= 0 {
+		return
+	}
+
+	now := time.Now()
+	job := models.BackfillJob{
+		Subreddit: sr,
+		Since:     now.Add(-c.backfillHistory),
+		Until:     now,
+		Reason:    "new subreddit",
+	}
+	if err := c.database.ScheduleBackfill(&job); err != nil {
+		c.log.WithError(err).WithField("subreddit", sr).Error("Failed to schedule backfill of new subreddit")
+		return
+	}
+
+	c.log.WithFields(logrus.Fields{
+		"subreddit": sr,
+		"since":     job.Since.Format(time.RFC3339),
+		"job_id":    job.ID,
+	}).Info("Scheduled backfill of new subreddit")
+}
+
+// backfillPage fetches the next page of a backfill job, saves the posts created within its range
+// and checkpoints the job. Jobs walk the new listing's after cursors first; reddit listings stop
+// after ~1000 posts, so when the listing runs out before the cutoff the job switches to a search
+// by timestamp for the rest of the range.
+func (c *Collector) backfillPage(ctx context.Context, job *models.BackfillJob) error {
+	if job.Source == "" {
+		job.Source = models.BackfillFromListing
+	}
+	job.Status = models.BackfillRunning
+
+	var posts []models.Post
+	var next string
+	var err error
+	switch job.Source {
+	case models.BackfillFromSearch:
+		// the listing already covered everything newer than the oldest post it reached
+		until := job.Until
+		if !job.Oldest.IsZero() && job.Oldest.Before(until) {
+			until = job.Oldest
+		}
+		posts, next, err = c.redditAPI.SearchPostsBetween(ctx, job.Subreddit, job.Since, until, newPostsPageSize, job.Cursor)
+	default:
+		posts, next, err = c.redditAPI.FetchPosts(ctx, job.Subreddit, newPostsPageSize, job.Cursor)
+	}
+	if err != nil {
+		// a banned, private or missing subreddit won't get better by retrying
+		if errors.Is(err, api.ErrNotFound) || errors.Is(err, api.ErrForbidden) {
+			job.Status = models.BackfillFailed
+			job.Error = err.Error()
+			return c.database.UpdateBackfillJob(job, c.backfillLease())
+		}
+		return fmt.Errorf("failed to fetch backfill page of %s: %w", job.Subreddit, err)
+	}
+	job.Pages++
+
+	inRange := make([]models.Post, 0, len(posts))
+	var oldest time.Time
+	for _, post := range posts {
+		created := time.Unix(int64(post.CreatedUTC), 0)
+		if oldest.IsZero() || created.Before(oldest) {
+			oldest = created
+		}
+		if !created.Before(job.Since) && !created.After(job.Until) {
+			inRange = append(inRange, post)
+		}
+	}
+
+	if err := c.processBackfilledPosts(ctx, inRange); err != nil {
+		return fmt.Errorf("failed to process backfill page of %s: %w", job.Subreddit, err)
+	}
+	job.Posts += len(inRange)
+
+	if job.Source == models.BackfillFromListing && !oldest.IsZero() {
+		job.Oldest = oldest
+	}
+
+	reachedCutoff := !oldest.IsZero() && oldest.Before(job.Since)
+	switch {
+	case reachedCutoff || (job.Source == models.BackfillFromSearch && next == ""):
+		job.Status = models.BackfillDone
+	case next == "":
+		// the listing ran out before the cutoff
+		job.Source = models.BackfillFromSearch
+		job.Cursor = ""
+	default:
+		job.Cursor = next
+	}
+
+	maxPages := job.MaxPages
+	if maxPages <= 0 {
+		maxPages = c.backfillMaxPages
+	}
+	if job.Status != models.BackfillDone && job.Pages >= maxPages {
+		job.Status = models.BackfillDone
+		job.Error = fmt.Sprintf("stopped at the page limit of %d before reaching the cutoff", maxPages)
+	}
+
+	if err := c.database.UpdateBackfillJob(job, c.backfillLease()); err != nil {
+		return err
+	}
+
+	c.log.WithFields(logrus.Fields{
+		"job_id":    job.ID,
+		"subreddit": job.Subreddit,
+		"source":    job.Source,
+		"pages":     job.Pages,
+		"posts":     job.Posts,
+		"status":    job.Status,
+	}).Info("Backfill page processed")
+
+	return nil
+}
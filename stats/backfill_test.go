@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/utils"
+)
+
+func TestBackfillFallsBackToSearch(t *testing.T) {
+	c, fake, database := newTestCollector(t, []string{"golang"}, WithBackfill(0.5, 10, 0))
+	ctx := context.Background()
+
+	// a post a minute for the last five hours; the listing only reaches back 150 posts
+	now := time.Now().Truncate(time.Second)
+	for minute := 0; minute < 300; minute++ {
+		id := utils.FormatBase36ID(uint64(200000 - minute))
+		fake.AddPosts("golang", models.Post{ID: id, Title: id, Author: "alice",
+			CreatedUTC: float64(now.Add(-time.Duration(minute) * time.Minute).Unix())})
+	}
+	fake.SetListingDepth(150)
+
+	job := models.BackfillJob{Subreddit: "golang", Since: now.Add(-4 * time.Hour), Until: now, Reason: "test"}
+	require.NoError(t, database.ScheduleBackfill(&job))
+
+	// the first page is checkpointed, so a restart picks the job up where it left off; until the
+	// job is released or its lease runs out, no other worker can claim it
+	claimed, err := database.ClaimNextBackfillJob(time.Now(), time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, c.backfillPage(ctx, claimed))
+	_, err = database.ClaimNextBackfillJob(time.Now(), time.Minute)
+	assert.ErrorIs(t, err, db.ErrNoBackfillJobs)
+	assert.ErrorIs(t, c.RunBackfill(ctx, &job), db.ErrBackfillJobHeld)
+
+	c.releaseBackfill(claimed)
+	resumed := &models.BackfillJob{ID: job.ID}
+	require.NoError(t, c.RunBackfill(ctx, resumed))
+	assert.Equal(t, models.BackfillDone, resumed.Status)
+	assert.Equal(t, models.BackfillFromSearch, resumed.Source)
+	assert.Empty(t, resumed.Error)
+	assert.Equal(t, 1, fake.RequestCount("/r/golang/search.json"))
+	assert.Equal(t, 2, fake.RequestCount("/r/golang/new.json"), "resumed after the checkpointed page")
+
+	// every post from the last four hours was saved
+	total, err := database.GetTotalPosts()
+	require.NoError(t, err)
+	assert.Equal(t, 241, total)
+
+	jobs, err := database.GetBackfillJobs(models.BackfillDone)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, resumed.Pages, jobs[0].Pages)
+}
+
+func TestBackfillStopsAtPageLimit(t *testing.T) {
+	c, fake, database := newTestCollector(t, []string{"golang"}, WithBackfill(0.5, 2, 0))
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Second)
+	for minute := 0; minute < 500; minute++ {
+		id := utils.FormatBase36ID(uint64(200000 - minute))
+		fake.AddPosts("golang", models.Post{ID: id, Title: id, Author: "alice",
+			CreatedUTC: float64(now.Add(-time.Duration(minute) * time.Minute).Unix())})
+	}
+
+	job := models.BackfillJob{Subreddit: "golang", Since: now.Add(-24 * time.Hour), Until: now, Reason: "test"}
+	require.NoError(t, database.ScheduleBackfill(&job))
+	require.NoError(t, c.RunBackfill(ctx, &job))
+
+	assert.Equal(t, models.BackfillDone, job.Status)
+	assert.Equal(t, 2, job.Pages)
+	assert.Contains(t, job.Error, "page limit")
+
+	total, err := database.GetTotalPosts()
+	require.NoError(t, err)
+	assert.Equal(t, 200, total)
+}
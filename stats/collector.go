@@ -3,7 +3,6 @@ package stats
 import (
 	"context"
 	"fmt"
-	"math"
 	"sync"
 	"time"
 
@@ -12,334 +11,488 @@ import (
 	"github.com/brettboylen/reddit-tracker/api"
 	"github.com/brettboylen/reddit-tracker/db"
 	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/pipeline"
+	"github.com/brettboylen/reddit-tracker/utils"
 )
 
 const (
 	defaultTopPostsLimit = 10
 	defaultTopUsersLimit = 10
+	newPostsPageSize     = 100
+	maxCatchUpPages      = 10 // reddit listings only go back ~1000 posts anyway
+	pollLogRetention     = 30 * 24 * time.Hour
 )
 
+// DefaultWriteWorkers is the number of post batches processed at once unless set otherwise
+const DefaultWriteWorkers = 2
+
 // Collector collects and analyzes Reddit posts
 type Collector struct {
 	redditAPI          *api.RedditAPI
-	database           *db.Database
+	database           db.Store
 	subreddits         []string
-	paginationKeys     map[string]string
-	pollingInterval    time.Duration
+	subredditsMutex    sync.Mutex        // serializes runtime subreddit changes with their persistence
+	subredditsChanged  chan struct{}     // signals the polling loop to rebalance the request rate
+	frontiers          map[string]string // id of the newest post seen per subreddit
+	pollLogPrunedAt    time.Time
+	pollingInterval    time.Duration // interval of a subreddit until the polling budget is first split
+	polls              *pollScheduler
+	writeWorkers       chan struct{}  // one slot per batch that may be processed at once
+	processors         pipeline.Chain // run on every batch before it's saved
+	alertRules         []*alertRule   // evaluated on every batch after it's saved
+	notifier           Notifier       // told about alerts, saved posts and errors; may be nil
 	topPostsLimit      int
 	topUsersLimit      int
 	stats              models.Statistics
+	engine             *statsEngine
 	log                *logrus.Logger
 	mutex              sync.RWMutex
 	processedPostCount int
+
+	// comment refresh settings; disabled when commentInterval is 0
+	commentInterval     time.Duration
+	commentPostsPerRun  int
+	commentMaxPostAge   time.Duration
+	commentCountsAtLast map[string]int // num_comments of each post when its comments were last fetched
+
+	// listing snapshot settings; disabled when there are no feeds
+	listingFeeds    []models.Feed
+	listingInterval time.Duration
+
+	// score refresh settings; disabled when refreshShare is 0
+	refreshShare    float64
+	refreshSchedule RefreshSchedule
+
+	// backfill settings; disabled when backfillShare is 0
+	backfillShare    float64
+	backfillMaxPages int
+	backfillHistory  time.Duration // history to backfill for newly tracked subreddits
+
+	// trending detection; observations are kept in memory for the longest window
+	trends        *trendTracker
+	trendingLimit int
+}
+
+// Option configures optional Collector settings
+type Option func(*Collector)
+
+// WithCommentRefresh enables periodic comment fetching. Every interval, the comments of up to
+// postsPerRun posts younger than maxPostAge whose comment count changed are fetched and stored.
+func WithCommentRefresh(interval time.Duration, postsPerRun int, maxPostAge time.Duration) Option {
+	return func(c *Collector) {
+		c.commentInterval = interval
+		c.commentPostsPerRun = postsPerRun
+		c.commentMaxPostAge = maxPostAge
+	}
+}
+
+// WithWriteWorkers sets how many batches of posts are processed at once. Writes to the database are
+// serialized anyway; more workers overlap one batch's write with another's statistics update.
+func WithWriteWorkers(n int) Option {
+	return func(c *Collector) {
+		if n > 0 {
+			c.writeWorkers = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithProcessors runs every batch of fetched posts through chain before it's saved. Posts the
+// chain drops are neither saved nor counted, though they still advance the subreddit's frontier.
+func WithProcessors(chain pipeline.Chain) Option {
+	return func(c *Collector) {
+		c.processors = chain
+	}
 }
 
 // NewCollector creates a new collector
 func NewCollector(
 	redditAPI *api.RedditAPI,
-	database *db.Database,
+	database db.Store,
 	subreddits []string,
 	pollingInterval int,
 	log *logrus.Logger,
+	opts ...Option,
 ) *Collector {
-	return &Collector{
-		redditAPI:       redditAPI,
-		database:        database,
-		subreddits:      subreddits,
-		paginationKeys:  make(map[string]string),
-		pollingInterval: time.Duration(pollingInterval) * time.Second,
-		topPostsLimit:   defaultTopPostsLimit,
-		topUsersLimit:   defaultTopUsersLimit,
+	c := &Collector{
+		redditAPI:         redditAPI,
+		database:          database,
+		subreddits:        append([]string(nil), subreddits...),
+		subredditsChanged: make(chan struct{}, 1),
+		frontiers:         make(map[string]string),
+		pollingInterval:   time.Duration(pollingInterval) * time.Second,
+		topPostsLimit:     defaultTopPostsLimit,
+		topUsersLimit:     defaultTopUsersLimit,
 		stats: models.Statistics{
 			TopPostsByUpvotes:   make([]models.Post, 0, defaultTopPostsLimit),
-			TopUsersByPostCount: make(map[string]int),
+			TopUsersByPostCount: make([]models.UserPostCount, 0, defaultTopUsersLimit),
 			StartTime:           time.Now(),
 			LastUpdated:         time.Now(),
 			SubredditStats:      make(map[string]models.SubredditStats),
 		},
-		log: log,
+		log:                 log,
+		commentCountsAtLast: make(map[string]int),
+		polls:               newPollScheduler(nil, DefaultMinPollInterval, DefaultMaxPollInterval, false),
+		writeWorkers:        make(chan struct{}, DefaultWriteWorkers),
+		trends:              newTrendTracker(DefaultTrendingWindows),
+		trendingLimit:       defaultTrendingLimit,
+		backfillMaxPages:    DefaultBackfillMaxPages,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.engine = newStatsEngine(c.topPostsLimit, c.topUsersLimit, database.GetPost)
+
+	return c
+}
+
+// warmStatistics loads the posts already in the database into the statistics engine
+func (c *Collector) warmStatistics() error {
+	start := time.Now()
+	summaries, err := c.database.GetPostSummaries()
+	if err != nil {
+		return err
 	}
+
+	c.engine.warm(summaries)
+	c.updateStatistics()
+
+	c.log.WithFields(logrus.Fields{
+		"posts":       len(summaries),
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Info("Statistics warmed from the database")
+
+	return nil
 }
 
 // Start func starts collecting posts from Reddit
 func (c *Collector) Start(ctx context.Context) error {
-	ticker := time.NewTicker(c.pollingInterval)
-	defer ticker.Stop()
+	if err := c.loadSubreddits(); err != nil {
+		return fmt.Errorf("failed to load subreddits: %w", err)
+	}
 
-	if err := c.fetchAndProcessPosts(ctx); err != nil {
-		c.log.WithError(err).Error("Failed to fetch and process posts")
+	if err := c.warmStatistics(); err != nil {
+		return fmt.Errorf("failed to warm statistics: %w", err)
 	}
 
+	// each subreddit is polled on its own schedule; see schedule.go
+	var polls sync.WaitGroup
+	defer polls.Wait()
+
+	c.rebalancePolling(true)
+	c.pollDue(ctx, &polls)
+
+	pollTicker := time.NewTicker(schedulerTick)
+	defer pollTicker.Stop()
+
+	rebalanceTicker := time.NewTicker(rebalanceInterval)
+	defer rebalanceTicker.Stop()
+
 	statsTicker := time.NewTicker(10 * time.Second)
 	defer statsTicker.Stop()
 	
-	resetInterval := 5 * time.Minute
-	c.log.WithFields(logrus.Fields{
-		"reset_interval_minutes": resetInterval.Minutes(),
-	}).Info("Pagination reset interval configured")
-	
-	// reset paginatin key every 5 minutes to check for new posts
-	resetTicker := time.NewTicker(resetInterval)
-	defer resetTicker.Stop()
+	if c.commentInterval > 0 {
+		go c.runCommentRefresh(ctx)
+	}
 
-	// channel to receive signals to adjust the polling interval
-	adjustTicker := make(chan time.Duration, 1)
-	
-	// adjust the polling interval based on rate limits, if needed
-	go func() {
-		adjustmentTicker := time.NewTicker(10 * time.Second)
-		defer adjustmentTicker.Stop()
-		
-		// track the last reset value to detect transitions between rate limit periods
-		lastResetValue := 600
-		
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-adjustmentTicker.C:
-				_, reset, used := c.redditAPI.GetRateLimitStatus()
-				
-				// attempt to detect if we've crossed into a new reset period
-				// (when reset suddenly jumps back up from a low number)
-				if reset > lastResetValue && lastResetValue < 100 {
-					c.log.Info("Detected new rate limit period, values have reset")
-				}
-				lastResetValue = reset
-				
-				var newInterval time.Duration
-				numSubreddits := len(c.subreddits)
-				
-				// Reddit allocates 1000 requests in a 600-second period
-				totalAllowedRequests := 1000
-				totalPeriodSeconds := 600
-				
-				// lets aim for 95% utilization of the rate limit
-				standardRate := (float64(totalAllowedRequests) / float64(totalPeriodSeconds)) * 0.95
-				
-				// for multiple subreddits, divide the rate among them
-				if numSubreddits > 1 {
-					standardRate = standardRate / float64(numSubreddits)
-				}
-				
-				secondsPerRequest := 1.0 / standardRate
-				newInterval = time.Duration(secondsPerRequest * float64(time.Second))
-				
-				if c.pollingInterval == 0 || 
-				   math.Abs(float64(newInterval-c.pollingInterval)) > float64(c.pollingInterval/4) {
-					logFields := logrus.Fields{
-						"old_interval_sec":     c.pollingInterval.Seconds(),
-						"new_interval_sec":     newInterval.Seconds(),
-						"reset_countdown_sec":  reset,
-						"used_requests":        used,
-						"total_allocation":     totalAllowedRequests,
-						"period_seconds":       totalPeriodSeconds,
-						"strategy":             "reddit_rate",
-						"target_req_per_sec":   standardRate,
-						"target_req_per_min":   standardRate * 60,
-						"utilization_target":   "95%",
-						"subreddit_count":      numSubreddits,
-					}
-					
-					c.log.WithFields(logFields).Info("Using Reddit API rate limit")
-					adjustTicker <- newInterval
-				}
-			}
-		}
-	}()
+	if len(c.listingFeeds) > 0 && c.listingInterval > 0 {
+		go c.runListingSnapshots(ctx)
+	}
+
+	if c.refreshShare > 0 {
+		go c.runScoreRefresh(ctx)
+	}
+
+	if c.backfillShare > 0 {
+		go c.runBackfill(ctx)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker.C:
-			if err := c.fetchAndProcessPosts(ctx); err != nil {
-				c.log.WithError(err).Error("Failed to fetch and process posts")
-			}
+		case <-pollTicker.C:
+			c.pollDue(ctx, &polls)
 		case <-statsTicker.C:
 			c.updateStatistics()
 			c.logStatistics()
-		case newInterval := <-adjustTicker:
-			ticker.Stop()
-			c.pollingInterval = newInterval
-			ticker = time.NewTicker(newInterval)
-		case <-resetTicker.C:
-			// reset pagination keys to check for new posts
-			c.resetPaginationKeys()
+		case <-rebalanceTicker.C:
+			// the request rate follows the rate limit, and adaptive shares follow the arrival rates
+			c.rebalancePolling(false)
+		case <-c.subredditsChanged:
+			// subreddits were added or removed; split the budget among them right away
+			c.rebalancePolling(true)
+			c.pollDue(ctx, &polls)
 		}
 	}
 }
 
-// fetchAndProcessPosts fetches posts from Reddit and processes them
-func (c *Collector) fetchAndProcessPosts(ctx context.Context) error {
-	c.log.WithField("subreddits", c.subreddits).Info("Fetching posts from all subreddits")
-
-	fetchCtx, cancel := context.WithTimeout(ctx, c.pollingInterval/2)
-	defer cancel()
-
-	var wg sync.WaitGroup
-	errorsCh := make(chan error, len(c.subreddits))
-
-	// process each subreddit concurrently; support for more than 1 subreddit
-	for _, subreddit := range c.subreddits {
-		wg.Add(1)
-		go func(sr string) {
-			defer wg.Done()
-			
-			c.log.WithField("subreddit", sr).Debug("Starting to fetch posts for subreddit")
-
-			c.mutex.RLock()
-			paginationKey := c.paginationKeys[sr]
-			c.mutex.RUnlock()
-			
-			c.log.WithFields(logrus.Fields{
-				"subreddit": sr,
-				"pagination_key": paginationKey,
-			}).Info("Using pagination key for fetch")
-			
-			posts, nextPaginationKey, err := c.redditAPI.FetchPosts(sr, 100, paginationKey)
-			if err != nil {
-				errorsCh <- fmt.Errorf("failed to fetch posts from %s: %w", sr, err)
-				return
-			}
+// fetchNewPosts fetches the posts of a subreddit that are newer than its frontier, the newest post
+// seen so far. It pages back through the new listing until it reaches posts older than the frontier,
+// then moves the frontier forward. If a page fails, the frontier stays put so the next poll
+// walks the same range again instead of leaving a gap. Every poll is recorded in the poll log.
+// Each page request times out after half the subreddit's polling interval; saving a page waits for
+// a write worker until ctx, the collector's lifetime, is done, however long the catch-up takes.
+func (c *Collector) fetchNewPosts(ctx context.Context, sr string) (err error) {
+	record := models.PollRecord{Subreddit: sr, PolledAt: time.Now()}
+	defer func() {
+		if err != nil {
+			record.Error = err.Error()
+		}
+		c.logPoll(record)
+		c.polls.observe(record)
+	}()
 
-			c.log.WithFields(logrus.Fields{
-				"subreddit": sr,
-				"count":     len(posts),
-				"old_pagination_key": paginationKey,
-				"new_pagination_key": nextPaginationKey,
-				"pagination_changed": paginationKey != nextPaginationKey,
-			}).Info("Fetched posts for subreddit with pagination update")
+	frontier, err := c.frontier(sr)
+	if err != nil {
+		return err
+	}
 
-			c.mutex.Lock()
-			c.paginationKeys[sr] = nextPaginationKey
-			c.mutex.Unlock()
+	c.log.WithFields(logrus.Fields{
+		"subreddit": sr,
+		"frontier":  frontier,
+	}).Debug("Starting to fetch posts for subreddit")
+
+	if frontier == "" {
+		c.scheduleNewSubredditBackfill(sr)
+	}
 
-			if err := c.processPosts(fetchCtx, posts); err != nil {
-				errorsCh <- fmt.Errorf("failed to process posts from %s: %w", sr, err)
+	newest := frontier
+	oldestCreated := 0.0
+	after := ""
+	for record.Pages < maxCatchUpPages {
+		pageCtx, cancel := context.WithTimeout(ctx, c.polls.interval(sr)/2)
+		posts, next, err := c.redditAPI.FetchPosts(pageCtx, sr, newPostsPageSize, after)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to fetch posts from %s: %w", sr, err)
+		}
+		record.Pages++
+		record.Posts += len(posts)
+
+		seen := 0
+		for _, post := range posts {
+			if utils.CompareIDs(post.ID, frontier) <= 0 {
+				seen++
+			} else {
+				record.NewPosts++
+			}
+			if utils.CompareIDs(post.ID, newest) > 0 {
+				newest = post.ID
 			}
-		}(subreddit)
+			if oldestCreated == 0 || post.CreatedUTC < oldestCreated {
+				oldestCreated = post.CreatedUTC
+			}
+		}
+
+		// every fetched post is saved, including already-seen ones, since that refreshes their scores
+		if err := c.processPosts(ctx, posts); err != nil {
+			return fmt.Errorf("failed to process posts from %s: %w", sr, err)
+		}
+
+		// on the very first poll there's nothing to catch up on; start from the newest page
+		if frontier == "" || seen > 0 || next == "" {
+			record.CaughtUp = true
+			break
+		}
+		after = next
 	}
 
-	wg.Wait()
-	close(errorsCh)
+	if oldestCreated > 0 {
+		record.CoveredFrom = time.Unix(int64(oldestCreated), 0)
+	}
 
-	for err := range errorsCh {
-		c.log.WithError(err).Error("Error while fetching and processing posts")
+	logFields := logrus.Fields{
+		"subreddit":    sr,
+		"pages":        record.Pages,
+		"new_posts":    record.NewPosts,
+		"old_frontier": frontier,
+		"new_frontier": newest,
+	}
+	if !record.CaughtUp {
+		// the posts between the frontier and the oldest post we got to are missing
+		if post, err := c.database.GetPost(frontier); err == nil {
+			record.GapStart = time.Unix(int64(post.CreatedUTC), 0)
+		}
+		record.GapEnd = record.CoveredFrom
+		c.log.WithFields(logFields).Warn("Reached the page limit before the frontier; some new posts may have been missed")
+	} else {
+		c.log.WithFields(logFields).Info("Fetched new posts for subreddit")
 	}
 
+	if newest == frontier {
+		return nil
+	}
+
+	if err := c.database.SaveFrontier(sr, newest, time.Now()); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.frontiers[sr] = newest
+	c.mutex.Unlock()
+
 	return nil
 }
 
-// processPosts processes a batch of posts
-// TODO: ctx is not used at current;  remove it later.
+// logPoll records a poll in the poll log, pruning old records once an hour
+func (c *Collector) logPoll(record models.PollRecord) {
+	if err := c.database.LogPoll(record); err != nil {
+		c.log.WithError(err).Error("Failed to log poll")
+	}
+
+	c.mutex.Lock()
+	prune := time.Since(c.pollLogPrunedAt) > time.Hour
+	if prune {
+		c.pollLogPrunedAt = time.Now()
+	}
+	c.mutex.Unlock()
+
+	if prune {
+		if err := c.database.PrunePollLog(time.Now().Add(-pollLogRetention)); err != nil {
+			c.log.WithError(err).Error("Failed to prune poll log")
+		}
+	}
+}
+
+// frontier returns the newest post id seen in a subreddit, loading it from the database after a restart
+func (c *Collector) frontier(sr string) (string, error) {
+	c.mutex.RLock()
+	frontier, ok := c.frontiers[sr]
+	c.mutex.RUnlock()
+	if ok {
+		return frontier, nil
+	}
+
+	frontier, err := c.database.GetFrontier(sr)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	c.frontiers[sr] = frontier
+	c.mutex.Unlock()
+
+	return frontier, nil
+}
+
+// processPosts saves a batch of posts, usually one fetched page, in a single transaction and records
+// them in the statistics. At most writeWorkers batches are processed at once; others wait for a free
+// worker until ctx is done. A free worker is always taken, even if ctx is done by then.
 func (c *Collector) processPosts(ctx context.Context, posts []models.Post) error {
+	return c.processBatch(ctx, posts, false)
+}
+
+// processBackfilledPosts is processPosts for a page of history: the posts aren't passed on to the
+// notifier, whose events are about what's happening now
+func (c *Collector) processBackfilledPosts(ctx context.Context, posts []models.Post) error {
+	return c.processBatch(ctx, posts, true)
+}
+
+func (c *Collector) processBatch(ctx context.Context, posts []models.Post, backfilled bool) error {
 	if len(posts) == 0 {
 		c.log.Info("No new posts to process")
 		return nil
 	}
 
-	c.log.WithField("count", len(posts)).Info("Processing posts")
+	start := time.Now()
+	select {
+	case c.writeWorkers <- struct{}{}:
+	default:
+		select {
+		case c.writeWorkers <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer func() { <-c.writeWorkers }()
 
-	var wg sync.WaitGroup
-	errCh := make(chan error, len(posts))
+	acquired := time.Now()
+	fetched := len(posts)
+	posts, err := c.processors.Process(ctx, posts)
+	if err != nil {
+		return err
+	}
 
-	// process each post in a separate goroutine
-	for _, post := range posts {
-		wg.Add(1)
-		go func(post models.Post) {
-			defer wg.Done()
-			if err := c.processPost(post); err != nil {
-				errCh <- err
-			}
-		}(post)
+	processed := time.Now()
+	if len(posts) == 0 {
+		c.log.WithField("fetched", fetched).Info("Every post was dropped by the post processors")
+		return nil
 	}
 
-	wg.Wait()
-	close(errCh)
+	if err := c.database.SavePosts(posts); err != nil {
+		return fmt.Errorf("failed to save posts: %w", err)
+	}
 
-	// log any errors from the processing of posts
-	for err := range errCh {
-		c.log.WithError(err).Error("Error processing post")
+	saved := time.Now()
+	if err := c.scheduleRefreshes(posts); err != nil {
+		return fmt.Errorf("failed to schedule post refreshes: %w", err)
 	}
 
-	c.updateStatistics()
+	scheduled := time.Now()
+	if err := c.evaluateAlerts(posts); err != nil {
+		// the posts are saved; their alerts get another chance when they're refreshed
+		c.log.WithError(err).Error("Failed to evaluate alerts")
+	}
 
-	return nil
-}
+	if c.notifier != nil && !backfilled {
+		c.notifier.PostsSaved(posts)
+	}
 
-// processPost processes a single post
-func (c *Collector) processPost(post models.Post) error {
-	if err := c.database.SavePost(&post); err != nil {
-		return fmt.Errorf("failed to save post: %w", err)
+	alerted := time.Now()
+	for _, post := range posts {
+		c.engine.observe(post)
+		c.trends.observe(post)
 	}
 
 	c.mutex.Lock()
-	c.processedPostCount++
+	c.processedPostCount += len(posts)
 	c.mutex.Unlock()
 
+	c.updateStatistics()
+
+	c.log.WithFields(logrus.Fields{
+		"count":       len(posts),
+		"dropped":     fetched - len(posts),
+		"wait_ms":     acquired.Sub(start).Milliseconds(),
+		"pipeline_ms": processed.Sub(acquired).Milliseconds(),
+		"save_ms":     saved.Sub(processed).Milliseconds(),
+		"schedule_ms": scheduled.Sub(saved).Milliseconds(),
+		"alerts_ms":   alerted.Sub(scheduled).Milliseconds(),
+		"stats_ms":    time.Since(alerted).Milliseconds(),
+		"total_ms":    time.Since(start).Milliseconds(),
+	}).Info("Processed posts")
+
 	return nil
 }
 
-// updateStatistics updates the in-memory statistics
+// updateStatistics rebuilds the published statistics from the statistics engine and the trend tracker;
+// neither touches the database, so this costs the same regardless of how many posts are stored
 func (c *Collector) updateStatistics() {
-	// top posts by upvotes from database
-	topPosts, err := c.database.GetTopPostsByUpvotes(c.topPostsLimit)
-	if err != nil {
-		c.log.WithError(err).Error("Failed to get top posts")
-		return
-	}
-
-	// top users by post count from database
-	topUsers, err := c.database.GetTopUsersByPostCount(c.topUsersLimit)
+	snap, err := c.engine.snapshot(c.Subreddits())
 	if err != nil {
-		c.log.WithError(err).Error("Failed to get top users")
+		c.log.WithError(err).Error("Failed to build statistics")
 		return
 	}
 
-	// total posts from db
-	totalPosts, err := c.database.GetTotalPosts()
-	if err != nil {
-		c.log.WithError(err).Error("Failed to get total posts")
-		return
-	}
+	now := time.Now()
+	c.trends.prune(now)
+	window := c.trends.defaultWindow()
+	trending := c.trends.trending(now, window, "", c.trendingLimit)
 
-	// get the stats per subreddit
-	subredditStats := make(map[string]models.SubredditStats)
-	for _, subreddit := range c.subreddits {
-		posts, err := c.database.GetPostsBySubreddit(subreddit)
-		if err != nil {
-			c.log.WithError(err).WithField("subreddit", subreddit).Error("Failed to get posts for subreddit")
-			continue
-		}
-		
-		if len(posts) == 0 {
-			continue
-		}
-		
-		stats := models.SubredditStats{
-			PostCount: len(posts),
-		}
-		
-		if len(posts) > 0 {
-			highestUpvoted := posts[0]
-			for _, post := range posts {
-				if post.Upvotes > highestUpvoted.Upvotes {
-					highestUpvoted = post
-				}
-			}
-			stats.HighestUpvotedPost = highestUpvoted
-		}
-		
-		subredditStats[subreddit] = stats
+	for subreddit, stats := range snap.subredditStats {
+		stats.Trending = c.trends.trending(now, window, subreddit, c.trendingLimit)
+		snap.subredditStats[subreddit] = stats
 	}
 
 	c.mutex.Lock()
-	c.stats.TopPostsByUpvotes = topPosts
-	c.stats.TopUsersByPostCount = topUsers
-	c.stats.TotalPosts = totalPosts
-	c.stats.SubredditStats = subredditStats
-	c.stats.LastUpdated = time.Now()
+	c.stats.TopPostsByUpvotes = snap.topPosts
+	c.stats.TopUsersByPostCount = snap.topUsers
+	c.stats.TotalPosts = snap.totalPosts
+	c.stats.SubredditStats = snap.subredditStats
+	c.stats.Trending = trending
+	c.stats.LastUpdated = now
 	c.mutex.Unlock()
 }
 
@@ -373,23 +526,61 @@ func (c *Collector) GetStatistics() models.Statistics {
 	return c.stats
 }
 
-// resetPaginationKeys resets all pagination keys to empty strings to fetch newest posts
-func (c *Collector) resetPaginationKeys() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	
-	oldKeys := make(map[string]string)
-	for k, v := range c.paginationKeys {
-		oldKeys[k] = v
+// GetWindowStatistics computes the statistics of the posts created in [since, until), for one
+// subreddit or all of them when subreddit is empty. Unlike GetStatistics it queries the database.
+func (c *Collector) GetWindowStatistics(subreddit string, since, until time.Time) (models.WindowStatistics, error) {
+	stats := models.WindowStatistics{
+		Since:     since.UTC(),
+		Until:     until.UTC(),
+		Subreddit: subreddit,
 	}
-	
-	// Clear all pagination keys to fetch newest posts
-	for subreddit := range c.paginationKeys {
-		c.paginationKeys[subreddit] = ""
+	filter := db.PostFilter{Subreddit: subreddit, Since: since, Until: until}
+
+	var err error
+	if stats.TotalPosts, err = c.database.CountPostsInRange(filter); err != nil {
+		return stats, err
 	}
-	
-	c.log.WithFields(logrus.Fields{
-		"old_keys": oldKeys,
-		"new_keys": c.paginationKeys,
-	}).Info("Reset pagination keys to check for newest posts")
-} 
\ No newline at end of file
+	if stats.TopPostsByUpvotes, err = c.database.GetTopPostsInRange(filter, c.topPostsLimit); err != nil {
+		return stats, err
+	}
+	if stats.TopUsersByPostCount, err = c.database.GetTopUsersInRange(filter, c.topUsersLimit); err != nil {
+		return stats, err
+	}
+
+	if subreddit != "" {
+		return stats, nil
+	}
+
+	stats.SubredditStats = make(map[string]models.SubredditStats)
+	for _, sr := range c.Subreddits() {
+		filter.Subreddit = sr
+		count, err := c.database.CountPostsInRange(filter)
+		if err != nil {
+			return stats, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		top, err := c.database.GetTopPostsInRange(filter, 1)
+		if err != nil {
+			return stats, err
+		}
+
+		srStats := models.SubredditStats{PostCount: count}
+		if len(top) > 0 {
+			srStats.HighestUpvotedPost = top[0]
+		}
+		stats.SubredditStats[sr] = srStats
+	}
+
+	return stats, nil
+}
+
+// Subreddits returns the subreddits being tracked
+func (c *Collector) Subreddits() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return append([]string(nil), c.subreddits...)
+}
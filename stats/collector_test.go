@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/api/redditfake"
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/pipeline"
+	"github.com/brettboylen/reddit-tracker/utils"
+)
+
+// newTestCollector creates a collector backed by a fake Reddit server and an in-memory store
+func newTestCollector(t *testing.T, subreddits []string, opts ...Option) (*Collector, *redditfake.Server, db.Store) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	fake := redditfake.NewServer()
+	t.Cleanup(fake.Close)
+
+	database := db.NewMemoryStore()
+
+	redditAPI := api.NewRedditAPI("id", "secret", "test-agent", 60000, log,
+		api.WithBaseURL(fake.URL()),
+		api.WithAuthURL(fake.AuthURL()),
+	)
+
+	return NewCollector(redditAPI, database, subreddits, 1, log, opts...), fake, database
+}
+
+func TestFetchNewPostsFrontier(t *testing.T) {
+	c, fake, database := newTestCollector(t, []string{"golang"})
+	ctx := context.Background()
+
+	addPosts := func(from, to int) {
+		for i := from; i < to; i++ {
+			id := utils.FormatBase36ID(uint64(100000 + i))
+			fake.AddPosts("golang", models.Post{ID: id, Title: id, Author: "alice", CreatedUTC: float64(1700000000 + i)})
+		}
+	}
+
+	// the first poll only takes the newest page
+	addPosts(0, 150)
+	require.NoError(t, c.fetchNewPosts(ctx, "golang"))
+	assert.Equal(t, 1, fake.RequestCount("/r/golang/new.json"))
+
+	frontier, err := database.GetFrontier("golang")
+	require.NoError(t, err)
+	assert.Equal(t, utils.FormatBase36ID(100149), frontier)
+
+	// 250 posts arrive between polls; the collector pages back until it reaches the frontier
+	addPosts(150, 400)
+	require.NoError(t, c.fetchNewPosts(ctx, "golang"))
+	assert.Equal(t, 4, fake.RequestCount("/r/golang/new.json"))
+
+	total, err := database.GetTotalPosts()
+	require.NoError(t, err)
+	assert.Equal(t, 350, total)
+
+	// a restarted collector resumes from the stored frontier
+	restarted := NewCollector(c.redditAPI, database, []string{"golang"}, 1, c.log)
+	addPosts(400, 410)
+	require.NoError(t, restarted.fetchNewPosts(ctx, "golang"))
+	assert.Equal(t, 5, fake.RequestCount("/r/golang/new.json"))
+
+	frontier, err = database.GetFrontier("golang")
+	require.NoError(t, err)
+	assert.Equal(t, utils.FormatBase36ID(100409), frontier)
+
+	polls, err := database.GetPollLog("golang", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, polls, 3)
+	assert.Equal(t, 3, polls[1].Pages)
+	assert.Equal(t, 250, polls[1].NewPosts)
+	assert.True(t, polls[1].CaughtUp)
+}
+
+func TestProcessPostsWaitsForWorker(t *testing.T) {
+	c, _, database := newTestCollector(t, []string{"golang"}, WithWriteWorkers(1))
+	posts := []models.Post{{ID: "w1", Author: "alice", Subreddit: "golang", ProcessedTime: time.Now()}}
+
+	// the only worker is busy, so the batch gives up when its context is done
+	c.writeWorkers <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.processPosts(ctx, posts), context.DeadlineExceeded)
+
+	_, err := database.GetPost("w1")
+	assert.ErrorIs(t, err, db.ErrPostNotFound)
+
+	// once the worker is free the batch goes through, even though the context it waited on is done
+	<-c.writeWorkers
+	for i := 0; i < 20; i++ {
+		require.NoError(t, c.processPosts(ctx, posts))
+	}
+	assert.Equal(t, 1, c.GetStatistics().TotalPosts)
+}
+
+func TestProcessPostsRunsProcessors(t *testing.T) {
+	c, _, database := newTestCollector(t, []string{"golang"}, WithProcessors(pipeline.Chain{
+		pipeline.NSFWFilter(),
+		pipeline.DomainExtractor(),
+	}))
+
+	posts := []models.Post{
+		{ID: "safe", Title: "safe", Author: "alice", Subreddit: "golang", URL: "https://go.dev/blog", CreatedUTC: 1700000000},
+		{ID: "nsfw", Title: "nsfw", Author: "bob", Subreddit: "golang", Over18: true, CreatedUTC: 1700000001},
+	}
+	require.NoError(t, c.processPosts(context.Background(), posts))
+
+	total, err := database.GetTotalPosts()
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	saved, err := database.GetPost("safe")
+	require.NoError(t, err)
+	assert.Equal(t, "go.dev", saved.Domain)
+	assert.Equal(t, 1, c.GetStatistics().TotalPosts)
+
+	// a batch the processors drop entirely is not saved at all
+	require.NoError(t, c.processPosts(context.Background(), posts[1:]))
+	total, err = database.GetTotalPosts()
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runCommentRefresh periodically refreshes the comments of active posts until ctx is done
+func (c *Collector) runCommentRefresh(ctx context.Context) {
+	ticker := time.NewTicker(c.commentInterval)
+	defer ticker.Stop()
+
+	c.log.WithFields(logrus.Fields{
+		"interval_sec":     c.commentInterval.Seconds(),
+		"posts_per_run":    c.commentPostsPerRun,
+		"max_post_age_hrs": c.commentMaxPostAge.Hours(),
+	}).Info("Comment refresh configured")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshComments(ctx)
+		}
+	}
+}
+
+// refreshComments fetches and stores the comments of the most active recent posts whose
+// comment count changed since we last fetched them
+func (c *Collector) refreshComments(ctx context.Context) {
+	// look at a few more candidates than we need since some won't have changed
+	candidates, err := c.database.GetActivePosts(time.Now().Add(-c.commentMaxPostAge), c.commentPostsPerRun*4)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to get active posts for comment refresh")
+		return
+	}
+
+	active := make(map[string]bool, len(candidates))
+	fetched := 0
+	for _, post := range candidates {
+		active[post.ID] = true
+		if fetched >= c.commentPostsPerRun {
+			continue
+		}
+
+		c.mutex.RLock()
+		lastCount, seen := c.commentCountsAtLast[post.ID]
+		c.mutex.RUnlock()
+		if seen && lastCount == post.NumComments {
+			continue
+		}
+
+		comments, err := c.redditAPI.FetchComments(ctx, post.Subreddit, post.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.log.WithError(err).WithField("post_id", post.ID).Error("Failed to fetch comments")
+			c.reportError("comments/"+post.Subreddit, err)
+			continue
+		}
+		fetched++
+
+		if err := c.database.SaveComments(comments); err != nil {
+			c.log.WithError(err).WithField("post_id", post.ID).Error("Failed to save comments")
+			continue
+		}
+
+		c.mutex.Lock()
+		c.commentCountsAtLast[post.ID] = post.NumComments
+		c.mutex.Unlock()
+
+		c.log.WithFields(logrus.Fields{
+			"post_id":       post.ID,
+			"subreddit":     post.Subreddit,
+			"comment_count": len(comments),
+		}).Debug("Refreshed comments for post")
+	}
+
+	// forget posts that are no longer active so the map doesn't grow forever
+	c.mutex.Lock()
+	for id := range c.commentCountsAtLast {
+		if !active[id] {
+			delete(c.commentCountsAtLast, id)
+		}
+	}
+	c.mutex.Unlock()
+}
@@ -0,0 +1,205 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/utils"
+)
+
+// CoverageOptions tunes the coverage gap analysis
+type CoverageOptions struct {
+	Since     time.Time     // only posts and polls after this time are analysed
+	MinGap    time.Duration // silences between posts shorter than this are never reported
+	GapFactor float64       // a silence is suspicious when it's this many times the median time between posts
+}
+
+// DefaultCoverageOptions looks at the last week and flags silences of at least 30 minutes that are
+// ten times longer than usual
+func DefaultCoverageOptions() CoverageOptions {
+	return CoverageOptions{
+		Since:     time.Now().Add(-7 * 24 * time.Hour),
+		MinGap:    30 * time.Minute,
+		GapFactor: 10,
+	}
+}
+
+// interval is a closed time range
+type interval struct {
+	start, end time.Time
+}
+
+// AnalyzeCoverage reports the time ranges of a subreddit where ingestion was probably incomplete.
+//
+// The poll log is the primary evidence: every successful poll saw all posts created since the oldest post
+// it fetched, a poll that reached the frontier also vouches for everything since the previous successful
+// poll, and a poll that hit its page limit records the range it couldn't reach. Where the poll log can't
+// vouch for a range (before it existed, or while polls were failing), stored posts are ordered by their
+// base36 id and unusually long silences between them are reported.
+func AnalyzeCoverage(database db.Store, subreddit string, opts CoverageOptions) ([]models.CoverageGap, error) {
+	polls, err := database.GetPollLog(subreddit, opts.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := database.GetPostTimeline(subreddit, opts.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	// ids are assigned in creation order, which is more reliable than created_utc ties
+	sort.SliceStable(posts, func(i, j int) bool {
+		return utils.CompareIDs(posts[i].ID, posts[j].ID) < 0
+	})
+
+	median := medianInterarrival(posts)
+	estimate := func(d time.Duration) int {
+		if median <= 0 {
+			return 0
+		}
+		return int(math.Max(math.Round(float64(d)/float64(median))-1, 0))
+	}
+
+	gaps := make([]models.CoverageGap, 0)
+	covered := make([]interval, 0)
+	var lastSuccess time.Time
+	for _, poll := range polls {
+		if poll.Error != "" {
+			continue
+		}
+
+		// a caught-up poll reached the frontier, so it also vouches for everything since the last success
+		from := poll.CoveredFrom
+		if poll.CaughtUp && !lastSuccess.IsZero() && (from.IsZero() || lastSuccess.Before(from)) {
+			from = lastSuccess
+		}
+		if !from.IsZero() {
+			covered = append(covered, interval{from, poll.PolledAt})
+		}
+
+		if !poll.CaughtUp && !poll.GapEnd.IsZero() {
+			start := poll.GapStart
+			if start.IsZero() {
+				start = lastSuccess
+			}
+			if start.IsZero() || start.After(poll.GapEnd) {
+				start = poll.GapEnd
+			}
+
+			gaps = append(gaps, models.CoverageGap{
+				Subreddit:        subreddit,
+				Start:            start.UTC(),
+				End:              poll.GapEnd.UTC(),
+				Reason:           models.GapPollTruncated,
+				EstimatedMissing: estimate(poll.GapEnd.Sub(start)),
+			})
+		}
+		lastSuccess = poll.PolledAt
+	}
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].start.Before(covered[j].start)
+	})
+
+	threshold := opts.MinGap
+	if factor := time.Duration(float64(median) * opts.GapFactor); factor > threshold {
+		threshold = factor
+	}
+
+	for i := 1; i < len(posts); i++ {
+		prev, next := posts[i-1], posts[i]
+		start := time.Unix(int64(prev.CreatedUTC), 0).UTC()
+		end := time.Unix(int64(next.CreatedUTC), 0).UTC()
+		if end.Sub(start) < threshold || isCovered(covered, start, end) {
+			continue
+		}
+
+		gaps = append(gaps, models.CoverageGap{
+			Subreddit:        subreddit,
+			Start:            start,
+			End:              end,
+			Reason:           models.GapSparsePosts,
+			BeforeID:         prev.ID,
+			AfterID:          next.ID,
+			EstimatedMissing: estimate(end.Sub(start)),
+		})
+	}
+
+	sort.SliceStable(gaps, func(i, j int) bool {
+		return gaps[i].Start.Before(gaps[j].Start)
+	})
+	return mergeGaps(gaps), nil
+}
+
+// ScheduleCoverageBackfills queues a backfill job for each gap
+func ScheduleCoverageBackfills(database db.Store, gaps []models.CoverageGap) ([]models.BackfillJob, error) {
+	jobs := make([]models.BackfillJob, 0, len(gaps))
+	for _, gap := range gaps {
+		job := models.BackfillJob{
+			Subreddit: gap.Subreddit,
+			Since:     gap.Start,
+			Until:     gap.End,
+			Reason:    fmt.Sprintf("coverage gap (%s)", gap.Reason),
+		}
+		if err := database.ScheduleBackfill(&job); err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// medianInterarrival returns the median time between consecutive posts
+func medianInterarrival(posts []models.PostRef) time.Duration {
+	if len(posts) < 2 {
+		return 0
+	}
+
+	deltas := make([]float64, 0, len(posts)-1)
+	for i := 1; i < len(posts); i++ {
+		deltas = append(deltas, math.Abs(posts[i].CreatedUTC-posts[i-1].CreatedUTC))
+	}
+	sort.Float64s(deltas)
+
+	return time.Duration(deltas[len(deltas)/2] * float64(time.Second))
+}
+
+// isCovered reports whether the range is entirely inside the union of the covered intervals,
+// which are sorted by start
+func isCovered(covered []interval, start, end time.Time) bool {
+	reached := start
+	for _, c := range covered {
+		if c.start.After(reached) {
+			break
+		}
+		if c.end.After(reached) {
+			reached = c.end
+		}
+		if !reached.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeGaps joins overlapping gaps, which are sorted by start; a merged gap keeps the first reason
+func mergeGaps(gaps []models.CoverageGap) []models.CoverageGap {
+	merged := make([]models.CoverageGap, 0, len(gaps))
+	for _, gap := range gaps {
+		if n := len(merged); n > 0 && !gap.Start.After(merged[n-1].End) {
+			last := &merged[n-1]
+			if gap.End.After(last.End) {
+				last.End = gap.End
+				last.AfterID = gap.AfterID
+			}
+			last.EstimatedMissing = max(last.EstimatedMissing, gap.EstimatedMissing)
+			continue
+		}
+		merged = append(merged, gap)
+	}
+	return merged
+}
@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+	"github.com/brettboylen/reddit-tracker/utils"
+)
+
+func TestAnalyzeCoverage(t *testing.T) {
+	_, _, database := newTestCollector(t, []string{"golang"})
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Minute)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	// a post a minute, except for a two hour silence
+	for minute := 0; minute <= 240; minute++ {
+		if minute > 60 && minute < 180 {
+			continue
+		}
+		post := models.Post{ID: utils.FormatBase36ID(uint64(100000 + minute)), Subreddit: "golang",
+			Author: "alice", CreatedUTC: float64(at(minute).Unix()), ProcessedTime: at(minute)}
+		require.NoError(t, database.SavePost(&post))
+	}
+
+	opts := CoverageOptions{Since: start.Add(-time.Hour), MinGap: 30 * time.Minute, GapFactor: 10}
+
+	// without a poll log the silence can't be told apart from missed posts
+	gaps, err := AnalyzeCoverage(database, "golang", opts)
+	require.NoError(t, err)
+	require.Len(t, gaps, 1)
+	assert.Equal(t, models.GapSparsePosts, gaps[0].Reason)
+	assert.Equal(t, at(60).UTC(), gaps[0].Start)
+	assert.Equal(t, at(180).UTC(), gaps[0].End)
+	assert.Equal(t, utils.FormatBase36ID(100060), gaps[0].BeforeID)
+	assert.Equal(t, 119, gaps[0].EstimatedMissing)
+
+	// caught-up polls vouch for the silence, but the last poll hit its page limit
+	require.NoError(t, database.LogPoll(models.PollRecord{Subreddit: "golang", PolledAt: at(70), CaughtUp: true, CoveredFrom: at(0)}))
+	require.NoError(t, database.LogPoll(models.PollRecord{Subreddit: "golang", PolledAt: at(200), Error: "boom"}))
+	require.NoError(t, database.LogPoll(models.PollRecord{Subreddit: "golang", PolledAt: at(250), CaughtUp: true, CoveredFrom: at(230)}))
+	require.NoError(t, database.LogPoll(models.PollRecord{Subreddit: "golang", PolledAt: at(320), CoveredFrom: at(300),
+		GapStart: at(240), GapEnd: at(300)}))
+
+	gaps, err = AnalyzeCoverage(database, "golang", opts)
+	require.NoError(t, err)
+	require.Len(t, gaps, 1)
+	assert.Equal(t, models.GapPollTruncated, gaps[0].Reason)
+	assert.Equal(t, at(240).UTC(), gaps[0].Start)
+	assert.Equal(t, at(300).UTC(), gaps[0].End)
+
+	// backfills are queued once per gap
+	jobs, err := ScheduleCoverageBackfills(database, gaps)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, models.BackfillPending, jobs[0].Status)
+
+	again, err := ScheduleCoverageBackfills(database, gaps)
+	require.NoError(t, err)
+	assert.Equal(t, jobs[0].ID, again[0].ID)
+
+	pending, err := database.GetBackfillJobs(models.BackfillPending)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
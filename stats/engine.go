@@ -0,0 +1,320 @@
+package stats
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// statsEngine keeps the statistics up to date as posts are saved, so building them never scans the
+// posts table. Only a slim summary of each post is kept in memory; full posts are kept for the ones
+// that currently rank, and loaded with lookup when a post enters a ranking during a rebuild.
+type statsEngine struct {
+	mutex  sync.Mutex
+	lookup func(id string) (*models.Post, error)
+
+	posts        map[string]models.PostSummary
+	authorCounts map[string]int
+	subreddits   map[string]*subredditTally // keyed by lowercase name
+	details      map[string]models.Post     // full posts of ranked ids
+
+	topPosts *rankedSet
+	topUsers *rankedSet
+}
+
+// subredditTally holds the running statistics of one subreddit
+type subredditTally struct {
+	posts map[string]struct{} // ids of the subreddit's posts, so a rebuild only goes through these
+	top   *rankedSet          // the single highest upvoted post
+}
+
+func newStatsEngine(topPostsLimit, topUsersLimit int, lookup func(id string) (*models.Post, error)) *statsEngine {
+	return &statsEngine{
+		lookup:       lookup,
+		posts:        make(map[string]models.PostSummary),
+		authorCounts: make(map[string]int),
+		subreddits:   make(map[string]*subredditTally),
+		details:      make(map[string]models.Post),
+		topPosts:     newRankedSet(topPostsLimit),
+		topUsers:     newRankedSet(topUsersLimit),
+	}
+}
+
+// warm loads the summaries of the posts already in the database
+func (e *statsEngine) warm(summaries []models.PostSummary) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, summary := range summaries {
+		e.apply(summary)
+	}
+}
+
+// observe records a saved post, which may be new or an update of a known one
+func (e *statsEngine) observe(post models.Post) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.apply(models.PostSummary{ID: post.ID, Subreddit: post.Subreddit, Author: post.Author, Upvotes: post.Upvotes})
+
+	// keep the details of ranked posts current
+	key := strings.ToLower(post.Subreddit)
+	if e.topPosts.contains(post.ID) || e.subreddits[key].top.contains(post.ID) {
+		e.details[post.ID] = post
+	}
+}
+
+// apply updates the tallies with a post summary; callers hold the mutex
+func (e *statsEngine) apply(summary models.PostSummary) {
+	old, known := e.posts[summary.ID]
+	e.posts[summary.ID] = summary
+
+	// [deleted], AutoModerator and the like aren't ranked, so they aren't counted either
+	if !known || old.Author != summary.Author {
+		if known && !models.IsExcludedAuthor(old.Author) {
+			e.authorCounts[old.Author]--
+			e.topUsers.update(old.Author, e.authorCounts[old.Author])
+			if e.authorCounts[old.Author] <= 0 {
+				delete(e.authorCounts, old.Author)
+				e.topUsers.remove(old.Author)
+			}
+		}
+		if !models.IsExcludedAuthor(summary.Author) {
+			e.authorCounts[summary.Author]++
+			e.topUsers.update(summary.Author, e.authorCounts[summary.Author])
+		}
+	}
+
+	key := strings.ToLower(summary.Subreddit)
+	if oldKey := strings.ToLower(old.Subreddit); known && oldKey != key {
+		if moved := e.subreddits[oldKey]; moved != nil {
+			delete(moved.posts, summary.ID)
+			moved.top.remove(summary.ID)
+		}
+	}
+	tally := e.subreddits[key]
+	if tally == nil {
+		tally = &subredditTally{posts: make(map[string]struct{}), top: newRankedSet(1)}
+		e.subreddits[key] = tally
+	}
+	tally.posts[summary.ID] = struct{}{}
+
+	e.topPosts.update(summary.ID, summary.Upvotes)
+	tally.top.update(summary.ID, summary.Upvotes)
+}
+
+// engineSnapshot is the statistics computed by the engine
+type engineSnapshot struct {
+	totalPosts     int
+	topPosts       []models.Post
+	topUsers       []models.UserPostCount
+	subredditStats map[string]models.SubredditStats
+}
+
+// snapshot builds the statistics for the given subreddits. Its cost depends on the ranking sizes,
+// not on the number of posts, except after a ranked post lost votes, which needs one in-memory rebuild
+// of that ranking: over every post for the top posts, over a subreddit's posts for its top post.
+func (e *statsEngine) snapshot(subreddits []string) (engineSnapshot, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.topPosts.dirty {
+		e.topPosts.rebuild(func(yield func(string, int)) {
+			for id, summary := range e.posts {
+				yield(id, summary.Upvotes)
+			}
+		})
+	}
+	if e.topUsers.dirty {
+		e.topUsers.rebuild(func(yield func(string, int)) {
+			for author, count := range e.authorCounts {
+				yield(author, count)
+			}
+		})
+	}
+	for _, tally := range e.subreddits {
+		if tally.top.dirty {
+			tally.top.rebuild(func(yield func(string, int)) {
+				for id := range tally.posts {
+					yield(id, e.posts[id].Upvotes)
+				}
+			})
+		}
+	}
+
+	snap := engineSnapshot{
+		totalPosts:     len(e.posts),
+		topPosts:       make([]models.Post, 0, e.topPosts.n),
+		topUsers:       make([]models.UserPostCount, 0, e.topUsers.n),
+		subredditStats: make(map[string]models.SubredditStats),
+	}
+
+	for _, item := range e.topPosts.sorted() {
+		post, err := e.detail(item.key)
+		if err != nil {
+			return snap, err
+		}
+		snap.topPosts = append(snap.topPosts, post)
+	}
+
+	for _, item := range e.topUsers.sorted() {
+		snap.topUsers = append(snap.topUsers, models.UserPostCount{Author: item.key, PostCount: item.value})
+	}
+
+	for _, subreddit := range subreddits {
+		tally := e.subreddits[strings.ToLower(subreddit)]
+		if tally == nil || len(tally.posts) == 0 {
+			continue
+		}
+
+		stats := models.SubredditStats{PostCount: len(tally.posts)}
+		if top := tally.top.sorted(); len(top) > 0 {
+			post, err := e.detail(top[0].key)
+			if err != nil {
+				return snap, err
+			}
+			stats.HighestUpvotedPost = post
+		}
+		snap.subredditStats[subreddit] = stats
+	}
+
+	e.pruneDetails()
+	return snap, nil
+}
+
+// detail returns the full post of a ranked id, loading it if it entered the ranking in a rebuild
+func (e *statsEngine) detail(id string) (models.Post, error) {
+	if post, ok := e.details[id]; ok {
+		return post, nil
+	}
+
+	post, err := e.lookup(id)
+	if err != nil {
+		return models.Post{}, err
+	}
+	e.details[id] = *post
+	return *post, nil
+}
+
+// pruneDetails forgets the full posts of ids that no longer rank
+func (e *statsEngine) pruneDetails() {
+	for id, post := range e.details {
+		tally := e.subreddits[strings.ToLower(post.Subreddit)]
+		if !e.topPosts.contains(id) && (tally == nil || !tally.top.contains(id)) {
+			delete(e.details, id)
+		}
+	}
+}
+
+// rankedItem is a key and the value it's ranked by
+type rankedItem struct {
+	key   string
+	value int
+}
+
+// rankedSet keeps the n keys with the highest values out of a larger population in a min-heap.
+// Values can go up or down: when a member's value drops, a key outside the set may now outrank it,
+// so the set is marked dirty and must be rebuilt from the population before it's read.
+type rankedSet struct {
+	n     int
+	items []rankedItem
+	index map[string]int
+	dirty bool
+}
+
+func newRankedSet(n int) *rankedSet {
+	return &rankedSet{n: n, index: make(map[string]int)}
+}
+
+// outranks orders items by value, then by key so the order is stable
+func outranks(a, b rankedItem) bool {
+	if a.value != b.value {
+		return a.value > b.value
+	}
+	return a.key < b.key
+}
+
+// heap.Interface; the root is the lowest ranked member
+func (r *rankedSet) Len() int           { return len(r.items) }
+func (r *rankedSet) Less(i, j int) bool { return outranks(r.items[j], r.items[i]) }
+func (r *rankedSet) Swap(i, j int) {
+	r.items[i], r.items[j] = r.items[j], r.items[i]
+	r.index[r.items[i].key] = i
+	r.index[r.items[j].key] = j
+}
+func (r *rankedSet) Push(x interface{}) {
+	item := x.(rankedItem)
+	r.index[item.key] = len(r.items)
+	r.items = append(r.items, item)
+}
+func (r *rankedSet) Pop() interface{} {
+	item := r.items[len(r.items)-1]
+	r.items = r.items[:len(r.items)-1]
+	delete(r.index, item.key)
+	return item
+}
+
+func (r *rankedSet) contains(key string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.index[key]
+	return ok
+}
+
+// update records the current value of a key
+func (r *rankedSet) update(key string, value int) {
+	if r.n <= 0 {
+		return
+	}
+
+	item := rankedItem{key: key, value: value}
+	if i, ok := r.index[key]; ok {
+		if value < r.items[i].value {
+			r.dirty = true
+		}
+		r.items[i] = item
+		heap.Fix(r, i)
+		return
+	}
+
+	if len(r.items) < r.n {
+		heap.Push(r, item)
+		return
+	}
+	if outranks(item, r.items[0]) {
+		heap.Pop(r)
+		heap.Push(r, item)
+	}
+}
+
+// remove drops a key that no longer exists in the population
+func (r *rankedSet) remove(key string) {
+	if i, ok := r.index[key]; ok {
+		heap.Remove(r, i)
+		r.dirty = true
+	}
+}
+
+// rebuild recomputes the set from every key in the population
+func (r *rankedSet) rebuild(population func(yield func(string, int))) {
+	r.items = r.items[:0]
+	r.index = make(map[string]int, r.n)
+	r.dirty = false
+	population(func(key string, value int) {
+		r.update(key, value)
+	})
+	r.dirty = false
+}
+
+// sorted returns the members, highest ranked first
+func (r *rankedSet) sorted() []rankedItem {
+	items := append([]rankedItem(nil), r.items...)
+	sort.Slice(items, func(i, j int) bool {
+		return outranks(items[i], items[j])
+	})
+	return items
+}
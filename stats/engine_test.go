@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// TestStatsEngineMatchesDatabase checks the incremental statistics against the database queries
+// they replace, through new posts, score changes in both directions and authors being deleted
+func TestStatsEngineMatchesDatabase(t *testing.T) {
+	c, _, database := newTestCollector(t, []string{"golang", "rust"})
+	rng := rand.New(rand.NewSource(1))
+	authors := []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy", "mallory", "oscar"}
+
+	posts := make(map[string]models.Post)
+	for round := 0; round < 600; round++ {
+		id := fmt.Sprintf("p%d", rng.Intn(200))
+		post, ok := posts[id]
+		if !ok {
+			subreddit := "golang"
+			if rng.Intn(3) == 0 {
+				subreddit = "rust"
+			}
+			post = models.Post{ID: id, Title: id, Subreddit: subreddit, Author: authors[rng.Intn(len(authors))]}
+		}
+		// unique upvotes so the ordering doesn't depend on tie-breaking
+		post.Upvotes = rng.Intn(1000)*1000 + round
+		if ok && rng.Intn(10) == 0 {
+			post.Author = "[deleted]"
+		}
+		post.ProcessedTime = time.Now()
+		posts[id] = post
+
+		require.NoError(t, c.processPosts(context.Background(), []models.Post{post}))
+	}
+	c.updateStatistics()
+	stats := c.GetStatistics()
+
+	total, err := database.GetTotalPosts()
+	require.NoError(t, err)
+	assert.Equal(t, total, stats.TotalPosts)
+
+	topPosts, err := database.GetTopPostsByUpvotes(defaultTopPostsLimit)
+	require.NoError(t, err)
+	require.Len(t, stats.TopPostsByUpvotes, len(topPosts))
+	for i := range topPosts {
+		assert.Equal(t, topPosts[i].ID, stats.TopPostsByUpvotes[i].ID)
+		assert.Equal(t, topPosts[i].Upvotes, stats.TopPostsByUpvotes[i].Upvotes)
+	}
+
+	counts := make(map[string]int)
+	for _, post := range posts {
+		counts[post.Author]++
+	}
+	for i, user := range stats.TopUsersByPostCount {
+		assert.Equal(t, counts[user.Author], user.PostCount, user.Author)
+		if i > 0 {
+			assert.GreaterOrEqual(t, stats.TopUsersByPostCount[i-1].PostCount, user.PostCount, "ranked by post count")
+		}
+	}
+	assert.Len(t, stats.TopUsersByPostCount, defaultTopUsersLimit)
+
+	dbUsers, err := database.GetTopUsersByPostCount(defaultTopUsersLimit)
+	require.NoError(t, err)
+	assert.Equal(t, dbUsers, stats.TopUsersByPostCount)
+
+	for _, subreddit := range []string{"golang", "rust"} {
+		subredditPosts, err := database.GetPostsBySubreddit(subreddit, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, len(subredditPosts), stats.SubredditStats[subreddit].PostCount)
+		assert.Equal(t, subredditPosts[0].ID, stats.SubredditStats[subreddit].HighestUpvotedPost.ID)
+	}
+
+	// a fresh collector warmed from the database agrees
+	warmed := NewCollector(c.redditAPI, database, []string{"golang", "rust"}, 1, c.log)
+	require.NoError(t, warmed.warmStatistics())
+	warmedStats := warmed.GetStatistics()
+	assert.Equal(t, stats.TotalPosts, warmedStats.TotalPosts)
+	require.Len(t, warmedStats.TopPostsByUpvotes, len(stats.TopPostsByUpvotes))
+	for i := range stats.TopPostsByUpvotes {
+		assert.Equal(t, stats.TopPostsByUpvotes[i].ID, warmedStats.TopPostsByUpvotes[i].ID)
+	}
+	assert.Equal(t, stats.TopUsersByPostCount, warmedStats.TopUsersByPostCount)
+	assert.Equal(t, stats.SubredditStats["rust"].PostCount, warmedStats.SubredditStats["rust"].PostCount)
+}
+
+// TestStatsEngineSubredditRebuild checks that a subreddit's top post is rebuilt from that
+// subreddit's own posts after it loses votes
+func TestStatsEngineSubredditRebuild(t *testing.T) {
+	saved := make(map[string]models.Post)
+	engine := newStatsEngine(1, 1, func(id string) (*models.Post, error) {
+		post := saved[id]
+		return &post, nil
+	})
+	observe := func(id, subreddit string, upvotes int) {
+		post := models.Post{ID: id, Subreddit: subreddit, Author: "alice", Upvotes: upvotes}
+		saved[id] = post
+		engine.observe(post)
+	}
+
+	observe("g1", "golang", 100)
+	observe("g2", "golang", 50)
+	observe("r1", "rust", 80)
+	observe("g1", "golang", 10)
+
+	snap, err := engine.snapshot([]string{"golang", "rust"})
+	require.NoError(t, err)
+	assert.Equal(t, "g2", snap.subredditStats["golang"].HighestUpvotedPost.ID, "not rust's r1")
+	assert.Equal(t, 2, snap.subredditStats["golang"].PostCount)
+	assert.Equal(t, "r1", snap.topPosts[0].ID)
+
+	// a post listed under another subreddit moves to its tally
+	observe("g2", "rust", 50)
+	snap, err = engine.snapshot([]string{"golang", "rust"})
+	require.NoError(t, err)
+	assert.Equal(t, "g1", snap.subredditStats["golang"].HighestUpvotedPost.ID)
+	assert.Equal(t, 1, snap.subredditStats["golang"].PostCount)
+	assert.Equal(t, 2, snap.subredditStats["rust"].PostCount)
+}
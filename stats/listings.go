@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// WithListings polls the given feeds (e.g. AskReddit:hot, news:top/week) every interval in addition to
+// the new listing of each subreddit, storing their posts and each post's rank in the listing
+func WithListings(feeds []models.Feed, interval time.Duration) Option {
+	return func(c *Collector) {
+		c.listingFeeds = feeds
+		c.listingInterval = interval
+	}
+}
+
+// listingRequestRate returns the requests per second spent on listing snapshots
+func (c *Collector) listingRequestRate() float64 {
+	if len(c.listingFeeds) == 0 || c.listingInterval <= 0 {
+		return 0
+	}
+	return float64(len(c.listingFeeds)) / c.listingInterval.Seconds()
+}
+
+// runListingSnapshots snapshots every configured feed once per listing interval until ctx is done
+func (c *Collector) runListingSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(c.listingInterval)
+	defer ticker.Stop()
+
+	c.log.WithFields(logrus.Fields{
+		"feeds":        c.listingFeeds,
+		"interval_sec": c.listingInterval.Seconds(),
+	}).Info("Listing snapshots configured")
+
+	c.snapshotListings(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.snapshotListings(ctx)
+		}
+	}
+}
+
+// snapshotListings fetches the first page of each feed, saves its posts and records their ranks
+func (c *Collector) snapshotListings(ctx context.Context) {
+	for _, feed := range c.listingFeeds {
+		posts, _, err := c.redditAPI.FetchListing(ctx, feed.Subreddit, api.ListingOptions{
+			Listing: feed.Listing,
+			Time:    feed.Time,
+			Limit:   100,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.log.WithError(err).WithField("feed", feed.String()).Error("Failed to fetch listing")
+			c.reportError("listing/"+feed.String(), err)
+			continue
+		}
+
+		if err := c.processPosts(ctx, posts); err != nil {
+			c.log.WithError(err).WithField("feed", feed.String()).Error("Failed to process listing posts")
+		}
+
+		snapshotAt := time.Now()
+		ranks := make([]models.ListingRank, 0, len(posts))
+		for i, post := range posts {
+			ranks = append(ranks, models.ListingRank{
+				Subreddit:  feed.Subreddit,
+				Listing:    feed.Listing,
+				Time:       feed.Time,
+				SnapshotAt: snapshotAt,
+				Rank:       i + 1,
+				PostID:     post.ID,
+				Score:      post.Score,
+			})
+		}
+
+		if err := c.database.SaveListingRanks(ranks); err != nil {
+			c.log.WithError(err).WithField("feed", feed.String()).Error("Failed to save listing ranks")
+			continue
+		}
+
+		c.log.WithFields(logrus.Fields{
+			"feed":       feed.String(),
+			"post_count": len(posts),
+		}).Debug("Recorded listing snapshot")
+	}
+}
@@ -0,0 +1,32 @@
+package stats
+
+import (
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// Notifier is told about what the collector does, so it can pass it on, e.g. to webhooks. Its methods
+// are called from the collector's goroutines and should return quickly.
+type Notifier interface {
+	// AlertsFired is called with the alerts that fired for the first time
+	AlertsFired(alerts []models.Alert)
+	// PostsSaved is called with every batch of posts saved by a poll, refresh or listing snapshot,
+	// new or refreshed; backfilled posts are history and aren't passed on
+	PostsSaved(posts []models.Post)
+	// CollectorError is called when a poll, refresh, listing snapshot or backfill page fails; source
+	// says which, such as poll/golang
+	CollectorError(source string, err error)
+}
+
+// WithNotifier sends the collector's alerts, saved posts and errors to n
+func WithNotifier(n Notifier) Option {
+	return func(c *Collector) {
+		c.notifier = n
+	}
+}
+
+// reportError passes a failure on to the notifier, if there is one
+func (c *Collector) reportError(source string, err error) {
+	if c.notifier != nil {
+		c.notifier.CollectorError(source, err)
+	}
+}
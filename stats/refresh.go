@@ -0,0 +1,147 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/api"
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// RefreshTier sets how often posts younger than MaxAge get their score refreshed
+type RefreshTier struct {
+	MaxAge   time.Duration
+	Interval time.Duration
+}
+
+// RefreshSchedule maps a post's age onto a refresh interval; tiers must be ordered by MaxAge.
+// Posts older than the last tier are no longer refreshed.
+type RefreshSchedule []RefreshTier
+
+// DefaultRefreshSchedule refreshes young posts often and tapers off over a week
+var DefaultRefreshSchedule = RefreshSchedule{
+	{MaxAge: time.Hour, Interval: 5 * time.Minute},
+	{MaxAge: 6 * time.Hour, Interval: 15 * time.Minute},
+	{MaxAge: 24 * time.Hour, Interval: time.Hour},
+	{MaxAge: 3 * 24 * time.Hour, Interval: 6 * time.Hour},
+	{MaxAge: 7 * 24 * time.Hour, Interval: 24 * time.Hour},
+}
+
+// NextRefresh returns when a post created at createdAt and observed at observedAt should be
+// refreshed next, or false if it is too old to refresh again
+func (s RefreshSchedule) NextRefresh(createdAt, observedAt time.Time) (time.Time, bool) {
+	age := observedAt.Sub(createdAt)
+	for _, tier := range s {
+		if age < tier.MaxAge {
+			return observedAt.Add(tier.Interval), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// WithScoreRefresh re-fetches stored posts through /api/info on an age-based schedule,
+// spending at most share (0-1) of the API request rate on it
+func WithScoreRefresh(share float64, schedule RefreshSchedule) Option {
+	return func(c *Collector) {
+		c.refreshShare = share
+		c.refreshSchedule = schedule
+	}
+}
+
+// budgetInterval returns how long to wait between requests to spend share of the API request rate
+func (c *Collector) budgetInterval(share float64) time.Duration {
+	rate := c.redditAPI.RequestRate() * share
+	if rate <= 0 {
+		return time.Minute
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// scheduleRefreshes records when just-observed posts should next be refreshed
+func (c *Collector) scheduleRefreshes(posts []models.Post) error {
+	if c.refreshShare <= 0 {
+		return nil
+	}
+
+	refreshes := make([]models.PostRefresh, 0, len(posts))
+	for _, post := range posts {
+		next, ok := c.refreshSchedule.NextRefresh(post.CreatedAt, post.ProcessedTime)
+		if !ok {
+			next = time.Time{}
+		}
+		refreshes = append(refreshes, models.PostRefresh{PostID: post.ID, LastRefreshed: post.ProcessedTime, NextRefresh: next})
+	}
+	return c.database.ScheduleRefreshes(refreshes)
+}
+
+// runScoreRefresh refreshes due posts, one /api/info batch at a time, until ctx is done
+func (c *Collector) runScoreRefresh(ctx context.Context) {
+	c.log.WithFields(logrus.Fields{
+		"budget_share": c.refreshShare,
+		"tiers":        len(c.refreshSchedule),
+	}).Info("Score refresh configured")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.budgetInterval(c.refreshShare)):
+			c.refreshScores(ctx)
+		}
+	}
+}
+
+// refreshScores re-fetches the most overdue batch of posts and saves their current scores
+func (c *Collector) refreshScores(ctx context.Context) {
+	ids, err := c.database.GetPostsDueForRefresh(time.Now(), api.MaxInfoBatch)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to get posts due for refresh")
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	fullnames := make([]string, 0, len(ids))
+	for _, id := range ids {
+		fullnames = append(fullnames, "t3_"+id)
+	}
+
+	start := time.Now()
+	posts, err := c.redditAPI.FetchInfo(ctx, fullnames)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.log.WithError(err).WithField("batch_size", len(ids)).Error("Failed to refresh post scores")
+			c.reportError("refresh", err)
+		}
+		return
+	}
+
+	// reddit leaves out posts it no longer knows about; stop refreshing them
+	returned := make(map[string]bool, len(posts))
+	for _, post := range posts {
+		returned[post.ID] = true
+	}
+	missing := make([]models.PostRefresh, 0)
+	for _, id := range ids {
+		if !returned[id] {
+			missing = append(missing, models.PostRefresh{PostID: id, LastRefreshed: start})
+		}
+	}
+	if err := c.database.ScheduleRefreshes(missing); err != nil {
+		c.log.WithError(err).WithField("missing", len(missing)).Error("Failed to stop refreshing missing posts")
+	}
+
+	if err := c.processPosts(ctx, posts); err != nil {
+		c.log.WithError(err).Error("Failed to process refreshed posts")
+		return
+	}
+
+	c.log.WithFields(logrus.Fields{
+		"requested":   len(ids),
+		"refreshed":   len(posts),
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Info("Refreshed post scores")
+}
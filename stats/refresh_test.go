@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func TestRefreshScheduleNextRefresh(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		age      time.Duration
+		interval time.Duration
+		ok       bool
+	}{
+		{name: "Brand new post", age: 10 * time.Minute, interval: 5 * time.Minute, ok: true},
+		{name: "A few hours old", age: 3 * time.Hour, interval: 15 * time.Minute, ok: true},
+		{name: "Half a day old", age: 12 * time.Hour, interval: time.Hour, ok: true},
+		{name: "Two days old", age: 48 * time.Hour, interval: 6 * time.Hour, ok: true},
+		{name: "Five days old", age: 5 * 24 * time.Hour, interval: 24 * time.Hour, ok: true},
+		{name: "Too old to refresh", age: 8 * 24 * time.Hour, ok: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			observed := created.Add(tc.age)
+			next, ok := DefaultRefreshSchedule.NextRefresh(created, observed)
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, observed.Add(tc.interval), next)
+			}
+		})
+	}
+}
+
+func TestRefreshScores(t *testing.T) {
+	c, fake, database := newTestCollector(t, []string{"golang"}, WithScoreRefresh(0.5, DefaultRefreshSchedule))
+	ctx := context.Background()
+
+	created := time.Now().Add(-10 * time.Minute)
+	post := models.Post{ID: "p1", Title: "post", Author: "alice", Subreddit: "golang",
+		CreatedUTC: float64(created.Unix()), Upvotes: 1, Score: 1}
+	fake.AddPosts("golang", post)
+
+	posts, _, err := c.redditAPI.FetchPosts(ctx, "golang", 100, "")
+	require.NoError(t, err)
+	require.NoError(t, c.processPosts(ctx, posts))
+
+	// nothing is due straight after the post was saved
+	due, err := database.GetPostsDueForRefresh(time.Now(), 100)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	// the post gains votes and its refresh comes due
+	post.Upvotes, post.Score = 250, 250
+	fake.UpdatePosts(post)
+	require.NoError(t, database.ScheduleRefresh("p1", created, time.Now().Add(-time.Second)))
+
+	c.refreshScores(ctx)
+
+	top, err := database.GetTopPostsByUpvotes(1)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, 250, top[0].Upvotes)
+	assert.Equal(t, 1, fake.RequestCount("/api/info"))
+
+	// and it's scheduled again, not due now
+	due, err = database.GetPostsDueForRefresh(time.Now(), 100)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+}
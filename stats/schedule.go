@@ -0,0 +1,416 @@
+package stats
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const (
+	DefaultMinPollInterval = 5 * time.Second
+	DefaultMaxPollInterval = 15 * time.Minute
+
+	schedulerTick     = time.Second      // how often the scheduler starts the polls that are due
+	rebalanceInterval = 10 * time.Second // how often the polling budget is split again
+	arrivalSmoothing  = 0.3              // weight of the latest measurement in the arrival rate estimate
+	arrivalSeedWindow = 24 * time.Hour   // stored posts in this window seed the arrival rate of a subreddit
+	quietArrivalRate  = 1.0 / 86400      // arrival rate floor (a post a day), so quiet subreddits keep a share
+)
+
+// WithPollSchedule sets how the new listings are polled. Each subreddit gets a share of the polling
+// budget proportional to its weight (1 unless it has a schedule) and is polled at most every
+// minInterval and at least every maxInterval; a schedule can override both bounds. In adaptive mode
+// the shares are also proportional to each subreddit's observed post arrival rate, and a subreddit
+// isn't polled more often than it gets a new post, so quiet subreddits back off to their max interval.
+func WithPollSchedule(schedules []models.SubredditSchedule, minInterval, maxInterval time.Duration, adaptive bool) Option {
+	return func(c *Collector) {
+		c.polls = newPollScheduler(schedules, minInterval, maxInterval, adaptive)
+	}
+}
+
+// pollState is the schedule of one subreddit
+type pollState struct {
+	subreddit    string
+	interval     time.Duration
+	arrivalRate  float64 // estimated new posts per second
+	arrivalKnown bool
+	lastPoll     time.Time // start of the last successful poll
+	nextPoll     time.Time
+	polled       bool // nextPoll was scheduled after a poll, rather than being the first poll
+	inFlight     bool
+}
+
+// pollScheduler decides when each subreddit's new listing is polled
+type pollScheduler struct {
+	mutex       sync.Mutex
+	schedules   map[string]models.SubredditSchedule // keyed by lowercase name
+	minInterval time.Duration
+	maxInterval time.Duration
+	adaptive    bool
+	states      map[string]*pollState // keyed by lowercase name
+}
+
+func newPollScheduler(schedules []models.SubredditSchedule, minInterval, maxInterval time.Duration, adaptive bool) *pollScheduler {
+	if minInterval <= 0 {
+		minInterval = DefaultMinPollInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = max(DefaultMaxPollInterval, minInterval)
+	}
+
+	p := &pollScheduler{
+		schedules:   make(map[string]models.SubredditSchedule, len(schedules)),
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		adaptive:    adaptive,
+		states:      make(map[string]*pollState),
+	}
+	for _, schedule := range schedules {
+		p.schedules[strings.ToLower(schedule.Subreddit)] = schedule
+	}
+	return p
+}
+
+// sync adds the subreddits that aren't scheduled yet, due right away, and forgets removed ones.
+// It returns the added subreddits.
+func (p *pollScheduler) sync(subreddits []string, initialInterval time.Duration, now time.Time) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	added := make([]string, 0)
+	tracked := make(map[string]bool, len(subreddits))
+	for _, sr := range subreddits {
+		key := strings.ToLower(sr)
+		tracked[key] = true
+		if p.states[key] == nil {
+			p.states[key] = &pollState{subreddit: sr, interval: initialInterval, nextPoll: now}
+			added = append(added, sr)
+		}
+	}
+
+	for key := range p.states {
+		if !tracked[key] {
+			delete(p.states, key)
+		}
+	}
+
+	return added
+}
+
+// seed sets the initial arrival rate estimate of a subreddit that hasn't been measured yet
+func (p *pollScheduler) seed(sr string, rate float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if st := p.states[strings.ToLower(sr)]; st != nil && !st.arrivalKnown {
+		st.arrivalRate = rate
+		st.arrivalKnown = true
+	}
+}
+
+// bounds returns the weight and the interval bounds, in seconds, of a subreddit
+func (p *pollScheduler) bounds(key string) (weight, lo, hi float64) {
+	schedule := p.schedules[key]
+
+	weight = schedule.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	minInterval, maxInterval := p.minInterval, p.maxInterval
+	if schedule.MinInterval > 0 {
+		minInterval = schedule.MinInterval
+	}
+	if schedule.MaxInterval > 0 {
+		maxInterval = schedule.MaxInterval
+	}
+
+	lo, hi = minInterval.Seconds(), maxInterval.Seconds()
+	return weight, lo, math.Max(lo, hi)
+}
+
+// rebalance splits rate (polls per second) among the subreddits in proportion to their demand,
+// keeping every interval within its bounds: subreddits held at their max interval take their polls out
+// of the budget first, and the polls saved by subreddits held at their min interval go to the others.
+// It reports whether any interval changed by more than a quarter.
+func (p *pollScheduler) rebalance(rate float64) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	type slot struct {
+		key            string
+		demand, lo, hi float64
+	}
+
+	// subreddits without a measured arrival rate are assumed to be average
+	fallbackArrival, known := 0.0, 0
+	for _, st := range p.states {
+		if st.arrivalKnown {
+			fallbackArrival += math.Max(st.arrivalRate, quietArrivalRate)
+			known++
+		}
+	}
+	if known > 0 {
+		fallbackArrival /= float64(known)
+	} else {
+		fallbackArrival = 1
+	}
+
+	free := make([]slot, 0, len(p.states))
+	for key, st := range p.states {
+		weight, lo, hi := p.bounds(key)
+		s := slot{key: key, demand: weight, lo: lo, hi: hi}
+		if p.adaptive {
+			arrival := fallbackArrival
+			if st.arrivalKnown {
+				arrival = math.Max(st.arrivalRate, quietArrivalRate)
+				// no point polling more often than a new post arrives
+				s.lo = math.Min(math.Max(s.lo, 1/arrival), s.hi)
+			}
+			s.demand *= arrival
+		}
+		free = append(free, s)
+	}
+
+	intervals := make(map[string]float64, len(free))
+	budget := rate
+	for len(free) > 0 {
+		total := 0.0
+		for _, s := range free {
+			total += s.demand
+		}
+		share := func(s slot) float64 {
+			if budget <= 0 {
+				return math.Inf(1)
+			}
+			return total / (budget * s.demand)
+		}
+
+		// max intervals are commitments, so they're settled before min intervals free up budget
+		rest := make([]slot, 0, len(free))
+		for _, s := range free {
+			if share(s) > s.hi {
+				intervals[s.key] = s.hi
+			} else {
+				rest = append(rest, s)
+			}
+		}
+		if len(rest) == len(free) {
+			rest = rest[:0]
+			for _, s := range free {
+				if share(s) < s.lo {
+					intervals[s.key] = s.lo
+				} else {
+					rest = append(rest, s)
+				}
+			}
+		}
+		if len(rest) == len(free) {
+			for _, s := range free {
+				intervals[s.key] = share(s)
+			}
+			break
+		}
+
+		for _, s := range free {
+			if interval, ok := intervals[s.key]; ok {
+				budget -= 1 / interval
+			}
+		}
+		free = rest
+	}
+
+	changed := false
+	for key, seconds := range intervals {
+		st := p.states[key]
+		interval := time.Duration(seconds * float64(time.Second))
+		if st.interval == 0 || math.Abs(float64(interval-st.interval)) > float64(st.interval/4) {
+			changed = true
+		}
+		// a scheduled poll moves with the interval, measured from when it was scheduled
+		if st.polled && !st.inFlight {
+			st.nextPoll = st.nextPoll.Add(interval - st.interval)
+		}
+		st.interval = interval
+	}
+
+	return changed
+}
+
+// due returns the subreddits whose next poll is due, earliest first, and marks them in flight
+func (p *pollScheduler) due(now time.Time) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	states := make([]*pollState, 0)
+	for _, st := range p.states {
+		if !st.inFlight && !now.Before(st.nextPoll) {
+			states = append(states, st)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].nextPoll.Before(states[j].nextPoll)
+	})
+
+	due := make([]string, 0, len(states))
+	for _, st := range states {
+		st.inFlight = true
+		due = append(due, st.subreddit)
+	}
+	return due
+}
+
+// interval returns the current polling interval of a subreddit
+func (p *pollScheduler) interval(sr string) time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if st := p.states[strings.ToLower(sr)]; st != nil && st.interval > 0 {
+		return st.interval
+	}
+	return p.minInterval
+}
+
+// observe updates the arrival rate estimate from a finished poll and schedules the next one.
+// New posts are measured against the previous successful poll, so a failed poll's posts are
+// counted by the next one that succeeds.
+func (p *pollScheduler) observe(record models.PollRecord) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	st := p.states[strings.ToLower(record.Subreddit)]
+	if st == nil {
+		return
+	}
+	st.inFlight = false
+	st.polled = true
+	st.nextPoll = time.Now().Add(st.interval)
+
+	if record.Error != "" {
+		return
+	}
+
+	// the first poll has nothing to measure against; everything on the page looks new
+	if elapsed := record.PolledAt.Sub(st.lastPoll).Seconds(); !st.lastPoll.IsZero() && elapsed > 0 {
+		measured := float64(record.NewPosts) / elapsed
+		if st.arrivalKnown {
+			st.arrivalRate = arrivalSmoothing*measured + (1-arrivalSmoothing)*st.arrivalRate
+		} else {
+			st.arrivalRate = measured
+			st.arrivalKnown = true
+		}
+	}
+	st.lastPoll = record.PolledAt
+}
+
+// status returns the schedule of every subreddit, sorted by name
+func (p *pollScheduler) status() []models.PollStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	statuses := make([]models.PollStatus, 0, len(p.states))
+	for key, st := range p.states {
+		weight, _, _ := p.bounds(key)
+		status := models.PollStatus{
+			Subreddit:   st.subreddit,
+			Weight:      weight,
+			IntervalSec: st.interval.Seconds(),
+			LastPoll:    st.lastPoll,
+			NextPoll:    st.nextPoll,
+		}
+		if st.arrivalKnown {
+			status.ArrivalPerHour = st.arrivalRate * 3600
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return strings.ToLower(statuses[i].Subreddit) < strings.ToLower(statuses[j].Subreddit)
+	})
+
+	return statuses
+}
+
+// PollSchedule returns the current polling schedule of every tracked subreddit
+func (c *Collector) PollSchedule() []models.PollStatus {
+	return c.polls.status()
+}
+
+// pollRequestRate returns the requests per second left for polling the new listings
+func (c *Collector) pollRequestRate() float64 {
+	// the API client adapts its rate to the budget left in the current rate limit period;
+	// leave room for the score refresh, backfills and listing snapshots
+	rate := c.redditAPI.RequestRate()*(1-c.refreshShare-c.backfillShare) - c.listingRequestRate()
+	if rate <= 0 {
+		rate = c.redditAPI.RequestRate() / 2
+	}
+	return rate
+}
+
+// syncPollSchedule schedules newly tracked subreddits and drops removed ones. In adaptive mode a
+// new subreddit's arrival rate is seeded from the posts already stored for it.
+func (c *Collector) syncPollSchedule() {
+	added := c.polls.sync(c.Subreddits(), c.pollingInterval, time.Now())
+	if !c.polls.adaptive {
+		return
+	}
+
+	for _, sr := range added {
+		count, err := c.database.CountPostsInRange(db.PostFilter{Subreddit: sr, Since: time.Now().Add(-arrivalSeedWindow)})
+		if err != nil {
+			c.log.WithError(err).WithField("subreddit", sr).Error("Failed to seed arrival rate")
+			continue
+		}
+		if count > 0 {
+			c.polls.seed(sr, float64(count)/arrivalSeedWindow.Seconds())
+		}
+	}
+}
+
+// rebalancePolling splits the polling budget among the tracked subreddits again, logging the new
+// schedule when it changed noticeably or force is set
+func (c *Collector) rebalancePolling(force bool) {
+	c.syncPollSchedule()
+
+	rate := c.pollRequestRate()
+	if !c.polls.rebalance(rate) && !force {
+		return
+	}
+
+	_, reset, used := c.redditAPI.GetRateLimitStatus()
+	intervals := make(map[string]float64)
+	for _, status := range c.polls.status() {
+		intervals[status.Subreddit] = math.Round(status.IntervalSec*10) / 10
+	}
+
+	c.log.WithFields(logrus.Fields{
+		"intervals_sec":       intervals,
+		"reset_countdown_sec": reset,
+		"used_requests":       used,
+		"adaptive":            c.polls.adaptive,
+		"target_req_per_sec":  rate,
+		"target_req_per_min":  rate * 60,
+		"subreddit_count":     len(intervals),
+	}).Info("Rebalanced polling schedule")
+}
+
+// pollDue starts a poll of every subreddit that is due; polls run concurrently and are tracked by wg
+func (c *Collector) pollDue(ctx context.Context, wg *sync.WaitGroup) {
+	for _, sr := range c.polls.due(time.Now()) {
+		wg.Add(1)
+		go func(sr string) {
+			defer wg.Done()
+
+			if err := c.fetchNewPosts(ctx, sr); err != nil && ctx.Err() == nil {
+				c.log.WithError(err).Error("Error while fetching and processing posts")
+				c.reportError("poll/"+sr, err)
+			}
+		}(sr)
+	}
+}
@@ -0,0 +1,139 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// intervals returns the polling interval of each scheduled subreddit in seconds
+func intervals(p *pollScheduler) map[string]float64 {
+	result := make(map[string]float64)
+	for _, status := range p.status() {
+		result[status.Subreddit] = status.IntervalSec
+	}
+	return result
+}
+
+func TestPollSchedulerWeights(t *testing.T) {
+	p := newPollScheduler([]models.SubredditSchedule{{Subreddit: "askreddit", Weight: 4}}, time.Second, time.Hour, false)
+	p.sync([]string{"AskReddit", "golang", "niche"}, time.Minute, time.Now())
+
+	// one poll per second, split 4:1:1
+	assert.True(t, p.rebalance(1))
+	got := intervals(p)
+	assert.InDelta(t, 1.5, got["AskReddit"], 0.01)
+	assert.InDelta(t, 6, got["golang"], 0.01)
+	assert.InDelta(t, 6, got["niche"], 0.01)
+
+	// nothing changed, so there's nothing to report
+	assert.False(t, p.rebalance(1))
+}
+
+func TestPollSchedulerBounds(t *testing.T) {
+	p := newPollScheduler([]models.SubredditSchedule{
+		{Subreddit: "AskReddit", Weight: 4, MinInterval: 3 * time.Second},
+		{Subreddit: "niche", Weight: 1, MaxInterval: 2 * time.Second},
+	}, time.Second, time.Hour, false)
+	p.sync([]string{"AskReddit", "golang", "niche"}, time.Minute, time.Now())
+
+	// niche must be polled every 2s, which leaves half a poll per second; AskReddit's 4:1 share of it
+	// would be faster than its 3s minimum, and golang gets what AskReddit leaves
+	p.rebalance(1)
+	got := intervals(p)
+	assert.InDelta(t, 2, got["niche"], 0.01)
+	assert.InDelta(t, 3, got["AskReddit"], 0.01)
+	assert.InDelta(t, 6, got["golang"], 0.01)
+
+	// without any budget, everything falls back to its max interval
+	p.rebalance(0)
+	got = intervals(p)
+	assert.InDelta(t, 2, got["niche"], 0.01)
+	assert.InDelta(t, 3600, got["golang"], 0.01)
+}
+
+func TestPollSchedulerAdaptive(t *testing.T) {
+	p := newPollScheduler(nil, time.Second, time.Minute, true)
+	start := time.Now().Add(-time.Hour)
+	p.sync([]string{"busy", "quiet"}, time.Minute, start)
+
+	// the first poll only sets the baseline
+	p.observe(models.PollRecord{Subreddit: "busy", PolledAt: start, NewPosts: 100})
+	p.observe(models.PollRecord{Subreddit: "quiet", PolledAt: start, NewPosts: 100})
+	p.observe(models.PollRecord{Subreddit: "busy", PolledAt: start.Add(100 * time.Second), NewPosts: 100})
+	p.observe(models.PollRecord{Subreddit: "quiet", PolledAt: start.Add(100 * time.Second), NewPosts: 1})
+
+	status := p.status()
+	require.Len(t, status, 2)
+	assert.InDelta(t, 3600, status[0].ArrivalPerHour, 0.01)
+	assert.InDelta(t, 36, status[1].ArrivalPerHour, 0.01)
+
+	// quiet would get 1/101 of the budget, but it's held at its 60s max interval
+	p.rebalance(1)
+	got := intervals(p)
+	assert.InDelta(t, 60, got["quiet"], 0.01)
+	assert.InDelta(t, 1/(1-1.0/60), got["busy"], 0.01)
+
+	// with budget to spare, a subreddit isn't polled more often than its posts arrive
+	p.rebalance(100)
+	got = intervals(p)
+	assert.InDelta(t, 1, got["busy"], 0.01)
+	assert.InDelta(t, 60, got["quiet"], 0.01)
+
+	// failed polls don't count; the next success measures against the last successful poll
+	p.observe(models.PollRecord{Subreddit: "quiet", PolledAt: start.Add(200 * time.Second), Error: "boom"})
+	p.observe(models.PollRecord{Subreddit: "quiet", PolledAt: start.Add(300 * time.Second), NewPosts: 20})
+	status = p.status()
+	assert.InDelta(t, 0.7*36+0.3*360, status[1].ArrivalPerHour, 0.01)
+}
+
+func TestPollSchedulerDue(t *testing.T) {
+	p := newPollScheduler(nil, time.Second, time.Hour, false)
+	now := time.Now()
+	p.sync([]string{"golang", "news"}, time.Minute, now)
+	p.rebalance(1)
+
+	// new subreddits are due straight away, and aren't handed out again while in flight
+	assert.ElementsMatch(t, []string{"golang", "news"}, p.due(now))
+	assert.Empty(t, p.due(now))
+
+	p.observe(models.PollRecord{Subreddit: "golang", PolledAt: now})
+	assert.Empty(t, p.due(time.Now()))
+	assert.Equal(t, []string{"golang"}, p.due(time.Now().Add(3*time.Second)))
+
+	// removed subreddits are forgotten, and late results for them are ignored
+	p.sync([]string{"golang"}, time.Minute, now)
+	p.observe(models.PollRecord{Subreddit: "news", PolledAt: now})
+	require.Len(t, p.status(), 1)
+	assert.Equal(t, "golang", p.status()[0].Subreddit)
+}
+
+func TestSyncPollScheduleSeedsArrivalRate(t *testing.T) {
+	c, _, database := newTestCollector(t, []string{"golang", "empty"},
+		WithPollSchedule(nil, time.Second, time.Hour, true))
+
+	now := time.Now()
+	for i := 0; i < 24; i++ {
+		require.NoError(t, database.SavePost(&models.Post{
+			ID:            "s" + string(rune('a'+i)),
+			Author:        "alice",
+			Subreddit:     "golang",
+			CreatedUTC:    float64(now.Add(-time.Duration(i) * time.Hour).Unix()),
+			CreatedAt:     now.Add(-time.Duration(i) * time.Hour),
+			ProcessedTime: now,
+		}))
+	}
+
+	c.syncPollSchedule()
+
+	status := c.PollSchedule()
+	require.Len(t, status, 2)
+	assert.Equal(t, "empty", status[0].Subreddit)
+	assert.Zero(t, status[0].ArrivalPerHour)
+	assert.Equal(t, "golang", status[1].Subreddit)
+	assert.InDelta(t, 1, status[1].ArrivalPerHour, 0.01)
+}
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+var (
+	// ErrInvalidSubreddit is returned when a subreddit name isn't valid
+	ErrInvalidSubreddit = errors.New("invalid subreddit name")
+	// ErrSubredditTracked is returned when adding a subreddit that is already tracked
+	ErrSubredditTracked = errors.New("subreddit is already tracked")
+	// ErrSubredditNotTracked is returned when removing a subreddit that isn't tracked
+	ErrSubredditNotTracked = errors.New("subreddit is not tracked")
+)
+
+// subredditName matches reddit's subreddit names: letters, digits and underscores, up to 21 characters
+var subredditName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_]{1,20}$`)
+
+// NormalizeSubreddit trims a subreddit name and its optional r/ prefix, and checks that it's valid
+func NormalizeSubreddit(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "r/")
+	if !subredditName.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSubreddit, name)
+	}
+	return name, nil
+}
+
+// loadSubreddits applies the subreddits added and removed at runtime, which are persisted in the
+// database, on top of the configured ones. A removal wins over the configuration until the
+// subreddit is added again.
+func (c *Collector) loadSubreddits() error {
+	c.subredditsMutex.Lock()
+	defer c.subredditsMutex.Unlock()
+
+	changes, err := c.database.GetTrackedSubreddits()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	for _, change := range changes {
+		i := indexFold(c.subreddits, change.Name)
+		switch {
+		case change.Tracked && i < 0:
+			c.subreddits = append(c.subreddits, change.Name)
+		case !change.Tracked && i >= 0:
+			c.subreddits = append(c.subreddits[:i:i], c.subreddits[i+1:]...)
+		}
+	}
+	subreddits := append([]string(nil), c.subreddits...)
+	c.mutex.Unlock()
+
+	if len(changes) > 0 {
+		c.log.WithField("subreddits", subreddits).Info("Applied persisted subreddit changes")
+	}
+	return nil
+}
+
+// AddSubreddit starts tracking a subreddit. It's polled from the next poll on, and the polling
+// interval is rebalanced to share the request rate with it.
+func (c *Collector) AddSubreddit(name string) (string, error) {
+	name, err := NormalizeSubreddit(name)
+	if err != nil {
+		return "", err
+	}
+
+	c.subredditsMutex.Lock()
+	defer c.subredditsMutex.Unlock()
+
+	if indexFold(c.Subreddits(), name) >= 0 {
+		return "", fmt.Errorf("%w: %s", ErrSubredditTracked, name)
+	}
+
+	if err := c.database.SaveTrackedSubreddit(models.TrackedSubreddit{Name: name, Tracked: true, UpdatedAt: time.Now()}); err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	c.subreddits = append(c.subreddits, name)
+	c.mutex.Unlock()
+
+	c.log.WithField("subreddit", name).Info("Started tracking subreddit")
+	c.notifySubredditsChanged()
+
+	return name, nil
+}
+
+// RemoveSubreddit stops tracking a subreddit. Its posts stay in the database, and its frontier is
+// kept so that tracking it again picks up where it left off.
+func (c *Collector) RemoveSubreddit(name string) (string, error) {
+	c.subredditsMutex.Lock()
+	defer c.subredditsMutex.Unlock()
+
+	c.mutex.Lock()
+	i := indexFold(c.subreddits, strings.TrimPrefix(strings.TrimSpace(name), "r/"))
+	if i < 0 {
+		c.mutex.Unlock()
+		return "", fmt.Errorf("%w: %s", ErrSubredditNotTracked, name)
+	}
+	name = c.subreddits[i]
+	c.mutex.Unlock()
+
+	if err := c.database.SaveTrackedSubreddit(models.TrackedSubreddit{Name: name, Tracked: false, UpdatedAt: time.Now()}); err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	if i := indexFold(c.subreddits, name); i >= 0 {
+		c.subreddits = append(c.subreddits[:i:i], c.subreddits[i+1:]...)
+	}
+	delete(c.frontiers, name)
+	c.mutex.Unlock()
+
+	c.log.WithField("subreddit", name).Info("Stopped tracking subreddit")
+	c.notifySubredditsChanged()
+
+	return name, nil
+}
+
+// notifySubredditsChanged asks the polling loop to rebalance the request rate; a pending
+// notification already covers this change
+func (c *Collector) notifySubredditsChanged() {
+	select {
+	case c.subredditsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// indexFold returns the index of name in subreddits, ignoring case, or -1
+func indexFold(subreddits []string, name string) int {
+	for i, sr := range subreddits {
+		if strings.EqualFold(sr, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSubreddit(t *testing.T) {
+	for input, want := range map[string]string{
+		"golang":        "golang",
+		" r/AskReddit ": "AskReddit",
+		"/r/de":         "de",
+	} {
+		got, err := NormalizeSubreddit(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got)
+	}
+
+	for _, input := range []string{"", "r/", "a", "has space", "semi;colon", "_leading", "waytoolongforasubredditname"} {
+		_, err := NormalizeSubreddit(input)
+		assert.ErrorIs(t, err, ErrInvalidSubreddit, input)
+	}
+}
+
+func TestRuntimeSubreddits(t *testing.T) {
+	c, _, database := newTestCollector(t, []string{"golang", "news"})
+
+	name, err := c.AddSubreddit("r/rust")
+	require.NoError(t, err)
+	assert.Equal(t, "rust", name)
+
+	_, err = c.AddSubreddit("RUST")
+	assert.ErrorIs(t, err, ErrSubredditTracked)
+
+	_, err = c.AddSubreddit("not a subreddit")
+	assert.ErrorIs(t, err, ErrInvalidSubreddit)
+
+	name, err = c.RemoveSubreddit("GoLang")
+	require.NoError(t, err)
+	assert.Equal(t, "golang", name)
+
+	_, err = c.RemoveSubreddit("golang")
+	assert.ErrorIs(t, err, ErrSubredditNotTracked)
+
+	assert.Equal(t, []string{"news", "rust"}, c.Subreddits())
+
+	// both changes were signalled, but only one rebalance is pending
+	assert.Len(t, c.subredditsChanged, 1)
+
+	// a restart with the same configuration keeps the runtime changes
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	restarted := NewCollector(c.redditAPI, database, []string{"golang", "news"}, 1, log)
+	require.NoError(t, restarted.loadSubreddits())
+	assert.Equal(t, []string{"news", "rust"}, restarted.Subreddits())
+
+	// adding a removed subreddit back tracks it again after the next restart too
+	_, err = restarted.AddSubreddit("golang")
+	require.NoError(t, err)
+
+	restarted = NewCollector(c.redditAPI, database, []string{"golang", "news"}, 1, log)
+	require.NoError(t, restarted.loadSubreddits())
+	assert.ElementsMatch(t, []string{"golang", "news", "rust"}, restarted.Subreddits())
+}
@@ -0,0 +1,241 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const (
+	defaultTrendingLimit = 10
+	trendingMaxPostAge   = 48 * time.Hour  // older posts aren't "trending now" no matter how fast they grow
+	minVelocitySpan      = 5 * time.Minute // floor for the time between observations, so one lucky poll doesn't dominate
+	commentWeight        = 2.0             // a comment counts as much as two points of score in the trend score
+	ageGravity           = 0.5             // how strongly the trend score favours younger posts
+)
+
+// DefaultTrendingWindows is used when no windows are configured
+var DefaultTrendingWindows = []time.Duration{time.Hour}
+
+// WithTrending sets the windows over which trending velocity can be computed; the first window is
+// the one reported in the statistics. limit is the number of trending posts reported per list.
+func WithTrending(windows []time.Duration, limit int) Option {
+	return func(c *Collector) {
+		if len(windows) > 0 {
+			c.trends = newTrendTracker(windows)
+		}
+		if limit > 0 {
+			c.trendingLimit = limit
+		}
+	}
+}
+
+// observation is the score and comment count of a post at one point in time
+type observation struct {
+	at       time.Time
+	score    int
+	comments int
+}
+
+// trendEntry holds the latest version of a post and its recent observations, oldest first
+type trendEntry struct {
+	post         models.Post
+	observations []observation
+}
+
+// trendTracker keeps recent observations of posts in memory to compute their velocity
+type trendTracker struct {
+	mutex     sync.Mutex
+	windows   []time.Duration
+	retention time.Duration // longest window; older observations are dropped
+	entries   map[string]*trendEntry
+}
+
+func newTrendTracker(windows []time.Duration) *trendTracker {
+	retention := time.Duration(0)
+	for _, window := range windows {
+		if window > retention {
+			retention = window
+		}
+	}
+
+	return &trendTracker{
+		windows:   windows,
+		retention: retention,
+		entries:   make(map[string]*trendEntry),
+	}
+}
+
+// defaultWindow is the window reported in the statistics
+func (t *trendTracker) defaultWindow() time.Duration {
+	return t.windows[0]
+}
+
+// observe records the current score and comment count of a post
+func (t *trendTracker) observe(post models.Post) {
+	at := post.ProcessedTime
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry, ok := t.entries[post.ID]
+	if !ok {
+		entry = &trendEntry{}
+		t.entries[post.ID] = entry
+	}
+	entry.post = post
+
+	obs := observation{at: at, score: post.Score, comments: post.NumComments}
+	if n := len(entry.observations); n > 0 {
+		last := entry.observations[n-1]
+		if !at.After(last.at) {
+			return
+		}
+		if last.score == obs.score && last.comments == obs.comments && n > 1 {
+			// nothing changed; slide the latest observation forward instead of growing the list
+			entry.observations[n-1] = obs
+			return
+		}
+	}
+	entry.observations = append(entry.observations, obs)
+}
+
+// prune drops observations older than the retention period, keeping one as a baseline,
+// and forgets posts that haven't been observed within it
+func (t *trendTracker) prune(now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	cutoff := now.Add(-t.retention)
+	for id, entry := range t.entries {
+		obs := entry.observations
+		if len(obs) == 0 || obs[len(obs)-1].at.Before(cutoff) {
+			delete(t.entries, id)
+			continue
+		}
+
+		keepFrom := 0
+		for i := range obs {
+			if obs[i].at.Before(cutoff) {
+				keepFrom = i
+			}
+		}
+		entry.observations = obs[keepFrom:]
+	}
+}
+
+// trending ranks posts (optionally of one subreddit) by trend score over the given window
+func (t *trendTracker) trending(now time.Time, window time.Duration, subreddit string, limit int) []models.TrendingPost {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	cutoff := now.Add(-window)
+	results := make([]models.TrendingPost, 0)
+	for _, entry := range t.entries {
+		if subreddit != "" && !strings.EqualFold(entry.post.Subreddit, subreddit) {
+			continue
+		}
+
+		if trend, ok := velocity(entry, cutoff, now); ok {
+			results = append(results, trend)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].TrendScore != results[j].TrendScore {
+			return results[i].TrendScore > results[j].TrendScore
+		}
+		return results[i].Post.ID < results[j].Post.ID
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// velocity computes a post's growth since the first observation at or before the window start.
+// Posts seen only once inside the window are measured from their creation, when score and comments were ~0.
+func velocity(entry *trendEntry, cutoff, now time.Time) (models.TrendingPost, bool) {
+	obs := entry.observations
+	if len(obs) == 0 {
+		return models.TrendingPost{}, false
+	}
+
+	latest := obs[len(obs)-1]
+	if latest.at.Before(cutoff) {
+		return models.TrendingPost{}, false
+	}
+
+	created := time.Unix(int64(entry.post.CreatedUTC), 0)
+	age := now.Sub(created)
+	if age > trendingMaxPostAge {
+		return models.TrendingPost{}, false
+	}
+
+	// latest observation at or before the window start, or the first one inside it
+	base := obs[0]
+	for _, o := range obs {
+		if o.at.After(cutoff) {
+			break
+		}
+		base = o
+	}
+
+	var scoreGain, commentGain float64
+	var span time.Duration
+	if base.at.Equal(latest.at) {
+		scoreGain = float64(latest.score)
+		commentGain = float64(latest.comments)
+		span = latest.at.Sub(created)
+	} else {
+		scoreGain = float64(latest.score - base.score)
+		commentGain = float64(latest.comments - base.comments)
+		span = latest.at.Sub(base.at)
+	}
+	if span < minVelocitySpan {
+		span = minVelocitySpan
+	}
+
+	hours := span.Hours()
+	ageHours := math.Max(age.Hours(), 0)
+	scoreVelocity := scoreGain / hours
+	commentVelocity := commentGain / hours
+
+	return models.TrendingPost{
+		Post:            entry.post,
+		ScoreVelocity:   scoreVelocity,
+		CommentVelocity: commentVelocity,
+		AgeHours:        ageHours,
+		TrendScore:      (scoreVelocity + commentWeight*commentVelocity) / math.Pow(ageHours+2, ageGravity),
+	}, true
+}
+
+// DefaultTrendingWindow returns the window reported in the statistics
+func (c *Collector) DefaultTrendingWindow() time.Duration {
+	return c.trends.defaultWindow()
+}
+
+// GetTrending returns the fastest growing posts over the given window, for one subreddit or
+// all of them when subreddit is empty. A zero window uses the default window.
+func (c *Collector) GetTrending(subreddit string, window time.Duration, limit int) ([]models.TrendingPost, error) {
+	if window == 0 {
+		window = c.trends.defaultWindow()
+	}
+	if window < 0 || window > c.trends.retention {
+		return nil, fmt.Errorf("window must be positive and at most %s", c.trends.retention)
+	}
+	if limit <= 0 {
+		limit = c.trendingLimit
+	}
+
+	return c.trends.trending(time.Now(), window, subreddit, limit), nil
+}
@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+func TestTrendTrackerVelocity(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newTrendTracker([]time.Duration{time.Hour, 6 * time.Hour})
+
+	observe := func(id, subreddit string, created time.Time, at time.Time, score, comments int) {
+		tracker.observe(models.Post{ID: id, Subreddit: subreddit, CreatedUTC: float64(created.Unix()),
+			Score: score, NumComments: comments, ProcessedTime: at})
+	}
+
+	// fast gains 300 score and 30 comments in the last hour
+	fastCreated := now.Add(-3 * time.Hour)
+	observe("fast", "golang", fastCreated, now.Add(-2*time.Hour), 50, 5)
+	observe("fast", "golang", fastCreated, now.Add(-time.Hour), 100, 10)
+	observe("fast", "golang", fastCreated, now, 400, 40)
+
+	// slow has a higher score but barely moves
+	slowCreated := now.Add(-5 * time.Hour)
+	observe("slow", "rust", slowCreated, now.Add(-time.Hour), 1000, 100)
+	observe("slow", "rust", slowCreated, now, 1010, 101)
+
+	// seen once; measured from its creation 30 minutes ago
+	newCreated := now.Add(-30 * time.Minute)
+	observe("new", "golang", newCreated, now, 60, 0)
+
+	// too old to be trending
+	observe("old", "golang", now.Add(-72*time.Hour), now, 5000, 500)
+
+	trending := tracker.trending(now, time.Hour, "", 10)
+	require.Len(t, trending, 3)
+	assert.Equal(t, "fast", trending[0].Post.ID)
+	assert.InDelta(t, 300, trending[0].ScoreVelocity, 0.001)
+	assert.InDelta(t, 30, trending[0].CommentVelocity, 0.001)
+	assert.InDelta(t, 3, trending[0].AgeHours, 0.001)
+	assert.Equal(t, "new", trending[1].Post.ID)
+	assert.InDelta(t, 120, trending[1].ScoreVelocity, 0.001)
+	assert.Equal(t, "slow", trending[2].Post.ID)
+
+	// over 6 hours, fast is measured from its first observation
+	trending = tracker.trending(now, 6*time.Hour, "golang", 1)
+	require.Len(t, trending, 1)
+	assert.Equal(t, "fast", trending[0].Post.ID)
+	assert.InDelta(t, 175, trending[0].ScoreVelocity, 0.001)
+
+	// posts not observed within the longest window are forgotten
+	tracker.prune(now.Add(7 * time.Hour))
+	assert.Empty(t, tracker.entries)
+}
+
+func TestGetTrendingWindow(t *testing.T) {
+	c, _, _ := newTestCollector(t, []string{"golang"}, WithTrending([]time.Duration{30 * time.Minute, 2 * time.Hour}, 5))
+
+	assert.Equal(t, 30*time.Minute, c.DefaultTrendingWindow())
+
+	_, err := c.GetTrending("", 2*time.Hour, 0)
+	assert.NoError(t, err)
+
+	_, err = c.GetTrending("", 3*time.Hour, 0)
+	assert.Error(t, err)
+}
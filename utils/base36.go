@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Reddit ids are sequential base36 numbers, so a larger id means a newer thing
+
+// ParseBase36ID parses a reddit id, with or without a kind prefix such as t3_
+func ParseBase36ID(id string) (uint64, error) {
+	if _, rest, ok := strings.Cut(id, "_"); ok {
+		id = rest
+	}
+
+	n, err := strconv.ParseUint(strings.ToLower(id), 36, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid base36 id %q: %w", id, err)
+	}
+	return n, nil
+}
+
+// FormatBase36ID formats a number as a reddit id
+func FormatBase36ID(n uint64) string {
+	return strconv.FormatUint(n, 36)
+}
+
+// CompareIDs orders two reddit ids numerically, returning -1, 0 or 1. Ids that aren't valid
+// base36 sort before valid ones so they never become a frontier.
+func CompareIDs(a, b string) int {
+	na, errA := ParseBase36ID(a)
+	nb, errB := ParseBase36ID(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	}
+	return 0
+}
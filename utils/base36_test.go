@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBase36ID(t *testing.T) {
+	n, err := ParseBase36ID("1abc2z")
+	require.NoError(t, err)
+	assert.Equal(t, "1abc2z", FormatBase36ID(n))
+
+	prefixed, err := ParseBase36ID("t3_1abc2z")
+	require.NoError(t, err)
+	assert.Equal(t, n, prefixed)
+
+	_, err = ParseBase36ID("not-an-id")
+	assert.Error(t, err)
+}
+
+func TestCompareIDs(t *testing.T) {
+	assert.Equal(t, -1, CompareIDs("zz", "100"))
+	assert.Equal(t, 1, CompareIDs("1abc30", "1abc2z"))
+	assert.Equal(t, 0, CompareIDs("t3_abc", "abc"))
+	assert.Equal(t, -1, CompareIDs("", "a"))
+}
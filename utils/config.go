@@ -6,9 +6,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
 )
 
 // Config holds all configuration for the application
@@ -17,6 +20,9 @@ type Config struct {
 	Reddit   RedditConfig   
 	Database DatabaseConfig 
 	Server   ServerConfig   
+	Pipeline PipelineConfig
+	Alerts   AlertsConfig
+	Webhooks WebhooksConfig
 }
 
 // AppConfig holds application-level configuration
@@ -33,16 +39,68 @@ type RedditConfig struct {
 	Subreddits           []string
 	PollingInterval      int
 	MaxRequestsPerMinute int // value is per minute, multiply by 10 for 10-minute rate
+
+	SubredditSchedules []models.SubredditSchedule // per-subreddit polling weights and interval bounds
+	MinPollInterval    int                        // seconds; no subreddit is polled more often than this
+	MaxPollInterval    int                        // seconds; every subreddit is polled at least this often
+	AdaptivePolling    bool                       // split the polling budget by observed post arrival rate too
+
+	CommentRefreshInterval int // seconds between comment refreshes; 0 disables comment fetching
+	CommentPostsPerRefresh int // max posts whose comments are fetched per refresh
+	CommentMaxPostAgeHours int // only posts younger than this get their comments refreshed
+
+	Listings            []models.Feed // extra listings (hot, top, ...) to snapshot; new is always polled
+	ListingPollInterval int           // seconds between listing snapshots
+
+	RefreshBudgetShare float64 // share (0-1) of the request rate spent refreshing stored post scores; 0 disables
+
+	BackfillBudgetShare      float64 // share (0-1) of the request rate spent on backfill jobs; 0 disables them
+	BackfillMaxPages         int     // page limit of backfill jobs that don't set their own
+	BackfillNewSubredditDays int     // days of history to backfill for a newly tracked subreddit; 0 disables
+
+	WriteWorkers int // batches of posts processed at once
+
+	TrendingWindows []time.Duration // windows over which trending velocity is computed; the first is the default
+	TrendingLimit   int             // number of trending posts reported per list
 }
 
 // DatabaseConfig holds database configuration
 type DatabaseConfig struct {
-	Path string 
+	Driver string // sqlite3, postgres or memory
+	Path   string // SQLite database file
+	URL    string // PostgreSQL connection URL; the server must be 12 or later and built with ICU
+}
+
+// DSN returns what the driver connects to: the URL for postgres, otherwise the file path
+func (c DatabaseConfig) DSN() string {
+	if c.Driver == "postgres" {
+		return c.URL
+	}
+	return c.Path
+}
+
+// PipelineConfig holds the post processing chain configuration
+type PipelineConfig struct {
+	Processors []string // names of the processors every batch of posts runs through, in order
+	JSONLPath  string   // file the jsonl_sink processor appends posts to
+}
+
+// AlertsConfig holds the alert rules, loaded from a JSON file
+type AlertsConfig struct {
+	RulesFile string             // empty disables alerts
+	Rules     []models.AlertRule // evaluated against every saved post
+}
+
+// WebhooksConfig holds the webhooks events are sent to, loaded from a JSON file
+type WebhooksConfig struct {
+	File     string // empty disables webhooks
+	Webhooks []models.Webhook
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	Port int 
+	Port       int
+	AdminToken string // bearer token required by the endpoints that change state; empty disables them
 }
 
 // LoadConfig loads configuration from .env file
@@ -57,6 +115,33 @@ func LoadConfig(envPath string, log *logrus.Logger) (*Config, error) {
 	
 	subredditsStr := getEnv("REDDIT_SUBREDDITS", "golang")
 	subreddits := parseSubreddits(subredditsStr)
+
+	listings, err := parseFeeds(getEnv("REDDIT_LISTINGS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDDIT_LISTINGS: %w", err)
+	}
+
+	schedules, err := parseSchedules(getEnv("REDDIT_SUBREDDIT_WEIGHTS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDDIT_SUBREDDIT_WEIGHTS: %w", err)
+	}
+
+	alertRulesFile := getEnv("ALERT_RULES_FILE", "")
+	alertRules, err := loadAlertRules(alertRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALERT_RULES_FILE: %w", err)
+	}
+
+	webhooksFile := getEnv("WEBHOOKS_FILE", "")
+	webhooks, err := loadWebhooks(webhooksFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOKS_FILE: %w", err)
+	}
+
+	trendingWindows, err := parseDurations(getEnv("TRENDING_WINDOWS", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRENDING_WINDOWS: %w", err)
+	}
 	
 	// Create config object
 	config := &Config{
@@ -71,12 +156,50 @@ func LoadConfig(envPath string, log *logrus.Logger) (*Config, error) {
 			Subreddits:           subreddits,
 			PollingInterval:      getEnvAsInt("REDDIT_POLLING_INTERVAL", 60),
 			MaxRequestsPerMinute: getEnvAsInt("REDDIT_MAX_REQUESTS_PER_MINUTE", 100),
+
+			SubredditSchedules: schedules,
+			MinPollInterval:    getEnvAsInt("REDDIT_MIN_POLL_INTERVAL", 5),
+			MaxPollInterval:    getEnvAsInt("REDDIT_MAX_POLL_INTERVAL", 900),
+			AdaptivePolling:    getEnvAsBool("REDDIT_ADAPTIVE_POLLING", false),
+
+			CommentRefreshInterval: getEnvAsInt("REDDIT_COMMENT_REFRESH_INTERVAL", 60),
+			CommentPostsPerRefresh: getEnvAsInt("REDDIT_COMMENT_POSTS_PER_REFRESH", 5),
+			CommentMaxPostAgeHours: getEnvAsInt("REDDIT_COMMENT_MAX_POST_AGE_HOURS", 24),
+
+			Listings:            listings,
+			ListingPollInterval: getEnvAsInt("REDDIT_LISTING_POLL_INTERVAL", 300),
+
+			RefreshBudgetShare: getEnvAsFloat("REDDIT_REFRESH_BUDGET_SHARE", 0.2),
+
+			BackfillBudgetShare:      getEnvAsFloat("BACKFILL_BUDGET_SHARE", 0.1),
+			BackfillMaxPages:         getEnvAsInt("BACKFILL_MAX_PAGES", 50),
+			BackfillNewSubredditDays: getEnvAsInt("BACKFILL_NEW_SUBREDDIT_DAYS", 0),
+
+			WriteWorkers: getEnvAsInt("WRITE_WORKERS", 2),
+
+			TrendingWindows: trendingWindows,
+			TrendingLimit:   getEnvAsInt("TRENDING_LIMIT", 10),
 		},
 		Database: DatabaseConfig{
-			Path: getEnv("DATABASE_PATH", "./reddit.db"),
+			Driver: getEnv("DATABASE_DRIVER", "sqlite3"),
+			Path:   getEnv("DATABASE_PATH", "./reddit.db"),
+			URL:    getEnv("DATABASE_URL", ""),
 		},
 		Server: ServerConfig{
-			Port: getEnvAsInt("SERVER_PORT", 8080),
+			Port:       getEnvAsInt("SERVER_PORT", 8080),
+			AdminToken: getEnv("ADMIN_TOKEN", ""),
+		},
+		Pipeline: PipelineConfig{
+			Processors: parseList(getEnv("POST_PROCESSORS", "")),
+			JSONLPath:  getEnv("JSONL_SINK_PATH", "./posts.jsonl"),
+		},
+		Alerts: AlertsConfig{
+			RulesFile: alertRulesFile,
+			Rules:     alertRules,
+		},
+		Webhooks: WebhooksConfig{
+			File:     webhooksFile,
+			Webhooks: webhooks,
 		},
 	}
 	
@@ -110,6 +233,103 @@ func parseSubreddits(subredditsStr string) []string {
 	return subreddits
 }
 
+// parseList parses a comma-separated list, skipping empty entries
+func parseList(listStr string) []string {
+	var items []string
+	for _, part := range strings.Split(listStr, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			items = append(items, trimmed)
+		}
+	}
+	return items
+}
+
+// parseFeeds parses a comma-separated list of subreddit:listing[/time] feeds
+func parseFeeds(feedsStr string) ([]models.Feed, error) {
+	feeds := make([]models.Feed, 0)
+	for _, part := range strings.Split(feedsStr, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		feed, err := models.ParseFeed(part)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, nil
+}
+
+// parseSchedules parses a comma-separated list of subreddit:weight[:min[:max]] polling schedules
+func parseSchedules(schedulesStr string) ([]models.SubredditSchedule, error) {
+	schedules := make([]models.SubredditSchedule, 0)
+	for _, part := range strings.Split(schedulesStr, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		schedule, err := models.ParseSubredditSchedule(part)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// loadAlertRules reads the JSON array of alert rules in path; an empty path means no rules
+func loadAlertRules(path string) ([]models.AlertRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+
+	return models.ParseAlertRules(data)
+}
+
+// loadWebhooks reads the JSON array of webhooks in path; an empty path means no webhooks
+func loadWebhooks(path string) ([]models.Webhook, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+
+	return models.ParseWebhooks(data)
+}
+
+// parseDurations parses a comma-separated list of durations such as 15m,1h,6h
+func parseDurations(durationsStr string) ([]time.Duration, error) {
+	durations := make([]time.Duration, 0)
+	for _, part := range strings.Split(durationsStr, ",") {
+		trimmed := strings.TrimSpace(part)
+		if trimmed == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration %q must be positive", trimmed)
+		}
+		durations = append(durations, d)
+	}
+
+	return durations, nil
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -128,6 +348,24 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsFloat gets an environment variable as a float or returns a default value
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsBool gets an environment variable as a boolean or returns a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 // validateConfig validates the configuration
 func validateConfig(config *Config) error {
 	// Check Reddit API credentials
@@ -148,14 +386,75 @@ func validateConfig(config *Config) error {
 	if config.Reddit.PollingInterval < 1 {
 		return fmt.Errorf("REDDIT_POLLING_INTERVAL must be positive")
 	}
+	if config.Reddit.MinPollInterval < 0 || config.Reddit.MaxPollInterval < 0 {
+		return fmt.Errorf("REDDIT_MIN_POLL_INTERVAL and REDDIT_MAX_POLL_INTERVAL must not be negative")
+	}
+	if config.Reddit.MaxPollInterval > 0 && config.Reddit.MaxPollInterval < config.Reddit.MinPollInterval {
+		return fmt.Errorf("REDDIT_MAX_POLL_INTERVAL must be at least REDDIT_MIN_POLL_INTERVAL")
+	}
+	if len(config.Reddit.Listings) > 0 && config.Reddit.ListingPollInterval < 1 {
+		return fmt.Errorf("REDDIT_LISTING_POLL_INTERVAL must be positive when REDDIT_LISTINGS is set")
+	}
+	if config.Reddit.RefreshBudgetShare < 0 || config.Reddit.RefreshBudgetShare >= 1 {
+		return fmt.Errorf("REDDIT_REFRESH_BUDGET_SHARE must be at least 0 and less than 1")
+	}
+	if config.Reddit.BackfillBudgetShare < 0 || config.Reddit.BackfillBudgetShare >= 1 {
+		return fmt.Errorf("BACKFILL_BUDGET_SHARE must be at least 0 and less than 1")
+	}
+	if config.Reddit.RefreshBudgetShare+config.Reddit.BackfillBudgetShare >= 1 {
+		return fmt.Errorf("REDDIT_REFRESH_BUDGET_SHARE and BACKFILL_BUDGET_SHARE must add up to less than 1")
+	}
+	if config.Reddit.BackfillNewSubredditDays < 0 {
+		return fmt.Errorf("BACKFILL_NEW_SUBREDDIT_DAYS must not be negative")
+	}
+	if config.Reddit.WriteWorkers < 0 {
+		return fmt.Errorf("WRITE_WORKERS must not be negative")
+	}
+	if err := validateWebhookRules(config.Webhooks.Webhooks, config.Alerts.Rules); err != nil {
+		return err
+	}
+	if config.Reddit.CommentRefreshInterval < 0 {
+		return fmt.Errorf("REDDIT_COMMENT_REFRESH_INTERVAL must not be negative")
+	}
+	if config.Reddit.CommentRefreshInterval > 0 && config.Reddit.CommentPostsPerRefresh < 1 {
+		return fmt.Errorf("REDDIT_COMMENT_POSTS_PER_REFRESH must be positive when comment refresh is enabled")
+	}
+	switch config.Database.Driver {
+	case "", "sqlite3", "memory":
+	case "postgres":
+		if config.Database.URL == "" {
+			return fmt.Errorf("DATABASE_URL is required when DATABASE_DRIVER is postgres")
+		}
+	default:
+		return fmt.Errorf("DATABASE_DRIVER must be sqlite3, postgres or memory, not %q", config.Database.Driver)
+	}
 	
 	// if we are storing the db in a nested directory, create the directory
+	sqlite := config.Database.Driver == "" || config.Database.Driver == "sqlite3"
 	dbDir := filepath.Dir(config.Database.Path)
-	if dbDir != "." && dbDir != "" {
+	if sqlite && dbDir != "." && dbDir != "" {
 		if err := os.MkdirAll(dbDir, 0755); err != nil {
 			return fmt.Errorf("failed to create database directory: %w", err)
 		}
 	}
 	
 	return nil
-} 
\ No newline at end of file
+}
+
+// validateWebhookRules checks that the alert rules webhooks subscribe to exist
+func validateWebhookRules(webhooks []models.Webhook, rules []models.AlertRule) error {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		names[rule.Name] = true
+	}
+
+	for _, webhook := range webhooks {
+		for _, rule := range webhook.Rules {
+			if !names[rule] {
+				return fmt.Errorf("webhook %s subscribes to alert rule %s, which isn't in ALERT_RULES_FILE", webhook.Name, rule)
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,10 +2,13 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const testEnvPath = "./test.env"
@@ -51,6 +54,35 @@ func TestGetEnvAsInt(t *testing.T) {
 	assert.Equal(t, 10, value)
 }
 
+func TestGetEnvAsFloat(t *testing.T) {
+	os.Setenv("TEST_FLOAT_VAR", "0.25")
+	defer os.Unsetenv("TEST_FLOAT_VAR")
+
+	value := getEnvAsFloat("TEST_FLOAT_VAR", 0.5)
+	assert.Equal(t, 0.25, value)
+
+	os.Setenv("TEST_INVALID_FLOAT_VAR", "not-a-float")
+	defer os.Unsetenv("TEST_INVALID_FLOAT_VAR")
+
+	value = getEnvAsFloat("TEST_INVALID_FLOAT_VAR", 0.5)
+	assert.Equal(t, 0.5, value)
+
+	value = getEnvAsFloat("NON_EXISTENT_VAR", 0.5)
+	assert.Equal(t, 0.5, value)
+}
+
+func TestParseDurations(t *testing.T) {
+	durations, err := parseDurations(" 15m, 1h,,6h ")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{15 * time.Minute, time.Hour, 6 * time.Hour}, durations)
+
+	_, err = parseDurations("1h,soon")
+	assert.Error(t, err)
+
+	_, err = parseDurations("-1h")
+	assert.Error(t, err)
+}
+
 func TestValidateConfig(t *testing.T) {
 	//valid
 	validConfig := &Config{
@@ -187,4 +219,19 @@ func TestParseSubredditsEdgeCases(t *testing.T) {
 	if !reflect.DeepEqual(result, expected) {
 		t.Errorf("Failed to handle mixed whitespace: got %v, want %v", result, expected)
 	}
-} 
\ No newline at end of file
+} 
+func TestLoadAlertRules(t *testing.T) {
+	rules, err := loadAlertRules("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name": "mentions", "keywords": ["tracker"]}]`), 0o644))
+	rules, err = loadAlertRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "mentions", rules[0].Name)
+
+	_, err = loadAlertRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "failed to read alert rules")
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// AlertsFired queues a post.matched event for every webhook that sends the rule's alerts
+func (d *Dispatcher) AlertsFired(alerts []models.Alert) {
+	var deliveries []models.WebhookDelivery
+	for _, name := range d.order {
+		w := d.webhooks[name]
+		if !w.Sends(models.EventPostMatched) {
+			continue
+		}
+
+		for i := range alerts {
+			alert := alerts[i]
+			if len(w.Rules) > 0 && !contains(w.Rules, alert.Rule) {
+				continue
+			}
+
+			event := models.WebhookEvent{Type: models.EventPostMatched, OccurredAt: alert.FiredAt, Alert: &alert}
+			key := fmt.Sprintf("alert/%s/%s", alert.Rule, alert.PostID)
+			deliveries = d.appendDelivery(deliveries, w, event, key)
+		}
+	}
+
+	d.enqueue(deliveries)
+}
+
+// PostsSaved queues a post.score_threshold event for every post whose score crossed one of a
+// webhook's thresholds: it reached the threshold after being observed below it. Only the highest
+// threshold reached is sent, once per webhook, post and threshold; the crossings are recorded in
+// the database, so that holds however often the post is saved again.
+func (d *Dispatcher) PostsSaved(posts []models.Post) {
+	now := time.Now()
+
+	var candidates []models.ScoreCrossing
+	byID := make(map[string]*models.Post, len(posts))
+	for _, name := range d.order {
+		w := d.webhooks[name]
+		if !w.Sends(models.EventScoreThreshold) {
+			continue
+		}
+
+		for i := range posts {
+			threshold, reached := highestReached(w.ScoreThresholds, posts[i].Score)
+			if !reached {
+				continue
+			}
+			byID[posts[i].ID] = &posts[i]
+			candidates = append(candidates, models.ScoreCrossing{
+				Webhook: w.Name, PostID: posts[i].ID, Threshold: threshold, Score: posts[i].Score, CrossedAt: now,
+			})
+		}
+	}
+
+	crossed, err := d.database.SaveScoreCrossings(candidates)
+	if err != nil {
+		d.log.WithError(err).Error("Failed to record score crossings")
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, crossing := range crossed {
+		post := *byID[crossing.PostID]
+		event := models.WebhookEvent{Type: models.EventScoreThreshold, OccurredAt: now, Post: &post, Threshold: crossing.Threshold}
+		key := fmt.Sprintf("score/%s/%d", post.ID, crossing.Threshold)
+		deliveries = d.appendDelivery(deliveries, d.webhooks[crossing.Webhook], event, key)
+	}
+
+	d.enqueue(deliveries)
+}
+
+// CollectorError queues a collector.error event; errors from the same source are sent at most once
+// every five minutes, so an outage doesn't flood the webhook
+func (d *Dispatcher) CollectorError(source string, err error) {
+	now := time.Now()
+	window := now.Truncate(errorEventWindow).Unix()
+
+	var deliveries []models.WebhookDelivery
+	for _, name := range d.order {
+		w := d.webhooks[name]
+		if !w.Sends(models.EventCollectorError) {
+			continue
+		}
+
+		event := models.WebhookEvent{Type: models.EventCollectorError, OccurredAt: now, Source: source, Error: err.Error()}
+		key := fmt.Sprintf("error/%s/%d", source, window)
+		deliveries = d.appendDelivery(deliveries, w, event, key)
+	}
+
+	d.enqueue(deliveries)
+}
+
+// appendDelivery renders event for w and adds it to deliveries; an event that fails to render is
+// logged and dropped
+func (d *Dispatcher) appendDelivery(deliveries []models.WebhookDelivery, w models.Webhook, event models.WebhookEvent, key string) []models.WebhookDelivery {
+	event.Webhook = w.Name
+	payload, err := d.render(w, event)
+	if err != nil {
+		d.log.WithError(err).WithFields(logrus.Fields{"webhook": w.Name, "event": event.Type}).Error("Failed to render webhook payload")
+		return deliveries
+	}
+
+	now := time.Now()
+	return append(deliveries, models.WebhookDelivery{
+		Webhook:       w.Name,
+		Event:         event.Type,
+		DedupKey:      key,
+		Payload:       payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+}
+
+// enqueue adds deliveries to the outbox and wakes Run to send them
+func (d *Dispatcher) enqueue(deliveries []models.WebhookDelivery) {
+	queued, err := d.database.EnqueueWebhookDeliveries(deliveries)
+	if err != nil {
+		d.log.WithError(err).Error("Failed to queue webhook deliveries")
+		return
+	}
+	if queued == 0 {
+		return
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// highestReached returns the highest threshold at or below score
+func highestReached(thresholds []int, score int) (int, bool) {
+	highest, reached := 0, false
+	for _, t := range thresholds {
+		if score >= t && (!reached || t > highest) {
+			highest, reached = t, true
+		}
+	}
+	return highest, reached
+}
+
+func contains(items []string, item string) bool {
+	for _, it := range items {
+		if strings.EqualFold(it, item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// templateFuncs are available to custom payload templates; json encodes a value, quotes included,
+// so that titles and error messages can't break the payload
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// render builds the payload of an event with the webhook's template
+func (d *Dispatcher) render(w models.Webhook, event models.WebhookEvent) (string, error) {
+	switch w.Template {
+	case "", models.TemplateJSON:
+		data, err := json.Marshal(event)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode event: %w", err)
+		}
+		return string(data), nil
+
+	case models.TemplateSlack:
+		data, err := json.Marshal(map[string]string{"text": slackText(event)})
+		if err != nil {
+			return "", fmt.Errorf("failed to encode slack message: %w", err)
+		}
+		return string(data), nil
+	}
+
+	var buf bytes.Buffer
+	if err := d.templates[w.Name].Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return "", fmt.Errorf("template produced invalid JSON: %s", buf.String())
+	}
+	return buf.String(), nil
+}
+
+// slackText formats an event as a Slack message
+func slackText(event models.WebhookEvent) string {
+	switch event.Type {
+	case models.EventPostMatched:
+		a := event.Alert
+		return fmt.Sprintf(":bell: *%s* matched %s in r/%s by u/%s (score %d, %d comments)",
+			slackEscape(a.Rule), slackLink(a.Permalink, a.Title), slackEscape(a.Subreddit), slackEscape(a.Author),
+			a.Score, a.NumComments)
+
+	case models.EventScoreThreshold:
+		p := event.Post
+		return fmt.Sprintf(":chart_with_upwards_trend: %s in r/%s reached a score of %d (now %d, %d comments)",
+			slackLink(p.Permalink, p.Title), slackEscape(p.Subreddit), event.Threshold, p.Score, p.NumComments)
+
+	case models.EventCollectorError:
+		return fmt.Sprintf(":warning: Collector error in %s: %s", slackEscape(event.Source), slackEscape(event.Error))
+	}
+
+	return slackEscape(event.Type)
+}
+
+// slackLink links to a post on reddit
+func slackLink(permalink, title string) string {
+	if permalink == "" {
+		return slackEscape(title)
+	}
+	return fmt.Sprintf("<https://www.reddit.com%s|%s>", permalink, strings.ReplaceAll(slackEscape(title), "|", "¦"))
+}
+
+// slackEscape escapes the characters Slack treats as markup
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,290 @@
+// Package webhook POSTs collector events to configured URLs. Events are rendered into a payload as
+// soon as they happen and queued in the webhook_outbox table, and a dispatcher delivers them from
+// there with retries, so deliveries survive restarts.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a delivery is tried unless its webhook sets otherwise
+	DefaultMaxAttempts = 8
+
+	defaultBaseBackoff  = 10 * time.Second
+	defaultMaxBackoff   = time.Hour
+	defaultPollInterval = 5 * time.Second
+	requestTimeout      = 10 * time.Second
+	deliveryBatchSize   = 50
+	deliveryLease       = deliveryBatchSize * requestTimeout // long enough for every delivery of a batch to time out
+	outboxRetention     = 7 * 24 * time.Hour
+	pruneInterval       = time.Hour
+	errorEventWindow    = 5 * time.Minute // repeated errors from one source are sent once per window
+)
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature" // sha256=<hex HMAC of "<timestamp>.<body>">; only with a secret
+)
+
+// Dispatcher queues collector events for the configured webhooks and delivers them. It implements
+// stats.Notifier.
+type Dispatcher struct {
+	database     db.Store
+	webhooks     map[string]models.Webhook
+	order        []string                      // webhook names in configuration order
+	templates    map[string]*template.Template // custom payload templates by webhook name
+	client       *http.Client
+	log          *logrus.Logger
+	pollInterval time.Duration
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	wake         chan struct{} // signals Run that deliveries were queued
+}
+
+// Option configures optional Dispatcher settings
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client deliveries are sent with
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithBackoff sets the delay before the first retry, which doubles with every attempt up to max
+func WithBackoff(base, max time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.baseBackoff = base
+		d.maxBackoff = max
+	}
+}
+
+// WithPollInterval sets how often the outbox is checked for retries that became due
+func WithPollInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.pollInterval = interval
+	}
+}
+
+// NewDispatcher creates a dispatcher for webhooks, which must be valid
+func NewDispatcher(database db.Store, webhooks []models.Webhook, log *logrus.Logger, opts ...Option) (*Dispatcher, error) {
+	d := &Dispatcher{
+		database:     database,
+		webhooks:     make(map[string]models.Webhook, len(webhooks)),
+		templates:    make(map[string]*template.Template),
+		client:       &http.Client{Timeout: requestTimeout},
+		log:          log,
+		pollInterval: defaultPollInterval,
+		baseBackoff:  defaultBaseBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		wake:         make(chan struct{}, 1),
+	}
+
+	for _, w := range webhooks {
+		if err := w.Validate(); err != nil {
+			return nil, err
+		}
+
+		switch w.Template {
+		case "", models.TemplateJSON, models.TemplateSlack:
+		default:
+			tmpl, err := template.New(w.Name).Funcs(templateFuncs).Parse(w.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s has an invalid template: %w", w.Name, err)
+			}
+			d.templates[w.Name] = tmpl
+		}
+
+		d.webhooks[w.Name] = w
+		d.order = append(d.order, w.Name)
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d, nil
+}
+
+// Run delivers queued events until ctx is cancelled: right after they're queued, and again on their
+// retry schedule. Old delivered and failed deliveries are pruned from the outbox.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	var prunedAt time.Time
+	for {
+		if time.Since(prunedAt) >= pruneInterval {
+			if err := d.database.PruneWebhookDeliveries(time.Now().Add(-outboxRetention)); err != nil {
+				d.log.WithError(err).Error("Failed to prune webhook outbox")
+			}
+			prunedAt = time.Now()
+		}
+
+		d.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue works through every delivery that is due
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := d.database.ClaimWebhookDeliveries(time.Now(), deliveryLease, deliveryBatchSize)
+		if err != nil {
+			d.log.WithError(err).Error("Failed to claim due webhook deliveries")
+			return
+		}
+
+		for i := range deliveries {
+			if ctx.Err() != nil {
+				d.release(deliveries[i:])
+				return
+			}
+			d.deliver(ctx, &deliveries[i])
+		}
+
+		if len(deliveries) < deliveryBatchSize {
+			return
+		}
+	}
+}
+
+// release hands claimed deliveries that weren't attempted back to the outbox, due right away
+func (d *Dispatcher) release(deliveries []models.WebhookDelivery) {
+	for i := range deliveries {
+		deliveries[i].NextAttemptAt = time.Now()
+		d.save(&deliveries[i], logrus.Fields{"webhook": deliveries[i].Webhook, "delivery_id": deliveries[i].ID})
+	}
+}
+
+// deliver makes one attempt at a delivery and records the outcome. An attempt interrupted by ctx
+// isn't counted, and the delivery is released to go out again on the next run.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	fields := logrus.Fields{"webhook": delivery.Webhook, "event": delivery.Event, "delivery_id": delivery.ID}
+
+	w, ok := d.webhooks[delivery.Webhook]
+	if !ok {
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = "webhook is no longer configured"
+		d.save(delivery, fields)
+		return
+	}
+
+	retryable, err := d.send(ctx, w, delivery)
+	if err != nil && ctx.Err() != nil {
+		d.release([]models.WebhookDelivery{*delivery})
+		return
+	}
+
+	delivery.Attempts++
+	fields["attempts"] = delivery.Attempts
+
+	maxAttempts := w.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = time.Now()
+		delivery.LastError = ""
+		d.log.WithFields(fields).Debug("Delivered webhook")
+	case retryable && delivery.Attempts < maxAttempts:
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+		d.log.WithError(err).WithFields(fields).WithField("next_attempt_at", delivery.NextAttemptAt).Warn("Webhook delivery failed; will retry")
+	default:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+		d.log.WithError(err).WithFields(fields).Error("Webhook delivery failed; giving up")
+	}
+
+	d.save(delivery, fields)
+}
+
+func (d *Dispatcher) save(delivery *models.WebhookDelivery, fields logrus.Fields) {
+	if err := d.database.UpdateWebhookDelivery(delivery); err != nil {
+		d.log.WithError(err).WithFields(fields).Error("Failed to record webhook delivery")
+	}
+}
+
+// send POSTs a delivery's payload; a failure is retryable unless the endpoint rejected the request
+func (d *Dispatcher) send(ctx context.Context, w models.Webhook, delivery *models.WebhookDelivery) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "reddit-tracker-webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, []byte(delivery.Payload)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint rejected the delivery with %s", resp.Status)
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseBackoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxBackoff)
+}
+
+// Sign returns the signature header value of a payload: sha256= followed by the hex HMAC-SHA256 of
+// "<timestamp>.<payload>" keyed with secret. Receivers should recompute it and compare in constant
+// time, and reject old timestamps to stop replays.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,303 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brettboylen/reddit-tracker/db"
+	"github.com/brettboylen/reddit-tracker/models"
+)
+
+// receiver is a webhook endpoint that answers with the queued status codes, then 200
+type receiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, string(body))
+
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.requests)
+}
+
+func newTestDispatcher(t *testing.T, database db.Store, webhooks ...models.Webhook) *Dispatcher {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	if database == nil {
+		database = db.NewMemoryStore()
+	}
+
+	d, err := NewDispatcher(database, webhooks, log,
+		WithBackoff(10*time.Millisecond, 40*time.Millisecond),
+		WithPollInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	return d
+}
+
+// run runs the dispatcher until the test ends
+func run(t *testing.T, d *Dispatcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func deliveries(t *testing.T, d *Dispatcher) []models.WebhookDelivery {
+	t.Helper()
+	all, err := d.database.GetWebhookDeliveries("", 100)
+	require.NoError(t, err)
+	return all
+}
+
+func TestDeliverSignedAlert(t *testing.T) {
+	r := newReceiver(t)
+	d := newTestDispatcher(t, nil, models.Webhook{
+		Name:   "signed",
+		URL:    r.URL,
+		Events: []string{models.EventPostMatched},
+		Rules:  []string{"mentions"},
+		Secret: "shh",
+	})
+	run(t, d)
+
+	alert := models.Alert{Rule: "mentions", PostID: "p1", Title: "a tracker", FiredAt: time.Unix(1700000000, 0)}
+	d.AlertsFired([]models.Alert{alert, {Rule: "other", PostID: "p2"}})
+	d.AlertsFired([]models.Alert{alert})
+
+	require.Eventually(t, func() bool { return r.count() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	r.mutex.Lock()
+	req, body := r.requests[0], r.bodies[0]
+	r.mutex.Unlock()
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, Sign("shh", timestamp, []byte(body)), req.Header.Get(HeaderSignature))
+	assert.Equal(t, models.EventPostMatched, req.Header.Get(HeaderEvent))
+
+	var event models.WebhookEvent
+	require.NoError(t, json.Unmarshal([]byte(body), &event))
+	assert.Equal(t, "signed", event.Webhook)
+	require.NotNil(t, event.Alert)
+	assert.Equal(t, "p1", event.Alert.PostID)
+
+	require.Eventually(t, func() bool {
+		all := deliveries(t, d)
+		return len(all) == 1 && all[0].Status == models.DeliveryDelivered
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	flaky := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	rejecting := newReceiver(t, http.StatusBadRequest)
+	down := newReceiver(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+	d := newTestDispatcher(t, nil,
+		models.Webhook{Name: "flaky", URL: flaky.URL, Events: []string{models.EventCollectorError}},
+		models.Webhook{Name: "rejecting", URL: rejecting.URL, Events: []string{models.EventCollectorError}},
+		models.Webhook{Name: "down", URL: down.URL, Events: []string{models.EventCollectorError}, MaxAttempts: 2},
+	)
+	run(t, d)
+
+	d.CollectorError("poll/golang", errors.New("boom"))
+
+	want := map[string]struct {
+		status   models.WebhookDeliveryStatus
+		attempts int
+	}{
+		"flaky":     {models.DeliveryDelivered, 3},
+		"rejecting": {models.DeliveryFailed, 1},
+		"down":      {models.DeliveryFailed, 2},
+	}
+	require.Eventually(t, func() bool {
+		all := deliveries(t, d)
+		if len(all) != len(want) {
+			return false
+		}
+		for _, delivery := range all {
+			if w := want[delivery.Webhook]; delivery.Status != w.status || delivery.Attempts != w.attempts {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, delivery := range deliveries(t, d) {
+		if delivery.Status == models.DeliveryFailed {
+			assert.NotEmpty(t, delivery.LastError, delivery.Webhook)
+		}
+	}
+	assert.Equal(t, 2, down.count(), "no attempts after max_attempts")
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	r := newReceiver(t)
+	hook := models.Webhook{Name: "later", URL: r.URL, Events: []string{models.EventCollectorError}}
+
+	// the first dispatcher queues an event but stops before delivering it
+	first := newTestDispatcher(t, nil, hook)
+	first.CollectorError("refresh", errors.New("boom"))
+	first.CollectorError("refresh", errors.New("boom again"))
+	require.Len(t, deliveries(t, first), 1, "repeated errors from one source are queued once")
+
+	second := newTestDispatcher(t, first.database, hook)
+	run(t, second)
+
+	require.Eventually(t, func() bool { return r.count() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSharedOutbox(t *testing.T) {
+	r := newReceiver(t)
+	hook := models.Webhook{Name: "shared", URL: r.URL, Events: []string{models.EventCollectorError}}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "outbox.db"), log)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	// two dispatchers working the same outbox deliver every event once between them
+	first := newTestDispatcher(t, database, hook)
+	second := newTestDispatcher(t, database, hook)
+	for i := 0; i < 20; i++ {
+		first.CollectorError(fmt.Sprintf("poll/%d", i), errors.New("boom"))
+	}
+	run(t, first)
+	run(t, second)
+
+	require.Eventually(t, func() bool { return r.count() == 20 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 20, r.count())
+}
+
+func TestRender(t *testing.T) {
+	d := newTestDispatcher(t, nil)
+	post := &models.Post{ID: "p1", Title: "Go <1.22> & more", Subreddit: "golang", Permalink: "/r/golang/comments/p1", Score: 1500}
+	event := models.WebhookEvent{Type: models.EventScoreThreshold, Webhook: "hook", Post: post, Threshold: 1000}
+
+	payload, err := d.render(models.Webhook{Template: models.TemplateSlack}, event)
+	require.NoError(t, err)
+
+	var slack map[string]string
+	require.NoError(t, json.Unmarshal([]byte(payload), &slack))
+	assert.Equal(t, ":chart_with_upwards_trend: <https://www.reddit.com/r/golang/comments/p1|Go &lt;1.22&gt; &amp; more> "+
+		"in r/golang reached a score of 1000 (now 1500, 0 comments)", slack["text"])
+
+	custom, err := NewDispatcher(d.database, []models.Webhook{{
+		Name:            "custom",
+		URL:             "https://example.com/hook",
+		Events:          []string{models.EventScoreThreshold},
+		ScoreThresholds: []int{1000},
+		Template:        `{"title": {{json .Post.Title}}, "threshold": {{.Threshold}}}`,
+	}}, d.log)
+	require.NoError(t, err)
+
+	payload, err = custom.render(custom.webhooks["custom"], event)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title": "Go <1.22> & more", "threshold": 1000}`, payload)
+
+	_, err = NewDispatcher(d.database, []models.Webhook{{
+		Name: "broken", URL: "https://example.com/hook", Events: []string{models.EventCollectorError}, Template: "{{",
+	}}, d.log)
+	assert.ErrorContains(t, err, "invalid template")
+}
+
+func TestScoreThresholds(t *testing.T) {
+	d := newTestDispatcher(t, nil, models.Webhook{
+		Name:            "scores",
+		URL:             "https://example.com/hook",
+		Events:          []string{models.EventScoreThreshold},
+		ScoreThresholds: []int{100, 1000},
+	})
+	observed := time.Now().Add(-time.Hour)
+	save := func(posts ...models.Post) {
+		observed = observed.Add(time.Minute)
+		for i := range posts {
+			posts[i].ProcessedTime = observed
+		}
+		require.NoError(t, d.database.SavePosts(posts))
+		d.PostsSaved(posts)
+	}
+
+	post := models.Post{ID: "p1", Score: 50}
+	save(post)
+	assert.Empty(t, deliveries(t, d))
+
+	// a post that jumps past both thresholds only sends the highest; one first seen above them,
+	// e.g. in a top listing, never crossed them
+	post.Score = 1200
+	save(post, models.Post{ID: "p2", Score: 5000})
+	save(post)
+
+	all := deliveries(t, d)
+	require.Len(t, all, 1)
+	assert.Equal(t, "score/p1/1000", all[0].DedupKey)
+
+	// the crossing isn't sent again once its delivery is pruned from the outbox
+	all[0].Status = models.DeliveryDelivered
+	require.NoError(t, d.database.UpdateWebhookDelivery(&all[0]))
+	require.NoError(t, d.database.PruneWebhookDeliveries(time.Now().Add(time.Hour)))
+	post.Score = 1300
+	save(post)
+	assert.Empty(t, deliveries(t, d))
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{baseBackoff: 10 * time.Second, maxBackoff: time.Minute}
+	assert.Equal(t, 10*time.Second, d.backoff(1))
+	assert.Equal(t, 20*time.Second, d.backoff(2))
+	assert.Equal(t, 40*time.Second, d.backoff(3))
+	assert.Equal(t, time.Minute, d.backoff(4))
+	assert.Equal(t, time.Minute, d.backoff(20))
+}